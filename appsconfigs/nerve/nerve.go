@@ -0,0 +1,6 @@
+// Package nerve declares nerve queues and their consumers,
+// `make configs` generates QueueConfig and ConsumerId declarations from queues.json, which is
+// validated by tests, queue `schema` is a name of schema registered by nerve.RegisterQueueSchema
+package nerve
+
+//-generate-configs: queues.json shared/nerve/QueuesDefinition
@@ -0,0 +1,16 @@
+package nerve
+
+import (
+	"testing"
+
+	queues "octopus/target/generated-sources/appsconfigs/nerve"
+)
+
+func TestQueuesDefinition(t *testing.T) {
+	if err := queues.QueuesJson.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if queues.NQLocalSourcedTest.GetSchema() == nil {
+		t.Fatalf("schema of %s is not resolved", queues.NQLocalSourcedTest.Name)
+	}
+}
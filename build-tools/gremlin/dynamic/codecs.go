@@ -0,0 +1,276 @@
+package dynamic
+
+import (
+	"octopus/shared/gremlin"
+)
+
+// codec reads and writes scalar values, values have the same go types as fields of generated structs
+type codec struct {
+	wire gremlin.ProtoWireType
+	zero interface{}
+	// read returns value at offset and its size, offset is checked by SkipData before
+	read func(buf *gremlin.Reader, offset int) (interface{}, int)
+	// write appends value without tag, only for packable kinds
+	write func(w *gremlin.Writer, value interface{})
+	// append appends value with tag
+	append func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{})
+	valid  func(value interface{}) bool
+}
+
+var codecs = map[gremlin.FieldKind]*codec{
+	gremlin.KindBool: {
+		wire: gremlin.VarIntType,
+		zero: false,
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadBool(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendBoolWithoutTag(value.(bool))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendBool(tag, value.(bool))
+		},
+		valid: func(value interface{}) bool {
+			_, ok := value.(bool)
+			return ok
+		},
+	},
+	gremlin.KindInt32: {
+		wire: gremlin.VarIntType,
+		zero: int32(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadInt32(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendInt32WithoutTag(value.(int32))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendInt32(tag, value.(int32))
+		},
+		valid: isInt32,
+	},
+	gremlin.KindInt64: {
+		wire: gremlin.VarIntType,
+		zero: int64(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadInt64(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendInt64WithoutTag(value.(int64))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendInt64(tag, value.(int64))
+		},
+		valid: isInt64,
+	},
+	gremlin.KindUint32: {
+		wire: gremlin.VarIntType,
+		zero: uint32(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadUint32(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendUint32WithoutTag(value.(uint32))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendUint32(tag, value.(uint32))
+		},
+		valid: isUint32,
+	},
+	gremlin.KindUint64: {
+		wire: gremlin.VarIntType,
+		zero: uint64(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadUint64(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendUint64WithoutTag(value.(uint64))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendUint64(tag, value.(uint64))
+		},
+		valid: isUint64,
+	},
+	gremlin.KindSInt32: {
+		wire: gremlin.VarIntType,
+		zero: int32(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadSInt32(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendSInt32WithoutTag(value.(int32))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendSInt32(tag, value.(int32))
+		},
+		valid: isInt32,
+	},
+	gremlin.KindSInt64: {
+		wire: gremlin.VarIntType,
+		zero: int64(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadSInt64(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendSInt64WithoutTag(value.(int64))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendSInt64(tag, value.(int64))
+		},
+		valid: isInt64,
+	},
+	gremlin.KindFixed32: {
+		wire: gremlin.Fixed32Type,
+		zero: uint32(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadFixed32(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendFixed32WithoutTag(value.(uint32))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendFixed32(tag, value.(uint32))
+		},
+		valid: isUint32,
+	},
+	gremlin.KindFixed64: {
+		wire: gremlin.Fixed64Type,
+		zero: uint64(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadFixed64(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendFixed64WithoutTag(value.(uint64))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendFixed64(tag, value.(uint64))
+		},
+		valid: isUint64,
+	},
+	gremlin.KindSFixed32: {
+		wire: gremlin.Fixed32Type,
+		zero: int32(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadSFixed32(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendSFixed32WithoutTag(value.(int32))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendSFixed32(tag, value.(int32))
+		},
+		valid: isInt32,
+	},
+	gremlin.KindSFixed64: {
+		wire: gremlin.Fixed64Type,
+		zero: int64(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadSFixed64(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendSFixed64WithoutTag(value.(int64))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendSFixed64(tag, value.(int64))
+		},
+		valid: isInt64,
+	},
+	gremlin.KindFloat: {
+		wire: gremlin.Fixed32Type,
+		zero: float32(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadFloat32(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendFloat32WithoutTag(value.(float32))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendFloat32(tag, value.(float32))
+		},
+		valid: func(value interface{}) bool {
+			_, ok := value.(float32)
+			return ok
+		},
+	},
+	gremlin.KindDouble: {
+		wire: gremlin.Fixed64Type,
+		zero: float64(0),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			return buf.SizedReadFloat64(offset)
+		},
+		write: func(w *gremlin.Writer, value interface{}) {
+			w.AppendFloat64WithoutTag(value.(float64))
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendFloat64(tag, value.(float64))
+		},
+		valid: func(value interface{}) bool {
+			_, ok := value.(float64)
+			return ok
+		},
+	},
+	gremlin.KindString: {
+		wire: gremlin.BytesType,
+		zero: "",
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			v, size := buf.SizedReadBytes(offset)
+			return string(v), size
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendString(tag, value.(string))
+		},
+		valid: func(value interface{}) bool {
+			_, ok := value.(string)
+			return ok
+		},
+	},
+	gremlin.KindBytes: {
+		wire: gremlin.BytesType,
+		zero: []byte(nil),
+		read: func(buf *gremlin.Reader, offset int) (interface{}, int) {
+			v, size := buf.SizedReadBytes(offset)
+			return append([]byte{}, v...), size
+		},
+		append: func(w *gremlin.Writer, tag gremlin.ProtoWireNumber, value interface{}) {
+			w.AppendBytes(tag, value.([]byte))
+		},
+		valid: func(value interface{}) bool {
+			_, ok := value.([]byte)
+			return ok
+		},
+	},
+}
+
+// enums are kept as their numbers
+var enumCodec = codecs[gremlin.KindInt32]
+
+func codecOf(kind gremlin.FieldKind) *codec {
+	if kind == gremlin.KindEnum {
+		return enumCodec
+	}
+	return codecs[kind]
+}
+
+func (c *codec) packable() bool {
+	return c.write != nil
+}
+
+func isInt32(value interface{}) bool {
+	_, ok := value.(int32)
+	return ok
+}
+
+func isInt64(value interface{}) bool {
+	_, ok := value.(int64)
+	return ok
+}
+
+func isUint32(value interface{}) bool {
+	_, ok := value.(uint32)
+	return ok
+}
+
+func isUint64(value interface{}) bool {
+	_, ok := value.(uint64)
+	return ok
+}
@@ -0,0 +1,146 @@
+package dynamic
+
+import (
+	"fmt"
+	"octopus/shared/gremlin"
+)
+
+// Unmarshal decodes data with gremlin.DefaultDecodeOptions, data may come from untrusted source
+func (m *Message) Unmarshal(data []byte) error {
+	return m.SafeUnmarshal(data, gremlin.DefaultDecodeOptions)
+}
+
+// SafeUnmarshal replaces content of the message, decoded values do not reference data
+func (m *Message) SafeUnmarshal(data []byte, opts gremlin.DecodeOptions) error {
+	opts, err := opts.Enter(data)
+	if err != nil {
+		return err
+	}
+
+	m.fields = map[gremlin.ProtoWireNumber]interface{}{}
+	m.unknown = nil
+
+	buf := gremlin.NewReader(data)
+	offset := 0
+	for offset < len(data) {
+		tag, wire, tagSize, err := buf.ReadTagAt(offset)
+		if err != nil {
+			return err
+		}
+		next, err := buf.SkipData(offset+tagSize, wire)
+		if err != nil {
+			return err
+		}
+
+		known := false
+		if field := m.desc.FieldByNumber(tag); field != nil {
+			known, err = m.decodeField(buf, field, wire, offset+tagSize, opts)
+			if err != nil {
+				return fmt.Errorf("%v.%v: %w", m.desc.Name, field.Name, err)
+			}
+		}
+		if !known {
+			m.unknown = append(m.unknown, data[offset:next]...)
+		}
+		offset = next
+	}
+	return nil
+}
+
+// decodeField returns false if wire type does not match the field
+func (m *Message) decodeField(buf *gremlin.Reader, field *gremlin.FieldDescriptor, wire gremlin.ProtoWireType, offset int, opts gremlin.DecodeOptions) (bool, error) {
+	if field.IsMap() {
+		if wire != gremlin.BytesType {
+			return false, nil
+		}
+		return true, m.decodeMapEntry(buf, field, offset, opts)
+	}
+
+	var value interface{}
+	switch {
+	case field.Kind == gremlin.KindMessage:
+		if wire != gremlin.BytesType {
+			return false, nil
+		}
+		nested, err := m.decodeMessage(field.TypeName, buf.ReadBytes(offset), opts)
+		if err != nil {
+			return true, err
+		}
+		value = nested
+	case field.IsList() && wire == gremlin.BytesType && codecOf(field.Kind).packable():
+		c := codecOf(field.Kind)
+		if err := buf.CheckPacked(offset, c.wire); err != nil {
+			return true, err
+		}
+		size, sizeSize := buf.SizedReadVarInt(offset)
+		list, _ := m.fields[field.Number].([]interface{})
+		for pos, end := offset+sizeSize, offset+sizeSize+int(size); pos < end; {
+			entry, entrySize := c.read(buf, pos)
+			list = append(list, entry)
+			pos += entrySize
+		}
+		m.fields[field.Number] = list
+		return true, nil
+	default:
+		c := codecOf(field.Kind)
+		if wire != c.wire {
+			return false, nil
+		}
+		value, _ = c.read(buf, offset)
+	}
+
+	if field.IsList() {
+		list, _ := m.fields[field.Number].([]interface{})
+		m.fields[field.Number] = append(list, value)
+	} else {
+		m.set(field, value)
+	}
+	return true, nil
+}
+
+// decodeMapEntry decodes entry as a message of key and value, absent key or value get default values
+func (m *Message) decodeMapEntry(buf *gremlin.Reader, field *gremlin.FieldDescriptor, offset int, opts gremlin.DecodeOptions) error {
+	valueWire := gremlin.BytesType
+	if field.Kind != gremlin.KindMessage {
+		valueWire = codecOf(field.Kind).wire
+	}
+	if _, err := buf.CheckMapEntry(offset, codecOf(field.MapKey).wire, valueWire); err != nil {
+		return err
+	}
+
+	entry := m.registry.newMessage(m.registry.entries[field])
+	if err := entry.SafeUnmarshal(buf.ReadBytes(offset), opts); err != nil {
+		return err
+	}
+
+	key, ok := entry.fields[1]
+	if !ok {
+		key = codecOf(field.MapKey).zero
+	}
+	value, ok := entry.fields[2]
+	if !ok && field.Kind == gremlin.KindMessage {
+		var err error
+		if value, err = m.decodeMessage(field.TypeName, nil, opts); err != nil {
+			return err
+		}
+	} else if !ok {
+		value = codecOf(field.Kind).zero
+	}
+
+	entries, _ := m.fields[field.Number].(map[interface{}]interface{})
+	if entries == nil {
+		entries = map[interface{}]interface{}{}
+		m.fields[field.Number] = entries
+	}
+	entries[key] = value
+	return nil
+}
+
+func (m *Message) decodeMessage(typeName string, data []byte, opts gremlin.DecodeOptions) (*Message, error) {
+	desc := m.registry.FindMessage(typeName)
+	if desc == nil {
+		return nil, fmt.Errorf("%w: message %v", ErrUnknownType, typeName)
+	}
+	res := m.registry.newMessage(desc)
+	return res, res.SafeUnmarshal(data, opts)
+}
@@ -0,0 +1,207 @@
+package dynamic
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"octopus/build-tools/gremlin/testdata"
+	"octopus/shared/gremlin"
+	"octopus/target/generated-sources/protobuf/gremlin/map_test"
+	"octopus/target/generated-sources/protobuf/gremlin/protobuf_unittest"
+	"strings"
+	"testing"
+)
+
+func loadRegistry(t *testing.T) *Registry {
+	registry, err := LoadProtoFiles("../../../protobufs")
+	if err != nil {
+		t.Fatalf("failed to load proto files: %v", err)
+	}
+	return registry
+}
+
+func TestGoldenMessage(t *testing.T) {
+	registry := loadRegistry(t)
+	content, err := testdata.TestData.ReadFile("golden_message")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := registry.NewMessage("protobuf_unittest.TestAllTypes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Unmarshal(content); err != nil {
+		t.Fatalf("failed to unmarshal golden message: %v", err)
+	}
+	if len(msg.Unknown()) == 0 {
+		t.Errorf("groups of the golden message should be unknown fields")
+	}
+
+	expected := protobuf_unittest.NewTestAllTypesReader()
+	if err := expected.Unmarshal(content); err != nil {
+		t.Fatal(err)
+	}
+	parsed := protobuf_unittest.NewTestAllTypesReader()
+	if err := parsed.Unmarshal(msg.Marshal()); err != nil {
+		t.Fatalf("failed to unmarshal dynamic message: %v", err)
+	}
+	if !cmp.Equal(parsed.ToStruct(), expected.ToStruct()) {
+		t.Errorf("round trip: %v", cmp.Diff(expected.ToStruct(), parsed.ToStruct()))
+	}
+
+	expectedJson, _ := json.Marshal(expected)
+	dynamicJson, _ := json.Marshal(msg)
+	if string(dynamicJson) != string(expectedJson) {
+		t.Errorf("json: got %s, want %s", dynamicJson, expectedJson)
+	}
+
+	text := msg.String()
+	for _, line := range []string{
+		"optional_int32: 101\n",
+		"optional_string: \"115\"\n",
+		"optional_nested_message {\n  bb: 118\n}\n",
+		"optional_nested_enum: BAZ\n",
+		"repeated_bool: true\nrepeated_bool: false\n",
+		"oneof_bytes: \"604\"\n",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("text format has no %q:\n%v", line, text)
+		}
+	}
+}
+
+func TestMaps(t *testing.T) {
+	registry := loadRegistry(t)
+	expected := &map_test.TestMap{
+		Int32ToInt32Field:   map[int32]int32{1: 10, -2: 0},
+		Int32ToEnumField:    map[int32]map_test.TestMap_EnumValue{3: map_test.TestMap_BAR},
+		Int32ToMessageField: map[int32]*map_test.TestMap_MessageValue{4: {Value: 40}, 5: {}},
+		StringToInt32Field:  map[string]int32{"b": 2, "a": 1},
+	}
+
+	msg, err := registry.NewMessage("map_test.TestMap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Unmarshal(expected.Marshal()); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	value, err := msg.Get("int32ToMessageField")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := value.(map[interface{}]interface{})
+	if nested, _ := entries[int32(4)].(*Message).Get("value"); nested != int32(40) {
+		t.Errorf("int32_to_message_field[4]: got %v", nested)
+	}
+
+	generated := map_test.NewTestMapReader()
+	if err := generated.Unmarshal(expected.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	parsed := map_test.NewTestMapReader()
+	if err := parsed.Unmarshal(msg.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(parsed.ToStruct(), generated.ToStruct()) {
+		t.Errorf("round trip: %v", cmp.Diff(generated.ToStruct(), parsed.ToStruct()))
+	}
+
+	expectedJson, _ := json.Marshal(expected)
+	dynamicJson, _ := json.Marshal(msg)
+	if string(dynamicJson) != string(expectedJson) {
+		t.Errorf("json: got %s, want %s", dynamicJson, expectedJson)
+	}
+
+	if !strings.Contains(msg.String(), "string_to_int32_field {\n  key: \"a\"\n  value: 1\n}\nstring_to_int32_field {\n  key: \"b\"") {
+		t.Errorf("map entries should be sorted by key:\n%v", msg)
+	}
+}
+
+func TestSetField(t *testing.T) {
+	registry := loadRegistry(t)
+	msg, err := registry.NewMessage("protobuf_unittest.TestAllTypes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err := registry.NewMessage("protobuf_unittest.TestAllTypes.NestedMessage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Set("bb", int32(7)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range map[string]interface{}{
+		"optional_int32":          int32(1),
+		"optionalString":          "str",
+		"optional_nested_enum":    int32(3),
+		"optional_nested_message": nested,
+		"repeated_int64":          []interface{}{int64(1), int64(-1)},
+		"oneof_uint32":            uint32(5),
+		"oneof_string":            "oneof",
+	} {
+		if err := msg.Set(name, value); err != nil {
+			t.Errorf("Set(%v): %v", name, err)
+		}
+	}
+	if v, _ := msg.Get("oneof_uint32"); v != nil {
+		t.Errorf("oneof_uint32 should be cleared by oneof_string, got %v", v)
+	}
+
+	if err := msg.Set("optional_int32", int64(1)); !errors.Is(err, gremlin.ErrFieldType) {
+		t.Errorf("expected ErrFieldType, got %v", err)
+	}
+	if err := msg.Set("optional_nested_message", msg); !errors.Is(err, gremlin.ErrFieldType) {
+		t.Errorf("expected ErrFieldType for message of other type, got %v", err)
+	}
+	if err := msg.Set("repeated_int64", []interface{}{int32(1)}); !errors.Is(err, gremlin.ErrFieldType) {
+		t.Errorf("expected ErrFieldType for list entry, got %v", err)
+	}
+	if err := msg.Set("no_such_field", int32(1)); !errors.Is(err, gremlin.ErrUnknownField) {
+		t.Errorf("expected ErrUnknownField, got %v", err)
+	}
+	if _, err := registry.NewMessage("protobuf_unittest.NoSuchMessage"); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("expected ErrUnknownType, got %v", err)
+	}
+
+	parsed := protobuf_unittest.NewTestAllTypesReader()
+	if err := parsed.Unmarshal(msg.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	expected := &protobuf_unittest.TestAllTypes{
+		OptionalInt32:         1,
+		OptionalString:        "str",
+		OptionalNestedEnum:    protobuf_unittest.TestAllTypes_BAZ,
+		OptionalNestedMessage: &protobuf_unittest.TestAllTypes_NestedMessage{Bb: 7},
+		RepeatedInt64:         []int64{1, -1},
+		OneofField:            &protobuf_unittest.TestAllTypes_OneofString{OneofString: "oneof"},
+	}
+	if actual := parsed.ToStruct(); actual.OptionalInt32 != 1 || !cmp.Equal(actual.OptionalNestedMessage, expected.OptionalNestedMessage) ||
+		!cmp.Equal(actual.RepeatedInt64, expected.RepeatedInt64) || !cmp.Equal(actual.OneofField, expected.OneofField) ||
+		actual.OptionalString != expected.OptionalString || actual.OptionalNestedEnum != expected.OptionalNestedEnum {
+		t.Errorf("unexpected message: %v", cmp.Diff(expected, actual))
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	registry := loadRegistry(t)
+	msg, err := registry.NewMessage("protobuf_unittest.TestAllTypes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// optional_nested_message with length beyond the data
+	if err := msg.Unmarshal([]byte{0x92, 0x01, 0x05, 0x08}); !errors.Is(err, gremlin.ErrTruncated) {
+		t.Errorf("expected ErrTruncated, got %v", err)
+	}
+	// packed repeated_int32 with incomplete varint
+	if err := msg.Unmarshal([]byte{0xfa, 0x01, 0x01, 0x80}); !errors.Is(err, gremlin.ErrTruncated) {
+		t.Errorf("expected ErrTruncated for packed list, got %v", err)
+	}
+	// optional_int32 written as fixed32 is kept as unknown field
+	if err := msg.Unmarshal([]byte{0x0d, 1, 0, 0, 0}); err != nil || len(msg.Unknown()) != 5 {
+		t.Errorf("expected unknown field, got %v, %v", err, msg.Unknown())
+	}
+}
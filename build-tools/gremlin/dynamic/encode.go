@@ -0,0 +1,101 @@
+package dynamic
+
+import (
+	"octopus/shared/gremlin"
+	"sort"
+)
+
+// maxTagSize is size of the tag and the length of bytes data in the worst case
+const maxTagSize = 15
+
+// Marshal writes fields in declaration order, unknown fields go last as they were read.
+// Packable lists are written packed except enums, generated readers expect enum lists unpacked
+func (m *Message) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var res []byte
+	for _, field := range m.desc.Fields {
+		value, ok := m.fields[field.Number]
+		if !ok {
+			continue
+		}
+		switch {
+		case field.IsMap():
+			entries := value.(map[interface{}]interface{})
+			for _, key := range sortedKeys(entries) {
+				entry := m.registry.newMessage(m.registry.entries[field])
+				entry.fields[1], entry.fields[2] = key, entries[key]
+				res = appendValue(res, field.Number, gremlin.KindMessage, entry)
+			}
+		case field.IsList():
+			list := value.([]interface{})
+			if c := codecOf(field.Kind); c != nil && field.Kind != gremlin.KindEnum && c.packable() {
+				res = appendPacked(res, field.Number, c, list)
+				continue
+			}
+			for _, v := range list {
+				res = appendValue(res, field.Number, field.Kind, v)
+			}
+		default:
+			res = appendValue(res, field.Number, field.Kind, value)
+		}
+	}
+	return append(res, m.unknown...)
+}
+
+func appendValue(res []byte, tag gremlin.ProtoWireNumber, kind gremlin.FieldKind, value interface{}) []byte {
+	var w *gremlin.Writer
+	switch v := value.(type) {
+	case *Message:
+		data := v.Marshal()
+		w = gremlin.NewWriter(maxTagSize + len(data))
+		w.AppendBytes(tag, data)
+	case string:
+		w = gremlin.NewWriter(maxTagSize + len(v))
+		w.AppendString(tag, v)
+	case []byte:
+		w = gremlin.NewWriter(maxTagSize + len(v))
+		w.AppendBytes(tag, v)
+	default:
+		w = gremlin.NewWriter(maxTagSize)
+		codecOf(kind).append(w, tag, value)
+	}
+	return append(res, w.Bytes()...)
+}
+
+func appendPacked(res []byte, tag gremlin.ProtoWireNumber, c *codec, list []interface{}) []byte {
+	packed := gremlin.NewWriter(len(list) * 10)
+	for _, v := range list {
+		c.write(packed, v)
+	}
+	w := gremlin.NewWriter(maxTagSize + len(packed.Bytes()))
+	w.AppendBytes(tag, packed.Bytes())
+	return append(res, w.Bytes()...)
+}
+
+// sortedKeys gives deterministic order of map entries, all keys have the same type
+func sortedKeys(entries map[interface{}]interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		switch a := keys[i].(type) {
+		case bool:
+			return !a && keys[j].(bool)
+		case string:
+			return a < keys[j].(string)
+		case int32:
+			return a < keys[j].(int32)
+		case int64:
+			return a < keys[j].(int64)
+		case uint32:
+			return a < keys[j].(uint32)
+		case uint64:
+			return a < keys[j].(uint64)
+		}
+		return false
+	})
+	return keys
+}
@@ -0,0 +1,174 @@
+package dynamic
+
+import (
+	"octopus/shared/gremlin"
+	"strconv"
+	"strings"
+)
+
+// String formats set fields in protobuf text format, unknown fields are omitted
+func (m *Message) String() string {
+	var sb strings.Builder
+	m.writeText(&sb, "")
+	return sb.String()
+}
+
+// rangeFields calls f for set fields in declaration order, unlike gremlin.RangeFields fields with default values are kept
+func (m *Message) rangeFields(f func(field *gremlin.FieldDescriptor, value interface{})) {
+	for _, field := range m.desc.Fields {
+		if value, ok := m.fields[field.Number]; ok {
+			f(field, value)
+		}
+	}
+}
+
+func (m *Message) writeText(sb *strings.Builder, indent string) {
+	m.rangeFields(func(field *gremlin.FieldDescriptor, value interface{}) {
+		switch {
+		case field.IsMap():
+			entries := value.(map[interface{}]interface{})
+			for _, key := range sortedKeys(entries) {
+				sb.WriteString(indent + field.Name + " {\n")
+				m.writeTextValue(sb, indent+"  ", "key", field.MapKey, "", key)
+				m.writeTextValue(sb, indent+"  ", "value", field.Kind, field.TypeName, entries[key])
+				sb.WriteString(indent + "}\n")
+			}
+		case field.IsList():
+			for _, v := range value.([]interface{}) {
+				m.writeTextValue(sb, indent, field.Name, field.Kind, field.TypeName, v)
+			}
+		default:
+			m.writeTextValue(sb, indent, field.Name, field.Kind, field.TypeName, value)
+		}
+	})
+}
+
+func (m *Message) writeTextValue(sb *strings.Builder, indent string, name string, kind gremlin.FieldKind, typeName string, value interface{}) {
+	if nested, ok := value.(*Message); ok {
+		sb.WriteString(indent + name + " {\n")
+		nested.writeText(sb, indent+"  ")
+		sb.WriteString(indent + "}\n")
+		return
+	}
+
+	sb.WriteString(indent + name + ": ")
+	switch v := value.(type) {
+	case string:
+		sb.WriteString(strconv.Quote(v))
+	case []byte:
+		sb.WriteString(strconv.Quote(string(v)))
+	case float32:
+		sb.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+	case float64:
+		sb.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	case int32:
+		if label := m.enumName(kind, typeName, v); label != "" {
+			sb.WriteString(label)
+		} else {
+			sb.WriteString(strconv.FormatInt(int64(v), 10))
+		}
+	case int64:
+		sb.WriteString(strconv.FormatInt(v, 10))
+	case uint32:
+		sb.WriteString(strconv.FormatUint(uint64(v), 10))
+	case uint64:
+		sb.WriteString(strconv.FormatUint(v, 10))
+	case bool:
+		sb.WriteString(strconv.FormatBool(v))
+	}
+	sb.WriteString("\n")
+}
+
+// enumName returns name of enum value, empty for other kinds and unknown values
+func (m *Message) enumName(kind gremlin.FieldKind, typeName string, value int32) string {
+	if kind != gremlin.KindEnum {
+		return ""
+	}
+	if enum := m.registry.FindEnum(typeName); enum != nil {
+		if v, ok := enum.ValueByNumber(value); ok {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+func (m *Message) MarshalJSON() ([]byte, error) {
+	w := gremlin.NewJsonWriter()
+	m.MarshalJSONTo(w)
+	return w.Bytes(), nil
+}
+
+// MarshalJSONTo writes json in the form of generated structs, well-known types are written as plain messages
+func (m *Message) MarshalJSONTo(w *gremlin.JsonWriter) {
+	w.BeginObject()
+	if m != nil {
+		m.rangeFields(func(field *gremlin.FieldDescriptor, value interface{}) {
+			w.Key(field.JsonName)
+			switch {
+			case field.IsMap():
+				entries := value.(map[interface{}]interface{})
+				w.BeginObject()
+				for _, key := range sortedKeys(entries) {
+					writeJsonKey(w, key)
+					m.writeJsonValue(w, field, entries[key])
+				}
+				w.EndObject()
+			case field.IsList():
+				w.BeginArray()
+				for _, v := range value.([]interface{}) {
+					m.writeJsonValue(w, field, v)
+				}
+				w.EndArray()
+			default:
+				m.writeJsonValue(w, field, value)
+			}
+		})
+	}
+	w.EndObject()
+}
+
+func writeJsonKey(w *gremlin.JsonWriter, key interface{}) {
+	switch k := key.(type) {
+	case string:
+		w.Key(k)
+	case bool:
+		w.KeyBool(k)
+	case int32:
+		w.KeyInt32(k)
+	case int64:
+		w.KeyInt64(k)
+	case uint32:
+		w.KeyUint32(k)
+	case uint64:
+		w.KeyUint64(k)
+	}
+}
+
+func (m *Message) writeJsonValue(w *gremlin.JsonWriter, field *gremlin.FieldDescriptor, value interface{}) {
+	switch v := value.(type) {
+	case *Message:
+		v.MarshalJSONTo(w)
+	case string:
+		w.String(v)
+	case []byte:
+		w.Bytes64(v)
+	case float32:
+		w.Float32(v)
+	case float64:
+		w.Float64(v)
+	case int32:
+		if field.Kind == gremlin.KindEnum {
+			w.Enum(v, m.enumName(field.Kind, field.TypeName, v))
+		} else {
+			w.Int32(v)
+		}
+	case int64:
+		w.Int64(v)
+	case uint32:
+		w.Uint32(v)
+	case uint64:
+		w.Uint64(v)
+	case bool:
+		w.Bool(v)
+	}
+}
@@ -0,0 +1,127 @@
+package dynamic
+
+import (
+	"octopus/shared/gremlin"
+)
+
+// Message is a protobuf message of the type loaded into Registry.
+// Fields hold values in go types of generated struct fields: enums are int32, messages are *Message,
+// lists are []interface{} and maps are map[interface{}]interface{}
+type Message struct {
+	registry *Registry
+	desc     *gremlin.MessageDescriptor
+	fields   map[gremlin.ProtoWireNumber]interface{}
+	unknown  []byte
+}
+
+func (m *Message) XXX_ProtoName() string {
+	return m.desc.Name
+}
+
+func (m *Message) XXX_Descriptor() *gremlin.MessageDescriptor {
+	return m.desc
+}
+
+func (m *Message) XXX_GetField(number gremlin.ProtoWireNumber) (interface{}, bool) {
+	if m == nil || m.desc.FieldByNumber(number) == nil {
+		return nil, false
+	}
+	return m.fields[number], true
+}
+
+func (m *Message) XXX_SetField(number gremlin.ProtoWireNumber, value interface{}) error {
+	field := m.desc.FieldByNumber(number)
+	if field == nil {
+		return gremlin.NewUnknownFieldError(m.desc.Name, number)
+	}
+	if value == nil {
+		delete(m.fields, number)
+		return nil
+	}
+	if !validField(field, value) {
+		return gremlin.NewFieldTypeError(field.Name, fieldTypeName(field), value)
+	}
+	m.set(field, value)
+	return nil
+}
+
+// Get gets field by proto or json name, nil if the field is not set
+func (m *Message) Get(name string) (interface{}, error) {
+	return gremlin.GetField(m, name)
+}
+
+// Set sets field by proto or json name, nil clears the field
+func (m *Message) Set(name string, value interface{}) error {
+	return gremlin.SetField(m, name, value)
+}
+
+// Unknown returns fields which are absent in the descriptor or have unexpected wire type, as they are on the wire
+func (m *Message) Unknown() []byte {
+	return m.unknown
+}
+
+// set keeps only one member of oneof
+func (m *Message) set(field *gremlin.FieldDescriptor, value interface{}) {
+	if field.OneOf != "" {
+		for _, member := range m.desc.Fields {
+			if member.OneOf == field.OneOf {
+				delete(m.fields, member.Number)
+			}
+		}
+	}
+	m.fields[field.Number] = value
+}
+
+func validField(field *gremlin.FieldDescriptor, value interface{}) bool {
+	switch {
+	case field.IsMap():
+		entries, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return false
+		}
+		keyCodec := codecOf(field.MapKey)
+		for k, v := range entries {
+			if !keyCodec.valid(k) || !validValue(field, v) {
+				return false
+			}
+		}
+		return true
+	case field.IsList():
+		list, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, v := range list {
+			if !validValue(field, v) {
+				return false
+			}
+		}
+		return true
+	}
+	return validValue(field, value)
+}
+
+func validValue(field *gremlin.FieldDescriptor, value interface{}) bool {
+	if field.Kind == gremlin.KindMessage {
+		msg, ok := value.(*Message)
+		return ok && msg != nil && msg.desc.Name == field.TypeName
+	}
+	return codecOf(field.Kind).valid(value)
+}
+
+func fieldTypeName(field *gremlin.FieldDescriptor) string {
+	res := field.Kind.String()
+	switch field.Kind {
+	case gremlin.KindMessage:
+		res = "*Message of " + field.TypeName
+	case gremlin.KindEnum:
+		res = "int32 of " + field.TypeName
+	}
+	switch {
+	case field.IsMap():
+		return "map[interface{}]interface{} of " + field.MapKey.String() + " to " + res
+	case field.IsList():
+		return "[]interface{} of " + res
+	}
+	return res
+}
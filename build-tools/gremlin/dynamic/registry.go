@@ -0,0 +1,189 @@
+// Package dynamic decodes and encodes protobuf messages described by .proto files loaded at runtime,
+// without generated code. Files are parsed and resolved the same way gremlin generator does.
+package dynamic
+
+import (
+	"errors"
+	"fmt"
+	"octopus/build-tools/gremlin/internal"
+	"octopus/build-tools/gremlin/internal/types"
+	"octopus/shared/gremlin"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrUnknownType = errors.New("unknown type")
+
+// Registry keeps descriptors loaded from .proto files, it is independent of descriptors registered by generated packages
+type Registry struct {
+	messages map[string]*gremlin.MessageDescriptor
+	enums    map[string]*gremlin.EnumDescriptor
+	// map fields are decoded as messages of key and value
+	entries map[*gremlin.FieldDescriptor]*gremlin.MessageDescriptor
+}
+
+// LoadProtoFiles loads all .proto files under root, imports are resolved relative to root subfolders
+func LoadProtoFiles(root string) (*Registry, error) {
+	// base folders of imports are resolved from absolute paths
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(root); err != nil {
+		return nil, err
+	}
+	files, err := internal.FindAllProtobufFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no proto files found in %v", root)
+	}
+	if err = internal.ParseProtoFiles(files); err != nil {
+		return nil, err
+	}
+	if errs := internal.ParseStruct(files); len(errs) > 0 {
+		return nil, joinErrors(errs)
+	}
+	if errs := internal.ResolveImportsAndReferences(files); len(errs) > 0 {
+		return nil, joinErrors(errs)
+	}
+
+	res := &Registry{
+		messages: map[string]*gremlin.MessageDescriptor{},
+		enums:    map[string]*gremlin.EnumDescriptor{},
+		entries:  map[*gremlin.FieldDescriptor]*gremlin.MessageDescriptor{},
+	}
+	for _, file := range files {
+		res.addFile(file)
+	}
+	return res, nil
+}
+
+func joinErrors(errs []error) error {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return errors.New(strings.Join(messages, "\n"))
+}
+
+func (r *Registry) addFile(file *types.ProtoFile) {
+	for _, enum := range file.Enums {
+		desc := &gremlin.EnumDescriptor{Name: enum.Name.String()}
+		for _, value := range enum.Values {
+			desc.Values = append(desc.Values, gremlin.EnumValueDescriptor{Name: value.Name.ProtoName(), Number: int32(value.Value)})
+		}
+		r.enums[desc.Name] = desc
+	}
+
+	for _, msg := range file.Messages {
+		desc := &gremlin.MessageDescriptor{Name: msg.Name.String()}
+		for _, field := range msg.Fields {
+			fieldDesc := newFieldDescriptor(field)
+			desc.Fields = append(desc.Fields, fieldDesc)
+			if fieldDesc.OneOf != "" && (len(desc.OneOfs) == 0 || desc.OneOfs[len(desc.OneOfs)-1] != fieldDesc.OneOf) {
+				desc.OneOfs = append(desc.OneOfs, fieldDesc.OneOf)
+			}
+			if fieldDesc.IsMap() {
+				r.entries[fieldDesc] = newEntryDescriptor(desc, fieldDesc)
+			}
+		}
+		for _, nested := range file.Messages {
+			if nested.Name.ToParent().String() == desc.Name {
+				desc.NestedMessages = append(desc.NestedMessages, nested.Name.String())
+			}
+		}
+		for _, nested := range file.Enums {
+			if nested.Name.ToParent().String() == desc.Name {
+				desc.NestedEnums = append(desc.NestedEnums, nested.Name.String())
+			}
+		}
+		r.messages[desc.Name] = desc
+	}
+}
+
+func newFieldDescriptor(field *types.MessageFieldDefinition) *gremlin.FieldDescriptor {
+	res := &gremlin.FieldDescriptor{
+		Name:     field.Name.ProtoName(),
+		JsonName: field.JsonName(),
+		Number:   gremlin.ProtoWireNumber(field.ProtoDef.Sequence),
+		OneOf:    field.OneOfGroup,
+	}
+
+	switch {
+	case field.ScalarValueType != "":
+		res.Kind = gremlin.KindByProtoType(field.ScalarValueType)
+	case field.LocalEnumType != nil:
+		res.Kind, res.TypeName = gremlin.KindEnum, field.LocalEnumType.Name.String()
+	case field.ExternalEnumType != nil:
+		res.Kind, res.TypeName = gremlin.KindEnum, field.ExternalEnumType.Name.String()
+	case field.LocalMsgType != nil:
+		res.Kind, res.TypeName = gremlin.KindMessage, field.LocalMsgType.Name.String()
+	case field.ExternalMsgType != nil:
+		res.Kind, res.TypeName = gremlin.KindMessage, field.ExternalMsgType.Name.String()
+	}
+
+	switch {
+	case field.Map:
+		res.Label, res.MapKey = gremlin.LabelRepeated, gremlin.KindByProtoType(field.MapKeyType)
+	case field.Repeated:
+		res.Label = gremlin.LabelRepeated
+	case field.Required:
+		res.Label = gremlin.LabelRequired
+	}
+
+	if field.DefaultValue != nil {
+		res.Default = field.DefaultValue.Constant.Source
+	}
+	return res
+}
+
+func newEntryDescriptor(msg *gremlin.MessageDescriptor, field *gremlin.FieldDescriptor) *gremlin.MessageDescriptor {
+	return &gremlin.MessageDescriptor{
+		Name: msg.Name + "." + field.Name + "Entry",
+		Fields: []*gremlin.FieldDescriptor{
+			{Name: "key", JsonName: "key", Number: 1, Kind: field.MapKey},
+			{Name: "value", JsonName: "value", Number: 2, Kind: field.Kind, TypeName: field.TypeName},
+		},
+	}
+}
+
+// FindMessage returns descriptor by full proto name, nil if it is not loaded
+func (r *Registry) FindMessage(name string) *gremlin.MessageDescriptor {
+	return r.messages[name]
+}
+
+// FindEnum returns descriptor by full proto name, nil if it is not loaded
+func (r *Registry) FindEnum(name string) *gremlin.EnumDescriptor {
+	return r.enums[name]
+}
+
+// MessageNames lists full names of all loaded messages in sorted order
+func (r *Registry) MessageNames() []string {
+	res := make([]string, 0, len(r.messages))
+	for name := range r.messages {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// NewMessage creates empty message of the loaded type
+func (r *Registry) NewMessage(name string) (*Message, error) {
+	desc := r.FindMessage(name)
+	if desc == nil {
+		return nil, fmt.Errorf("%w: message %v", ErrUnknownType, name)
+	}
+	return r.newMessage(desc), nil
+}
+
+func (r *Registry) newMessage(desc *gremlin.MessageDescriptor) *Message {
+	return &Message{
+		registry: r,
+		desc:     desc,
+		fields:   map[gremlin.ProtoWireNumber]interface{}{},
+	}
+}
@@ -19,13 +19,23 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/google/go-cmp/cmp"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"octopus/build-tools/gremlin/testdata"
+	"octopus/shared/gremlin"
 	"octopus/target/generated-sources/protobuf/gremlin/map_test"
 	"octopus/target/generated-sources/protobuf/gremlin/protobuf_unittest"
 	"octopus/target/generated-sources/protobuf/gremlin/protobuf_unittest_import"
 	"octopus/target/generated-sources/protobuf/gremlin/test"
+	"octopus/target/generated-sources/protobuf/gremlin/wellknown_test"
+	"octopus/target/generated-sources/protobuf/wellknown"
 	"testing"
+	"time"
 )
 
 func TestGoldenMessage(t *testing.T) {
@@ -375,16 +385,21 @@ func checkParsedGoldenMessage(t *testing.T, parsed *protobuf_unittest.TestAllTyp
 		t.Errorf("default_cord: got %v, want %v", parsed.GetDefaultCord(), "425")
 	}
 
-	if parsed.GetOneofUint32() != 601 {
-		t.Errorf("oneof_uint32: got %v, want %v", parsed.GetOneofUint32(), 601)
+	// all oneof members are in the golden message, the last one wins
+	if parsed.WhichOneofField() != protobuf_unittest.TestAllTypes_OneofFieldCase_OneofBytes {
+		t.Errorf("oneof_field: got %v, want %v", parsed.WhichOneofField(), protobuf_unittest.TestAllTypes_OneofFieldCase_OneofBytes)
 	}
 
-	if parsed.GetOneofNestedMessage().GetBb() != 602 {
-		t.Errorf("oneof_nested_message: got %v, want %v", parsed.GetOneofNestedMessage().GetBb(), 602)
+	if parsed.GetOneofUint32() != 0 {
+		t.Errorf("oneof_uint32: got %v, want %v", parsed.GetOneofUint32(), 0)
 	}
 
-	if parsed.GetOneofString() != "603" {
-		t.Errorf("oneof_string: got %v, want %v", parsed.GetOneofString(), "603")
+	if parsed.GetOneofNestedMessage() != nil {
+		t.Errorf("oneof_nested_message: got %v, want %v", parsed.GetOneofNestedMessage(), nil)
+	}
+
+	if parsed.GetOneofString() != "" {
+		t.Errorf("oneof_string: got %v, want %v", parsed.GetOneofString(), "")
 	}
 
 	if !cmp.Equal(parsed.GetOneofBytes(), []byte("604")) {
@@ -574,12 +589,7 @@ func TestReadWrite(t *testing.T) {
 		DefaultImportEnum:   protobuf_unittest_import.ImportEnum_IMPORT_FOO,
 		DefaultStringPiece:  "424",
 		DefaultCord:         "425",
-		OneofUint32:         601,
-		OneofNestedMessage: &protobuf_unittest.TestAllTypes_NestedMessage{
-			Bb: 602,
-		},
-		OneofString: "603",
-		OneofBytes:  []byte("604"),
+		OneofField:          &protobuf_unittest.TestAllTypes_OneofBytes{OneofBytes: []byte("604")},
 	}
 
 	content := msg.Marshal()
@@ -890,3 +900,743 @@ func TestBasicValues(t *testing.T) {
 		t.Fatalf("Expected %v, got %v", msg.Field15, parsed.GetField15())
 	}
 }
+
+func TestOneOf(t *testing.T) {
+	msg := &protobuf_unittest.TestOneof2{
+		Foo: &protobuf_unittest.TestOneof2_FooInt{FooInt: 0},
+		Bar: &protobuf_unittest.TestOneof2_BarString{BarString: "bar"},
+	}
+
+	parsed := protobuf_unittest.NewTestOneof2Reader()
+	if err := parsed.Unmarshal(msg.Marshal()); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	// member set to default value is still set
+	if parsed.WhichFoo() != protobuf_unittest.TestOneof2_FooCase_FooInt {
+		t.Errorf("foo: got %v, want %v", parsed.WhichFoo(), protobuf_unittest.TestOneof2_FooCase_FooInt)
+	}
+	if parsed.WhichBar() != protobuf_unittest.TestOneof2_BarCase_BarString || parsed.GetBarString() != "bar" {
+		t.Errorf("bar: got %v %q", parsed.WhichBar(), parsed.GetBarString())
+	}
+	// not set members give their defaults
+	if parsed.GetBarInt() != 5 {
+		t.Errorf("bar_int: got %v, want %v", parsed.GetBarInt(), 5)
+	}
+	if !cmp.Equal(parsed.ToStruct(), msg) {
+		t.Errorf("ToStruct: %v", cmp.Diff(msg, parsed.ToStruct()))
+	}
+
+	// the last member on the wire wins
+	next := &protobuf_unittest.TestOneof2{
+		Foo: &protobuf_unittest.TestOneof2_FooMessage{
+			FooMessage: &protobuf_unittest.TestOneof2_NestedMessage{MooInt: 7},
+		},
+	}
+	parsed = protobuf_unittest.NewTestOneof2Reader()
+	if err := parsed.Unmarshal(append(msg.Marshal(), next.Marshal()...)); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if parsed.WhichFoo() != protobuf_unittest.TestOneof2_FooCase_FooMessage || parsed.GetFooMessage().GetMooInt() != 7 {
+		t.Errorf("foo: got %v %v", parsed.WhichFoo(), parsed.GetFooMessage().ToStruct())
+	}
+	expected := &protobuf_unittest.TestOneof2{Foo: next.Foo, Bar: msg.Bar}
+	if !cmp.Equal(parsed.ToStruct(), expected) {
+		t.Errorf("ToStruct: %v", cmp.Diff(expected, parsed.ToStruct()))
+	}
+
+	copied := expected.Copy()
+	copied.Foo.(*protobuf_unittest.TestOneof2_FooMessage).FooMessage.MooInt = 8
+	if next.Foo.(*protobuf_unittest.TestOneof2_FooMessage).FooMessage.MooInt != 7 {
+		t.Errorf("Copy shares oneof member")
+	}
+
+	parsed = protobuf_unittest.NewTestOneof2Reader()
+	if err := parsed.Unmarshal((&protobuf_unittest.TestOneof2{}).Marshal()); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if parsed.WhichFoo() != protobuf_unittest.TestOneof2_FooCase_NotSet || parsed.ToStruct().Foo != nil {
+		t.Errorf("foo: got %v, want not set", parsed.WhichFoo())
+	}
+}
+
+func TestJson(t *testing.T) {
+	// proto2 fields with defaults are skipped only when they hold their defaults
+	empty := protobuf_unittest.NewTestAllTypesReader()
+	if err := empty.Unmarshal(nil); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	msg := empty.ToStruct()
+	msg.OptionalInt64 = -5
+	msg.OptionalUint64 = 7
+	msg.OptionalBytes = []byte{0xfb, 0xff}
+	msg.OptionalString = "a\"b"
+	msg.OptionalNestedEnum = protobuf_unittest.TestAllTypes_BAR
+	msg.RepeatedInt32 = []int32{1, 2}
+	msg.OneofField = &protobuf_unittest.TestAllTypes_OneofUint32{OneofUint32: 0}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := `{"optionalInt64":"-5","optionalUint64":"7","optionalString":"a\"b","optionalBytes":"+/8=","optionalNestedEnum":"BAR","repeatedInt32":[1,2],"oneofUint32":0}`
+	if string(data) != expected {
+		t.Errorf("json: got %s, want %s", data, expected)
+	}
+
+	parsed := &protobuf_unittest.TestAllTypes{}
+	if err := json.Unmarshal(data, parsed); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !cmp.Equal(parsed, msg) {
+		t.Errorf("round trip: %v", cmp.Diff(msg, parsed))
+	}
+
+	reader := protobuf_unittest.NewTestAllTypesReader()
+	if err := reader.Unmarshal(msg.Marshal()); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	readerData, err := json.Marshal(reader)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	structData, _ := json.Marshal(reader.ToStruct())
+	if string(readerData) != string(structData) {
+		t.Errorf("reader json: got %s, want %s", readerData, structData)
+	}
+
+	// original names, numbers and numeric enums are accepted too
+	parsed = &protobuf_unittest.TestAllTypes{}
+	err = json.Unmarshal([]byte(`{"optional_int64":-5,"optionalUint64":7,"optional_nested_enum":2,"unknown":{},"optionalInt32":null}`), parsed)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if parsed.OptionalInt64 != -5 || parsed.OptionalUint64 != 7 || parsed.OptionalNestedEnum != protobuf_unittest.TestAllTypes_BAR {
+		t.Errorf("parsed: %+v", parsed)
+	}
+
+	err = json.Unmarshal([]byte(`{"oneofUint32":1,"oneof_string":"x"}`), parsed)
+	if err == nil {
+		t.Errorf("duplicate oneof members are accepted")
+	}
+	err = json.Unmarshal([]byte(`{"optionalNestedEnum":"NOPE"}`), parsed)
+	if err == nil {
+		t.Errorf("unknown enum name is accepted")
+	}
+}
+
+func TestJsonGolden(t *testing.T) {
+	reader := protobuf_unittest.NewTestAllTypesReader()
+	if err := reader.Unmarshal(getTestFileContent("golden_message")); err != nil {
+		t.Fatalf("failed to unmarshal golden message: %v", err)
+	}
+	data, err := json.Marshal(reader)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	parsed := &protobuf_unittest.TestAllTypes{}
+	if err := json.Unmarshal(data, parsed); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	// groups of the golden message are unknown fields, json has no form for them
+	expected := reader.ToStruct()
+	expected.DiscardUnknown()
+	if !cmp.Equal(parsed, expected) {
+		t.Errorf("round trip: %v", cmp.Diff(expected, parsed))
+	}
+}
+
+func TestJsonMap(t *testing.T) {
+	msg := &map_test.TestMap{
+		Int32ToEnumField:    map[int32]map_test.TestMap_EnumValue{3: map_test.TestMap_BAR, -1: map_test.TestMap_FOO},
+		Int32ToMessageField: map[int32]*map_test.TestMap_MessageValue{4: {Value: 4}},
+		StringToInt32Field:  map[string]int32{"b": 2, "a": 1},
+		Int64ToInt32Field:   map[int64]int32{10: 1, 9: 2},
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := `{"int32ToEnumField":{"-1":"FOO","3":"BAR"},"int32ToMessageField":{"4":{"value":4}},"stringToInt32Field":{"a":1,"b":2},"int64ToInt32Field":{"9":2,"10":1}}`
+	if string(data) != expected {
+		t.Errorf("json: got %s, want %s", data, expected)
+	}
+
+	parsed := &map_test.TestMap{}
+	if err := json.Unmarshal(data, parsed); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !cmp.Equal(parsed, msg) {
+		t.Errorf("round trip: %v", cmp.Diff(msg, parsed))
+	}
+}
+
+type testService struct {
+	foo int
+}
+
+func (s *testService) Foo(context.Context, *protobuf_unittest.FooRequestReader) (*protobuf_unittest.FooResponse, error) {
+	s.foo++
+	return &protobuf_unittest.FooResponse{}, nil
+}
+
+func (s *testService) Bar(context.Context, *protobuf_unittest.BarRequestReader) (*protobuf_unittest.BarResponse, error) {
+	return nil, gremlin.NewRpcError(http.StatusConflict, "bar is busy")
+}
+
+func TestService(t *testing.T) {
+	service := &testService{}
+	server := httptest.NewServer(gremlin.NewHttpRpcServer(protobuf_unittest.NewTestServiceHandler(service)))
+	defer server.Close()
+
+	client := protobuf_unittest.NewTestServiceClient(gremlin.NewHttpRpcTransport(server.URL, server.Client()))
+	if _, err := client.Foo(context.Background(), &protobuf_unittest.FooRequest{}); err != nil {
+		t.Fatalf("Foo failed: %v", err)
+	}
+	if service.foo != 1 {
+		t.Errorf("Foo calls: got %v, want 1", service.foo)
+	}
+
+	_, err := client.Bar(context.Background(), &protobuf_unittest.BarRequest{})
+	var rpcErr *gremlin.RpcError
+	if !errors.As(err, &rpcErr) || rpcErr.Status != http.StatusConflict || rpcErr.Message != "bar is busy" {
+		t.Errorf("Bar: got %v, want conflict", err)
+	}
+
+	// truncated request is rejected before it gets to the server
+	transport := gremlin.NewHttpRpcTransport(server.URL, server.Client())
+	_, err = transport.Call(context.Background(), protobuf_unittest.TestServiceName, "Foo", []byte{0x0a, 0x05})
+	if !errors.As(err, &rpcErr) || rpcErr.Status != http.StatusBadRequest || service.foo != 1 {
+		t.Errorf("truncated Foo: got %v, want bad request", err)
+	}
+
+	// truncated reply fails the call rather than panics in getters
+	truncated := protobuf_unittest.NewTestServiceClient(truncatingTransport{})
+	if _, err = truncated.Foo(context.Background(), &protobuf_unittest.FooRequest{}); err == nil {
+		t.Errorf("truncated reply: no error")
+	}
+}
+
+type truncatingTransport struct{}
+
+func (truncatingTransport) Call(context.Context, string, string, []byte) ([]byte, error) {
+	return []byte{0x0a, 0x05}, nil
+}
+
+func TestWellKnownTypes(t *testing.T) {
+	created := time.Date(2022, 10, 13, 12, 30, 0, 5000000, time.UTC)
+	msg := &wellknown_test.WellKnownTypes{
+		Created: wellknown.NewTimestamp(created),
+		Ttl:     wellknown.NewDuration(-1500 * time.Millisecond),
+		Payload: wellknown.NewAny(&protobuf_unittest.ForeignMessage{C: 7}),
+		Attributes: &wellknown.Struct{Fields: map[string]*wellknown.Value{
+			"n":    {Kind: &wellknown.Value_NumberValue{NumberValue: 1.5}},
+			"list": {Kind: &wellknown.Value_ListValue{ListValue: &wellknown.ListValue{Values: []*wellknown.Value{{}, {Kind: &wellknown.Value_BoolValue{BoolValue: true}}}}}},
+		}},
+		Limit: &wellknown.Int64Value{Value: 10},
+		Name:  &wellknown.StringValue{},
+		Empty: &wellknown.Empty{},
+		Mask:  wellknown.NewFieldMask("optional_foreign_message.c", "repeated_int32"),
+	}
+
+	parsed := wellknown_test.NewWellKnownTypesReader()
+	if err := parsed.Unmarshal(msg.Marshal()); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if !parsed.GetCreated().AsTime().Equal(created) || parsed.GetTtl().AsDuration() != -1500*time.Millisecond {
+		t.Errorf("time: got %v %v", parsed.GetCreated().AsTime(), parsed.GetTtl().AsDuration())
+	}
+
+	foreign := protobuf_unittest.NewForeignMessageReader()
+	if err := parsed.GetPayload().UnmarshalTo(foreign); err != nil || foreign.GetC() != 7 {
+		t.Errorf("any: got %v %v", foreign.GetC(), err)
+	}
+	if err := parsed.GetPayload().UnmarshalTo(protobuf_unittest.NewFooRequestReader()); !errors.Is(err, gremlin.ErrAnyTypeMismatch) {
+		t.Errorf("any: got %v, want type mismatch", err)
+	}
+	if mask := parsed.GetMask().Mask(); !mask.Has("repeated_int32") || !mask.Sub("optional_foreign_message").Has("c") {
+		t.Errorf("mask: got %v", mask)
+	}
+
+	data, err := json.Marshal(parsed)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := `{"created":"2022-10-13T12:30:00.005Z","ttl":"-1.500s","payload":{"@type":"type.googleapis.com/protobuf_unittest.ForeignMessage","c":7},` +
+		`"attributes":{"list":[null,true],"n":1.5},"limit":"10","name":"","empty":{},"mask":"optionalForeignMessage.c,repeatedInt32"}`
+	if string(data) != expected {
+		t.Errorf("json: got %s, want %s", data, expected)
+	}
+
+	fromJson := &wellknown_test.WellKnownTypes{}
+	if err := json.Unmarshal(data, fromJson); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	// null list value is parsed as explicit NullValue
+	msg.Attributes.Fields["list"].Kind.(*wellknown.Value_ListValue).ListValue.Values[0] = &wellknown.Value{Kind: &wellknown.Value_NullValue{}}
+	if !cmp.Equal(fromJson, msg) {
+		t.Errorf("round trip: %v", cmp.Diff(msg, fromJson))
+	}
+
+	// well-known types with special json form are packed as value of Any
+	wrapped := &wellknown_test.WellKnownTypes{Payload: wellknown.NewAny(wellknown.NewDuration(time.Second))}
+	if data, err = json.Marshal(wrapped); err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if expected = `{"payload":{"@type":"type.googleapis.com/google.protobuf.Duration","value":"1s"}}`; string(data) != expected {
+		t.Errorf("json: got %s, want %s", data, expected)
+	}
+	fromJson = &wellknown_test.WellKnownTypes{}
+	if err = json.Unmarshal(data, fromJson); err != nil || !cmp.Equal(fromJson, wrapped) {
+		t.Errorf("round trip: %v %v", err, cmp.Diff(wrapped, fromJson))
+	}
+
+	unknown := &wellknown_test.WellKnownTypes{Payload: &wellknown.Any{TypeUrl: "type.googleapis.com/unknown.Message"}}
+	if _, err = json.Marshal(unknown); err == nil {
+		t.Errorf("any of unknown type is marshalled")
+	}
+	if err = json.Unmarshal([]byte(`{"payload":{"@type":"type.googleapis.com/unknown.Message"}}`), fromJson); err == nil {
+		t.Errorf("any of unknown type is unmarshalled")
+	}
+}
+
+func TestEmptySubMessages(t *testing.T) {
+	// present empty well-known values are read as they are
+	wk := wellknown_test.NewWellKnownTypesReader()
+	if err := wk.Unmarshal((&wellknown_test.WellKnownTypes{Name: &wellknown.StringValue{}, Empty: &wellknown.Empty{}}).Marshal()); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if wk.GetName() == nil || wk.GetEmpty() == nil || wk.GetLimit() != nil {
+		t.Errorf("well-known: got name %v, empty %v, limit %v", wk.GetName(), wk.GetEmpty(), wk.GetLimit())
+	}
+
+	// while empty fields of other messages are read as absent ones
+	msg := protobuf_unittest.NewTestAllTypesReader()
+	if err := msg.Unmarshal((&protobuf_unittest.TestAllTypes{OptionalForeignMessage: &protobuf_unittest.ForeignMessage{}}).Marshal()); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if msg.GetOptionalForeignMessage() != nil {
+		t.Errorf("empty message: got %v", msg.GetOptionalForeignMessage())
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	msg := &protobuf_unittest.TestAllTypes{
+		OptionalInt32:          5,
+		OptionalForeignMessage: &protobuf_unittest.ForeignMessage{C: 7},
+		RepeatedForeignMessage: []*protobuf_unittest.ForeignMessage{{C: 1}},
+	}
+	data := msg.Marshal()
+
+	// every field is unknown to an empty message, still nothing is lost
+	empty := wellknown.NewEmptyReader()
+	if err := empty.Unmarshal(data); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if !bytes.Equal(empty.UnknownFields(), data) || !bytes.Equal(empty.ToStruct().Marshal(), data) {
+		t.Errorf("empty: got %v, want %v", empty.ToStruct().Marshal(), data)
+	}
+
+	w := gremlin.NewWriter(32)
+	w.AppendInt32(1, 7)
+	w.AppendString(100, "from the future")
+	foreign := protobuf_unittest.NewForeignMessageReader()
+	if err := foreign.Unmarshal(w.Bytes()); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	unknown := foreign.ToStruct().XXX_unrecognized
+	if foreign.GetC() != 7 || len(unknown) == 0 {
+		t.Fatalf("foreign: got %v, unknown %v", foreign.GetC(), unknown)
+	}
+
+	msg.OptionalForeignMessage = foreign.ToStruct()
+	msg.RepeatedForeignMessage = []*protobuf_unittest.ForeignMessage{foreign.ToStruct().Copy()}
+	parsed := protobuf_unittest.NewTestAllTypesReader()
+	if err := parsed.Unmarshal(msg.Marshal()); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	res := parsed.ToStruct()
+	if !bytes.Equal(res.OptionalForeignMessage.XXX_unrecognized, unknown) || !bytes.Equal(res.RepeatedForeignMessage[0].XXX_unrecognized, unknown) {
+		t.Errorf("nested: got %v", res.OptionalForeignMessage.XXX_unrecognized)
+	}
+	if !bytes.Equal(res.Marshal(), msg.Marshal()) {
+		t.Errorf("round trip: got %v, want %v", res.Marshal(), msg.Marshal())
+	}
+
+	res.DiscardUnknown()
+	if res.OptionalForeignMessage.XXX_unrecognized != nil || res.RepeatedForeignMessage[0].XXX_unrecognized != nil {
+		t.Errorf("discard: unknown fields left")
+	}
+	if got := res.OptionalForeignMessage.Marshal(); !bytes.Equal(got, (&protobuf_unittest.ForeignMessage{C: 7}).Marshal()) {
+		t.Errorf("discard: got %v", got)
+	}
+
+	// reused reader keeps no unknown fields of the previous message
+	if err := foreign.Unmarshal((&protobuf_unittest.ForeignMessage{C: 8}).Marshal()); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if got := foreign.UnknownFields(); len(got) != 0 {
+		t.Errorf("reused reader: got unknown %v", got)
+	}
+
+	// copy doesn't share unknown fields with the source
+	src := &protobuf_unittest.ForeignMessage{C: 7, XXX_unrecognized: append(make([]byte, 0, 64), unknown...)}
+	copied := src.Copy()
+	src.Merge(&protobuf_unittest.ForeignMessage{XXX_unrecognized: unknown})
+	copied.Merge(&protobuf_unittest.ForeignMessage{XXX_unrecognized: []byte{0x08, 0x09}})
+	if !bytes.Equal(src.XXX_unrecognized, append(append([]byte{}, unknown...), unknown...)) {
+		t.Errorf("copy: source unknown fields changed to %v", src.XXX_unrecognized)
+	}
+}
+
+func TestSafeUnmarshal(t *testing.T) {
+	msg := &protobuf_unittest.NestedTestAllTypes{Payload: &protobuf_unittest.TestAllTypes{OptionalInt32: 1}}
+	for i := 0; i < 4; i++ {
+		msg = &protobuf_unittest.NestedTestAllTypes{Child: msg}
+	}
+	data := msg.Marshal()
+
+	if err := protobuf_unittest.NewNestedTestAllTypesReader().SafeUnmarshal(data, gremlin.DefaultDecodeOptions); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if err := protobuf_unittest.NewNestedTestAllTypesReader().SafeUnmarshal(data, gremlin.DecodeOptions{MaxDepth: 5}); !errors.Is(err, gremlin.ErrMaxDepth) {
+		t.Errorf("depth: got %v, want max depth", err)
+	}
+	if err := protobuf_unittest.NewNestedTestAllTypesReader().SafeUnmarshal(data, gremlin.DecodeOptions{MaxSize: len(data) - 1}); !errors.Is(err, gremlin.ErrMaxSize) {
+		t.Errorf("size: got %v, want max size", err)
+	}
+
+	// broken child is found upfront, plain Unmarshal leaves it to the lazy getter
+	payload := (&protobuf_unittest.TestAllTypes{OptionalString: "truncated"}).Marshal()
+	payload[bytes.Index(payload, []byte("truncated"))-1] = 100
+	w := gremlin.NewWriter(len(payload) + 8)
+	w.AppendBytes(2, payload) // payload
+	if err := protobuf_unittest.NewNestedTestAllTypesReader().Unmarshal(w.Bytes()); err != nil {
+		t.Errorf("unmarshal: got %v", err)
+	}
+	if err := protobuf_unittest.NewNestedTestAllTypesReader().SafeUnmarshal(w.Bytes(), gremlin.DefaultDecodeOptions); !errors.Is(err, gremlin.ErrTruncated) {
+		t.Errorf("safe unmarshal: got %v, want truncated", err)
+	}
+
+	// known field of unexpected wire type can't be read, so it is kept as unknown
+	w = gremlin.NewWriter(16)
+	w.AppendInt32(14, 1) // optional_string
+	reader := protobuf_unittest.NewTestAllTypesReader()
+	if err := reader.SafeUnmarshal(w.Bytes(), gremlin.DefaultDecodeOptions); err != nil || reader.GetOptionalString() != "" || !bytes.Equal(reader.UnknownFields(), w.Bytes()) {
+		t.Errorf("wire type: got %q, unknown %v, err %v", reader.GetOptionalString(), reader.UnknownFields(), err)
+	}
+}
+
+func FuzzSafeUnmarshal(f *testing.F) {
+	f.Add(getTestFileContent("golden_message"))
+	f.Add(getTestFileContent("map_test"))
+	f.Add((&protobuf_unittest.TestPackedTypes{
+		PackedInt32:   []int32{1, -1},
+		PackedFixed64: []uint64{2},
+		PackedDouble:  []float64{1.5},
+		PackedEnum:    []protobuf_unittest.ForeignEnum{protobuf_unittest.ForeignEnum_FOREIGN_BAR},
+	}).Marshal())
+	f.Add((&protobuf_unittest.NestedTestAllTypes{
+		Child:         &protobuf_unittest.NestedTestAllTypes{Payload: &protobuf_unittest.TestAllTypes{OptionalInt32: 1}},
+		RepeatedChild: []*protobuf_unittest.NestedTestAllTypes{{}},
+	}).Marshal())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzReader(t, data, protobuf_unittest.NewTestAllTypesReader, func(r *protobuf_unittest.TestAllTypesReader) gremlin.ProtoWriter { return r.ToStruct() })
+		fuzzReader(t, data, protobuf_unittest.NewTestPackedTypesReader, func(r *protobuf_unittest.TestPackedTypesReader) gremlin.ProtoWriter { return r.ToStruct() })
+		fuzzReader(t, data, protobuf_unittest.NewNestedTestAllTypesReader, func(r *protobuf_unittest.NestedTestAllTypesReader) gremlin.ProtoWriter { return r.ToStruct() })
+		fuzzReader(t, data, map_test.NewTestMapReader, func(r *map_test.TestMapReader) gremlin.ProtoWriter { return r.ToStruct() })
+	})
+}
+
+// fuzzReader reads everything accepted by SafeUnmarshal, it should neither panic nor produce data it can't read back
+func fuzzReader[R gremlin.ProtoSafeReader](t *testing.T, data []byte, newReader func() R, toStruct func(R) gremlin.ProtoWriter) {
+	reader := newReader()
+	if err := reader.SafeUnmarshal(data, gremlin.DefaultDecodeOptions); err != nil {
+		return
+	}
+	_, _ = json.Marshal(reader)
+	written := toStruct(reader).Marshal()
+	if err := newReader().SafeUnmarshal(written, gremlin.DefaultDecodeOptions); err != nil {
+		t.Errorf("written data can't be read back: %v", err)
+	}
+}
+
+func TestReflection(t *testing.T) {
+	desc := gremlin.FindMessageDescriptor("protobuf_unittest.TestAllTypes")
+	if desc == nil || desc != (&protobuf_unittest.TestAllTypes{}).XXX_Descriptor() || desc != protobuf_unittest.NewTestAllTypesReader().XXX_Descriptor() {
+		t.Fatalf("descriptor is not registered")
+	}
+	field := desc.FieldByName("optionalNestedMessage")
+	if field == nil || field.Number != 18 || field.Kind != gremlin.KindMessage || field.Message() == nil || field.Message().Fields[0].Name != "bb" {
+		t.Errorf("message field: %+v", field)
+	}
+	if field = desc.FieldByNumber(51); field == nil || field.Name != "repeated_nested_enum" || !field.IsList() || field.Enum() == nil {
+		t.Errorf("enum field: %+v", field)
+	}
+	if value, ok := field.Enum().ValueByNumber(2); !ok || value.Name != "BAR" {
+		t.Errorf("enum value: %+v", value)
+	}
+	if desc.FieldByName("default_int32").Default != "41" || desc.FieldByName("oneof_string").OneOf != "oneof_field" {
+		t.Errorf("default and oneof are not described")
+	}
+	if len(desc.NestedMessages) == 0 || desc.NestedMessages[0] != "protobuf_unittest.TestAllTypes.NestedMessage" {
+		t.Errorf("nested: %v", desc.NestedMessages)
+	}
+	if mapField := map_test.NewTestMapReader().XXX_Descriptor().FieldByName("int32_to_message_field"); !mapField.IsMap() || mapField.MapKey != gremlin.KindInt32 || mapField.Kind != gremlin.KindMessage {
+		t.Errorf("map field: %+v", mapField)
+	}
+
+	msg := &protobuf_unittest.TestAllTypes{}
+	if err := gremlin.SetField(msg, "optional_string", "hello"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := gremlin.SetField(msg, "optionalNestedMessage", &protobuf_unittest.TestAllTypes_NestedMessage{Bb: 3}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := gremlin.SetField(msg, "oneof_uint32", uint32(0)); err != nil {
+		t.Fatalf("set oneof: %v", err)
+	}
+	if err := gremlin.SetField(msg, "optional_int32", "wrong"); !errors.Is(err, gremlin.ErrFieldType) {
+		t.Errorf("set wrong type: got %v", err)
+	}
+	if err := gremlin.SetField(msg, "missing", 1); !errors.Is(err, gremlin.ErrUnknownField) {
+		t.Errorf("set missing: got %v", err)
+	}
+	if msg.OptionalString != "hello" || msg.OptionalNestedMessage.Bb != 3 || msg.OneofField.(*protobuf_unittest.TestAllTypes_OneofUint32).OneofUint32 != 0 {
+		t.Errorf("set: %+v", msg)
+	}
+
+	reader := protobuf_unittest.NewTestAllTypesReader()
+	if err := reader.Unmarshal(msg.Marshal()); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	for _, m := range []gremlin.Reflectable{msg, reader} {
+		var names []string
+		gremlin.RangeFields(m, func(field *gremlin.FieldDescriptor, value interface{}) bool {
+			names = append(names, field.Name)
+			return true
+		})
+		if !cmp.Equal(names, []string{"optional_string", "optional_nested_message", "oneof_uint32"}) {
+			t.Errorf("%T fields: %v", m, names)
+		}
+	}
+	nested, err := gremlin.GetField(reader, "optional_nested_message")
+	if err != nil || nested.(*protobuf_unittest.TestAllTypes_NestedMessageReader).GetBb() != 3 {
+		t.Errorf("get nested: %v %v", nested, err)
+	}
+
+	if err := gremlin.SetField(msg, "oneof_uint32", nil); err != nil || msg.OneofField != nil {
+		t.Errorf("clear oneof: %v %v", msg.OneofField, err)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	reader := protobuf_unittest.NewTestAllTypesReader()
+	if err := reader.Unmarshal(getTestFileContent("golden_message")); err != nil {
+		t.Fatalf("failed to unmarshal golden message: %v", err)
+	}
+	a, b := reader.ToStruct(), reader.ToStruct()
+	if !a.Equal(b) || !a.Equal(a.Copy()) || len(a.Diff(b)) != 0 {
+		t.Errorf("golden message should be equal to its copy: %v", a.Diff(b))
+	}
+
+	b.OptionalNestedMessage.Bb++
+	b.RepeatedInt32 = b.RepeatedInt32[:1]
+	b.OneofField = &protobuf_unittest.TestAllTypes_OneofUint32{}
+	if a.Equal(b) {
+		t.Errorf("changed message should not be equal")
+	}
+	var paths []string
+	for _, diff := range a.Diff(b) {
+		paths = append(paths, diff.Path)
+	}
+	expected := []string{"optional_nested_message.bb", "repeated_int32[1]", "oneof_uint32", "oneof_bytes"}
+	if !cmp.Equal(paths, expected) {
+		t.Errorf("diff paths: got %v, want %v", paths, expected)
+	}
+
+	nan := &protobuf_unittest.TestAllTypes{OptionalDouble: math.NaN(), RepeatedFloat: []float32{float32(math.NaN())}, RepeatedInt32: []int32{}}
+	if !nan.Equal(&protobuf_unittest.TestAllTypes{OptionalDouble: math.NaN(), RepeatedFloat: []float32{float32(math.NaN())}}) {
+		t.Errorf("NaN should be equal to NaN, nil list to empty one")
+	}
+	empty := &protobuf_unittest.TestAllTypes{OptionalNestedMessage: &protobuf_unittest.TestAllTypes_NestedMessage{}}
+	if empty.Equal(&protobuf_unittest.TestAllTypes{}) || (*protobuf_unittest.TestAllTypes)(nil).Equal(&protobuf_unittest.TestAllTypes{}) {
+		t.Errorf("nil message should not be equal to empty one")
+	}
+	if diff := empty.Diff(&protobuf_unittest.TestAllTypes{}); len(diff) != 1 || diff[0].Path != "optional_nested_message" {
+		t.Errorf("nil message diff: %v", diff)
+	}
+	if (&protobuf_unittest.TestAllTypes{XXX_unrecognized: []byte{8, 1}}).Equal(&protobuf_unittest.TestAllTypes{}) {
+		t.Errorf("unknown fields should be compared")
+	}
+	unknown := &protobuf_unittest.TestAllTypes{OptionalForeignMessage: &protobuf_unittest.ForeignMessage{XXX_unrecognized: []byte{8, 1}}}
+	if diff := unknown.Diff(&protobuf_unittest.TestAllTypes{OptionalForeignMessage: &protobuf_unittest.ForeignMessage{}}); len(diff) != 1 ||
+		diff[0].Path != "optional_foreign_message."+gremlin.UnknownFieldsPath {
+		t.Errorf("unknown fields diff: %v", diff)
+	}
+
+	m1 := &map_test.TestMap{
+		Int32ToMessageField: map[int32]*map_test.TestMap_MessageValue{4: {Value: 4}},
+		StringToInt32Field:  map[string]int32{"a": 1},
+	}
+	m2 := &map_test.TestMap{
+		Int32ToMessageField: map[int32]*map_test.TestMap_MessageValue{4: {Value: 5}},
+		StringToInt32Field:  map[string]int32{"b": 1},
+	}
+	if m1.Equal(m2) {
+		t.Errorf("maps should not be equal")
+	}
+	paths = nil
+	for _, diff := range m1.Diff(m2) {
+		paths = append(paths, diff.String())
+	}
+	expected = []string{"int32_to_message_field[4].value: 4 != 5", `string_to_int32_field["a"]: 1 != <nil>`, `string_to_int32_field["b"]: <nil> != 1`}
+	if !cmp.Equal(paths, expected) {
+		t.Errorf("map diff: got %v, want %v", paths, expected)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dst := &protobuf_unittest.TestAllTypes{
+		OptionalInt32:         1,
+		OptionalString:        "dst",
+		RepeatedInt32:         []int32{1},
+		OptionalNestedMessage: &protobuf_unittest.TestAllTypes_NestedMessage{Bb: 1},
+		OneofField:            &protobuf_unittest.TestAllTypes_OneofNestedMessage{OneofNestedMessage: &protobuf_unittest.TestAllTypes_NestedMessage{Bb: 1}},
+		XXX_unrecognized:      []byte{0xa0, 0x1f, 1},
+	}
+	src := &protobuf_unittest.TestAllTypes{
+		OptionalInt64:          2,
+		OptionalString:         "src",
+		RepeatedInt32:          []int32{2},
+		RepeatedNestedMessage:  []*protobuf_unittest.TestAllTypes_NestedMessage{{Bb: 2}},
+		OptionalNestedMessage:  &protobuf_unittest.TestAllTypes_NestedMessage{},
+		OptionalForeignMessage: &protobuf_unittest.ForeignMessage{C: 2},
+		OneofField:             &protobuf_unittest.TestAllTypes_OneofNestedMessage{OneofNestedMessage: &protobuf_unittest.TestAllTypes_NestedMessage{Bb: 2}},
+		XXX_unrecognized:       []byte{0xa0, 0x1f, 2},
+	}
+	dst.Merge(src)
+	expected := &protobuf_unittest.TestAllTypes{
+		OptionalInt32:          1,
+		OptionalInt64:          2,
+		OptionalString:         "src",
+		RepeatedInt32:          []int32{1, 2},
+		RepeatedNestedMessage:  []*protobuf_unittest.TestAllTypes_NestedMessage{{Bb: 2}},
+		OptionalNestedMessage:  &protobuf_unittest.TestAllTypes_NestedMessage{Bb: 1},
+		OptionalForeignMessage: &protobuf_unittest.ForeignMessage{C: 2},
+		OneofField:             &protobuf_unittest.TestAllTypes_OneofNestedMessage{OneofNestedMessage: &protobuf_unittest.TestAllTypes_NestedMessage{Bb: 2}},
+		XXX_unrecognized:       []byte{0xa0, 0x1f, 1, 0xa0, 0x1f, 2},
+	}
+	if !dst.Equal(expected) {
+		t.Errorf("merge: %v", dst.Diff(expected))
+	}
+
+	src.RepeatedNestedMessage[0].Bb = 3
+	src.OptionalForeignMessage.C = 3
+	if dst.RepeatedNestedMessage[0].Bb != 2 || dst.OptionalForeignMessage.C != 2 {
+		t.Errorf("merged messages should be copies of src")
+	}
+
+	dst.Merge(&protobuf_unittest.TestAllTypes{OneofField: &protobuf_unittest.TestAllTypes_OneofUint32{}})
+	if v, ok := dst.OneofField.(*protobuf_unittest.TestAllTypes_OneofUint32); !ok || v.OneofUint32 != 0 {
+		t.Errorf("other member of oneof should replace chosen one: %v", dst.OneofField)
+	}
+
+	maps := &map_test.TestMap{Int32ToInt32Field: map[int32]int32{1: 1, 2: 2}}
+	maps.Merge(&map_test.TestMap{Int32ToInt32Field: map[int32]int32{2: 3, 4: 4}, StringToInt32Field: map[string]int32{"a": 1}})
+	if !maps.Equal(&map_test.TestMap{Int32ToInt32Field: map[int32]int32{1: 1, 2: 3, 4: 4}, StringToInt32Field: map[string]int32{"a": 1}}) {
+		t.Errorf("map merge: %+v", maps)
+	}
+}
+
+func TestFieldMask(t *testing.T) {
+	msg := &protobuf_unittest.TestAllTypes{
+		OptionalInt32:          1,
+		OptionalString:         "msg",
+		RepeatedInt32:          []int32{1, 2},
+		OptionalForeignMessage: &protobuf_unittest.ForeignMessage{C: 1, D: 2},
+		OneofField:             &protobuf_unittest.TestAllTypes_OneofString{OneofString: "msg"},
+		XXX_unrecognized:       []byte{0xa0, 0x1f, 1},
+	}
+	mask, err := gremlin.ParseFieldMask(msg.XXX_Descriptor(), "optional_int32", "repeated_int32", "optional_foreign_message.c", "oneof_string")
+	if err != nil {
+		t.Fatalf("ParseFieldMask failed: %v", err)
+	}
+	expected := &protobuf_unittest.TestAllTypes{
+		OptionalInt32:          1,
+		RepeatedInt32:          []int32{1, 2},
+		OptionalForeignMessage: &protobuf_unittest.ForeignMessage{C: 1},
+		OneofField:             &protobuf_unittest.TestAllTypes_OneofString{OneofString: "msg"},
+	}
+	if res := msg.CopyMasked(mask); !res.Equal(expected) {
+		t.Errorf("copy: %v", res.Diff(expected))
+	}
+	if !msg.CopyMasked(nil).Equal(msg) || !msg.CopyMasked(gremlin.NewFieldMask()).Equal(&protobuf_unittest.TestAllTypes{}) {
+		t.Errorf("nil mask selects all fields, empty one selects nothing")
+	}
+	if !bytes.Equal(msg.MarshalMasked(mask), expected.Marshal()) {
+		t.Errorf("marshal: got %v, want %v", msg.MarshalMasked(mask), expected.Marshal())
+	}
+
+	// masked out and unknown fields are skipped
+	parsed := protobuf_unittest.NewTestAllTypesReader()
+	if err := parsed.UnmarshalMasked(msg.Marshal(), mask); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if parsed.GetOptionalString() != "" || parsed.GetOptionalForeignMessage().GetD() != 0 || len(parsed.UnknownFields()) != 0 {
+		t.Errorf("masked read: %v", parsed.ToStruct())
+	}
+	if res := parsed.ToStruct().CopyMasked(mask); !res.Equal(expected) {
+		t.Errorf("masked read: %v", res.Diff(expected))
+	}
+
+	for _, invalid := range []string{"missing", "optional_int32.c", "repeated_nested_message.bb", "optional_foreign_message.e"} {
+		if _, err := gremlin.ParseFieldMask(msg.XXX_Descriptor(), invalid); !errors.Is(err, gremlin.ErrFieldMaskPath) {
+			t.Errorf("expected path error for %v, got %v", invalid, err)
+		}
+	}
+}
+
+func TestMergeMasked(t *testing.T) {
+	dst := &protobuf_unittest.TestAllTypes{
+		OptionalInt32:          1,
+		OptionalString:         "dst",
+		RepeatedInt32:          []int32{1},
+		OptionalForeignMessage: &protobuf_unittest.ForeignMessage{C: 1, D: 1},
+		OneofField:             &protobuf_unittest.TestAllTypes_OneofUint32{OneofUint32: 1},
+		XXX_unrecognized:       []byte{0xa0, 0x1f, 1},
+	}
+	src := &protobuf_unittest.TestAllTypes{
+		OptionalString:         "src",
+		RepeatedInt32:          []int32{2},
+		OptionalNestedMessage:  &protobuf_unittest.TestAllTypes_NestedMessage{Bb: 2},
+		OptionalForeignMessage: &protobuf_unittest.ForeignMessage{C: 2, D: 2},
+		OneofField:             &protobuf_unittest.TestAllTypes_OneofString{OneofString: "src"},
+	}
+	// fields absent in src are cleared, lists are replaced, oneof member not in the mask is not set
+	dst.MergeMasked(src, gremlin.NewFieldMask("optional_int32", "repeated_int32", "optional_nested_message.bb", "optional_foreign_message.d", "oneof_uint32"))
+	expected := &protobuf_unittest.TestAllTypes{
+		OptionalString:         "dst",
+		RepeatedInt32:          []int32{2},
+		OptionalNestedMessage:  &protobuf_unittest.TestAllTypes_NestedMessage{Bb: 2},
+		OptionalForeignMessage: &protobuf_unittest.ForeignMessage{C: 1, D: 2},
+		XXX_unrecognized:       []byte{0xa0, 0x1f, 1},
+	}
+	if !dst.Equal(expected) {
+		t.Errorf("merge: %v", dst.Diff(expected))
+	}
+
+	src.OptionalNestedMessage.Bb = 3
+	if dst.OptionalNestedMessage.Bb != 2 {
+		t.Errorf("merged messages should be copies of src")
+	}
+
+	dst.MergeMasked(nil, gremlin.NewFieldMask("optional_foreign_message"))
+	if dst.OptionalForeignMessage != nil {
+		t.Errorf("nil src clears masked fields: %v", dst.OptionalForeignMessage)
+	}
+	dst.MergeMasked(src, nil)
+	if !dst.Equal(src) {
+		t.Errorf("nil mask replaces everything: %v", dst.Diff(src))
+	}
+}
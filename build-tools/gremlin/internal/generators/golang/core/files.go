@@ -28,8 +28,9 @@ type GoGeneratedFile struct {
 	imports            []*GoImport
 	samePackageImports []*GoGeneratedFile
 
-	enums   []GoType
-	structs []GoType
+	enums    []GoType
+	structs  []GoType
+	services []GoType
 
 	ProtoFile        *types.ProtoFile
 	Path             string
@@ -107,6 +108,10 @@ func (g *GoGeneratedFile) AddStruct(structDef GoType) {
 	g.structs = append(g.structs, structDef)
 }
 
+func (g *GoGeneratedFile) AddService(service GoType) {
+	g.services = append(g.services, service)
+}
+
 func (g *GoGeneratedFile) FindEnum(enumType *types.EnumDefinition) GoType {
 	for _, enum := range g.enums {
 		if enum.IsEnum(enumType) {
@@ -125,6 +130,21 @@ func (g *GoGeneratedFile) FindStruct(msgType *types.MessageDefinition) GoType {
 	return nil
 }
 
+// IsTypeNameUsed tells whether file already has enum or struct with given go name
+func (g *GoGeneratedFile) IsTypeNameUsed(name string) bool {
+	for _, enum := range g.enums {
+		if enum.GetName() == name {
+			return true
+		}
+	}
+	for _, structDef := range g.structs {
+		if structDef.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *GoGeneratedFile) FindEnumInImports(enumFile *types.ProtoFile, enumType *types.EnumDefinition) (string, GoType) {
 	for _, goFile := range g.samePackageImports {
 		if goFile.ProtoFile == enumFile {
@@ -185,9 +205,16 @@ func (g *GoImport) FindStruct(msgType *types.MessageDefinition) GoType {
 }
 
 func (g *GoGeneratedFile) GenerateCode() string {
-	if len(g.structs) > 0 {
+	if len(g.structs) > 0 || len(g.enums) > 0 || len(g.services) > 0 {
 		g.AddImport("octopus/shared/gremlin", "gremlin")
 	}
+	if len(g.structs) > 0 {
+		// Equal compares unknown fields
+		g.AddImport("bytes", "bytes")
+	}
+	if len(g.services) > 0 {
+		g.AddImport("context", "context")
+	}
 	sb := &strings.Builder{}
 
 	sb.WriteString(fmt.Sprintf(`// Code generated by gremlin. DO NOT EDIT.
@@ -222,5 +249,9 @@ package %v
 		structDef.GenerateCode(sb)
 	}
 
+	for _, service := range g.services {
+		service.GenerateCode(sb)
+	}
+
 	return sb.String()
 }
@@ -28,6 +28,7 @@ type GoFieldType interface {
 
 	OffsetsType() string
 	WireTypeType() string
+	WireType() string // wire type of a single entry, packed lists also accept gremlin.BytesType
 	CanBePacked() bool
 
 	EntrySizedReader(tabs string, localVarName string) string       // should produce entry and entrySize variables
@@ -37,6 +38,11 @@ type GoFieldType interface {
 
 	ToStruct(tabs string, targetVar string, readerField string) string
 	EntryCopy(tabs string, targetVar string, srcVar string) string
+	EntryDiscardUnknown(tabs string, varName string) string // empty if type can't hold unknown fields
+	EntryEqual(leftVar string, rightVar string) string      // bool expression for generated Equal
+	EntryMerge(tabs string, targetVar string, srcVar string) string
+	// EntryValidate checks data at offsetsVar (and wire types at wireTypesVar if any) for SafeUnmarshal, empty if nothing to check
+	EntryValidate(tabs string, offsetsVar string, wireTypesVar string, optsVar string) string
 	JsonStructCanBeUsedDirectly() bool
 
 	EntryIsNotEmpty(localVarName string) string
@@ -45,6 +51,9 @@ type GoFieldType interface {
 
 	EntryWriter(tabs string, targetBuffer string, tag string, varName string) string
 	PackedEntryWriter(tabs string, targetBuffer string, varName string) string
+
+	JsonWriter(tabs string, writerVar string, varName string) string
+	JsonReader(tabs string, targetVar string, rawVar string) string
 }
 
 type GoType interface {
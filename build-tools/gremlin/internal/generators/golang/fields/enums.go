@@ -53,6 +53,14 @@ func (e *goEnumValueType) WireTypeType() string {
 	return ""
 }
 
+func (e *goEnumValueType) WireType() string {
+	return "gremlin.VarIntType"
+}
+
+func (e *goEnumValueType) EntryValidate(string, string, string, string) string {
+	return ""
+}
+
 func (e *goEnumValueType) CanBePacked() bool {
 	return false
 }
@@ -137,6 +145,18 @@ func (e *goEnumValueType) EntryCopy(tabs string, targetVar string, srcVar string
 	return formatting.AddTabs(fmt.Sprintf(`%v = %v`, targetVar, srcVar), tabs)
 }
 
+func (e *goEnumValueType) EntryDiscardUnknown(string, string) string {
+	return ""
+}
+
+func (e *goEnumValueType) EntryEqual(leftVar string, rightVar string) string {
+	return fmt.Sprintf(`%v == %v`, leftVar, rightVar)
+}
+
+func (e *goEnumValueType) EntryMerge(tabs string, targetVar string, srcVar string) string {
+	return formatting.AddTabs(mergeIfNotEmpty(e.EntryIsNotEmpty(srcVar), e.EntryCopy("", targetVar, srcVar)), tabs)
+}
+
 func (e *goEnumValueType) EntryFullSizeWithTag(tabs string, sizeVarName string, fieldName string, fieldTag string) string {
 	return formatting.AddTabs(fmt.Sprintf(`%v = gremlin.SizeTag(%v) + gremlin.SizeInt32(int32(%v))`, sizeVarName, fieldTag, fieldName), tabs)
 }
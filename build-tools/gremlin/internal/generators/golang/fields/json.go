@@ -0,0 +1,165 @@
+package fields
+
+import (
+	"fmt"
+	"octopus/build-tools/gremlin/internal/formatting"
+)
+
+// canonical proto3 json mapping of field values, see shared/gremlin/json.go.
+// JsonWriter writes value of varName into gremlin.JsonWriter,
+// JsonReader decodes rawVar into targetVar and sets err.
+
+var jsonBasicTypesWriters = map[string]string{
+	"string":   "String",
+	"bytes":    "Bytes64",
+	"bool":     "Bool",
+	"double":   "Float64",
+	"float":    "Float32",
+	"int32":    "Int32",
+	"int64":    "Int64",
+	"uint32":   "Uint32",
+	"uint64":   "Uint64",
+	"sint32":   "Int32",
+	"sint64":   "Int64",
+	"fixed32":  "Uint32",
+	"fixed64":  "Uint64",
+	"sfixed32": "Int32",
+	"sfixed64": "Int64",
+}
+
+var jsonBasicTypesReaders = map[string]string{
+	"string":   "JsonString",
+	"bytes":    "JsonBytes",
+	"bool":     "JsonBool",
+	"double":   "JsonFloat64",
+	"float":    "JsonFloat32",
+	"int32":    "JsonInt32",
+	"int64":    "JsonInt64",
+	"uint32":   "JsonUint32",
+	"uint64":   "JsonUint64",
+	"sint32":   "JsonInt32",
+	"sint64":   "JsonInt64",
+	"fixed32":  "JsonUint32",
+	"fixed64":  "JsonUint64",
+	"sfixed32": "JsonInt32",
+	"sfixed64": "JsonInt64",
+}
+
+func (t *goBasicValueType) JsonWriter(tabs string, writerVar string, varName string) string {
+	return formatting.AddTabs(fmt.Sprintf(`%v.%v(%v)`, writerVar, jsonBasicTypesWriters[t.ProtoType], varName), tabs)
+}
+
+func (t *goBasicValueType) JsonReader(tabs string, targetVar string, rawVar string) string {
+	return formatting.AddTabs(fmt.Sprintf(`%v, err = gremlin.%v(%v)`, targetVar, jsonBasicTypesReaders[t.ProtoType], rawVar), tabs)
+}
+
+// map keys are always strings in json
+
+func (t *goBasicValueType) jsonKeyWriter(tabs string, writerVar string, varName string) string {
+	if t.ProtoType == "string" {
+		return formatting.AddTabs(fmt.Sprintf(`%v.Key(%v)`, writerVar, varName), tabs)
+	}
+	return formatting.AddTabs(fmt.Sprintf(`%v.Key%v(%v)`, writerVar, jsonBasicTypesWriters[t.ProtoType], varName), tabs)
+}
+
+func (t *goBasicValueType) jsonKeyReader(tabs string, targetVar string, keyVar string) string {
+	if t.ProtoType == "string" {
+		return formatting.AddTabs(fmt.Sprintf(`%v = %v`, targetVar, keyVar), tabs)
+	}
+	return formatting.AddTabs(fmt.Sprintf(`%v, err = gremlin.JsonKey%v(%v)`, targetVar, jsonBasicTypesWriters[t.ProtoType], keyVar), tabs)
+}
+
+func (e *goEnumValueType) JsonWriter(tabs string, writerVar string, varName string) string {
+	return formatting.AddTabs(fmt.Sprintf(`%v.Enum(int32(%v), %v.String())`, writerVar, varName, varName), tabs)
+}
+
+func (e *goEnumValueType) JsonReader(tabs string, targetVar string, rawVar string) string {
+	return formatting.AddTabs(fmt.Sprintf(`err = %v.UnmarshalJSON(%v)`, targetVar, rawVar), tabs)
+}
+
+func (t *goStructValueType) JsonWriter(tabs string, writerVar string, varName string) string {
+	return formatting.AddTabs(fmt.Sprintf(`%v.MarshalJSONTo(%v)`, varName, writerVar), tabs)
+}
+
+func (t *goStructValueType) JsonReader(tabs string, targetVar string, rawVar string) string {
+	return formatting.AddTabs(fmt.Sprintf(`%v = new(%v)
+err = %v.UnmarshalJSON(%v)`, targetVar, t.WriterTypeName()[1:], targetVar, rawVar), tabs)
+}
+
+func jsonListWriter(tabs string, itemType jsonFieldType, writerVar string, varName string) string {
+	return formatting.AddTabs(fmt.Sprintf(`%v.BeginArray()
+for _, entry := range %v {
+%v
+}
+%v.EndArray()`, writerVar, varName, itemType.JsonWriter("\t", writerVar, "entry"), writerVar), tabs)
+}
+
+func jsonListReader(tabs string, itemType jsonFieldType, listType string, targetVar string, rawVar string) string {
+	return formatting.AddTabs(fmt.Sprintf(`list, listErr := gremlin.JsonArray(%v)
+err = listErr
+if err == nil {
+	%v = make(%v, len(list))
+}
+for i := 0; err == nil && i < len(list); i++ {
+%v
+}`, rawVar, targetVar, listType, itemType.JsonReader("\t", targetVar+"[i]", "list[i]")), tabs)
+}
+
+type jsonFieldType interface {
+	JsonWriter(tabs string, writerVar string, varName string) string
+	JsonReader(tabs string, targetVar string, rawVar string) string
+}
+
+func (t *goRepeatedValueType) JsonWriter(tabs string, writerVar string, varName string) string {
+	return jsonListWriter(tabs, t.RepeatedType, writerVar, varName)
+}
+
+func (t *goRepeatedValueType) JsonReader(tabs string, targetVar string, rawVar string) string {
+	return jsonListReader(tabs, t.RepeatedType, t.WriterTypeName(), targetVar, rawVar)
+}
+
+func (t *goRepeatedPackedValueType) JsonWriter(tabs string, writerVar string, varName string) string {
+	return jsonListWriter(tabs, t.RepeatedType, writerVar, varName)
+}
+
+func (t *goRepeatedPackedValueType) JsonReader(tabs string, targetVar string, rawVar string) string {
+	return jsonListReader(tabs, t.RepeatedType, t.WriterTypeName(), targetVar, rawVar)
+}
+
+func (t *goMapValueType) JsonWriter(tabs string, writerVar string, varName string) string {
+	keyType := t.KeyType.(*goBasicValueType)
+	return formatting.AddTabs(fmt.Sprintf(`%v.BeginObject()
+for _, k := range gremlin.SortedMapKeys(%v) {
+%v
+%v
+}
+%v.EndObject()`, writerVar, varName,
+		keyType.jsonKeyWriter("\t", writerVar, "k"),
+		t.ValueType.JsonWriter("\t", writerVar, varName+"[k]"),
+		writerVar), tabs)
+}
+
+func (t *goMapValueType) JsonReader(tabs string, targetVar string, rawVar string) string {
+	keyType := t.KeyType.(*goBasicValueType)
+	return formatting.AddTabs(fmt.Sprintf(`entries, entriesErr := gremlin.JsonObject(%v)
+err = entriesErr
+if err == nil {
+	%v = make(%v, len(entries))
+}
+for k, entry := range entries {
+	var mapKey %v
+	var mapValue %v
+%v
+	if err == nil {
+%v
+	}
+	if err != nil {
+		break
+	}
+	%v[mapKey] = mapValue
+}`, rawVar, targetVar, t.WriterTypeName(),
+		t.KeyType.WriterTypeName(), t.ValueType.WriterTypeName(),
+		keyType.jsonKeyReader("\t", "mapKey", "k"),
+		t.ValueType.JsonReader("\t\t", "mapValue", "entry"),
+		targetVar), tabs)
+}
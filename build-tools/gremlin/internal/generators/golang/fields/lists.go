@@ -49,6 +49,29 @@ func (t *goRepeatedValueType) WireTypeType() string {
 	}
 }
 
+func (t *goRepeatedValueType) WireType() string {
+	return t.RepeatedType.WireType()
+}
+
+func (t *goRepeatedValueType) EntryValidate(tabs string, offsetsVar string, wireTypesVar string, optsVar string) string {
+	if t.RepeatedType.CanBePacked() {
+		return formatting.AddTabs(fmt.Sprintf(`for i, wOffset := range %v {
+	if %v[i] == gremlin.BytesType {
+		if err := m.buf.CheckPacked(wOffset, %v); err != nil {
+			return err
+		}
+	}
+}`, offsetsVar, wireTypesVar, t.RepeatedType.WireType()), tabs)
+	}
+	inner := t.RepeatedType.EntryValidate("\t", "wOffset", "", optsVar)
+	if inner == "" {
+		return ""
+	}
+	return formatting.AddTabs(fmt.Sprintf(`for _, wOffset := range %v {
+%v
+}`, offsetsVar, inner), tabs)
+}
+
 func (t *goRepeatedValueType) CanBePacked() bool {
 	return false
 }
@@ -140,3 +163,38 @@ for i := range %v {
 }`, targetVar, t.WriterTypeName(), srcVar, srcVar, t.RepeatedType.EntryCopy("\t", targetVar+"[i]", srcVar+"[i]")), tabs)
 	}
 }
+
+func (t *goRepeatedValueType) EntryDiscardUnknown(tabs string, varName string) string {
+	inner := t.RepeatedType.EntryDiscardUnknown("\t", "entry")
+	if inner == "" {
+		return ""
+	}
+	return formatting.AddTabs(fmt.Sprintf(`for _, entry := range %v {
+%v
+}`, varName, inner), tabs)
+}
+
+func (t *goRepeatedValueType) EntryEqual(leftVar string, rightVar string) string {
+	return equalLists(t.RepeatedType, leftVar, rightVar)
+}
+
+func (t *goRepeatedValueType) EntryMerge(tabs string, targetVar string, srcVar string) string {
+	return mergeLists(t.RepeatedType, tabs, targetVar, srcVar)
+}
+
+func equalLists(entryType core.GoFieldType, leftVar string, rightVar string) string {
+	return fmt.Sprintf(`gremlin.EqualLists(%v, %v, func(a, b %v) bool { return %v })`,
+		leftVar, rightVar, entryType.WriterTypeName(), entryType.EntryEqual("a", "b"))
+}
+
+// mergeLists appends copies of src entries to target
+func mergeLists(entryType core.GoFieldType, tabs string, targetVar string, srcVar string) string {
+	if entryType.JsonStructCanBeUsedDirectly() {
+		return formatting.AddTabs(fmt.Sprintf(`%v = append(%v, %v...)`, targetVar, targetVar, srcVar), tabs)
+	}
+	return formatting.AddTabs(fmt.Sprintf(`for _, v := range %v {
+	var c %v
+%v
+	%v = append(%v, c)
+}`, srcVar, entryType.WriterTypeName(), entryType.EntryCopy("\t", "c", "v"), targetVar, targetVar), tabs)
+}
@@ -45,6 +45,23 @@ func (t *goMapValueType) WireTypeType() string {
 	return ""
 }
 
+func (t *goMapValueType) WireType() string {
+	return "gremlin.BytesType"
+}
+
+func (t *goMapValueType) EntryValidate(tabs string, offsetsVar string, _ string, optsVar string) string {
+	var valueVar, valueCheck = "_", ""
+	if inner := t.ValueType.EntryValidate("\t", "valueOffset", "", optsVar); inner != "" {
+		valueVar, valueCheck = "valueOffset", "\n"+inner
+	}
+	return formatting.AddTabs(fmt.Sprintf(`for _, wOffset := range %v {
+	%v, err := m.buf.CheckMapEntry(wOffset, %v, %v)
+	if err != nil {
+		return err
+	}%v
+}`, offsetsVar, valueVar, t.KeyType.WireType(), t.ValueType.WireType(), valueCheck), tabs)
+}
+
 func (t *goMapValueType) CanBePacked() bool {
 	return false
 }
@@ -176,3 +193,29 @@ for k, v := range %v {
 %v
 }`, targetVar, t.KeyType.WriterTypeName(), t.ValueType.WriterTypeName(), srcVar, srcVar, t.ValueType.EntryCopy("\t", targetVar+"[k]", "v")), tabs)
 }
+
+func (t *goMapValueType) EntryDiscardUnknown(tabs string, varName string) string {
+	inner := t.ValueType.EntryDiscardUnknown("\t", "v")
+	if inner == "" {
+		return ""
+	}
+	return formatting.AddTabs(fmt.Sprintf(`for _, v := range %v {
+%v
+}`, varName, inner), tabs)
+}
+
+func (t *goMapValueType) EntryEqual(leftVar string, rightVar string) string {
+	return fmt.Sprintf(`gremlin.EqualMaps(%v, %v, func(a, b %v) bool { return %v })`,
+		leftVar, rightVar, t.ValueType.WriterTypeName(), t.ValueType.EntryEqual("a", "b"))
+}
+
+// EntryMerge replaces entries of target by entries of src
+func (t *goMapValueType) EntryMerge(tabs string, targetVar string, srcVar string) string {
+	return formatting.AddTabs(fmt.Sprintf(`if %v == nil && len(%v) > 0 {
+	%v = make(map[%v]%v, len(%v))
+}
+for k, v := range %v {
+%v
+}`, targetVar, srcVar, targetVar, t.KeyType.WriterTypeName(), t.ValueType.WriterTypeName(), srcVar,
+		srcVar, t.ValueType.EntryCopy("\t", targetVar+"[k]", "v")), tabs)
+}
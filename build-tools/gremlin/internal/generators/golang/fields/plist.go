@@ -49,6 +49,29 @@ func (t *goRepeatedPackedValueType) WireTypeType() string {
 	}
 }
 
+func (t *goRepeatedPackedValueType) WireType() string {
+	return t.RepeatedType.WireType()
+}
+
+func (t *goRepeatedPackedValueType) EntryValidate(tabs string, offsetsVar string, wireTypesVar string, optsVar string) string {
+	if t.RepeatedType.CanBePacked() {
+		return formatting.AddTabs(fmt.Sprintf(`for i, wOffset := range %v {
+	if %v[i] == gremlin.BytesType {
+		if err := m.buf.CheckPacked(wOffset, %v); err != nil {
+			return err
+		}
+	}
+}`, offsetsVar, wireTypesVar, t.RepeatedType.WireType()), tabs)
+	}
+	inner := t.RepeatedType.EntryValidate("\t", "wOffset", "", optsVar)
+	if inner == "" {
+		return ""
+	}
+	return formatting.AddTabs(fmt.Sprintf(`for _, wOffset := range %v {
+%v
+}`, offsetsVar, inner), tabs)
+}
+
 func (t *goRepeatedPackedValueType) CanBePacked() bool {
 	return false
 }
@@ -185,3 +208,21 @@ for i := range %v {
 }`, targetVar, t.WriterTypeName(), srcVar, srcVar, t.RepeatedType.EntryCopy("\t", targetVar+"[i]", srcVar+"[i]")), tabs)
 	}
 }
+
+func (t *goRepeatedPackedValueType) EntryDiscardUnknown(tabs string, varName string) string {
+	inner := t.RepeatedType.EntryDiscardUnknown("\t", "entry")
+	if inner == "" {
+		return ""
+	}
+	return formatting.AddTabs(fmt.Sprintf(`for _, entry := range %v {
+%v
+}`, varName, inner), tabs)
+}
+
+func (t *goRepeatedPackedValueType) EntryEqual(leftVar string, rightVar string) string {
+	return equalLists(t.RepeatedType, leftVar, rightVar)
+}
+
+func (t *goRepeatedPackedValueType) EntryMerge(tabs string, targetVar string, srcVar string) string {
+	return mergeLists(t.RepeatedType, tabs, targetVar, srcVar)
+}
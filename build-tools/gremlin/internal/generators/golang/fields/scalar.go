@@ -305,6 +305,53 @@ func (t *goBasicValueType) EntryCopy(tabs string, targetVar string, srcVar strin
 	return formatting.AddTabs(fmt.Sprintf(`%v = %v`, targetVar, srcVar), tabs)
 }
 
+func (t *goBasicValueType) EntryDiscardUnknown(string, string) string {
+	return ""
+}
+
+func (t *goBasicValueType) EntryEqual(leftVar string, rightVar string) string {
+	switch t.ProtoType {
+	case "float":
+		return fmt.Sprintf(`gremlin.EqualFloat32(%v, %v)`, leftVar, rightVar)
+	case "double":
+		return fmt.Sprintf(`gremlin.EqualFloat64(%v, %v)`, leftVar, rightVar)
+	case "bytes":
+		return fmt.Sprintf(`bytes.Equal(%v, %v)`, leftVar, rightVar)
+	}
+	return fmt.Sprintf(`%v == %v`, leftVar, rightVar)
+}
+
+// EntryMerge replaces value only if src one would be written by Marshal
+func (t *goBasicValueType) EntryMerge(tabs string, targetVar string, srcVar string) string {
+	return formatting.AddTabs(mergeIfNotEmpty(t.EntryIsNotEmpty(srcVar), t.EntryCopy("", targetVar, srcVar)), tabs)
+}
+
+var bufBasicWireTypes = map[string]string{
+	"string":   "gremlin.BytesType",
+	"bytes":    "gremlin.BytesType",
+	"bool":     "gremlin.VarIntType",
+	"double":   "gremlin.Fixed64Type",
+	"float":    "gremlin.Fixed32Type",
+	"int32":    "gremlin.VarIntType",
+	"int64":    "gremlin.VarIntType",
+	"uint32":   "gremlin.VarIntType",
+	"uint64":   "gremlin.VarIntType",
+	"sint32":   "gremlin.VarIntType",
+	"sint64":   "gremlin.VarIntType",
+	"fixed32":  "gremlin.Fixed32Type",
+	"fixed64":  "gremlin.Fixed64Type",
+	"sfixed32": "gremlin.Fixed32Type",
+	"sfixed64": "gremlin.Fixed64Type",
+}
+
+func (t *goBasicValueType) WireType() string {
+	return bufBasicWireTypes[t.ProtoType]
+}
+
+func (t *goBasicValueType) EntryValidate(string, string, string, string) string {
+	return ""
+}
+
 var bufBasicTypesSizers = map[string]string{
 	"string":   "SizeString",
 	"bytes":    "SizeBytes",
@@ -344,3 +391,11 @@ func (t *goBasicValueType) EntryFullSizeWithoutTag(tabs string, sizeVarName stri
 			tabs)
 	}
 }
+
+// mergeIfNotEmpty guards assignment with the condition of Marshal, so Merge skips values which are not written
+func mergeIfNotEmpty(condition string, assignment string) string {
+	if condition == "true" {
+		return assignment
+	}
+	return fmt.Sprintf("if %v {\n\t%v\n}", condition, assignment)
+}
@@ -27,6 +27,12 @@ type goStructValueType struct {
 	StructPackage string
 	StructName    string
 	Required      bool
+	// empty entries are read back as nil: nil list entries are written as empty messages,
+	// and readers of other messages don't tell empty fields from absent ones.
+	// It's false for singular well-known types only, present one is never nil even if it is empty
+	EmptyIsNil bool
+	// proto name of singular field, its reader parses only fields of the sub mask of the parent reader
+	MaskName string
 }
 
 func (t *goStructValueType) ReaderTypeName() string {
@@ -52,6 +58,18 @@ func (t *goStructValueType) WireTypeType() string {
 	return ""
 }
 
+func (t *goStructValueType) WireType() string {
+	return "gremlin.BytesType"
+}
+
+func (t *goStructValueType) EntryValidate(tabs string, offsetsVar string, _ string, optsVar string) string {
+	return formatting.AddTabs(fmt.Sprintf(`if %v > 0 {
+	if err := %v.SafeUnmarshal(m.buf.ReadBytes(%v), %v); err != nil {
+		return err
+	}
+}`, offsetsVar, t.newReaderCall(), offsetsVar, optsVar), tabs)
+}
+
 func (t *goStructValueType) CanBePacked() bool {
 	return false
 }
@@ -64,32 +82,39 @@ func (t *goStructValueType) EntryUnmarshalSaveOffsets(tabs string, fieldName str
 }
 
 func (t *goStructValueType) EntryReader(tabs string, localVarName string) string {
-	var res string
-	if t.StructPackage == "" {
-		res = fmt.Sprintf(`
+	var readerType = t.StructName
+	if t.StructPackage != "" {
+		readerType = t.StructPackage + "." + t.StructName
+	}
+	var newReader = fmt.Sprintf(`%v = %v
+%v.%v`, localVarName, t.newReaderCall(), localVarName, t.unmarshalCall(localVarName+"Data"))
+	if t.EmptyIsNil {
+		newReader = fmt.Sprintf("if len(%vData) > 0 {\n%v\n}", localVarName, formatting.AddTabs(newReader, "\t"))
+	}
+
+	res := fmt.Sprintf(`
 var %v *%vReader
 if wOffset > 0 {
 	var %vData = m.buf.ReadBytes(wOffset)
-	if len(%vData) > 0 {
-		%v = New%vReader()
-		%v.Unmarshal(%vData)
-	}
+%v
 }
-`, localVarName, t.StructName, localVarName, localVarName, localVarName, t.StructName, localVarName, localVarName)
-	} else {
-		res = fmt.Sprintf(`
-var %v *%v.%vReader
-if wOffset > 0 {
-	var %vData = m.buf.ReadBytes(wOffset)
-	if len(%vData) > 0 {
-		%v = %v.New%vReader()
-		%v.Unmarshal(%vData)
-	}
+`, localVarName, readerType, localVarName, formatting.AddTabs(newReader, "\t"))
+
+	return formatting.AddTabs(res, tabs)
 }
-`, localVarName, t.StructPackage, t.StructName, localVarName, localVarName, localVarName, t.StructPackage, t.StructName, localVarName, localVarName)
+
+func (t *goStructValueType) newReaderCall() string {
+	if t.StructPackage == "" {
+		return "New" + t.StructName + "Reader()"
 	}
+	return t.StructPackage + ".New" + t.StructName + "Reader()"
+}
 
-	return formatting.AddTabs(res, tabs)
+func (t *goStructValueType) unmarshalCall(dataVar string) string {
+	if t.MaskName == "" {
+		return fmt.Sprintf("Unmarshal(%v)", dataVar)
+	}
+	return fmt.Sprintf("UnmarshalMasked(%v, m.mask.Sub(%q))", dataVar, t.MaskName)
 }
 
 func (t *goStructValueType) EntrySizedReader(tabs string, localVarName string) string {
@@ -174,3 +199,19 @@ func (t *goStructValueType) EntryCopy(tabs string, targetVar string, srcVar stri
 	%v = %v.Copy()
 }`, srcVar, targetVar, srcVar), tabs)
 }
+
+func (t *goStructValueType) EntryDiscardUnknown(tabs string, varName string) string {
+	return formatting.AddTabs(fmt.Sprintf(`%v.DiscardUnknown()`, varName), tabs)
+}
+
+func (t *goStructValueType) EntryEqual(leftVar string, rightVar string) string {
+	return fmt.Sprintf(`%v.Equal(%v)`, leftVar, rightVar)
+}
+
+func (t *goStructValueType) EntryMerge(tabs string, targetVar string, srcVar string) string {
+	return formatting.AddTabs(fmt.Sprintf(`if %v == nil {
+	%v = %v.Copy()
+} else if %v != nil {
+	%v.Merge(%v)
+}`, targetVar, targetVar, srcVar, srcVar, targetVar, srcVar), tabs)
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"octopus/build-tools/gremlin/internal/generators/golang/core"
 	"octopus/build-tools/gremlin/internal/types"
+	"strings"
 )
 
 type GoEntitiesProvider interface {
@@ -137,6 +138,8 @@ func resolveEnumType(targetFile GoEntitiesProvider, field *types.MessageFieldDef
 	}
 }
 
+const wellKnownPackagePrefix = "google.protobuf."
+
 func resolveMsgType(targetFile GoEntitiesProvider, field *types.MessageFieldDefinition) (core.GoFieldType, error) {
 	var msgPackage string
 	var msgType core.GoType
@@ -150,10 +153,16 @@ func resolveMsgType(targetFile GoEntitiesProvider, field *types.MessageFieldDefi
 		return nil, fmt.Errorf("unknown message type %q", field.ProtoDef.Type)
 	}
 
+	msgDef := field.LocalMsgType
+	if msgDef == nil {
+		msgDef = field.ExternalMsgType
+	}
 	valueType := &goStructValueType{
 		StructPackage: msgPackage,
 		StructName:    msgType.GetName(),
 		Required:      field.Required,
+		// present well-known values are meaningful even if empty, e.g. StringValue of "" or Empty
+		EmptyIsNil: field.Repeated || !strings.HasPrefix(msgDef.Name.String(), wellKnownPackagePrefix),
 	}
 
 	if field.Repeated {
@@ -171,6 +180,7 @@ func resolveMsgType(targetFile GoEntitiesProvider, field *types.MessageFieldDefi
 			ValueType: valueType,
 		}, nil
 	} else {
+		valueType.MaskName = field.Name.ProtoName()
 		return valueType, nil
 	}
 }
@@ -68,6 +68,9 @@ func MapProtoFiles(root string, files []*types.ProtoFile) ([]*core.GoGeneratedFi
 			messageDef := goFile.ProtoFile.Messages[j]
 			goStruct := gotypes.NewStructType(messageDef)
 			goFile.AddStruct(goStruct)
+			for _, goImport := range goStruct.WellKnownImports() {
+				goFile.AddImport(goImport, goImport)
+			}
 			fileStructs = append(fileStructs, goStruct)
 		}
 		structs = append(structs, fileStructs)
@@ -88,12 +91,46 @@ func MapProtoFiles(root string, files []*types.ProtoFile) ([]*core.GoGeneratedFi
 
 				goMessageDef.AddField(fieldDef, fieldType)
 			}
+			goMessageDef.ResolveOneOfWrapperNames(goFile.IsTypeNameUsed)
+			goMessageDef.ResolveNestedTypes(goFile.ProtoFile)
+		}
+
+		for j := range goFile.ProtoFile.Services {
+			goService, err := mapService(goFile, goFile.ProtoFile.Services[j])
+			if err != nil {
+				errors = append(errors, fmt.Errorf("file: %v, %w", goFile.ProtoFile.RelativePath, err))
+				continue
+			}
+			goFile.AddService(goService)
 		}
 	}
 
 	return result, errors
 }
 
+func mapService(goFile *core.GoGeneratedFile, serviceDef *types.ServiceDefinition) (*gotypes.GoServiceType, error) {
+	goService := gotypes.NewServiceType(serviceDef)
+	for _, method := range serviceDef.Methods {
+		requestPackage, requestType := findServiceType(goFile, method.Request)
+		if requestType == nil {
+			return nil, fmt.Errorf("unknown request type %q of %v.%v", method.Request.ProtoType, serviceDef.Name.String(), method.Name)
+		}
+		responsePackage, responseType := findServiceType(goFile, method.Response)
+		if responseType == nil {
+			return nil, fmt.Errorf("unknown response type %q of %v.%v", method.Response.ProtoType, serviceDef.Name.String(), method.Name)
+		}
+		goService.AddMethod(method, requestPackage, requestType.GetName(), responsePackage, responseType.GetName())
+	}
+	return goService, nil
+}
+
+func findServiceType(goFile *core.GoGeneratedFile, ref *types.ServiceTypeReference) (string, core.GoType) {
+	if ref.ExternalTypeFile != nil {
+		return goFile.FindStructInImports(ref.ExternalTypeFile, ref.ExternalMsgType)
+	}
+	return "", goFile.FindStruct(ref.LocalMsgType)
+}
+
 func getGoPackage(outBase string, target *types.ProtoFile) (string, string) {
 	if target.Package == nil || target.Package.Name.PlatformName(types.TargetPlatform_Go) == "" {
 		var pkgName = filepath.Dir(target.RelativePath)
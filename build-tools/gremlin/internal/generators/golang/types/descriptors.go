@@ -0,0 +1,224 @@
+package types
+
+import (
+	"fmt"
+	"octopus/build-tools/gremlin/internal/types"
+	"strings"
+)
+
+var protoKinds = map[string]string{
+	"bool":     "gremlin.KindBool",
+	"int32":    "gremlin.KindInt32",
+	"int64":    "gremlin.KindInt64",
+	"uint32":   "gremlin.KindUint32",
+	"uint64":   "gremlin.KindUint64",
+	"sint32":   "gremlin.KindSInt32",
+	"sint64":   "gremlin.KindSInt64",
+	"fixed32":  "gremlin.KindFixed32",
+	"fixed64":  "gremlin.KindFixed64",
+	"sfixed32": "gremlin.KindSFixed32",
+	"sfixed64": "gremlin.KindSFixed64",
+	"float":    "gremlin.KindFloat",
+	"double":   "gremlin.KindDouble",
+	"string":   "gremlin.KindString",
+	"bytes":    "gremlin.KindBytes",
+}
+
+// ResolveNestedTypes collects messages and enums of the file declared inside the message
+func (g *GoStructType) ResolveNestedTypes(file *types.ProtoFile) {
+	for _, msg := range file.Messages {
+		if msg.Name.ToParent().String() == g.Proto.Name.String() {
+			g.NestedMessages = append(g.NestedMessages, msg.Name.String())
+		}
+	}
+	for _, enum := range file.Enums {
+		if enum.Name.ToParent().String() == g.Proto.Name.String() {
+			g.NestedEnums = append(g.NestedEnums, enum.Name.String())
+		}
+	}
+}
+
+func (g *GoStructType) descriptorVarName() string {
+	return "descriptor" + g.StructName
+}
+
+func (g *GoStructType) writeDescriptor(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+var %v = gremlin.RegisterMessage(&gremlin.MessageDescriptor{
+	Name: %q,
+	Fields: []*gremlin.FieldDescriptor{
+`, g.descriptorVarName(), g.Proto.Name.String()))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf("\t\t{%v},\n", field.descriptorLiteral()))
+	}
+	sb.WriteString("\t},\n")
+	if len(g.OneOfs) > 0 {
+		var names []string
+		for _, group := range g.OneOfs {
+			names = append(names, fmt.Sprintf("%q", group.ProtoName))
+		}
+		sb.WriteString(fmt.Sprintf("\tOneOfs: []string{%v},\n", strings.Join(names, ", ")))
+	}
+	writeNamesLiteral(sb, "NestedMessages", g.NestedMessages)
+	writeNamesLiteral(sb, "NestedEnums", g.NestedEnums)
+	sb.WriteString(fmt.Sprintf("\tNewStruct: func() gremlin.JsonMessage { return &%v{} },\n", g.StructName))
+	sb.WriteString(fmt.Sprintf("\tNewReader: func() gremlin.JsonMessageReader { return New%vReader() },\n", g.StructName))
+	sb.WriteString("})\n")
+
+	for _, typeName := range []string{g.StructName, g.StructName + "Reader"} {
+		sb.WriteString(fmt.Sprintf(`
+func (*%v) XXX_Descriptor() *gremlin.MessageDescriptor {
+	return %v
+}
+`, typeName, g.descriptorVarName()))
+	}
+}
+
+func writeNamesLiteral(sb *strings.Builder, field string, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	var quoted []string
+	for _, name := range names {
+		quoted = append(quoted, fmt.Sprintf("%q", name))
+	}
+	sb.WriteString(fmt.Sprintf("\t%v: []string{%v},\n", field, strings.Join(quoted, ", ")))
+}
+
+func (g *GoStructField) descriptorLiteral() string {
+	res := fmt.Sprintf("Name: %q, JsonName: %q, Number: %v", g.Proto.Name.ProtoName(), g.jsonName(), g.wireTypeConstName())
+
+	proto := g.Proto
+	switch {
+	case proto.ScalarValueType != "":
+		res += ", Kind: " + protoKinds[proto.ScalarValueType]
+	case proto.LocalEnumType != nil:
+		res += fmt.Sprintf(", Kind: gremlin.KindEnum, TypeName: %q", proto.LocalEnumType.Name.String())
+	case proto.ExternalEnumType != nil:
+		res += fmt.Sprintf(", Kind: gremlin.KindEnum, TypeName: %q", proto.ExternalEnumType.Name.String())
+	case proto.LocalMsgType != nil:
+		res += fmt.Sprintf(", Kind: gremlin.KindMessage, TypeName: %q", proto.LocalMsgType.Name.String())
+	case proto.ExternalMsgType != nil:
+		res += fmt.Sprintf(", Kind: gremlin.KindMessage, TypeName: %q", proto.ExternalMsgType.Name.String())
+	}
+
+	switch {
+	case proto.Map:
+		res += ", Label: gremlin.LabelRepeated, MapKey: " + protoKinds[proto.MapKeyType]
+	case proto.Repeated:
+		res += ", Label: gremlin.LabelRepeated"
+	case proto.Required:
+		res += ", Label: gremlin.LabelRequired"
+	}
+
+	if g.OneOf != nil {
+		res += fmt.Sprintf(", OneOf: %q", g.OneOf.ProtoName)
+	}
+	if proto.DefaultValue != nil {
+		res += fmt.Sprintf(", Default: %q", proto.DefaultValue.Constant.Source)
+	}
+	return res
+}
+
+func (g *GoStructType) writeReaderGetField(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+func (m *%vReader) XXX_GetField(number gremlin.ProtoWireNumber) (interface{}, bool) {
+	switch number {`, g.StructName))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf("\n\tcase %v:\n", field.wireTypeConstName()))
+		if field.OneOf != nil {
+			sb.WriteString(fmt.Sprintf(`		if m.Which%v() != %v {
+			return nil, true
+		}
+`, field.OneOf.Name, field.caseConstName()))
+		}
+		sb.WriteString(fmt.Sprintf("\t\treturn m.Get%v(), true", field.Name))
+	}
+	sb.WriteString(`
+	}
+	return nil, false
+}
+`)
+}
+
+func (g *GoStructType) writeGetField(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+func (s *%v) XXX_GetField(number gremlin.ProtoWireNumber) (interface{}, bool) {
+	if s == nil {
+		return nil, false
+	}
+	switch number {`, g.StructName))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf("\n\tcase %v:\n", field.wireTypeConstName()))
+		if field.OneOf != nil {
+			sb.WriteString(fmt.Sprintf(`		if v, ok := s.%v.(*%v); ok {
+			return v.%v, true
+		}
+		return nil, true`, field.OneOf.Name, field.WrapperName, field.Name))
+		} else {
+			sb.WriteString(fmt.Sprintf("\t\treturn s.%v, true", field.Name))
+		}
+	}
+	sb.WriteString(`
+	}
+	return nil, false
+}
+`)
+}
+
+func (g *GoStructType) writeSetField(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+func (s *%v) XXX_SetField(number gremlin.ProtoWireNumber, value interface{}) error {
+	switch number {`, g.StructName))
+	for _, field := range g.Fields {
+		typeName := field.Type.WriterTypeName()
+		sb.WriteString(fmt.Sprintf("\n\tcase %v:\n", field.wireTypeConstName()))
+		if field.OneOf != nil {
+			sb.WriteString(fmt.Sprintf(`		if value == nil {
+			if _, ok := s.%v.(*%v); ok {
+				s.%v = nil
+			}
+			return nil
+		}
+		v, ok := value.(%v)
+		if !ok {
+			return gremlin.NewFieldTypeError(%q, %q, value)
+		}
+		s.%v = &%v{%v: v}
+		return nil`, field.OneOf.Name, field.WrapperName, field.OneOf.Name,
+				typeName, field.Proto.Name.ProtoName(), typeName,
+				field.OneOf.Name, field.WrapperName, field.Name))
+		} else {
+			sb.WriteString(fmt.Sprintf(`		v, ok := value.(%v)
+		if !ok && value != nil {
+			return gremlin.NewFieldTypeError(%q, %q, value)
+		}
+		s.%v = v
+		return nil`, typeName, field.Proto.Name.ProtoName(), typeName, field.Name))
+		}
+	}
+	sb.WriteString(fmt.Sprintf(`
+	}
+	return gremlin.NewUnknownFieldError(%q, number)
+}
+`, g.Proto.Name.String()))
+}
+
+func (g *GoEnumType) writeEnumDescriptor(sb *strings.Builder) {
+	varName := "descriptor" + g.EnumName
+	sb.WriteString(fmt.Sprintf(`
+var %v = gremlin.RegisterEnum(&gremlin.EnumDescriptor{
+	Name: %q,
+	Values: []gremlin.EnumValueDescriptor{
+`, varName, g.Proto.Name.String()))
+	for _, v := range g.Values {
+		sb.WriteString(fmt.Sprintf("\t\t{Name: %q, Number: %v},\n", v.Proto.Name.ProtoName(), v.Value))
+	}
+	sb.WriteString(fmt.Sprintf(`	},
+})
+
+func (%v) XXX_Descriptor() *gremlin.EnumDescriptor {
+	return %v
+}
+`, g.EnumName, varName))
+}
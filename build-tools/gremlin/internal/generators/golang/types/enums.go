@@ -99,6 +99,8 @@ func (g *GoEnumType) GenerateCode(sb *strings.Builder) {
 	g.writeEnumHeader(sb)
 	g.writeEnumConstants(sb)
 	g.writeEnumToString(sb)
+	g.writeEnumJson(sb)
+	g.writeEnumDescriptor(sb)
 }
 
 func (g *GoEnumType) writeEnumHeader(sb *strings.Builder) {
@@ -0,0 +1,120 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// negate keeps generated conditions readable: a == b becomes a != b, calls get !
+func negate(condition string) string {
+	if strings.HasSuffix(condition, ")") {
+		return "!" + condition
+	}
+	return strings.Replace(condition, " == ", " != ", 1)
+}
+
+func (g *GoStructType) writeEqual(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+// Equal compares messages by protobuf rules: nil and empty lists or maps are equal, NaN is equal to NaN,
+// nil message is not equal to empty one, unknown fields should be equal bytes
+func (s *%v) Equal(other *%v) bool {
+	if s == nil || other == nil {
+		return s == other
+	}`, g.StructName, g.StructName))
+	for _, field := range g.Fields {
+		if field.OneOf == nil {
+			field.writeEqual(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeEqual(sb)
+		}
+	}
+	sb.WriteString(`
+	return bytes.Equal(s.XXX_unrecognized, other.XXX_unrecognized)
+}
+`)
+}
+
+func (g *GoStructField) writeEqual(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+	if %v {
+		return false
+	}`, negate(g.Type.EntryEqual("s."+g.Name, "other."+g.Name))))
+}
+
+func (g *GoOneOfGroup) writeEqual(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\n\tswitch v := s.%v.(type) {", g.Name))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf(`
+	case *%v:
+		o, ok := other.%v.(*%v)
+		if !ok || %v {
+			return false
+		}`, field.WrapperName, g.Name, field.WrapperName, negate(field.Type.EntryEqual("v."+field.Name, "o."+field.Name))))
+	}
+	sb.WriteString(fmt.Sprintf(`
+	default:
+		if other.%v != nil {
+			return false
+		}
+	}`, g.Name))
+}
+
+// Diff is generic, it walks fields by descriptor
+func (g *GoStructType) writeDiff(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+// Diff lists paths of fields which make messages not Equal, unknown fields differ at gremlin.UnknownFieldsPath
+func (s *%v) Diff(other *%v) []gremlin.FieldDiff {
+	return gremlin.Diff(s, other)
+}
+`, g.StructName, g.StructName))
+}
+
+func (g *GoStructType) writeMerge(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+// Merge merges src into the message as parsing of both messages one after another would do:
+// scalars are replaced if they would be written, lists are appended, map entries are replaced,
+// messages and chosen members of oneofs are merged recursively, unknown fields are appended
+func (s *%v) Merge(src *%v) {
+	if s == nil || src == nil {
+		return
+	}
+`, g.StructName, g.StructName))
+	for _, field := range g.Fields {
+		if field.OneOf == nil {
+			field.writeMerge(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeMerge(sb)
+		}
+	}
+	sb.WriteString(`	s.XXX_unrecognized = append(s.XXX_unrecognized, src.XXX_unrecognized...)
+}
+`)
+}
+
+func (g *GoStructField) writeMerge(sb *strings.Builder) {
+	sb.WriteString(g.Type.EntryMerge("\t", "s."+g.Name, "src."+g.Name) + "\n")
+}
+
+// members of oneof are present even with default values, only messages of the same member are merged
+func (g *GoOneOfGroup) writeMerge(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\tswitch v := src.%v.(type) {", g.Name))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf("\n\tcase *%v:", field.WrapperName))
+		if field.isMessage() {
+			sb.WriteString(fmt.Sprintf(`
+		if dst, ok := s.%v.(*%v); ok {
+%v
+			break
+		}`, g.Name, field.WrapperName, field.Type.EntryMerge("\t\t\t", "dst."+field.Name, "v."+field.Name)))
+		}
+		sb.WriteString(fmt.Sprintf(`
+		c := &%v{}
+%v
+		s.%v = c`, field.WrapperName, field.Type.EntryCopy("\t\t", "c."+field.Name, "v."+field.Name), g.Name))
+	}
+	sb.WriteString("\n\t}\n")
+}
+
+func (g *GoStructField) isMessage() bool {
+	return g.Proto.LocalMsgType != nil || g.Proto.ExternalMsgType != nil
+}
@@ -0,0 +1,125 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (g *GoStructType) writeCopyMasked(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+// CopyMasked copies fields selected by the mask, messages with sub paths are copied partially.
+// Nil mask copies everything, otherwise unknown fields are not copied
+func (s *%v) CopyMasked(mask *gremlin.FieldMask) *%v {
+	if s == nil {
+		return nil
+	}
+	if mask == nil {
+		return s.Copy()
+	}
+	res := &%v{}
+`, g.StructName, g.StructName, g.StructName))
+	for _, field := range g.Fields {
+		if field.OneOf == nil {
+			field.writeCopyMasked(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeCopyMasked(sb)
+		}
+	}
+	sb.WriteString(`	return res
+}
+`)
+}
+
+func (g *GoStructField) writeCopyMasked(sb *strings.Builder) {
+	name := g.Proto.Name.ProtoName()
+	if g.isSingularMessage() {
+		sb.WriteString(fmt.Sprintf("\tif mask.Has(%q) {\n\t\tres.%v = s.%v.CopyMasked(mask.Sub(%q))\n\t}\n", name, g.Name, g.Name, name))
+		return
+	}
+	sb.WriteString(fmt.Sprintf("\tif mask.Has(%q) {\n%v\n\t}\n", name, g.Type.EntryCopy("\t\t", "res."+g.Name, "s."+g.Name)))
+}
+
+func (g *GoOneOfGroup) writeCopyMasked(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\tswitch v := s.%v.(type) {", g.Name))
+	for _, field := range g.Fields {
+		name := field.Proto.Name.ProtoName()
+		copyCode := field.Type.EntryCopy("\t\t\t", "c."+field.Name, "v."+field.Name)
+		if field.isMessage() {
+			copyCode = fmt.Sprintf("\t\t\tc.%v = v.%v.CopyMasked(mask.Sub(%q))", field.Name, field.Name, name)
+		}
+		sb.WriteString(fmt.Sprintf(`
+	case *%v:
+		if mask.Has(%q) {
+			c := &%v{}
+%v
+			res.%v = c
+		}`, field.WrapperName, name, field.WrapperName, copyCode, g.Name))
+	}
+	sb.WriteString("\n\t}\n")
+}
+
+func (g *GoStructType) writeMergeMasked(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+// MergeMasked applies partial update: fields selected by the mask are replaced with fields of src,
+// fields absent in src are cleared, messages with sub paths are updated recursively,
+// members of oneofs are replaced as a whole. Nil mask replaces everything, nil src is empty message
+func (s *%v) MergeMasked(src *%v, mask *gremlin.FieldMask) {
+	if s == nil {
+		return
+	}
+	if src == nil {
+		src = &%v{}
+	}
+	if mask == nil {
+		*s = *src.Copy()
+		return
+	}
+`, g.StructName, g.StructName, g.StructName))
+	if len(g.Fields) > 0 {
+		sb.WriteString("\tc := src.CopyMasked(mask)\n")
+	}
+	for _, field := range g.Fields {
+		field.writeMergeMasked(sb)
+	}
+	sb.WriteString("}\n")
+}
+
+func (g *GoStructField) writeMergeMasked(sb *strings.Builder) {
+	name := g.Proto.Name.ProtoName()
+	switch {
+	case g.OneOf != nil:
+		sb.WriteString(fmt.Sprintf(`	if mask.Has(%q) {
+		if _, ok := c.%v.(*%v); ok {
+			s.%v = c.%v
+		} else if _, ok := s.%v.(*%v); ok {
+			s.%v = nil
+		}
+	}
+`, name, g.OneOf.Name, g.WrapperName, g.OneOf.Name, g.OneOf.Name, g.OneOf.Name, g.WrapperName, g.OneOf.Name))
+	case g.isSingularMessage():
+		sb.WriteString(fmt.Sprintf(`	if sub := mask.Sub(%q); sub != nil {
+		if s.%v == nil && src.%v != nil {
+			s.%v = &%v{}
+		}
+		s.%v.MergeMasked(src.%v, sub)
+	} else if mask.Has(%q) {
+		s.%v = c.%v
+	}
+`, name, g.Name, g.Name, g.Name, strings.TrimPrefix(g.Type.WriterTypeName(), "*"), g.Name, g.Name, name, g.Name, g.Name))
+	default:
+		sb.WriteString(fmt.Sprintf("\tif mask.Has(%q) {\n\t\ts.%v = c.%v\n\t}\n", name, g.Name, g.Name))
+	}
+}
+
+func (g *GoStructType) writeMarshalMasked(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+// MarshalMasked writes only fields selected by the mask
+func (s *%v) MarshalMasked(mask *gremlin.FieldMask) []byte {
+	return s.CopyMasked(mask).Marshal()
+}
+`, g.StructName))
+}
+
+func (g *GoStructField) isSingularMessage() bool {
+	return g.isMessage() && !g.Proto.Repeated && !g.Proto.Map
+}
@@ -29,10 +29,16 @@ type GoStructField struct {
 	Name   string
 	Type   core.GoFieldType
 	Proto  *types.MessageFieldDefinition
+
+	OneOf       *GoOneOfGroup
+	WrapperName string
 }
 
 func (g *GoStructField) parseName(field *types.MessageFieldDefinition) {
-	var name = field.Name.ProtoName()
+	g.Name = goName(field.Name.ProtoName())
+}
+
+func goName(name string) string {
 	targetNameShouldUpper := true
 	resName := ""
 	for _, c := range name {
@@ -48,7 +54,15 @@ func (g *GoStructField) parseName(field *types.MessageFieldDefinition) {
 			}
 		}
 	}
-	g.Name = resName
+	return resName
+}
+
+func (g *GoStructField) isFirstInOneOf() bool {
+	return g.OneOf != nil && g.OneOf.Fields[0] == g
+}
+
+func (g *GoStructField) caseConstName() string {
+	return fmt.Sprintf("%v_%v", g.OneOf.caseTypeName(), g.Name)
 }
 
 func (g *GoStructField) wireTypeConstName() string {
@@ -81,14 +95,18 @@ func (g *GoStructField) writeAccessors(sb *strings.Builder) {
 }
 
 func (g *GoStructField) writeGetter(sb *strings.Builder) {
+	var condition = "m == nil"
+	if g.OneOf != nil {
+		condition = fmt.Sprintf("m == nil || m.%v != %v", g.OneOf.caseFieldName(), g.caseConstName())
+	}
 	sb.WriteString(fmt.Sprintf(`
 func (m *%vReader) Get%v() %v {
-	if m == nil {
+	if %v {
 		return %v
 	}
 	return m.read%v()
 }
-`, g.Struct.StructName, g.Name, g.Type.ReaderTypeName(), g.Type.DefaultReturn(), g.Name))
+`, g.Struct.StructName, g.Name, g.Type.ReaderTypeName(), condition, g.Type.DefaultReturn(), g.Name))
 }
 
 func (g *GoStructField) writeReader(sb *strings.Builder) {
@@ -110,10 +128,25 @@ func (m *%vReader) read%v() %v {
 `, g.Struct.StructName, g.Name, g.Type.ReaderTypeName(), g.Name, g.Name, g.Name, wireTypeCode, g.Type.EntryReader("\t", "entry"), g.Name, g.Name))
 }
 
+// field with unexpected wire type is kept as unknown, lazy getters never see data they can't read
 func (g *GoStructField) writeUnmarshal(sb *strings.Builder) {
+	var mismatch = fmt.Sprintf("wire != %v", g.Type.WireType())
+	if g.Proto.Repeated && g.Type.WireType() != "gremlin.BytesType" {
+		mismatch += " && wire != gremlin.BytesType"
+	}
 	sb.WriteString(fmt.Sprintf(`
 		case %v:
-%v`, g.wireTypeConstName(), g.Type.EntryUnmarshalSaveOffsets("\t\t\t", g.Name)))
+			if %v {
+				unknown = true
+				break
+			}
+			if !m.mask.Has(%q) {
+				break
+			}
+%v`, g.wireTypeConstName(), mismatch, g.Proto.Name.ProtoName(), g.Type.EntryUnmarshalSaveOffsets("\t\t\t", g.Name)))
+	if g.OneOf != nil {
+		sb.WriteString(fmt.Sprintf("\n\t\t\tm.%v = %v", g.OneOf.caseFieldName(), g.caseConstName()))
+	}
 }
 
 func (g *GoStructField) writeStructField(sb *strings.Builder) {
@@ -155,6 +188,18 @@ func (g *GoStructField) writeMarshal(sb *strings.Builder) {
 	}`, g.Type.EntryIsNotEmpty("s."+g.Name), g.Type.EntryWriter("\t\t", "res", g.wireTypeConstName(), "s."+g.Name)))
 }
 
+func (g *GoStructField) writeValidate(sb *strings.Builder) {
+	if code := g.Type.EntryValidate("\t", "m.offset"+g.Name, "m.wireType"+g.Name, "opts"); code != "" {
+		sb.WriteString(code + "\n")
+	}
+}
+
+func (g *GoStructField) writeDiscardUnknown(sb *strings.Builder) {
+	if code := g.Type.EntryDiscardUnknown("\t", "s."+g.Name); code != "" {
+		sb.WriteString(code + "\n")
+	}
+}
+
 func (g *GoStructField) writeCopy(sb *strings.Builder) {
 	sb.WriteString(g.Type.EntryCopy("\t", "res."+g.Name, "s."+g.Name) + "\n")
 }
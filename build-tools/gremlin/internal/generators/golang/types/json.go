@@ -0,0 +1,201 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (g *GoStructField) jsonName() string {
+	return g.Proto.JsonName()
+}
+
+// jsonKeys lists accepted json keys of the field: json name and original proto name
+func (g *GoStructField) jsonKeys() string {
+	keys := fmt.Sprintf("%q", g.jsonName())
+	if g.Proto.Name.ProtoName() != g.jsonName() {
+		keys += fmt.Sprintf(", %q", g.Proto.Name.ProtoName())
+	}
+	return keys
+}
+
+func (g *GoStructField) writeMarshalJson(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+		if %v {
+			w.Key(%q)
+%v
+		}`, g.Type.EntryIsNotEmpty("s."+g.Name), g.jsonName(), g.Type.JsonWriter("\t\t\t", "w", "s."+g.Name)))
+}
+
+func (g *GoStructField) writeReaderMarshalJson(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+		if v := m.Get%v(); %v {
+			w.Key(%q)
+%v
+		}`, g.Name, g.Type.EntryIsNotEmpty("v"), g.jsonName(), g.Type.JsonWriter("\t\t\t", "w", "v")))
+}
+
+func (g *GoStructField) writeUnmarshalJson(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+		case %v:
+%v`, g.jsonKeys(), g.Type.JsonReader("\t\t\t", "s."+g.Name, "raw")))
+}
+
+func (g *GoOneOfGroup) writeMarshalJson(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\n\t\tswitch v := s.%v.(type) {", g.Name))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf(`
+		case *%v:
+			w.Key(%q)
+%v`, field.WrapperName, field.jsonName(), field.Type.JsonWriter("\t\t\t", "w", "v."+field.Name)))
+	}
+	sb.WriteString("\n\t\t}")
+}
+
+func (g *GoOneOfGroup) writeReaderMarshalJson(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\n\t\tswitch m.%v {", g.caseFieldName()))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf(`
+		case %v:
+			w.Key(%q)
+%v`, field.caseConstName(), field.jsonName(), field.Type.JsonWriter("\t\t\t", "w", "m.Get"+field.Name+"()")))
+	}
+	sb.WriteString("\n\t\t}")
+}
+
+func (g *GoOneOfGroup) writeUnmarshalJson(sb *strings.Builder) {
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf(`
+		case %v:
+			if s.%v != nil {
+				return gremlin.JsonFieldError(key, gremlin.ErrJsonDuplicateOneOf)
+			}
+			var v %v
+%v
+			s.%v = &%v{%v: v}`, field.jsonKeys(), g.Name, field.Type.WriterTypeName(),
+			field.Type.JsonReader("\t\t\t", "v", "raw"), g.Name, field.WrapperName, field.Name))
+	}
+}
+
+func writeMarshalJson(sb *strings.Builder, receiver string, typeName string) {
+	sb.WriteString(fmt.Sprintf(`
+func (%v *%v) MarshalJSON() ([]byte, error) {
+	w := gremlin.NewJsonWriter()
+	%v.MarshalJSONTo(w)
+	return w.Bytes(), w.Err()
+}
+`, receiver, typeName, receiver))
+}
+
+func (g *GoStructType) writeJson(sb *strings.Builder) {
+	writeMarshalJson(sb, "s", g.StructName)
+	if custom := g.wellKnownJson(); custom != nil {
+		custom.writeStruct(g, sb)
+		return
+	}
+
+	sb.WriteString(fmt.Sprintf(`
+func (s *%v) MarshalJSONTo(w *gremlin.JsonWriter) {
+	w.BeginObject()
+	if s != nil {`, g.StructName))
+	for _, field := range g.Fields {
+		if field.OneOf == nil {
+			field.writeMarshalJson(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeMarshalJson(sb)
+		}
+	}
+	sb.WriteString(`
+	}
+	w.EndObject()
+}
+`)
+
+	sb.WriteString(fmt.Sprintf(`
+func (s *%v) UnmarshalJSON(data []byte) error {
+	fields, err := gremlin.JsonObject(data)
+	if err != nil {
+		return err
+	}
+	*s = %v{}`, g.StructName, g.StructName))
+	// absent proto2 fields keep their defaults, as after ToStruct of the reader
+	for _, field := range g.Fields {
+		if field.OneOf == nil && field.Proto.DefaultValue != nil {
+			sb.WriteString(fmt.Sprintf("\n\ts.%v = %v", field.Name, field.Type.DefaultReturn()))
+		}
+	}
+	sb.WriteString(`
+	for key, raw := range fields {
+		if gremlin.JsonIsNull(raw) {
+			continue
+		}
+		switch key {`)
+	for _, field := range g.Fields {
+		if field.OneOf == nil {
+			field.writeUnmarshalJson(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeUnmarshalJson(sb)
+		}
+	}
+	sb.WriteString(`
+		}
+		if err != nil {
+			return gremlin.JsonFieldError(key, err)
+		}
+	}
+	return nil
+}
+`)
+}
+
+// writeReaderJson lets readers produce the same json as their structs without ToStruct call
+func (g *GoStructType) writeReaderJson(sb *strings.Builder) {
+	writeMarshalJson(sb, "m", g.StructName+"Reader")
+	if custom := g.wellKnownJson(); custom != nil {
+		custom.writeReader(g, sb)
+		return
+	}
+
+	sb.WriteString(fmt.Sprintf(`
+func (m *%vReader) MarshalJSONTo(w *gremlin.JsonWriter) {
+	w.BeginObject()
+	if m != nil {`, g.StructName))
+	for _, field := range g.Fields {
+		if field.OneOf == nil {
+			field.writeReaderMarshalJson(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeReaderMarshalJson(sb)
+		}
+	}
+	sb.WriteString(`
+	}
+	w.EndObject()
+}
+`)
+}
+
+func (g *GoEnumType) writeEnumJson(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+func (e %v) MarshalJSON() ([]byte, error) {
+	w := gremlin.NewJsonWriter()
+	w.Enum(int32(e), e.String())
+	return w.Bytes(), nil
+}
+
+func (e *%v) UnmarshalJSON(data []byte) error {
+	value, err := gremlin.JsonEnum(data, func(name string) (int32, bool) {
+		switch name {
+`, g.EnumName, g.EnumName))
+	for _, v := range g.Values {
+		sb.WriteString(fmt.Sprintf("\t\tcase %q:\n\t\t\treturn %v, true\n", v.Proto.Name.ProtoName(), v.Value))
+	}
+	sb.WriteString(fmt.Sprintf(`		}
+		return 0, false
+	})
+	if err != nil {
+		return err
+	}
+	*e = %v(value)
+	return nil
+}
+`, g.EnumName))
+}
@@ -0,0 +1,146 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GoOneOfGroup is a oneof of a message: struct keeps it as a single field of interface type
+// implemented by per-member wrapper structs, reader tracks the member seen last on the wire
+type GoOneOfGroup struct {
+	Struct    *GoStructType
+	Name      string
+	ProtoName string
+	Fields    []*GoStructField
+}
+
+func (g *GoOneOfGroup) interfaceName() string {
+	return fmt.Sprintf("is%v_%v", g.Struct.StructName, g.Name)
+}
+
+func (g *GoOneOfGroup) caseTypeName() string {
+	return fmt.Sprintf("%v_%vCase", g.Struct.StructName, g.Name)
+}
+
+func (g *GoOneOfGroup) notSetConstName() string {
+	return g.caseTypeName() + "_NotSet"
+}
+
+func (g *GoOneOfGroup) caseFieldName() string {
+	return "case" + g.Name
+}
+
+func (g *GoOneOfGroup) writeTypes(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+type %v int32
+
+const (
+	%v %v = 0
+`, g.caseTypeName(), g.notSetConstName(), g.caseTypeName()))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf("\t%v %v = %v\n", field.caseConstName(), g.caseTypeName(), field.Proto.ProtoDef.Sequence))
+	}
+	sb.WriteString(")\n")
+
+	sb.WriteString(fmt.Sprintf(`
+type %v interface {
+	%v()
+}
+`, g.interfaceName(), g.interfaceName()))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf(`
+type %v struct {
+	%v	%v	`+"`"+`json:"%v"`+"`"+`
+}
+
+func (*%v) %v() {}
+`, field.WrapperName, field.Name, field.Type.WriterTypeName(), field.Proto.Name.ProtoName(),
+			field.WrapperName, g.interfaceName()))
+	}
+}
+
+func (g *GoOneOfGroup) writeReaderField(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\n\t%v %v\n", g.caseFieldName(), g.caseTypeName()))
+}
+
+func (g *GoOneOfGroup) writeWhich(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+func (m *%vReader) Which%v() %v {
+	if m == nil {
+		return %v
+	}
+	return m.%v
+}
+`, g.Struct.StructName, g.Name, g.caseTypeName(), g.notSetConstName(), g.caseFieldName()))
+}
+
+func (g *GoOneOfGroup) writeStructField(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`	%v	%v	`+"`"+`json:"%v,omitempty"`+"`"+`
+`, g.Name, g.interfaceName(), g.ProtoName))
+}
+
+func (g *GoOneOfGroup) writeToStruct(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\n\tswitch m.%v {", g.caseFieldName()))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf("\n\tcase %v:\n", field.caseConstName()))
+		if field.Type.JsonStructCanBeUsedDirectly() {
+			sb.WriteString(fmt.Sprintf("\t\tres.%v = &%v{%v: m.Get%v()}", g.Name, field.WrapperName, field.Name, field.Name))
+		} else {
+			sb.WriteString(fmt.Sprintf(`		var data = m.Get%v()
+		var structData %v
+%v
+		res.%v = &%v{%v: structData}`, field.Name, field.Type.WriterTypeName(), field.Type.ToStruct("\t\t", "structData", "data"),
+				g.Name, field.WrapperName, field.Name))
+		}
+	}
+	sb.WriteString("\n\t}\n")
+}
+
+// set member is written even if it holds default value, so the choice survives round trip
+func (g *GoOneOfGroup) writeMarshal(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\n\tswitch v := s.%v.(type) {", g.Name))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf("\n\tcase *%v:\n%v", field.WrapperName,
+			field.Type.EntryWriter("\t\t", "res", field.wireTypeConstName(), "v."+field.Name)))
+	}
+	sb.WriteString("\n\t}")
+}
+
+func (g *GoOneOfGroup) writeSizeCalc(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\n\tswitch v := s.%v.(type) {", g.Name))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf(`
+	case *%v:
+		var entrySize = 0
+%v
+		size += entrySize`, field.WrapperName,
+			field.Type.EntryFullSizeWithTag("\t\t", "entrySize", "v."+field.Name, field.wireTypeConstName())))
+	}
+	sb.WriteString("\n\t}\n")
+}
+
+func (g *GoOneOfGroup) writeCopy(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\tswitch v := s.%v.(type) {", g.Name))
+	for _, field := range g.Fields {
+		sb.WriteString(fmt.Sprintf(`
+	case *%v:
+		c := &%v{}
+%v
+		res.%v = c`, field.WrapperName, field.WrapperName,
+			field.Type.EntryCopy("\t\t", "c."+field.Name, "v."+field.Name), g.Name))
+	}
+	sb.WriteString("\n\t}\n")
+}
+
+func (g *GoOneOfGroup) writeDiscardUnknown(sb *strings.Builder) {
+	var cases strings.Builder
+	for _, field := range g.Fields {
+		if code := field.Type.EntryDiscardUnknown("\t\t", "v."+field.Name); code != "" {
+			cases.WriteString(fmt.Sprintf("\n\tcase *%v:\n%v", field.WrapperName, code))
+		}
+	}
+	if cases.Len() == 0 {
+		return
+	}
+	sb.WriteString(fmt.Sprintf("\tswitch v := s.%v.(type) {%v\n\t}\n", g.Name, cases.String()))
+}
@@ -0,0 +1,183 @@
+package types
+
+import (
+	"fmt"
+	"octopus/build-tools/gremlin/internal/types"
+	"strings"
+)
+
+// GoServiceType generates server interface, client over gremlin.RpcTransport
+// and gremlin.RpcHandler dispatching calls to the server implementation
+type GoServiceType struct {
+	ServiceName string
+	Proto       *types.ServiceDefinition
+
+	Methods []*GoServiceMethod
+}
+
+type GoServiceMethod struct {
+	Name  string
+	Proto *types.ServiceMethodDefinition
+
+	RequestPackage  string
+	RequestName     string
+	ResponsePackage string
+	ResponseName    string
+}
+
+func (g *GoServiceType) GetName() string {
+	return g.ServiceName
+}
+
+func (g *GoServiceType) IsEnum(*types.EnumDefinition) bool {
+	return false
+}
+
+func (g *GoServiceType) IsStruct(*types.MessageDefinition) bool {
+	return false
+}
+
+func (g *GoServiceType) GetEnumForDefault(string) string {
+	return ""
+}
+
+func NewServiceType(serviceDef *types.ServiceDefinition) *GoServiceType {
+	return &GoServiceType{
+		ServiceName: goName(serviceDef.Name.ProtoName()),
+		Proto:       serviceDef,
+	}
+}
+
+func (g *GoServiceType) AddMethod(methodDef *types.ServiceMethodDefinition, requestPackage string, requestName string, responsePackage string, responseName string) {
+	g.Methods = append(g.Methods, &GoServiceMethod{
+		Name:            goName(methodDef.Name),
+		Proto:           methodDef,
+		RequestPackage:  requestPackage,
+		RequestName:     requestName,
+		ResponsePackage: responsePackage,
+		ResponseName:    responseName,
+	})
+}
+
+func (g *GoServiceMethod) requestType(suffix string) string {
+	if g.RequestPackage == "" {
+		return g.RequestName + suffix
+	}
+	return g.RequestPackage + "." + g.RequestName + suffix
+}
+
+func (g *GoServiceMethod) responseType(suffix string) string {
+	if g.ResponsePackage == "" {
+		return g.ResponseName + suffix
+	}
+	return g.ResponsePackage + "." + g.ResponseName + suffix
+}
+
+func (g *GoServiceMethod) newRequestReader() string {
+	if g.RequestPackage == "" {
+		return "New" + g.RequestName + "Reader()"
+	}
+	return g.RequestPackage + ".New" + g.RequestName + "Reader()"
+}
+
+func (g *GoServiceMethod) newResponseReader() string {
+	if g.ResponsePackage == "" {
+		return "New" + g.ResponseName + "Reader()"
+	}
+	return g.ResponsePackage + ".New" + g.ResponseName + "Reader()"
+}
+
+func (g *GoServiceType) nameConstName() string {
+	return g.ServiceName + "Name"
+}
+
+func (g *GoServiceType) handlerTypeName() string {
+	return strings.ToLower(g.ServiceName[:1]) + g.ServiceName[1:] + "Handler"
+}
+
+func (g *GoServiceType) GenerateCode(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+const %v = %q
+`, g.nameConstName(), g.Proto.Name.String()))
+
+	g.writeServerInterface(sb)
+	g.writeClient(sb)
+	g.writeHandler(sb)
+}
+
+// server gets lazy readers of requests and returns structs to marshal
+func (g *GoServiceType) writeServerInterface(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf("\ntype %vServer interface {\n", g.ServiceName))
+	for _, method := range g.Methods {
+		sb.WriteString(fmt.Sprintf("\t%v(ctx context.Context, request *%v) (*%v, error)\n",
+			method.Name, method.requestType("Reader"), method.responseType("")))
+	}
+	sb.WriteString("}\n")
+}
+
+func (g *GoServiceType) writeClient(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+type %vClient struct {
+	transport gremlin.RpcTransport
+}
+
+func New%vClient(transport gremlin.RpcTransport) *%vClient {
+	return &%vClient{transport: transport}
+}
+`, g.ServiceName, g.ServiceName, g.ServiceName, g.ServiceName))
+
+	for _, method := range g.Methods {
+		sb.WriteString(fmt.Sprintf(`
+func (c *%vClient) %v(ctx context.Context, request *%v) (*%v, error) {
+	data, err := c.transport.Call(ctx, %v, %q, request.Marshal())
+	if err != nil {
+		return nil, err
+	}
+	response := %v
+	if err = response.SafeUnmarshal(data, gremlin.DefaultDecodeOptions); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+`, g.ServiceName, method.Name, method.requestType(""), method.responseType("Reader"),
+			g.nameConstName(), method.Proto.Name, method.newResponseReader()))
+	}
+}
+
+func (g *GoServiceType) writeHandler(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+type %v struct {
+	server %vServer
+}
+
+func New%vHandler(server %vServer) gremlin.RpcHandler {
+	return &%v{server: server}
+}
+
+func (h *%v) ServiceName() string {
+	return %v
+}
+
+func (h *%v) HandleRpc(ctx context.Context, method string, data []byte) ([]byte, error) {
+	switch method {`, g.handlerTypeName(), g.ServiceName, g.ServiceName, g.ServiceName, g.handlerTypeName(),
+		g.handlerTypeName(), g.nameConstName(), g.handlerTypeName()))
+
+	for _, method := range g.Methods {
+		sb.WriteString(fmt.Sprintf(`
+	case %q:
+		request := %v
+		if err := request.SafeUnmarshal(data, gremlin.DefaultDecodeOptions); err != nil {
+			return nil, gremlin.RpcBadRequest(err)
+		}
+		response, err := h.server.%v(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		return response.Marshal(), nil`, method.Proto.Name, method.newRequestReader(), method.Name))
+	}
+	sb.WriteString(`
+	}
+	return nil, gremlin.ErrRpcUnknownMethod
+}
+`)
+}
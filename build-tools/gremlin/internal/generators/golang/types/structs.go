@@ -29,6 +29,10 @@ type GoStructType struct {
 	Proto      *types.MessageDefinition
 
 	Fields []*GoStructField
+	OneOfs []*GoOneOfGroup
+
+	NestedMessages []string
+	NestedEnums    []string
 }
 
 func (g *GoStructType) GetName() string {
@@ -72,25 +76,80 @@ func (g *GoStructType) AddField(fieldDef *types.MessageFieldDefinition, fieldTyp
 		Struct: g,
 	}
 	field.parseName(fieldDef)
+	if fieldDef.OneOfGroup != "" {
+		g.addOneOfField(field)
+	}
 
 	g.Fields = append(g.Fields, field)
 }
 
+func (g *GoStructType) addOneOfField(field *GoStructField) {
+	var group *GoOneOfGroup
+	for _, oneOf := range g.OneOfs {
+		if oneOf.ProtoName == field.Proto.OneOfGroup {
+			group = oneOf
+			break
+		}
+	}
+	if group == nil {
+		group = &GoOneOfGroup{
+			Struct:    g,
+			Name:      goName(field.Proto.OneOfGroup),
+			ProtoName: field.Proto.OneOfGroup,
+		}
+		g.OneOfs = append(g.OneOfs, group)
+	}
+
+	field.OneOf = group
+	field.WrapperName = g.StructName + "_" + field.Name
+	group.Fields = append(group.Fields, field)
+}
+
+// ResolveOneOfWrapperNames renames oneof wrappers clashing with other types of the file
+func (g *GoStructType) ResolveOneOfWrapperNames(isTypeNameUsed func(name string) bool) {
+	for _, group := range g.OneOfs {
+		for _, field := range group.Fields {
+			for isTypeNameUsed(field.WrapperName) {
+				field.WrapperName += "_"
+			}
+		}
+	}
+}
+
 func (g *GoStructType) GenerateCode(sb *strings.Builder) {
 	g.writeWireTypes(sb)
 	// reader
 	g.writeReaderStruct(sb)
 	g.writeReaderConstructor(sb)
 	g.writeFieldsAccessors(sb)
+	g.writeUnknownFields(sb)
 	g.writeUnmarshal(sb)
+	g.writeSafeUnmarshal(sb)
 	g.writeToStruct(sb)
 	g.writeGetBytes(sb)
+	g.writeReaderJson(sb)
+	g.writeProtoName(sb, g.StructName+"Reader")
+	g.writeReaderGetField(sb)
 
 	// writer
 	g.writeStruct(sb)
+	g.writeOneOfTypes(sb)
 	g.writeMarshal(sb)
 	g.writeCopy(sb)
+	g.writeEqual(sb)
+	g.writeDiff(sb)
+	g.writeMerge(sb)
+	g.writeCopyMasked(sb)
+	g.writeMergeMasked(sb)
+	g.writeMarshalMasked(sb)
+	g.writeDiscardUnknown(sb)
 	g.writeSize(sb)
+	g.writeJson(sb)
+	g.writeProtoName(sb, g.StructName)
+	g.writeGetField(sb)
+	g.writeSetField(sb)
+	g.writeDescriptor(sb)
+	g.writeWellKnown(sb)
 }
 
 func (g *GoStructType) writeWireTypes(sb *strings.Builder) {
@@ -118,7 +177,10 @@ type %vReader struct {
 	for _, field := range g.Fields {
 		field.writeProtoStruct(sb)
 	}
-	sb.WriteString("}\n")
+	for _, group := range g.OneOfs {
+		group.writeReaderField(sb)
+	}
+	sb.WriteString("\n\tunknownFields []int\n\tmask          *gremlin.FieldMask\n}\n")
 }
 
 func (g *GoStructType) writeStruct(sb *strings.Builder) {
@@ -126,46 +188,97 @@ func (g *GoStructType) writeStruct(sb *strings.Builder) {
 type %v struct {
 `, g.StructName))
 	for _, field := range g.Fields {
-		field.writeStructField(sb)
+		if field.OneOf == nil {
+			field.writeStructField(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeStructField(sb)
+		}
+	}
+	sb.WriteString("\n\tXXX_unrecognized []byte `json:\"-\"`\n}\n")
+}
+
+func (g *GoStructType) writeOneOfTypes(sb *strings.Builder) {
+	for _, group := range g.OneOfs {
+		group.writeTypes(sb)
 	}
-	sb.WriteString("}\n")
 }
 
 func (g *GoStructType) writeFieldsAccessors(sb *strings.Builder) {
 	for _, field := range g.Fields {
 		field.writeAccessors(sb)
 	}
+	for _, group := range g.OneOfs {
+		group.writeWhich(sb)
+	}
 }
 
 func (g *GoStructType) writeUnmarshal(sb *strings.Builder) {
 	sb.WriteString(fmt.Sprintf(`
 func (m *%vReader) Unmarshal(data []byte) error {
+	return m.UnmarshalMasked(data, nil)
+}
+
+// UnmarshalMasked reads only fields selected by the mask, other fields and unknown ones are dropped,
+// nested messages are read with their sub masks. Nil mask reads everything as Unmarshal does
+func (m *%vReader) UnmarshalMasked(data []byte, mask *gremlin.FieldMask) error {
 	m.buf = gremlin.NewReader(data)
+	m.mask = mask
+	// offsets of the previous buffer are meaningless for reused reader
+	m.unknownFields = m.unknownFields[:0]
 	offset := 0
 	for m.buf.HasNext(offset, 0) {
+		fieldStart := offset
 		tag, wire, tagSize, err := m.buf.ReadTagAt(offset)
 		if err != nil {
 			return err
 		}
 
 		offset += tagSize
-		switch tag {`, g.StructName))
+		next, err := m.buf.SkipData(offset, wire)
+		if err != nil {
+			return err
+		}
+
+		var unknown bool
+		switch tag {`, g.StructName, g.StructName))
 	for _, field := range g.Fields {
 		field.writeUnmarshal(sb)
 	}
 	sb.WriteString(`
+		default:
+			unknown = true
 		}
 
-		offset, err = m.buf.SkipData(offset, wire)
-		if err != nil {
-			return err
+		if unknown && m.mask == nil {
+			m.unknownFields = append(m.unknownFields, fieldStart, next)
 		}
+		offset = next
 	}
 	return nil
 }
 `)
 }
 
+// lazy getters trust offsets saved by Unmarshal, so everything they would parse later is checked here
+func (g *GoStructType) writeSafeUnmarshal(sb *strings.Builder) {
+	var checks strings.Builder
+	for _, field := range g.Fields {
+		field.writeValidate(&checks)
+	}
+	sb.WriteString(fmt.Sprintf(`
+func (m *%vReader) SafeUnmarshal(data []byte, opts gremlin.DecodeOptions) error {
+	opts, err := opts.Enter(data)
+	if err != nil {
+		return err
+	}
+	if err = m.Unmarshal(data); err != nil {
+		return err
+	}
+%v	return nil
+}
+`, g.StructName, checks.String()))
+}
+
 func (g *GoStructType) writeToStruct(sb *strings.Builder) {
 	sb.WriteString(fmt.Sprintf(`
 func (m *%vReader) ToStruct() *%v {
@@ -175,9 +288,14 @@ func (m *%vReader) ToStruct() *%v {
 	res := &%v{}
 `, g.StructName, g.StructName, g.StructName))
 	for _, field := range g.Fields {
-		field.writeToStruct(sb)
+		if field.OneOf == nil {
+			field.writeToStruct(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeToStruct(sb)
+		}
 	}
 	sb.WriteString(`
+	res.XXX_unrecognized = m.UnknownFields()
 	return res
 }
 `)
@@ -205,9 +323,14 @@ func (s *%v) MarshalTo(res *gremlin.Writer) {
 `, g.StructName, g.StructName))
 
 	for _, field := range g.Fields {
-		field.writeMarshal(sb)
+		if field.OneOf == nil {
+			field.writeMarshal(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeMarshal(sb)
+		}
 	}
 	sb.WriteString(`
+	res.AppendRaw(s.XXX_unrecognized)
 }
 `)
 }
@@ -222,9 +345,14 @@ func (s *%v) XXX_PbContentSize() int {
 `, g.StructName))
 
 	for _, field := range g.Fields {
-		field.writeSizeCalc(sb)
+		if field.OneOf == nil {
+			field.writeSizeCalc(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeSizeCalc(sb)
+		}
 	}
 	sb.WriteString(`
+	size += len(s.XXX_unrecognized)
 	return size
 }
 `)
@@ -241,6 +369,14 @@ func (s *%vReader) SourceBytes() []byte {
 `, g.StructName))
 }
 
+func (g *GoStructType) writeProtoName(sb *strings.Builder, typeName string) {
+	sb.WriteString(fmt.Sprintf(`
+func (*%v) XXX_ProtoName() string {
+	return %q
+}
+`, typeName, g.Proto.Name.String()))
+}
+
 func (g *GoStructType) writeCopy(sb *strings.Builder) {
 	sb.WriteString(fmt.Sprintf(`
 func (s *%v) Copy() *%v {
@@ -250,10 +386,46 @@ func (s *%v) Copy() *%v {
 	res := &%v{}
 `, g.StructName, g.StructName, g.StructName))
 	for _, field := range g.Fields {
-		field.writeCopy(sb)
+		if field.OneOf == nil {
+			field.writeCopy(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeCopy(sb)
+		}
 	}
 	sb.WriteString(`
+	// copy must not share unknown fields, Merge appends to them
+	res.XXX_unrecognized = append([]byte(nil), s.XXX_unrecognized...)
 	return res
 }
 `)
 }
+
+// unknown fields are kept as offsets of the source buffer until requested
+func (g *GoStructType) writeUnknownFields(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+func (m *%vReader) UnknownFields() []byte {
+	if m == nil {
+		return nil
+	}
+	return m.buf.ReadRanges(m.unknownFields)
+}
+`, g.StructName))
+}
+
+func (g *GoStructType) writeDiscardUnknown(sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+func (s *%v) DiscardUnknown() {
+	if s == nil {
+		return
+	}
+	s.XXX_unrecognized = nil
+`, g.StructName))
+	for _, field := range g.Fields {
+		if field.OneOf == nil {
+			field.writeDiscardUnknown(sb)
+		} else if field.isFirstInOneOf() {
+			field.OneOf.writeDiscardUnknown(sb)
+		}
+	}
+	sb.WriteString("}\n")
+}
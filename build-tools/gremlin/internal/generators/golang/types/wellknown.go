@@ -0,0 +1,306 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// well-known types from protobufs/google/protobuf get conversions to go types
+// and their special forms of canonical json
+type wellKnownType struct {
+	imports []string
+	code    func(g *GoStructType, sb *strings.Builder)
+	json    func(g *GoStructType) *customJson
+}
+
+// customJson holds bodies of struct MarshalJSONTo (s is never nil), struct UnmarshalJSON (sets err)
+// and reader MarshalJSONTo (m can be nil, getters handle it)
+type customJson struct {
+	marshal       string
+	unmarshal     string
+	readerMarshal string
+}
+
+var wellKnownTypes = map[string]*wellKnownType{
+	"google.protobuf.Timestamp": {
+		imports: []string{"time"},
+		code:    writeTimestampCode,
+		json: func(*GoStructType) *customJson {
+			return &customJson{
+				marshal:       "\tw.Timestamp(s.Seconds, s.Nanos)",
+				unmarshal:     "\ts.Seconds, s.Nanos, err = gremlin.JsonTimestamp(data)",
+				readerMarshal: "\tw.Timestamp(m.GetSeconds(), m.GetNanos())",
+			}
+		},
+	},
+	"google.protobuf.Duration": {
+		imports: []string{"time"},
+		code:    writeDurationCode,
+		json: func(*GoStructType) *customJson {
+			return &customJson{
+				marshal:       "\tw.Duration(s.Seconds, s.Nanos)",
+				unmarshal:     "\ts.Seconds, s.Nanos, err = gremlin.JsonDuration(data)",
+				readerMarshal: "\tw.Duration(m.GetSeconds(), m.GetNanos())",
+			}
+		},
+	},
+	"google.protobuf.Any": {
+		code: writeAnyCode,
+		json: func(*GoStructType) *customJson {
+			return &customJson{
+				marshal:       "\tw.Any(s.TypeUrl, s.Value)",
+				unmarshal:     "\ts.TypeUrl, s.Value, err = gremlin.JsonAny(data)",
+				readerMarshal: "\tw.Any(m.GetTypeUrl(), m.GetValue())",
+			}
+		},
+	},
+	"google.protobuf.FieldMask": {
+		code: writeFieldMaskCode,
+		json: func(*GoStructType) *customJson {
+			return &customJson{
+				marshal:       "\tw.FieldMask(s.Paths)",
+				unmarshal:     "\ts.Paths, err = gremlin.JsonFieldMask(data)",
+				readerMarshal: "\tw.FieldMask(m.GetPaths())",
+			}
+		},
+	},
+	"google.protobuf.Value":       {json: valueJson},
+	"google.protobuf.Struct":      {json: unwrappedJson},
+	"google.protobuf.ListValue":   {json: unwrappedJson},
+	"google.protobuf.DoubleValue": {json: unwrappedJson},
+	"google.protobuf.FloatValue":  {json: unwrappedJson},
+	"google.protobuf.Int64Value":  {json: unwrappedJson},
+	"google.protobuf.UInt64Value": {json: unwrappedJson},
+	"google.protobuf.Int32Value":  {json: unwrappedJson},
+	"google.protobuf.UInt32Value": {json: unwrappedJson},
+	"google.protobuf.BoolValue":   {json: unwrappedJson},
+	"google.protobuf.StringValue": {json: unwrappedJson},
+	"google.protobuf.BytesValue":  {json: unwrappedJson},
+}
+
+func (g *GoStructType) wellKnown() *wellKnownType {
+	return wellKnownTypes[g.Proto.Name.String()]
+}
+
+// WellKnownImports lists go imports required by the well-known type code
+func (g *GoStructType) WellKnownImports() []string {
+	if wk := g.wellKnown(); wk != nil {
+		return wk.imports
+	}
+	return nil
+}
+
+func (g *GoStructType) wellKnownJson() *customJson {
+	if wk := g.wellKnown(); wk != nil && wk.json != nil {
+		return wk.json(g)
+	}
+	return nil
+}
+
+func (g *GoStructType) writeWellKnown(sb *strings.Builder) {
+	if wk := g.wellKnown(); wk != nil && wk.code != nil {
+		wk.code(g, sb)
+	}
+}
+
+func (c *customJson) writeStruct(g *GoStructType, sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+func (s *%v) MarshalJSONTo(w *gremlin.JsonWriter) {
+	if s == nil {
+		s = &%v{}
+	}
+%v
+}
+
+func (s *%v) UnmarshalJSON(data []byte) error {
+	*s = %v{}
+	var err error
+%v
+	return err
+}
+`, g.StructName, g.StructName, c.marshal, g.StructName, g.StructName, c.unmarshal))
+}
+
+func (c *customJson) writeReader(g *GoStructType, sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+func (m *%vReader) MarshalJSONTo(w *gremlin.JsonWriter) {
+%v
+}
+`, g.StructName, c.readerMarshal))
+}
+
+// wrappers, Struct and ListValue are written as their only field
+func unwrappedJson(g *GoStructType) *customJson {
+	field := g.Fields[0]
+	return &customJson{
+		marshal:   field.Type.JsonWriter("\t", "w", "s."+field.Name),
+		unmarshal: field.Type.JsonReader("\t", "s."+field.Name, "data"),
+		readerMarshal: fmt.Sprintf("\tv := m.Get%v()\n%v",
+			field.Name, field.Type.JsonWriter("\t", "w", "v")),
+	}
+}
+
+// Value is any json value, its kind is chosen by the first byte of json
+func valueJson(g *GoStructType) *customJson {
+	group := g.OneOfs[0]
+	var kinds = map[string]string{
+		"string_value": `'"'`,
+		"bool_value":   `'t', 'f'`,
+		"struct_value": `'{'`,
+		"list_value":   `'['`,
+	}
+
+	marshal := &strings.Builder{}
+	readerMarshal := &strings.Builder{}
+	unmarshal := &strings.Builder{}
+	marshal.WriteString(fmt.Sprintf("\tswitch v := s.%v.(type) {", group.Name))
+	readerMarshal.WriteString(fmt.Sprintf("\tswitch m.%v() {", "Which"+group.Name))
+	unmarshal.WriteString("\tswitch gremlin.JsonValueKind(data) {")
+	var number *GoStructField
+	for _, field := range group.Fields {
+		switch field.Proto.Name.ProtoName() {
+		case "null_value":
+			unmarshal.WriteString(fmt.Sprintf("\n\tcase 'n':\n\t\ts.%v = &%v{}", group.Name, field.WrapperName))
+			continue
+		case "number_value":
+			number = field
+		default:
+			unmarshal.WriteString(fmt.Sprintf("\n\tcase %v:", kinds[field.Proto.Name.ProtoName()]))
+			writeValueKindUnmarshal(unmarshal, group, field)
+		}
+		marshal.WriteString(fmt.Sprintf("\n\tcase *%v:\n%v", field.WrapperName,
+			field.Type.JsonWriter("\t\t", "w", "v."+field.Name)))
+		readerMarshal.WriteString(fmt.Sprintf("\n\tcase %v:\n%v", field.caseConstName(),
+			field.Type.JsonWriter("\t\t", "w", "m.Get"+field.Name+"()")))
+	}
+	unmarshal.WriteString("\n\tdefault:")
+	writeValueKindUnmarshal(unmarshal, group, number)
+	for _, sb := range []*strings.Builder{marshal, readerMarshal} {
+		sb.WriteString("\n\tdefault:\n\t\tw.Null()")
+	}
+	for _, sb := range []*strings.Builder{marshal, readerMarshal, unmarshal} {
+		sb.WriteString("\n\t}")
+	}
+
+	return &customJson{
+		marshal:       marshal.String(),
+		unmarshal:     unmarshal.String(),
+		readerMarshal: readerMarshal.String(),
+	}
+}
+
+func writeValueKindUnmarshal(sb *strings.Builder, group *GoOneOfGroup, field *GoStructField) {
+	sb.WriteString(fmt.Sprintf(`
+		v := &%v{}
+%v
+		s.%v = v`, field.WrapperName, field.Type.JsonReader("\t\t", "v."+field.Name, "data"), group.Name))
+}
+
+func writeTimestampCode(g *GoStructType, sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+// New%v converts t to %v
+func New%v(t time.Time) *%v {
+	res := &%v{}
+	res.Seconds, res.Nanos = gremlin.TimeToProto(t)
+	return res
+}
+
+// AsTime converts timestamp to time.Time in UTC
+func (s *%v) AsTime() time.Time {
+	if s == nil {
+		return gremlin.TimeFromProto(0, 0)
+	}
+	return gremlin.TimeFromProto(s.Seconds, s.Nanos)
+}
+
+func (m *%vReader) AsTime() time.Time {
+	return gremlin.TimeFromProto(m.GetSeconds(), m.GetNanos())
+}
+`, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName))
+}
+
+func writeDurationCode(g *GoStructType, sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+// New%v converts d to %v
+func New%v(d time.Duration) *%v {
+	res := &%v{}
+	res.Seconds, res.Nanos = gremlin.DurationToProto(d)
+	return res
+}
+
+// AsDuration converts duration to time.Duration, saturating values out of its range
+func (s *%v) AsDuration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return gremlin.DurationFromProto(s.Seconds, s.Nanos)
+}
+
+func (m *%vReader) AsDuration() time.Duration {
+	return gremlin.DurationFromProto(m.GetSeconds(), m.GetNanos())
+}
+`, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName))
+}
+
+func writeAnyCode(g *GoStructType, sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+// New%v packs msg with its type url
+func New%v(msg gremlin.ProtoMessage) *%v {
+	return &%v{
+		TypeUrl: gremlin.AnyTypeUrl(msg.XXX_ProtoName()),
+		Value:   msg.Marshal(),
+	}
+}
+
+// MessageName returns full proto name of the packed message
+func (s *%v) MessageName() string {
+	if s == nil {
+		return ""
+	}
+	return gremlin.AnyMessageName(s.TypeUrl)
+}
+
+// UnmarshalTo unpacks the message into msg, fails with gremlin.ErrAnyTypeMismatch if msg is of other type
+func (s *%v) UnmarshalTo(msg gremlin.ProtoMessageReader) error {
+	if err := gremlin.AnyCheckType(s.MessageName(), msg); err != nil {
+		return err
+	}
+	return msg.Unmarshal(s.Value)
+}
+
+func (m *%vReader) MessageName() string {
+	return gremlin.AnyMessageName(m.GetTypeUrl())
+}
+
+func (m *%vReader) UnmarshalTo(msg gremlin.ProtoMessageReader) error {
+	if err := gremlin.AnyCheckType(m.GetTypeUrl(), msg); err != nil {
+		return err
+	}
+	return msg.Unmarshal(m.GetValue())
+}
+`, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName))
+}
+
+func writeFieldMaskCode(g *GoStructType, sb *strings.Builder) {
+	sb.WriteString(fmt.Sprintf(`
+// New%v holds paths of proto names separated by dots
+func New%v(paths ...string) *%v {
+	return &%v{Paths: paths}
+}
+
+// Mask converts paths to gremlin.FieldMask, absent mask is nil and selects all fields
+func (s *%v) Mask() *gremlin.FieldMask {
+	if s == nil {
+		return nil
+	}
+	return gremlin.NewFieldMask(s.Paths...)
+}
+
+func (m *%vReader) Mask() *gremlin.FieldMask {
+	if m == nil {
+		return nil
+	}
+	return gremlin.NewFieldMask(m.GetPaths()...)
+}
+`, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName, g.StructName))
+}
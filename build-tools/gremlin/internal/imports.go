@@ -43,6 +43,10 @@ func ResolveImportsAndReferences(parsed []*types.ProtoFile) []error {
 		file := parsed[i]
 		errors = append(errors, resolveImports(file, parsedMap)...)
 	}
+	// references can't be resolved through missing files
+	if len(errors) > 0 {
+		return errors
+	}
 
 	for i := range parsed {
 		addPublicImports(parsed[i])
@@ -56,6 +60,10 @@ func ResolveImportsAndReferences(parsed []*types.ProtoFile) []error {
 		errors = append(errors, resolveReferences(parsed[i])...)
 	}
 
+	for i := range parsed {
+		errors = append(errors, resolveServices(parsed[i])...)
+	}
+
 	for i := range parsed {
 		errors = append(errors, resolveOptions(parsed[i])...)
 	}
@@ -86,6 +94,8 @@ func resolveImports(pFile *types.ProtoFile, parsed map[string]*types.ProtoFile)
 
 		if _, found := parsed[path]; found {
 			imp.TargetFile = parsed[path]
+		} else if wellKnown := findWellKnownImport(imp.FSPath, parsed); wellKnown != nil {
+			imp.TargetFile = wellKnown
 		} else {
 			errors = append(errors, fmt.Errorf("failed to resolve `%v`\nSource: %v\nRoot: %v\nPath: %v",
 				aurora.Red("import "+imp.FSPath),
@@ -98,6 +108,19 @@ func resolveImports(pFile *types.ProtoFile, parsed map[string]*types.ProtoFile)
 	return errors
 }
 
+// well-known types are bundled in protobufs/google/protobuf and can be imported from any base folder
+func findWellKnownImport(fsPath string, parsed map[string]*types.ProtoFile) *types.ProtoFile {
+	if !strings.HasPrefix(fsPath, types.WellKnownImportPrefix) {
+		return nil
+	}
+	for _, file := range parsed {
+		if file.RelativePath == fsPath {
+			return file
+		}
+	}
+	return nil
+}
+
 type fsNode struct {
 	Path     string
 	Children []*fsNode
@@ -145,7 +168,7 @@ func resolveBaseFolders(parsed []*types.ProtoFile) error {
 		rootNode = rootNode.Children[0]
 	}
 
-	if len(rootNode.Children) < 1 {
+	if len(rootNode.Children) < 1 && len(rootNode.Files) == 0 {
 		log.Fatalf(`
 We've found too many (or zero) subdirs on default zero level of build path, not sure what happened.
 Here is what we see at the very system root of OS we're building on: %v
@@ -160,6 +183,10 @@ Here is what we see at the very system root of OS we're building on: %v
 }
 
 func assignBaseFolder(rootNode *fsNode) {
+	// files of a single proto folder import each other by plain names
+	for _, file := range rootNode.Files {
+		file.BaseFolder = rootNode.Path
+	}
 	for _, child := range rootNode.Children {
 		recursivelyAssignImportBaseFolder(child, child.Path)
 	}
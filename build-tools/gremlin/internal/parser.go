@@ -92,6 +92,11 @@ func walkHandler(pFile *types.ProtoFile, errors *[]error, lock *sync.Mutex) prot
 			pFile.Enums = append(pFile.Enums, extractEnum(pFile, e))
 			return
 		}
+
+		if s, ok := v.(*proto.Service); ok {
+			pFile.Services = append(pFile.Services, extractService(pFile, s, errors, lock))
+			return
+		}
 	}
 }
 
@@ -134,6 +139,35 @@ func extractEnum(pFile *types.ProtoFile, e *proto.Enum) *types.EnumDefinition {
 	return res
 }
 
+func extractService(pFile *types.ProtoFile, s *proto.Service, errors *[]error, lock *sync.Mutex) *types.ServiceDefinition {
+	res := &types.ServiceDefinition{
+		Name:     buildScopedName(pFile, s, s.Name),
+		ProtoDef: s,
+	}
+
+	for _, element := range s.Elements {
+		rpc, ok := element.(*proto.RPC)
+		if !ok {
+			continue
+		}
+		if rpc.StreamsRequest || rpc.StreamsReturns {
+			lock.Lock()
+			*errors = append(*errors, fmt.Errorf("streaming rpc is not supported by this parser (file: %s, service: %v, rpc: %v)", pFile.Path, s.Name, rpc.Name))
+			lock.Unlock()
+			continue
+		}
+
+		res.Methods = append(res.Methods, &types.ServiceMethodDefinition{
+			Name:     rpc.Name,
+			Request:  &types.ServiceTypeReference{ProtoType: rpc.RequestType},
+			Response: &types.ServiceTypeReference{ProtoType: rpc.ReturnsType},
+			ProtoDef: rpc,
+		})
+	}
+
+	return res
+}
+
 func extractMessage(pFile *types.ProtoFile, e *proto.Message, errors *[]error, lock *sync.Mutex) *types.MessageDefinition {
 	res := &types.MessageDefinition{
 		Name:     buildScopedName(pFile, e, e.Name),
@@ -65,6 +65,8 @@ func buildRelativePath(root string, pFile *types.ProtoFile) string {
 
 	relative := strings.TrimPrefix(pFile.Path, root)
 	relative = strings.TrimPrefix(relative, "/protobufs/")
+	// root may be the protobufs folder itself
+	relative = strings.TrimPrefix(relative, "/")
 	return relative
 }
 
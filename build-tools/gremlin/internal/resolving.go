@@ -115,6 +115,81 @@ func resolveReferences(pFile *types.ProtoFile) []error {
 	return errors
 }
 
+func resolveServices(pFile *types.ProtoFile) []error {
+	var errors []error
+
+	for _, service := range pFile.Services {
+		for _, method := range service.Methods {
+			for _, ref := range []*types.ServiceTypeReference{method.Request, method.Response} {
+				if resolveLocalServiceReference(pFile, service, ref) || resolveImportedServiceReference(pFile, ref) {
+					continue
+				}
+
+				errors = append(errors,
+					fmt.Errorf("failed to resolve reference %v in %v.%v (%v)",
+						ref.ProtoType,
+						service.Name.String(),
+						method.Name,
+						pFile.RelativePath,
+					))
+			}
+		}
+	}
+
+	return errors
+}
+
+func resolveLocalServiceReference(file *types.ProtoFile, s *types.ServiceDefinition, ref *types.ServiceTypeReference) bool {
+	scopedName := types.ParseName(ref.ProtoType)
+	searchPath := s.Name
+	search := true
+	for search {
+		name := scopedName.ToScope(searchPath)
+
+		for _, msg := range file.Messages {
+			if msg.Name.Equal(name) && !msg.ProtoDef.IsExtend {
+				ref.LocalMsgType = msg
+				return true
+			}
+		}
+		search = searchPath.CanResolveParent()
+		searchPath = searchPath.ToParent()
+	}
+
+	return false
+}
+
+func resolveImportedServiceReference(file *types.ProtoFile, ref *types.ServiceTypeReference) bool {
+	scopedName := types.ParseName(ref.ProtoType)
+
+	for _, protoImport := range file.Imports {
+		var searchPath types.ScopedName
+		if protoImport.TargetFile == nil {
+			continue
+		}
+
+		if protoImport.TargetFile.Package != nil {
+			searchPath = protoImport.TargetFile.Package.Name
+		}
+
+		var search = true
+		for search {
+			name := scopedName.ToScope(searchPath)
+
+			for _, msg := range protoImport.TargetFile.Messages {
+				if msg.Name.Equal(name) && !msg.ProtoDef.IsExtend {
+					ref.ExternalMsgType = msg
+					ref.ExternalTypeFile = protoImport.TargetFile
+					return true
+				}
+			}
+			search = searchPath.CanResolveParent()
+			searchPath = searchPath.ToParent()
+		}
+	}
+	return false
+}
+
 func resolveLocalExtendSource(pFile *types.ProtoFile, m *types.MessageDefinition) *types.MessageDefinition {
 	scopedName := types.ParseName(m.ProtoDef.Name)
 	searchPath := m.Name
@@ -0,0 +1,30 @@
+package types
+
+import "github.com/emicklei/proto"
+
+type ServiceDefinition struct {
+	Name ScopedName
+
+	Methods []*ServiceMethodDefinition
+
+	ProtoDef *proto.Service
+}
+
+type ServiceMethodDefinition struct {
+	Name string
+
+	Request  *ServiceTypeReference
+	Response *ServiceTypeReference
+
+	ProtoDef *proto.RPC
+}
+
+// ServiceTypeReference is request or response message of rpc, resolved like message fields
+type ServiceTypeReference struct {
+	ProtoType string
+
+	ExternalTypeFile *ProtoFile
+	ExternalMsgType  *MessageDefinition
+
+	LocalMsgType *MessageDefinition
+}
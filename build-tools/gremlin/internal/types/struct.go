@@ -17,7 +17,10 @@ Created by ab, 27.09.2022
 
 package types
 
-import "github.com/emicklei/proto"
+import (
+	"github.com/emicklei/proto"
+	"strings"
+)
 
 type TargetPlatform string
 
@@ -108,6 +111,30 @@ type MessageFieldDefinition struct {
 	DefaultValue *proto.Option
 }
 
+// JsonName follows protoc: explicit json_name option or lowerCamel form of the proto name
+func (m *MessageFieldDefinition) JsonName() string {
+	for _, opt := range m.ProtoDef.Options {
+		if opt.Name == "json_name" {
+			return opt.Constant.Source
+		}
+	}
+
+	var res strings.Builder
+	upperNext := false
+	for _, c := range m.Name.ProtoName() {
+		if c == '_' {
+			upperNext = true
+			continue
+		}
+		if upperNext && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upperNext = false
+		res.WriteRune(c)
+	}
+	return res.String()
+}
+
 func (m *MessageFieldDefinition) Copy() *MessageFieldDefinition {
 	res := &MessageFieldDefinition{
 		Name:             m.Name,
@@ -22,6 +22,7 @@ import "github.com/emicklei/proto"
 const PbGoExtension = ".pb2.go"
 const ProtoExtension = ".proto"
 const TargetFolder = "target/generated-sources/protobuf"
+const WellKnownImportPrefix = "google/protobuf/"
 
 type ProtoFile struct {
 	Path         string
@@ -33,6 +34,7 @@ type ProtoFile struct {
 	Imports  []*ProtoImport
 	Enums    []*EnumDefinition
 	Messages []*MessageDefinition
+	Services []*ServiceDefinition
 
 	BaseFolder string // used for search for imports
 }
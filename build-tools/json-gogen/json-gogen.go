@@ -18,6 +18,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -347,19 +348,25 @@ func processConfigFile(baseDir string, goParsed *goSourcesState, config *genConf
 
 	genPackage := filepath.Base(filepath.Dir(config.configPath))
 	genPackage = strings.ReplaceAll(genPackage, "-", "_")
-	generated += fmt.Sprintf("\npackage %v\n\n", genPackage)
-	generated += generateImports(state.imports)
-	generated += "\n"
 
 	generatedType, err := generateTypeInstanceString(res.Type, state, false)
 	if err != nil {
 		return err
 	}
+	declarations, err := generateDeclarations(resName, res.Type, parsedStruct, state)
+	if err != nil {
+		return err
+	}
+
+	generated += fmt.Sprintf("\npackage %v\n\n", genPackage)
+	generated += generateImports(state.imports)
+	generated += "\n"
 
 	generated += fmt.Sprintf("var %v %v", resName, generatedType)
 	if parsedStruct != nil {
 		generated += " = " + state.buf.String()
 	}
+	generated += "\n" + declarations
 
 	cleanedTargetPath := filepath.Base(config.configPath)
 	cleanedTargetPath = strings.ReplaceAll(cleanedTargetPath, "+", "")
@@ -524,14 +531,7 @@ func generateGoStruct(val interface{}, structType *types.Struct, state *generato
 		state.buf.WriteString("nil")
 		return nil
 	}
-	casted, ok := val.(map[string]interface{})
-	if !ok {
-		res := make(map[string]interface{})
-		for k, v := range val.(map[interface{}]interface{}) {
-			res[k.(string)] = v
-		}
-		casted = res
-	}
+	casted := castConfigObject(val)
 	structDef, err := generateTypeInstanceString(structType, state, false)
 	if err != nil {
 		return err
@@ -539,21 +539,10 @@ func generateGoStruct(val interface{}, structType *types.Struct, state *generato
 	state.buf.WriteString(structDef)
 	state.buf.WriteString("{\n")
 	for i := 0; i < structType.NumFields(); i++ {
-		tag := structType.Tag(i)
-		if tag == "" {
-			continue
-		}
-		castedTag := reflect.StructTag(tag)
-		targetKey, _ := castedTag.Lookup("json")
-		if targetKey == "" {
-			targetKey, _ = castedTag.Lookup("yaml")
-		}
+		targetKey := getConfigKey(structType.Tag(i))
 		if targetKey == "" {
 			continue
 		}
-		if strings.Contains(targetKey, ",") {
-			targetKey = strings.Split(targetKey, ",")[0]
-		}
 
 		value, found := casted[targetKey]
 		if found && value != nil {
@@ -569,6 +558,114 @@ func generateGoStruct(val interface{}, structType *types.Struct, state *generato
 	return nil
 }
 
+// castConfigObject returns parsed json/yaml object as map, yaml objects are parsed with interface{} keys
+func castConfigObject(val interface{}) map[string]interface{} {
+	casted, ok := val.(map[string]interface{})
+	if !ok {
+		res := make(map[string]interface{})
+		for k, v := range val.(map[interface{}]interface{}) {
+			res[k.(string)] = v
+		}
+		casted = res
+	}
+	return casted
+}
+
+// getConfigKey returns config key of struct field by json or yaml tag
+func getConfigKey(tag string) string {
+	if tag == "" {
+		return ""
+	}
+	castedTag := reflect.StructTag(tag)
+	targetKey, _ := castedTag.Lookup("json")
+	if targetKey == "" {
+		targetKey, _ = castedTag.Lookup("yaml")
+	}
+	if strings.Contains(targetKey, ",") {
+		targetKey = strings.Split(targetKey, ",")[0]
+	}
+	return targetKey
+}
+
+// generateDeclarations generates top level declarations for entries of map fields of config struct
+// marked with `gogen` tag, map keys are used as names:
+//
+//	`gogen:"vars"` - variable with map entry for every key
+//	`gogen:"consts=Field"` - constant with value of Field of map entry for every key
+func generateDeclarations(resName string, goType types.Type, val interface{}, state *generatorState) (string, error) {
+	structType, isStruct := goType.Underlying().(*types.Struct)
+	if !isStruct || val == nil {
+		return "", nil
+	}
+	casted := castConfigObject(val)
+
+	res := ""
+	for i := 0; i < structType.NumFields(); i++ {
+		gogenTag, found := reflect.StructTag(structType.Tag(i)).Lookup("gogen")
+		if !found {
+			continue
+		}
+		field := structType.Field(i)
+		mapType, isMap := field.Type().Underlying().(*types.Map)
+		if !isMap {
+			return "", fmt.Errorf("gogen tag of %v: field is not a map", field.Name())
+		}
+
+		value := casted[getConfigKey(structType.Tag(i))]
+		if value == nil {
+			continue
+		}
+		entries := castConfigObject(value)
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if !token.IsIdentifier(key) || !token.IsExported(key) {
+				return "", fmt.Errorf("gogen tag of %v: %q is not exported identifier", field.Name(), key)
+			}
+
+			if gogenTag == "vars" {
+				res += fmt.Sprintf("var %v = %v.%v[%q]\n", key, resName, field.Name(), key)
+				continue
+			}
+
+			constField := strings.TrimPrefix(gogenTag, "consts=")
+			if constField == gogenTag {
+				return "", fmt.Errorf("gogen tag of %v: unknown %q", field.Name(), gogenTag)
+			}
+			entryType, isStructEntry := mapType.Elem().Underlying().(*types.Struct)
+			if !isStructEntry {
+				return "", fmt.Errorf("gogen tag of %v: entries are not structs", field.Name())
+			}
+			var constType types.Type
+			var constValue interface{}
+			for j := 0; j < entryType.NumFields(); j++ {
+				if entryType.Field(j).Name() == constField {
+					constType = entryType.Field(j).Type()
+					constValue = castConfigObject(entries[key])[getConfigKey(entryType.Tag(j))]
+				}
+			}
+			if _, isBasic := constType.Underlying().(*types.Basic); constType == nil || !isBasic || constValue == nil {
+				return "", fmt.Errorf("gogen tag of %v: %v of %v is not a basic value", field.Name(), constField, key)
+			}
+
+			constState := &generatorState{imports: state.imports, buf: bytes.NewBufferString("")}
+			if err := generateGoSource(constType, constValue, constState); err != nil {
+				return "", err
+			}
+			typeDef, err := generateTypeInstanceString(constType, state, false)
+			if err != nil {
+				return "", err
+			}
+			res += fmt.Sprintf("const %v %v = %v\n", key, typeDef, constState.buf.String())
+		}
+	}
+	return res, nil
+}
+
 func generateGoSlice(val interface{}, sliceType *types.Slice, state *generatorState) error {
 	if val == nil {
 		state.buf.WriteString("nil")
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"octopus/shared/nerve"
+	protoNerve "octopus/target/generated-sources/protobuf/nerve"
+	"os"
+	"time"
+)
+
+var mode = flag.String("mode", "", "export, import or verify")
+var file = flag.String("file", "", "archive file path")
+var host = flag.String("host", "127.0.0.1", "nerve mysql host")
+var port = flag.Uint("port", 0, "nerve mysql port")
+var dbName = flag.String("db", "nerve", "nerve database name")
+var queueName = flag.String("queue", "", "queue to export from or import to")
+var tableParallelism = flag.Uint("table-parallelism", 4, "number of queue tables")
+var pointersParallelism = flag.Uint("pointers-parallelism", 1, "number of queue pointers tables")
+var shardedPointers = flag.Bool("sharded-pointers", false, "pointers are migrated to pointers-parallelism tables, write them to their shards")
+var from = flag.Int64("from", 0, "export packets with index > from")
+var to = flag.Int64("to", 0, "export packets with index <= to, 0 means up to writer pointer")
+var sourced = flag.Bool("sourced", false, "packets are sent by SendTraced, export their sending time as record timestamps")
+var keepEncrypted = flag.Bool("keep-encrypted", false, "export encrypted packets as ciphertext instead of failing, such archive can't be imported")
+
+func main() {
+	flag.Parse()
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+
+	if *file == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	switch *mode {
+	case "verify":
+		verify()
+	case "export":
+		export()
+	case "import":
+		verify()
+		restore()
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func verify() {
+	f, err := os.Open(*file)
+	if err != nil {
+		zlog.Fatal().Err(err).Send()
+	}
+	defer f.Close()
+
+	// records without timestamp have 0 in it
+	var records, minTs, maxTs int64
+	header, err := nerve.ReadArchive(f, func(record *protoNerve.NerveArchiveRecordReader) error {
+		records++
+		if ts := record.GetTimestamp(); ts != 0 {
+			if minTs == 0 || ts < minTs {
+				minTs = ts
+			}
+			if ts > maxTs {
+				maxTs = ts
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		zlog.Fatal().Err(err).Str("file", *file).Msg("archive verification failed")
+	}
+
+	event := zlog.Info().
+		Str("file", *file).
+		Str("queue", header.QueueName).
+		Int64("from", header.FromIndex).
+		Int64("to", header.ToIndex).
+		Int64("records", records)
+	if maxTs != 0 {
+		event = event.Time("first-ts", time.Unix(0, minTs)).Time("last-ts", time.Unix(0, maxTs))
+	}
+	event.Msg("archive is valid")
+}
+
+func getSynapse() (*nerve.Synapse, nerve.QueueConfig) {
+	if *queueName == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	backend, err := nerve.NewSMysqlBackend(nerve.SMysqlBackendConfig{
+		Host:                *host,
+		Port:                *port,
+		DbName:              *dbName,
+		TableParallelism:    *tableParallelism,
+		PointersParallelism: *pointersParallelism,
+		MaxRPSPerThread:     50,
+		ShardedPointers:     *shardedPointers,
+	})
+	if err != nil {
+		zlog.Fatal().Err(err).Send()
+	}
+
+	s := nerve.NewSynapse(backend)
+	s.SetTrace(false)
+
+	return s, nerve.QueueConfig{Name: nerve.QueueName(*queueName)}
+}
+
+func export() {
+	s, queue := getSynapse()
+
+	f, err := os.Create(*file)
+	if err != nil {
+		zlog.Fatal().Err(err).Send()
+	}
+
+	upTo := nerve.QueueElementIndex(*to)
+	if upTo == 0 {
+		upTo = 1<<63 - 1
+	}
+
+	var tsExtractor nerve.ArchiveTimestampExtractor
+	if *sourced {
+		tsExtractor = nerve.SourcedPacketTimestamp
+	}
+
+	n, err := s.ExportRange(queue, nerve.QueueElementIndex(*from), upTo, f, tsExtractor, *keepEncrypted)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		zlog.Fatal().Err(err).Uint64("records", n).Msg("export failed")
+	}
+
+	zlog.Info().Str("file", *file).Uint64("records", n).Msg("export done")
+}
+
+func restore() {
+	s, queue := getSynapse()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		zlog.Fatal().Err(err).Send()
+	}
+	defer f.Close()
+
+	n, err := s.ImportArchive(queue, f)
+	if err != nil {
+		zlog.Fatal().Err(err).Uint64("records", n).Msg("import failed")
+	}
+
+	zlog.Info().Str("file", *file).Uint64("records", n).Msg("import done")
+}
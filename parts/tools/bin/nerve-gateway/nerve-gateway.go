@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"net/http"
+	"octopus/shared/nerve"
+	"octopus/shared/nerve/gateway"
+	"os"
+	"strings"
+	"time"
+)
+
+var listen = flag.String("listen", ":8080", "address to listen on")
+var host = flag.String("host", "127.0.0.1", "nerve mysql host")
+var port = flag.Uint("port", 0, "nerve mysql port")
+var dbName = flag.String("db", "nerve", "nerve database name")
+var tableParallelism = flag.Uint("table-parallelism", 4, "number of queue tables")
+var pointersParallelism = flag.Uint("pointers-parallelism", 1, "number of queue pointers tables")
+var shardedPointers = flag.Bool("sharded-pointers", false, "pointers are migrated to pointers-parallelism tables, write them to their shards")
+var maxRPS = flag.Uint("max-rps", 50, "max mysql requests per second per thread")
+var queues = flag.String("queues", "", "comma separated list of allowed queues, empty allows any valid queue name")
+var maxBodySize = flag.Int64("max-body-size", 64<<20, "max request body size in bytes")
+var leaseTimeout = flag.Duration("lease-timeout", 30*time.Second, "consumed packets not acked in that time are delivered again")
+var idleTimeout = flag.Duration("idle-timeout", 5*time.Minute, "receivers of consumers without requests in that time are closed")
+
+func main() {
+	flag.Parse()
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+
+	backend, err := nerve.NewSMysqlBackend(nerve.SMysqlBackendConfig{
+		Host:                *host,
+		Port:                *port,
+		DbName:              *dbName,
+		TableParallelism:    *tableParallelism,
+		PointersParallelism: *pointersParallelism,
+		MaxRPSPerThread:     *maxRPS,
+		ShardedPointers:     *shardedPointers,
+	})
+	if err != nil {
+		zlog.Fatal().Err(err).Send()
+	}
+
+	s := nerve.NewSynapse(backend)
+	s.SetTrace(false)
+
+	var allowed []nerve.QueueConfig
+	for _, name := range strings.Split(*queues, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if !nerve.QueueName(name).IsValid() {
+				zlog.Fatal().Str("queue", name).Msg("invalid queue name")
+			}
+			allowed = append(allowed, nerve.QueueConfig{Name: nerve.QueueName(name)})
+		}
+	}
+
+	g := gateway.NewGateway(s, allowed...).
+		WithMaxBodySize(*maxBodySize).
+		WithLeaseTimeout(*leaseTimeout).
+		WithIdleTimeout(*idleTimeout)
+	defer g.Close()
+
+	zlog.Info().Str("listen", *listen).Int("queues", len(allowed)).Msg("starting nerve gateway")
+	if err = http.ListenAndServe(*listen, g); err != nil {
+		zlog.Fatal().Err(err).Send()
+	}
+}
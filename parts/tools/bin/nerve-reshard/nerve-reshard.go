@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"octopus/shared/nerve"
+	"os"
+)
+
+var host = flag.String("host", "127.0.0.1", "nerve mysql host")
+var port = flag.Uint("port", 0, "nerve mysql port")
+var dbName = flag.String("db", "nerve", "nerve database name")
+var queueName = flag.String("queue", "", "name of the queue to reshard")
+var from = flag.Uint("from", 0, "current number of queue tables")
+var to = flag.Uint("to", 0, "new number of queue tables")
+var pointersParallelism = flag.Uint("pointers-parallelism", 1, "number of queue pointers tables")
+var shardedPointers = flag.Bool("sharded-pointers", false, "pointers are migrated to pointers-parallelism tables, write them to their shards")
+var pointersFrom = flag.Uint("pointers-from", 0, "previous number of queue pointers tables, migrates pointers to pointers-parallelism tables (the same number shards pointers stored before sharding)")
+var batchSize = flag.Int64("batch", 10_000, "number of ids copied at once")
+
+func main() {
+	flag.Parse()
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+
+	reshardTables := *from != 0 && *to != 0 && *from != *to
+	migratePointers := *pointersFrom != 0
+	if *queueName == "" || (!reshardTables && !migratePointers) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if !reshardTables {
+		*from = 0
+	}
+
+	backend, err := nerve.NewSMysqlBackend(nerve.SMysqlBackendConfig{
+		Host:                     *host,
+		Port:                     *port,
+		DbName:                   *dbName,
+		TableParallelism:         *to,
+		PointersParallelism:      *pointersParallelism,
+		MaxRPSPerThread:          50,
+		PreviousTableParallelism: *from,
+
+		PreviousPointersParallelism: *pointersFrom,
+		ShardedPointers:             *shardedPointers,
+	})
+	if err != nil {
+		zlog.Fatal().Err(err).Send()
+	}
+	backend.SetTrace(false)
+
+	// pointers go first: resharder keeps its progress in pointers tables
+	if migratePointers {
+		if _, err = backend.MigratePointers(nerve.QueueName(*queueName)); err != nil {
+			zlog.Fatal().Err(err).Msg("pointers migration failed")
+		}
+	}
+
+	if !reshardTables {
+		return
+	}
+
+	resharder, err := nerve.NewResharder(backend, nerve.QueueName(*queueName))
+	if err != nil {
+		zlog.Fatal().Err(err).Send()
+	}
+
+	if err = resharder.WithBatchSize(nerve.QueueElementIndex(*batchSize)).Run(); err != nil {
+		zlog.Fatal().Err(err).Msg("resharding failed")
+	}
+}
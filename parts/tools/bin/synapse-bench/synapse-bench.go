@@ -1,72 +1,572 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
+	"math"
+	"math/bits"
+	"math/rand"
 	"octopus/shared/nerve"
+	protoNerve "octopus/target/generated-sources/protobuf/nerve"
 	"os"
+	"regexp"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
-var nIOThreads = flag.Uint("io-threads", 4, "number of IO threads")
+var nIOThreads = flag.Uint("io-threads", 4, "number of IO threads of mysql backend")
+var host = flag.String("host", "127.0.0.1", "nerve mysql host")
+var port = flag.Uint("port", 0, "nerve mysql port")
+var dbName = flag.String("db", "nerve", "nerve database name")
+var scenariosFile = flag.String("scenarios", "", "json file with scenario or list of scenarios, default scenario is used if empty")
+var backendName = flag.String("backend", "", "overrides backend of scenarios: mysql or memory")
+var out = flag.String("out", "synapse-bench.json", "json report path, `-` for stdout")
+
+// drainTimeout limits waiting for consumers to receive packets sent before producers were stopped
+const drainTimeout = 30 * time.Second
+
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	*d = duration(parsed)
+	return err
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type payloadConfig struct {
+	// fixed, uniform or exponential
+	Distribution string `json:"distribution"`
+	// size of fixed payloads and mean of exponential ones
+	Size int `json:"size"`
+	Min  int `json:"min"`
+	Max  int `json:"max"`
+}
+
+type scenario struct {
+	Name    string `json:"name"`
+	Backend string `json:"backend"`
+
+	Duration duration `json:"duration"`
+	// latencies of packets sent during warmup aren't recorded
+	Warmup duration `json:"warmup"`
+
+	Producers int `json:"producers"`
+	BatchSize int `json:"batch_size"`
+	// packets per second of all producers, 0 means as fast as possible
+	TargetRate float64 `json:"target_rate"`
+
+	// every consumer receives all packets
+	Consumers         int `json:"consumers"`
+	ConsumerWorkers   int `json:"consumer_workers"`
+	ConsumerBatchSize int `json:"consumer_batch_size"`
+
+	Payload payloadConfig `json:"payload"`
+}
+
+var defaultScenario = scenario{
+	Name:              "default",
+	Backend:           "mysql",
+	Duration:          duration(time.Minute),
+	Warmup:            duration(5 * time.Second),
+	Producers:         1000,
+	BatchSize:         200,
+	Consumers:         1,
+	ConsumerWorkers:   1,
+	ConsumerBatchSize: 1000,
+	Payload:           payloadConfig{Distribution: "fixed", Size: 4},
+}
+
+func (s *scenario) validate() error {
+	if s.Name == "" || s.Duration <= 0 || s.Producers <= 0 || s.BatchSize <= 0 || s.Consumers < 0 {
+		return fmt.Errorf("scenario %q: name, duration, producers and batch size are required", s.Name)
+	}
+	if s.Consumers > 0 && (s.ConsumerWorkers <= 0 || s.ConsumerBatchSize <= 0) {
+		return fmt.Errorf("scenario %q: consumer workers and batch size are required", s.Name)
+	}
+	switch s.Payload.Distribution {
+	case "fixed", "exponential":
+		if s.Payload.Size < 0 {
+			return fmt.Errorf("scenario %q: invalid payload size", s.Name)
+		}
+	case "uniform":
+		if s.Payload.Min < 0 || s.Payload.Max < s.Payload.Min {
+			return fmt.Errorf("scenario %q: invalid payload size range", s.Name)
+		}
+	default:
+		return fmt.Errorf("scenario %q: unknown payload distribution %q", s.Name, s.Payload.Distribution)
+	}
+	return nil
+}
+
+func (s *scenario) payloadSize(rnd *rand.Rand) int {
+	var size int
+	switch s.Payload.Distribution {
+	case "uniform":
+		size = s.Payload.Min + rnd.Intn(s.Payload.Max-s.Payload.Min+1)
+	case "exponential":
+		size = int(rnd.ExpFloat64() * float64(s.Payload.Size))
+	default:
+		size = s.Payload.Size
+	}
+	if s.Payload.Max > 0 && size > s.Payload.Max {
+		size = s.Payload.Max
+	}
+	return size
+}
+
+// histogram keeps microsecond values with ~1.5% precision: values up to 128 are stored
+// as is, bigger ones are bucketed by power of two split into histogramSubBuckets each
+const histogramSubBits = 6
+const histogramSubBuckets = 1 << histogramSubBits
+
+type histogram struct {
+	counts [64 * histogramSubBuckets]uint64
+	total  uint64
+	sum    uint64
+	max    uint64
+	min    uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{min: math.MaxUint64}
+}
+
+func histogramBucket(v uint64) int {
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - histogramSubBits - 1
+	return exp*histogramSubBuckets + int(v>>exp)
+}
+
+// histogramValue returns the biggest value of bucket
+func histogramValue(bucket int) uint64 {
+	if bucket < histogramSubBuckets {
+		return uint64(bucket)
+	}
+	exp := bucket/histogramSubBuckets - 1
+	sub := uint64(bucket - exp*histogramSubBuckets)
+	return (sub+1)<<exp - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	v := uint64(d.Microseconds())
+	if d < 0 {
+		v = 0
+	}
+	atomic.AddUint64(&h.counts[histogramBucket(v)], 1)
+	atomic.AddUint64(&h.total, 1)
+	atomic.AddUint64(&h.sum, v)
+	for old := atomic.LoadUint64(&h.max); v > old && !atomic.CompareAndSwapUint64(&h.max, old, v); {
+		old = atomic.LoadUint64(&h.max)
+	}
+	for old := atomic.LoadUint64(&h.min); v < old && !atomic.CompareAndSwapUint64(&h.min, old, v); {
+		old = atomic.LoadUint64(&h.min)
+	}
+}
+
+func (h *histogram) percentile(p float64) uint64 {
+	total := atomic.LoadUint64(&h.total)
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p / 100 * float64(total)))
+	var seen uint64
+	for bucket := range h.counts {
+		seen += atomic.LoadUint64(&h.counts[bucket])
+		if seen >= rank {
+			v := histogramValue(bucket)
+			if max := atomic.LoadUint64(&h.max); v > max {
+				return max
+			}
+			return v
+		}
+	}
+	return atomic.LoadUint64(&h.max)
+}
+
+type latencyReport struct {
+	Count uint64  `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	P999  float64 `json:"p999"`
+	Max   float64 `json:"max"`
+}
+
+func (h *histogram) report() latencyReport {
+	ms := func(us uint64) float64 {
+		return float64(us) / 1000
+	}
+	res := latencyReport{Count: atomic.LoadUint64(&h.total)}
+	if res.Count == 0 {
+		return res
+	}
+	res.Min = ms(atomic.LoadUint64(&h.min))
+	res.Mean = ms(atomic.LoadUint64(&h.sum)) / float64(res.Count)
+	res.P50 = ms(h.percentile(50))
+	res.P90 = ms(h.percentile(90))
+	res.P99 = ms(h.percentile(99))
+	res.P999 = ms(h.percentile(99.9))
+	res.Max = ms(atomic.LoadUint64(&h.max))
+	return res
+}
+
+type report struct {
+	Scenario  scenario  `json:"scenario"`
+	Queue     string    `json:"queue"`
+	StartedAt time.Time `json:"started_at"`
+	// time producers were running
+	SendSeconds float64 `json:"send_seconds"`
+	// time from start till consumers received everything or drain timeout
+	TotalSeconds float64 `json:"total_seconds"`
+
+	Sent      uint64  `json:"sent"`
+	SentBytes uint64  `json:"sent_bytes"`
+	SendRate  float64 `json:"send_rate"`
+	// received packets of all consumers
+	Received    uint64  `json:"received"`
+	ReceiveRate float64 `json:"receive_rate"`
+	// packets not received by consumers till drain timeout, per consumer
+	Missing []uint64 `json:"missing,omitempty"`
+
+	// send to receive latency in milliseconds
+	Latency latencyReport `json:"latency_ms"`
+	// SendPack call duration in milliseconds
+	SendLatency latencyReport `json:"send_latency_ms"`
+}
 
 func main() {
 	flag.Parse()
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 
-	backend, err := nerve.NewSMysqlBackend(nerve.SMysqlBackendConfig{
-		Host:                "127.0.0.1",
-		DbName:              "nerve",
-		TableParallelism:    *nIOThreads,
-		PointersParallelism: *nIOThreads,
-		MaxRPSPerThread:     50,
-	})
+	scenarios, err := loadScenarios()
 	if err != nil {
 		zlog.Fatal().Err(err).Send()
 	}
 
+	var reports []*report
+	for _, sc := range scenarios {
+		res, err := run(sc)
+		if err != nil {
+			zlog.Fatal().Err(err).Str("scenario", sc.Name).Send()
+		}
+		reports = append(reports, res)
+	}
+
+	if err = writeReports(reports); err != nil {
+		zlog.Fatal().Err(err).Send()
+	}
+}
+
+func loadScenarios() ([]scenario, error) {
+	scenarios := []scenario{defaultScenario}
+	if *scenariosFile != "" {
+		data, err := os.ReadFile(*scenariosFile)
+		if err != nil {
+			return nil, err
+		}
+		var raw []json.RawMessage
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			err = json.Unmarshal(data, &raw)
+		} else {
+			raw = []json.RawMessage{data}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// fields missing in file are taken from default scenario
+		scenarios = make([]scenario, len(raw))
+		for i, r := range raw {
+			scenarios[i] = defaultScenario
+			if err = json.Unmarshal(r, &scenarios[i]); err != nil {
+				return nil, fmt.Errorf("error parsing scenario %d: %w", i, err)
+			}
+		}
+	}
+
+	for i := range scenarios {
+		if *backendName != "" {
+			scenarios[i].Backend = *backendName
+		}
+		if err := scenarios[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	return scenarios, nil
+}
+
+func writeReports(reports []*report) error {
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	zlog.Info().Str("file", *out).Msg("writing report")
+	return os.WriteFile(*out, data, 0644)
+}
+
+func newBackend(sc scenario) (nerve.SynapseBackend, error) {
+	switch sc.Backend {
+	case "memory":
+		return nerve.NewMemoryBackend(*nIOThreads), nil
+	case "mysql":
+		return nerve.NewSMysqlBackend(nerve.SMysqlBackendConfig{
+			Host:                *host,
+			Port:                *port,
+			DbName:              *dbName,
+			TableParallelism:    *nIOThreads,
+			PointersParallelism: *nIOThreads,
+			MaxRPSPerThread:     50,
+		})
+	default:
+		return nil, fmt.Errorf("unknown backend %q", sc.Backend)
+	}
+}
+
+var invalidQueueChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+type bench struct {
+	sc      scenario
+	synapse *nerve.Synapse
+	queue   nerve.QueueConfig
+
+	startedAt   time.Time
+	recordAfter time.Time
+
+	sent      uint64
+	sentBytes uint64
+	received  []uint64
+
+	latency     *histogram
+	sendLatency *histogram
+}
+
+func run(sc scenario) (*report, error) {
+	backend, err := newBackend(sc)
+	if err != nil {
+		return nil, err
+	}
+
 	s := nerve.NewSynapse(backend)
 	s.SetTrace(false)
 
-	ts := time.Now()
-	msgSent := uint64(0)
-	for x := 0; x < 1_000; x++ {
-		go func() {
-			for {
-				var pack = make([]*nerve.Packet, 200)
-				for i := 0; i < 200; i++ {
-					pack[i] = &nerve.Packet{
-						Data: []byte("test"),
-					}
+	// every run writes into a new queue, so consumers don't read leftovers of previous runs
+	queueName := fmt.Sprintf("NQBench_%s_%d", invalidQueueChars.ReplaceAllString(sc.Name, "_"), time.Now().Unix())
+	b := &bench{
+		sc:      sc,
+		synapse: s,
+		// no schema: payload validation would be measured as send latency
+		queue:       nerve.QueueConfig{Name: nerve.QueueName(queueName)},
+		received:    make([]uint64, sc.Consumers),
+		latency:     newHistogram(),
+		sendLatency: newHistogram(),
+	}
+	b.startedAt = time.Now()
+	b.recordAfter = b.startedAt.Add(time.Duration(sc.Warmup))
+
+	zlog.Info().Str("scenario", sc.Name).Str("queue", queueName).Str("backend", sc.Backend).Msg("starting")
+
+	consumersCtx, stopConsumers := context.WithCancel(context.Background())
+	consumersWg := sync.WaitGroup{}
+	consumersWg.Add(sc.Consumers)
+	for i := 0; i < sc.Consumers; i++ {
+		go func(i int) {
+			defer consumersWg.Done()
+			b.consume(consumersCtx, i)
+		}(i)
+	}
+
+	producersCtx, stopProducers := context.WithTimeout(context.Background(), time.Duration(sc.Duration))
+	producersWg := sync.WaitGroup{}
+	producersWg.Add(sc.Producers)
+	for i := 0; i < sc.Producers; i++ {
+		go func(i int) {
+			defer producersWg.Done()
+			b.produce(producersCtx, i)
+		}(i)
+	}
+
+	progressDone := make(chan struct{})
+	go b.logProgress(progressDone)
+
+	producersWg.Wait()
+	stopProducers()
+	sendDuration := time.Since(b.startedAt)
+
+	drainDeadline := time.Now().Add(drainTimeout)
+	for !b.drained() && time.Now().Before(drainDeadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	totalDuration := time.Since(b.startedAt)
+	stopConsumers()
+	consumersWg.Wait()
+	close(progressDone)
+
+	res := &report{
+		Scenario:     sc,
+		Queue:        queueName,
+		StartedAt:    b.startedAt,
+		SendSeconds:  sendDuration.Seconds(),
+		TotalSeconds: totalDuration.Seconds(),
+		Sent:         atomic.LoadUint64(&b.sent),
+		SentBytes:    atomic.LoadUint64(&b.sentBytes),
+		Latency:      b.latency.report(),
+		SendLatency:  b.sendLatency.report(),
+	}
+	res.SendRate = float64(res.Sent) / res.SendSeconds
+	for i := range b.received {
+		received := atomic.LoadUint64(&b.received[i])
+		res.Received += received
+		if received < res.Sent {
+			res.Missing = append(res.Missing, res.Sent-received)
+		}
+	}
+	res.ReceiveRate = float64(res.Received) / res.TotalSeconds
+
+	zlog.Info().
+		Str("scenario", sc.Name).
+		Uint64("sent", res.Sent).
+		Uint64("received", res.Received).
+		Float64("send-rate", res.SendRate).
+		Float64("p50-ms", res.Latency.P50).
+		Float64("p99-ms", res.Latency.P99).
+		Msg("done")
+	return res, nil
+}
+
+func (b *bench) drained() bool {
+	sent := atomic.LoadUint64(&b.sent)
+	for i := range b.received {
+		if atomic.LoadUint64(&b.received[i]) < sent {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bench) produce(ctx context.Context, producer int) {
+	rnd := rand.New(rand.NewSource(int64(producer) + time.Now().UnixNano()))
+	filler := make([]byte, 0)
+
+	var interval time.Duration
+	if b.sc.TargetRate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(b.sc.BatchSize) * float64(b.sc.Producers) / b.sc.TargetRate)
+	}
+	// producers start evenly spread over the interval
+	scheduled := time.Now().Add(time.Duration(rnd.Int63n(int64(interval) + 1)))
+
+	for ctx.Err() == nil {
+		if interval > 0 {
+			if wait := time.Until(scheduled); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
 				}
-				_ = s.SendPack(nerve.NQLocalTest, pack)
-				atomic.AddUint64(&msgSent, uint64(200))
 			}
-		}()
+		} else {
+			scheduled = time.Now()
+		}
+
+		pack := make([]*nerve.Packet, b.sc.BatchSize)
+		var packBytes uint64
+		for i := range pack {
+			size := b.sc.payloadSize(rnd)
+			for len(filler) < size {
+				filler = append(filler, byte(rnd.Intn(256)))
+			}
+			// packets are stamped with scheduled time, so stalled sends show up in latency
+			packet := &protoNerve.NerveTestPacket{CreatedAt: scheduled.UnixNano(), Payload: filler[:size]}
+			envelope := &protoNerve.NerveSourcedPacket{
+				Source: protoNerve.NerveSourceType_NST_TEST,
+				Packet: packet.Marshal(),
+			}
+			pack[i] = &nerve.Packet{Data: envelope.Marshal()}
+			packBytes += uint64(len(pack[i].Data))
+		}
+
+		ts := time.Now()
+		if err := b.synapse.SendPack(b.queue, pack); err != nil {
+			zlog.Error().Err(err).Int("producer", producer).Msg("error sending pack")
+			return
+		}
+		if scheduled.After(b.recordAfter) {
+			b.sendLatency.record(time.Since(ts))
+		}
+		atomic.AddUint64(&b.sent, uint64(len(pack)))
+		atomic.AddUint64(&b.sentBytes, packBytes)
+
+		scheduled = scheduled.Add(interval)
 	}
+}
 
-	msgGot := uint64(0)
-	msgBytesGot := uint64(0)
-	go func() {
-		receiver := s.GetReceiver(nerve.NQLocalTest, nerve.NCTest)
-		for msg := range receiver.DataChan {
-			atomic.AddUint64(&msgGot, 1)
-			atomic.AddUint64(&msgBytesGot, uint64(len(msg.Data)))
-			receiver.Ack(msg)
+func (b *bench) consume(ctx context.Context, consumer int) {
+	consumerId := nerve.ConsumerId(fmt.Sprintf("NCBench_%d", consumer))
+	receiver := b.synapse.GetBufferedReceiver(b.queue, consumerId, b.sc.ConsumerBatchSize*b.sc.ConsumerWorkers)
+	defer receiver.Close()
+
+	_ = receiver.Consume(ctx, b.sc.ConsumerWorkers, b.sc.ConsumerBatchSize, func(_ context.Context, batch []*nerve.Packet) error {
+		now := time.Now()
+		for _, p := range batch {
+			envelope := protoNerve.NewNerveSourcedPacketReader()
+			packet := protoNerve.NewNerveTestPacketReader()
+			if envelope.Unmarshal(p.Data) != nil || packet.Unmarshal(envelope.GetPacket()) != nil {
+				zlog.Error().Int64("id", int64(p.DbId)).Msg("invalid packet")
+				continue
+			}
+			createdAt := time.Unix(0, packet.GetCreatedAt())
+			if createdAt.After(b.recordAfter) {
+				b.latency.record(now.Sub(createdAt))
+			}
 		}
-	}()
+		atomic.AddUint64(&b.received[consumer], uint64(len(batch)))
+		return nil
+	})
+}
 
+func (b *bench) logProgress(done chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
-		zlog.Info().Uint64("sent", msgSent).
-			Uint64("rcvd", msgGot).
-			Msgf("writes at %f, batch size of %f; reading at %f.",
-				float64(msgSent)*float64(time.Second)/float64(time.Since(ts)),
-				float64(backend.Packets)/float64(backend.Batches),
-				float64(msgGot)*float64(time.Second)/float64(time.Since(ts)),
-			)
-		time.Sleep(1 * time.Second)
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		var received uint64
+		for i := range b.received {
+			received += atomic.LoadUint64(&b.received[i])
+		}
+		elapsed := time.Since(b.startedAt).Seconds()
+		zlog.Info().
+			Str("scenario", b.sc.Name).
+			Uint64("sent", atomic.LoadUint64(&b.sent)).
+			Uint64("rcvd", received).
+			Float64("send-rate", float64(atomic.LoadUint64(&b.sent))/elapsed).
+			Float64("p99-ms", float64(b.latency.percentile(99))/1000).
+			Send()
 	}
 }
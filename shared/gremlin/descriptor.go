@@ -0,0 +1,202 @@
+package gremlin
+
+import "sync"
+
+// FieldKind is proto type of a field value, map fields have kind of the map value
+type FieldKind int8
+
+const (
+	KindBool FieldKind = iota + 1
+	KindInt32
+	KindInt64
+	KindUint32
+	KindUint64
+	KindSInt32
+	KindSInt64
+	KindFixed32
+	KindFixed64
+	KindSFixed32
+	KindSFixed64
+	KindFloat
+	KindDouble
+	KindString
+	KindBytes
+	KindEnum
+	KindMessage
+)
+
+var fieldKindNames = map[FieldKind]string{
+	KindBool:     "bool",
+	KindInt32:    "int32",
+	KindInt64:    "int64",
+	KindUint32:   "uint32",
+	KindUint64:   "uint64",
+	KindSInt32:   "sint32",
+	KindSInt64:   "sint64",
+	KindFixed32:  "fixed32",
+	KindFixed64:  "fixed64",
+	KindSFixed32: "sfixed32",
+	KindSFixed64: "sfixed64",
+	KindFloat:    "float",
+	KindDouble:   "double",
+	KindString:   "string",
+	KindBytes:    "bytes",
+	KindEnum:     "enum",
+	KindMessage:  "message",
+}
+
+func (k FieldKind) String() string {
+	return fieldKindNames[k]
+}
+
+// KindByProtoType returns kind of proto scalar type, 0 for unknown types
+func KindByProtoType(protoType string) FieldKind {
+	for kind, name := range fieldKindNames {
+		if name == protoType && kind != KindEnum && kind != KindMessage {
+			return kind
+		}
+	}
+	return 0
+}
+
+type FieldLabel int8
+
+const (
+	LabelOptional FieldLabel = iota
+	LabelRequired
+	LabelRepeated
+)
+
+type FieldDescriptor struct {
+	Name     string
+	JsonName string
+	Number   ProtoWireNumber
+	Kind     FieldKind
+	Label    FieldLabel
+	// TypeName is full proto name of the message or the enum of the field value
+	TypeName string
+	// MapKey is kind of the map key, 0 if the field is not a map
+	MapKey  FieldKind
+	OneOf   string
+	Default string
+}
+
+func (f *FieldDescriptor) IsMap() bool {
+	return f.MapKey != 0
+}
+
+func (f *FieldDescriptor) IsList() bool {
+	return f.Label == LabelRepeated && f.MapKey == 0
+}
+
+// Message returns descriptor of the field message, nil for other kinds
+func (f *FieldDescriptor) Message() *MessageDescriptor {
+	if f.Kind != KindMessage {
+		return nil
+	}
+	return FindMessageDescriptor(f.TypeName)
+}
+
+// Enum returns descriptor of the field enum, nil for other kinds
+func (f *FieldDescriptor) Enum() *EnumDescriptor {
+	if f.Kind != KindEnum {
+		return nil
+	}
+	return FindEnumDescriptor(f.TypeName)
+}
+
+// MessageDescriptor is static description of a message, names of nested types are full proto names
+type MessageDescriptor struct {
+	Name           string
+	Fields         []*FieldDescriptor
+	OneOfs         []string
+	NestedMessages []string
+	NestedEnums    []string
+	// NewStruct and NewReader create empty message, used by Any to convert packed message to json and back
+	NewStruct func() JsonMessage
+	NewReader func() JsonMessageReader
+}
+
+func (m *MessageDescriptor) FieldByNumber(number ProtoWireNumber) *FieldDescriptor {
+	for _, field := range m.Fields {
+		if field.Number == number {
+			return field
+		}
+	}
+	return nil
+}
+
+// FieldByName accepts both proto and json names of the field
+func (m *MessageDescriptor) FieldByName(name string) *FieldDescriptor {
+	for _, field := range m.Fields {
+		if field.Name == name || field.JsonName == name {
+			return field
+		}
+	}
+	return nil
+}
+
+type EnumValueDescriptor struct {
+	Name   string
+	Number int32
+}
+
+type EnumDescriptor struct {
+	Name   string
+	Values []EnumValueDescriptor
+}
+
+func (e *EnumDescriptor) ValueByName(name string) (EnumValueDescriptor, bool) {
+	for _, value := range e.Values {
+		if value.Name == name {
+			return value, true
+		}
+	}
+	return EnumValueDescriptor{}, false
+}
+
+// ValueByNumber returns the first value with the number, enums can alias numbers
+func (e *EnumDescriptor) ValueByNumber(number int32) (EnumValueDescriptor, bool) {
+	for _, value := range e.Values {
+		if value.Number == number {
+			return value, true
+		}
+	}
+	return EnumValueDescriptor{}, false
+}
+
+var descriptors = struct {
+	sync.RWMutex
+	messages map[string]*MessageDescriptor
+	enums    map[string]*EnumDescriptor
+}{
+	messages: map[string]*MessageDescriptor{},
+	enums:    map[string]*EnumDescriptor{},
+}
+
+// RegisterMessage makes descriptor available by its name, generated packages register their messages on init
+func RegisterMessage(desc *MessageDescriptor) *MessageDescriptor {
+	descriptors.Lock()
+	defer descriptors.Unlock()
+	descriptors.messages[desc.Name] = desc
+	return desc
+}
+
+func RegisterEnum(desc *EnumDescriptor) *EnumDescriptor {
+	descriptors.Lock()
+	defer descriptors.Unlock()
+	descriptors.enums[desc.Name] = desc
+	return desc
+}
+
+func FindMessageDescriptor(name string) *MessageDescriptor {
+	descriptors.RLock()
+	defer descriptors.RUnlock()
+	return descriptors.messages[name]
+}
+
+func FindEnumDescriptor(name string) *EnumDescriptor {
+	descriptors.RLock()
+	defer descriptors.RUnlock()
+	return descriptors.enums[name]
+}
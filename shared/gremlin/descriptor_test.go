@@ -0,0 +1,33 @@
+package gremlin
+
+import "testing"
+
+func TestDescriptors(t *testing.T) {
+	desc := RegisterMessage(&MessageDescriptor{
+		Name: "gremlin_test.Point",
+		Fields: []*FieldDescriptor{
+			{Name: "x_value", JsonName: "xValue", Number: 1, Kind: KindSInt32},
+			{Name: "tags", JsonName: "tags", Number: 2, Kind: KindString, Label: LabelRepeated, MapKey: KindString},
+		},
+	})
+	if FindMessageDescriptor("gremlin_test.Point") != desc || FindMessageDescriptor("gremlin_test.Missing") != nil {
+		t.Fatalf("registry lookup failed")
+	}
+	if desc.FieldByName("xValue") != desc.Fields[0] || desc.FieldByName("x_value") != desc.Fields[0] || desc.FieldByNumber(2) != desc.Fields[1] {
+		t.Errorf("field lookup failed")
+	}
+	if !desc.Fields[1].IsMap() || desc.Fields[1].IsList() || desc.Fields[0].Message() != nil {
+		t.Errorf("field shape: %+v", desc.Fields[1])
+	}
+	if KindByProtoType("sfixed64") != KindSFixed64 || KindByProtoType("enum") != 0 || KindSFixed64.String() != "sfixed64" {
+		t.Errorf("kinds mismatch")
+	}
+
+	enum := RegisterEnum(&EnumDescriptor{Name: "gremlin_test.Color", Values: []EnumValueDescriptor{{"RED", 0}, {"CRIMSON", 0}, {"BLUE", 1}}})
+	if value, ok := FindEnumDescriptor("gremlin_test.Color").ValueByNumber(0); !ok || value.Name != "RED" {
+		t.Errorf("enum by number: %+v", value)
+	}
+	if value, ok := enum.ValueByName("BLUE"); !ok || value.Number != 1 {
+		t.Errorf("enum by name: %+v", value)
+	}
+}
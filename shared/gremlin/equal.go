@@ -0,0 +1,204 @@
+package gremlin
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// EqualFloat32 compares floats for generated Equal, unlike == NaN is equal to NaN
+func EqualFloat32(a, b float32) bool {
+	return a == b || (a != a && b != b)
+}
+
+// EqualFloat64 compares floats for generated Equal, unlike == NaN is equal to NaN
+func EqualFloat64(a, b float64) bool {
+	return a == b || (a != a && b != b)
+}
+
+// EqualLists compares lists by entries, nil and empty lists are equal
+func EqualLists[T any](a, b []T, eq func(a, b T) bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !eq(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualMaps compares maps by entries, nil and empty maps are equal
+func EqualMaps[K comparable, V any](a, b map[K]V, eq func(a, b V) bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, va := range a {
+		vb, ok := b[k]
+		if !ok || !eq(va, vb) {
+			return false
+		}
+	}
+	return true
+}
+
+// FieldDiff is a difference found by Diff, Left or Right is nil if the value is absent
+type FieldDiff struct {
+	// Path is a dot separated path of proto field names with [index] or [key] of lists and maps,
+	// empty path means the messages themselves
+	Path  string
+	Left  interface{}
+	Right interface{}
+}
+
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%v: %v != %v", d.Path, d.Left, d.Right)
+}
+
+// UnknownFieldsPath is the Diff path of unknown fields of the message, they are compared as raw bytes
+const UnknownFieldsPath = "XXX_unrecognized"
+
+// Diff lists differences of messages of the same type with the rules of generated Equal
+func Diff(a, b Reflectable) []FieldDiff {
+	var res []FieldDiff
+	diffMessages(&res, "", a, b)
+	return res
+}
+
+func diffMessages(res *[]FieldDiff, path string, a, b Reflectable) {
+	if isNilValue(a) || isNilValue(b) {
+		if isNilValue(a) != isNilValue(b) {
+			*res = append(*res, FieldDiff{Path: path, Left: a, Right: b})
+		}
+		return
+	}
+	if path != "" {
+		path += "."
+	}
+	for _, field := range a.XXX_Descriptor().Fields {
+		va, _ := a.XXX_GetField(field.Number)
+		vb, _ := b.XXX_GetField(field.Number)
+		switch {
+		case field.IsMap():
+			diffMaps(res, path+field.Name, field, va, vb)
+		case field.IsList():
+			diffLists(res, path+field.Name, field, va, vb)
+		default:
+			diffValues(res, path+field.Name, field, va, vb)
+		}
+	}
+
+	if ua, ub := unknownFields(a), unknownFields(b); !bytes.Equal(ua, ub) {
+		*res = append(*res, FieldDiff{Path: path + UnknownFieldsPath, Left: ua, Right: ub})
+	}
+}
+
+// unknownFields returns unknown fields of generated reader or struct
+func unknownFields(msg Reflectable) []byte {
+	if reader, ok := msg.(interface{ UnknownFields() []byte }); ok {
+		return reader.UnknownFields()
+	}
+	if field := reflect.ValueOf(msg).Elem().FieldByName(UnknownFieldsPath); field.IsValid() {
+		return field.Bytes()
+	}
+	return nil
+}
+
+func diffLists(res *[]FieldDiff, path string, field *FieldDescriptor, a, b interface{}) {
+	la, lb := reflectValue(a), reflectValue(b)
+	for i := 0; i < la.Len() || i < lb.Len(); i++ {
+		var va, vb interface{}
+		if i < la.Len() {
+			va = la.Index(i).Interface()
+		}
+		if i < lb.Len() {
+			vb = lb.Index(i).Interface()
+		}
+		diffValues(res, fmt.Sprintf("%v[%v]", path, i), field, va, vb)
+	}
+}
+
+func diffMaps(res *[]FieldDiff, path string, field *FieldDescriptor, a, b interface{}) {
+	ma, mb := reflectValue(a), reflectValue(b)
+	keys := map[interface{}]reflect.Value{}
+	for _, m := range []reflect.Value{ma, mb} {
+		if m.Kind() != reflect.Map {
+			continue
+		}
+		for _, k := range m.MapKeys() {
+			keys[k.Interface()] = k
+		}
+	}
+
+	sorted := make([]interface{}, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return fmt.Sprint(sorted[i]) < fmt.Sprint(sorted[j])
+	})
+
+	for _, k := range sorted {
+		var va, vb interface{}
+		if ma.Kind() == reflect.Map {
+			if v := ma.MapIndex(keys[k]); v.IsValid() {
+				va = v.Interface()
+			}
+		}
+		if mb.Kind() == reflect.Map {
+			if v := mb.MapIndex(keys[k]); v.IsValid() {
+				vb = v.Interface()
+			}
+		}
+		key := fmt.Sprint(k)
+		if s, ok := k.(string); ok {
+			key = fmt.Sprintf("%q", s)
+		}
+		diffValues(res, fmt.Sprintf("%v[%v]", path, key), field, va, vb)
+	}
+}
+
+func diffValues(res *[]FieldDiff, path string, field *FieldDescriptor, a, b interface{}) {
+	if field.Kind == KindMessage {
+		ma, _ := a.(Reflectable)
+		mb, _ := b.(Reflectable)
+		diffMessages(res, path, ma, mb)
+		return
+	}
+	if !equalScalars(a, b) {
+		*res = append(*res, FieldDiff{Path: path, Left: a, Right: b})
+	}
+}
+
+func equalScalars(a, b interface{}) bool {
+	switch va := a.(type) {
+	case float32:
+		vb, ok := b.(float32)
+		return ok && EqualFloat32(va, vb)
+	case float64:
+		vb, ok := b.(float64)
+		return ok && EqualFloat64(va, vb)
+	case []byte:
+		vb, ok := b.([]byte)
+		return ok && bytes.Equal(va, vb)
+	}
+	return a == b
+}
+
+// reflectValue gives empty slice for absent list, so Len and Index can be used without checks
+func reflectValue(value interface{}) reflect.Value {
+	if value == nil {
+		return reflect.ValueOf([]interface{}{})
+	}
+	return reflect.ValueOf(value)
+}
+
+func isNilValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
@@ -0,0 +1,26 @@
+package gremlin
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEqualHelpers(t *testing.T) {
+	nan := math.NaN()
+	if !EqualFloat64(nan, nan) || !EqualFloat32(float32(nan), float32(nan)) || EqualFloat64(nan, 0) || !EqualFloat64(0, math.Copysign(0, -1)) {
+		t.Errorf("float equality")
+	}
+
+	eq := func(a, b int32) bool { return a == b }
+	if !EqualLists(nil, []int32{}, eq) || EqualLists([]int32{1}, []int32{2}, eq) || EqualLists([]int32{1}, []int32{1, 1}, eq) {
+		t.Errorf("list equality")
+	}
+	if !EqualMaps(nil, map[string]int32{}, eq) || EqualMaps(map[string]int32{"a": 0}, map[string]int32{"b": 0}, eq) ||
+		!EqualMaps(map[string]int32{"a": 1}, map[string]int32{"a": 1}, eq) {
+		t.Errorf("map equality")
+	}
+
+	if d := (FieldDiff{Path: "a.b[1]", Left: 1, Right: nil}); d.String() != "a.b[1]: 1 != <nil>" {
+		t.Errorf("diff string: %v", d)
+	}
+}
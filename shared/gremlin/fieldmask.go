@@ -0,0 +1,128 @@
+package gremlin
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrFieldMaskPath = errors.New("invalid field mask path")
+
+// FieldMask selects fields by dot separated paths of proto names.
+// Nil mask selects all fields, as well as sub mask of a field selected as a whole
+type FieldMask struct {
+	fields map[string]*FieldMask
+}
+
+// NewFieldMask builds mask of paths, path of a message selects all its fields even if longer paths are listed
+func NewFieldMask(paths ...string) *FieldMask {
+	res := &FieldMask{fields: map[string]*FieldMask{}}
+	for _, path := range paths {
+		res.add(strings.Split(path, "."))
+	}
+	return res
+}
+
+func (m *FieldMask) add(names []string) {
+	sub, found := m.fields[names[0]]
+	if found && sub == nil {
+		return
+	}
+	if len(names) == 1 {
+		m.fields[names[0]] = nil
+		return
+	}
+	if sub == nil {
+		sub = &FieldMask{fields: map[string]*FieldMask{}}
+		m.fields[names[0]] = sub
+	}
+	sub.add(names[1:])
+}
+
+// Has reports whether the field is selected as a whole or partially
+func (m *FieldMask) Has(name string) bool {
+	if m == nil {
+		return true
+	}
+	_, ok := m.fields[name]
+	return ok
+}
+
+// Sub returns mask of the field message, nil if the field is selected as a whole
+func (m *FieldMask) Sub(name string) *FieldMask {
+	if m == nil {
+		return nil
+	}
+	return m.fields[name]
+}
+
+// Paths lists paths of the mask in sorted order, nil mask has no paths
+func (m *FieldMask) Paths() []string {
+	if m == nil {
+		return nil
+	}
+	var res []string
+	for name, sub := range m.fields {
+		if sub == nil {
+			res = append(res, name)
+			continue
+		}
+		for _, path := range sub.Paths() {
+			res = append(res, name+"."+path)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (m *FieldMask) String() string {
+	return strings.Join(m.Paths(), ",")
+}
+
+// Validate checks that paths of the mask are fields of desc,
+// only singular message fields may have sub paths
+func (m *FieldMask) Validate(desc *MessageDescriptor) error {
+	if m == nil {
+		return nil
+	}
+	for name, sub := range m.fields {
+		field := fieldByProtoName(desc, name)
+		if field == nil {
+			return fmt.Errorf("%w: %v has no field %q", ErrFieldMaskPath, desc.Name, name)
+		}
+		if sub == nil {
+			continue
+		}
+		if field.Kind != KindMessage || field.Label == LabelRepeated {
+			return fmt.Errorf("%w: %v.%v is not a message, it can't have sub paths", ErrFieldMaskPath, desc.Name, name)
+		}
+		nested := field.Message()
+		if nested == nil {
+			return fmt.Errorf("%w: %v.%v has unknown type %v", ErrFieldMaskPath, desc.Name, name, field.TypeName)
+		}
+		if err := sub.Validate(nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseFieldMask builds mask of paths and validates it against desc
+func ParseFieldMask(desc *MessageDescriptor, paths ...string) (*FieldMask, error) {
+	res := NewFieldMask(paths...)
+	if err := res.Validate(desc); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// masks use proto names only, json names are converted by JsonFieldMask
+func fieldByProtoName(desc *MessageDescriptor, name string) *FieldDescriptor {
+	for _, field := range desc.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
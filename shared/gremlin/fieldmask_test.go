@@ -0,0 +1,49 @@
+package gremlin
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFieldMask(t *testing.T) {
+	mask := NewFieldMask("a.b.c", "a.d", "e", "e.f")
+	if paths := mask.Paths(); !reflect.DeepEqual(paths, []string{"a.b.c", "a.d", "e"}) {
+		t.Fatalf("paths: %v", paths)
+	}
+	if !mask.Has("a") || !mask.Has("e") || mask.Has("b") || mask.Sub("e") != nil || !mask.Sub("a").Sub("b").Has("c") {
+		t.Errorf("mask lookup: %v", mask)
+	}
+	if !mask.Sub("e").Has("any") || !mask.Sub("e").Sub("any").Has("other") {
+		t.Errorf("field selected as a whole should select all sub fields")
+	}
+	if NewFieldMask().Has("a") || NewFieldMask().String() != "" {
+		t.Errorf("empty mask selects nothing")
+	}
+}
+
+func TestFieldMaskValidate(t *testing.T) {
+	inner := RegisterMessage(&MessageDescriptor{
+		Name: "gremlin_test.MaskInner",
+		Fields: []*FieldDescriptor{
+			{Name: "value", JsonName: "value", Number: 1, Kind: KindString},
+		},
+	})
+	outer := RegisterMessage(&MessageDescriptor{
+		Name: "gremlin_test.MaskOuter",
+		Fields: []*FieldDescriptor{
+			{Name: "inner", JsonName: "inner", Number: 1, Kind: KindMessage, TypeName: inner.Name},
+			{Name: "list", JsonName: "list", Number: 2, Kind: KindMessage, TypeName: inner.Name, Label: LabelRepeated},
+			{Name: "name", JsonName: "name", Number: 3, Kind: KindString},
+		},
+	})
+
+	if _, err := ParseFieldMask(outer, "inner.value", "list", "name"); err != nil {
+		t.Errorf("valid mask: %v", err)
+	}
+	for _, invalid := range []string{"missing", "inner.missing", "list.value", "name.value", "innerValue"} {
+		if _, err := ParseFieldMask(outer, invalid); !errors.Is(err, ErrFieldMaskPath) {
+			t.Errorf("expected path error for %v, got %v", invalid, err)
+		}
+	}
+}
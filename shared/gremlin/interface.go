@@ -24,3 +24,35 @@ type ProtoReader interface {
 type ProtoWriter interface {
 	Marshal() []byte
 }
+
+// ProtoNamed is implemented by generated structs and readers, XXX_ProtoName is full proto name of the message
+type ProtoNamed interface {
+	XXX_ProtoName() string
+}
+
+type ProtoMessage interface {
+	ProtoWriter
+	ProtoNamed
+}
+
+type ProtoMessageReader interface {
+	ProtoReader
+	ProtoNamed
+}
+
+// ProtoSafeReader is implemented by generated readers, see DecodeOptions
+type ProtoSafeReader interface {
+	SafeUnmarshal(data []byte, opts DecodeOptions) error
+}
+
+// JsonMessage is implemented by generated structs
+type JsonMessage interface {
+	ProtoWriter
+	UnmarshalJSON(data []byte) error
+}
+
+// JsonMessageReader is implemented by generated readers
+type JsonMessageReader interface {
+	ProtoSafeReader
+	MarshalJSONTo(w *JsonWriter)
+}
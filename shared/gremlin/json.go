@@ -0,0 +1,428 @@
+package gremlin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// canonical proto3 json mapping helpers used by generated MarshalJSON/UnmarshalJSON:
+// 64-bit integers are strings, bytes are base64, enums are names, NaN and infinities are strings
+
+var ErrJsonDuplicateOneOf = errors.New("several members of oneof are set")
+
+type JsonWriter struct {
+	buf       []byte
+	needComma bool
+	afterKey  bool
+	err       error
+}
+
+func NewJsonWriter() *JsonWriter {
+	return &JsonWriter{buf: make([]byte, 0, 64)}
+}
+
+func (w *JsonWriter) Bytes() []byte {
+	return w.buf
+}
+
+// Err returns the first error of values which have no json form, e.g. Any of unknown type
+func (w *JsonWriter) Err() error {
+	return w.err
+}
+
+func (w *JsonWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *JsonWriter) beforeValue() {
+	if w.afterKey {
+		w.afterKey = false
+	} else if w.needComma {
+		w.buf = append(w.buf, ',')
+	}
+	w.needComma = true
+}
+
+func (w *JsonWriter) BeginObject() {
+	w.beforeValue()
+	w.buf = append(w.buf, '{')
+	w.needComma = false
+}
+
+func (w *JsonWriter) EndObject() {
+	w.buf = append(w.buf, '}')
+	w.needComma = true
+}
+
+func (w *JsonWriter) BeginArray() {
+	w.beforeValue()
+	w.buf = append(w.buf, '[')
+	w.needComma = false
+}
+
+func (w *JsonWriter) EndArray() {
+	w.buf = append(w.buf, ']')
+	w.needComma = true
+}
+
+func (w *JsonWriter) Key(name string) {
+	if w.needComma {
+		w.buf = append(w.buf, ',')
+	}
+	w.buf = appendJsonString(w.buf, name)
+	w.buf = append(w.buf, ':')
+	w.needComma = false
+	w.afterKey = true
+}
+
+func (w *JsonWriter) KeyBool(key bool) {
+	w.Key(strconv.FormatBool(key))
+}
+
+func (w *JsonWriter) KeyInt32(key int32) {
+	w.Key(strconv.FormatInt(int64(key), 10))
+}
+
+func (w *JsonWriter) KeyInt64(key int64) {
+	w.Key(strconv.FormatInt(key, 10))
+}
+
+func (w *JsonWriter) KeyUint32(key uint32) {
+	w.Key(strconv.FormatUint(uint64(key), 10))
+}
+
+func (w *JsonWriter) KeyUint64(key uint64) {
+	w.Key(strconv.FormatUint(key, 10))
+}
+
+func (w *JsonWriter) Null() {
+	w.beforeValue()
+	w.buf = append(w.buf, "null"...)
+}
+
+// Raw writes already encoded json value
+func (w *JsonWriter) Raw(value []byte) {
+	w.beforeValue()
+	w.buf = append(w.buf, value...)
+}
+
+func (w *JsonWriter) String(value string) {
+	w.beforeValue()
+	w.buf = appendJsonString(w.buf, value)
+}
+
+func (w *JsonWriter) Bytes64(value []byte) {
+	w.beforeValue()
+	w.buf = append(w.buf, '"')
+	w.buf = append(w.buf, base64.StdEncoding.EncodeToString(value)...)
+	w.buf = append(w.buf, '"')
+}
+
+func (w *JsonWriter) Bool(value bool) {
+	w.beforeValue()
+	w.buf = strconv.AppendBool(w.buf, value)
+}
+
+func (w *JsonWriter) Int32(value int32) {
+	w.beforeValue()
+	w.buf = strconv.AppendInt(w.buf, int64(value), 10)
+}
+
+func (w *JsonWriter) Uint32(value uint32) {
+	w.beforeValue()
+	w.buf = strconv.AppendUint(w.buf, uint64(value), 10)
+}
+
+func (w *JsonWriter) Int64(value int64) {
+	w.beforeValue()
+	w.buf = append(w.buf, '"')
+	w.buf = strconv.AppendInt(w.buf, value, 10)
+	w.buf = append(w.buf, '"')
+}
+
+func (w *JsonWriter) Uint64(value uint64) {
+	w.beforeValue()
+	w.buf = append(w.buf, '"')
+	w.buf = strconv.AppendUint(w.buf, value, 10)
+	w.buf = append(w.buf, '"')
+}
+
+func (w *JsonWriter) Float32(value float32) {
+	w.appendFloat(float64(value), 32)
+}
+
+func (w *JsonWriter) Float64(value float64) {
+	w.appendFloat(value, 64)
+}
+
+func (w *JsonWriter) appendFloat(value float64, bitSize int) {
+	w.beforeValue()
+	switch {
+	case math.IsNaN(value):
+		w.buf = append(w.buf, `"NaN"`...)
+	case math.IsInf(value, 1):
+		w.buf = append(w.buf, `"Infinity"`...)
+	case math.IsInf(value, -1):
+		w.buf = append(w.buf, `"-Infinity"`...)
+	default:
+		w.buf = strconv.AppendFloat(w.buf, value, 'g', -1, bitSize)
+	}
+}
+
+// Enum writes enum name, or its number if value is unknown
+func (w *JsonWriter) Enum(value int32, name string) {
+	if name == "" {
+		w.Int32(value)
+	} else {
+		w.String(name)
+	}
+}
+
+func appendJsonString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				buf = append(buf, '\\', c)
+			case c == '\n':
+				buf = append(buf, '\\', 'n')
+			case c == '\r':
+				buf = append(buf, '\\', 'r')
+			case c == '\t':
+				buf = append(buf, '\\', 't')
+			case c < 0x20:
+				buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			default:
+				buf = append(buf, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, "\ufffd"...)
+		} else {
+			buf = append(buf, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(buf, '"')
+}
+
+type JsonMapKey interface {
+	~string | ~bool | ~int32 | ~int64 | ~uint32 | ~uint64
+}
+
+// SortedMapKeys gives deterministic order of map entries in json
+func SortedMapKeys[K JsonMapKey, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		switch a := any(keys[i]).(type) {
+		case bool:
+			return !a && any(keys[j]).(bool)
+		case string:
+			return a < any(keys[j]).(string)
+		case int32:
+			return a < any(keys[j]).(int32)
+		case int64:
+			return a < any(keys[j]).(int64)
+		case uint32:
+			return a < any(keys[j]).(uint32)
+		case uint64:
+			return a < any(keys[j]).(uint64)
+		}
+		return false
+	})
+	return keys
+}
+
+func JsonFieldError(field string, err error) error {
+	return fmt.Errorf("field %q: %w", field, err)
+}
+
+func JsonIsNull(raw json.RawMessage) bool {
+	return string(raw) == "null"
+}
+
+// JsonObject decodes object into raw values by keys, null gives empty map
+func JsonObject(raw json.RawMessage) (map[string]json.RawMessage, error) {
+	var res map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// JsonArray decodes array into raw values, null gives empty list
+func JsonArray(raw json.RawMessage) ([]json.RawMessage, error) {
+	var res []json.RawMessage
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func JsonString(raw json.RawMessage) (string, error) {
+	var res string
+	err := json.Unmarshal(raw, &res)
+	return res, err
+}
+
+// JsonBytes accepts both standard and url-safe base64, with or without padding
+func JsonBytes(raw json.RawMessage) ([]byte, error) {
+	s, err := JsonString(raw)
+	if err != nil {
+		return nil, err
+	}
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func JsonBool(raw json.RawMessage) (bool, error) {
+	var res bool
+	err := json.Unmarshal(raw, &res)
+	return res, err
+}
+
+// jsonNumber returns number literal of raw, which may be quoted
+func jsonNumber(raw json.RawMessage) (string, error) {
+	if len(raw) > 0 && raw[0] == '"' {
+		return JsonString(raw)
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return "", err
+	}
+	return n.String(), nil
+}
+
+// parseJsonInt accepts integers in exponent notation as well, e.g. 1e3
+func parseJsonInt(raw json.RawMessage, bitSize int) (int64, error) {
+	s, err := jsonNumber(raw)
+	if err != nil {
+		return 0, err
+	}
+	if res, err := strconv.ParseInt(s, 10, bitSize); err == nil {
+		return res, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid integer %s", raw)
+	}
+	return strconv.ParseInt(strconv.FormatFloat(f, 'f', -1, 64), 10, bitSize)
+}
+
+func parseJsonUint(raw json.RawMessage, bitSize int) (uint64, error) {
+	s, err := jsonNumber(raw)
+	if err != nil {
+		return 0, err
+	}
+	if res, err := strconv.ParseUint(s, 10, bitSize); err == nil {
+		return res, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+		return 0, fmt.Errorf("invalid unsigned integer %s", raw)
+	}
+	return strconv.ParseUint(strconv.FormatFloat(f, 'f', -1, 64), 10, bitSize)
+}
+
+func JsonInt32(raw json.RawMessage) (int32, error) {
+	res, err := parseJsonInt(raw, 32)
+	return int32(res), err
+}
+
+func JsonInt64(raw json.RawMessage) (int64, error) {
+	return parseJsonInt(raw, 64)
+}
+
+func JsonUint32(raw json.RawMessage) (uint32, error) {
+	res, err := parseJsonUint(raw, 32)
+	return uint32(res), err
+}
+
+func JsonUint64(raw json.RawMessage) (uint64, error) {
+	return parseJsonUint(raw, 64)
+}
+
+func parseJsonFloat(raw json.RawMessage, bitSize int) (float64, error) {
+	s, err := jsonNumber(raw)
+	if err != nil {
+		return 0, err
+	}
+	switch s {
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	}
+	return strconv.ParseFloat(s, bitSize)
+}
+
+func JsonFloat32(raw json.RawMessage) (float32, error) {
+	res, err := parseJsonFloat(raw, 32)
+	return float32(res), err
+}
+
+func JsonFloat64(raw json.RawMessage) (float64, error) {
+	return parseJsonFloat(raw, 64)
+}
+
+// JsonEnum accepts enum value name or number, names are resolved by byName
+func JsonEnum(raw json.RawMessage, byName func(name string) (int32, bool)) (int32, error) {
+	if len(raw) > 0 && raw[0] == '"' {
+		name, err := JsonString(raw)
+		if err != nil {
+			return 0, err
+		}
+		if value, ok := byName(name); ok {
+			return value, nil
+		}
+		return 0, fmt.Errorf("unknown enum value %q", name)
+	}
+	return JsonInt32(raw)
+}
+
+func jsonKey(key string) json.RawMessage {
+	return json.RawMessage(strconv.Quote(key))
+}
+
+func JsonKeyBool(key string) (bool, error) {
+	return strconv.ParseBool(key)
+}
+
+func JsonKeyInt32(key string) (int32, error) {
+	return JsonInt32(jsonKey(key))
+}
+
+func JsonKeyInt64(key string) (int64, error) {
+	return JsonInt64(jsonKey(key))
+}
+
+func JsonKeyUint32(key string) (uint32, error) {
+	return JsonUint32(jsonKey(key))
+}
+
+func JsonKeyUint64(key string) (uint64, error) {
+	return JsonUint64(jsonKey(key))
+}
@@ -0,0 +1,61 @@
+package gremlin
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJsonWriter(t *testing.T) {
+	w := NewJsonWriter()
+	w.BeginObject()
+	w.Key("s")
+	w.String("a\"\n\x01\xff")
+	w.Key("i64")
+	w.Int64(-1)
+	w.Key("f")
+	w.Float64(math.Inf(-1))
+	w.Key("e")
+	w.Enum(5, "")
+	w.KeyInt32(3)
+	w.BeginArray()
+	w.Float32(1.5)
+	w.Null()
+	w.EndArray()
+	w.EndObject()
+
+	expected := `{"s":"a\"\n\u0001�","i64":"-1","f":"-Infinity","e":5,"3":[1.5,null]}`
+	if string(w.Bytes()) != expected {
+		t.Errorf("got %s, want %s", w.Bytes(), expected)
+	}
+}
+
+func TestJsonDecode(t *testing.T) {
+	if v, err := JsonInt64([]byte(`"-12"`)); err != nil || v != -12 {
+		t.Errorf("int64 string: got %v %v", v, err)
+	}
+	if v, err := JsonInt32([]byte(`1e2`)); err != nil || v != 100 {
+		t.Errorf("int32 exponent: got %v %v", v, err)
+	}
+	if _, err := JsonInt32([]byte(`1.5`)); err == nil {
+		t.Errorf("fractional int32 is accepted")
+	}
+	if _, err := JsonUint32([]byte(`4294967296`)); err == nil {
+		t.Errorf("uint32 overflow is accepted")
+	}
+	if v, err := JsonFloat64([]byte(`"NaN"`)); err != nil || !math.IsNaN(v) {
+		t.Errorf("NaN: got %v %v", v, err)
+	}
+	if v, err := JsonBytes([]byte(`"-_8"`)); err != nil || string(v) != "\xfb\xff" {
+		t.Errorf("url base64: got %v %v", v, err)
+	}
+	if v, err := JsonKeyBool("true"); err != nil || !v {
+		t.Errorf("bool key: got %v %v", v, err)
+	}
+	byName := func(name string) (int32, bool) { return 1, name == "ONE" }
+	if v, err := JsonEnum([]byte(`"ONE"`), byName); err != nil || v != 1 {
+		t.Errorf("enum: got %v %v", v, err)
+	}
+	if _, err := JsonEnum([]byte(`"TWO"`), byName); err == nil {
+		t.Errorf("unknown enum name is accepted")
+	}
+}
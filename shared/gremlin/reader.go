@@ -45,3 +45,19 @@ func (p *Reader) Bytes() []byte {
 	}
 	return p.buf
 }
+
+// ReadRanges concatenates [start, end) offset pairs of the buffer, returns nil if there are none
+func (p *Reader) ReadRanges(ranges []int) []byte {
+	if p == nil || len(ranges) == 0 {
+		return nil
+	}
+	size := 0
+	for i := 0; i+1 < len(ranges); i += 2 {
+		size += ranges[i+1] - ranges[i]
+	}
+	res := make([]byte, 0, size)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		res = append(res, p.buf[ranges[i]:ranges[i+1]]...)
+	}
+	return res
+}
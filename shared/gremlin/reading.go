@@ -25,16 +25,21 @@ import (
 func (p *Reader) ReadTagAt(offset int) (ProtoWireNumber, ProtoWireType, int, error) {
 	tagData, tagSize := p.readVarIntAt(offset)
 	if tagSize < 0 {
-		return 0, 0, 0, fmt.Errorf("invalid tag")
+		return 0, 0, 0, fmt.Errorf("invalid tag: %w", p.varIntError(offset))
 	}
 
-	if tagData>>3 > uint64(math.MaxInt32) {
-		return 0, 0, 0, fmt.Errorf("tag number out of range")
+	if tagData>>3 == 0 || tagData>>3 > MaxFieldNumber {
+		return 0, 0, 0, fmt.Errorf("%w: %v", ErrFieldNumber, tagData>>3)
 	}
 	return ProtoWireNumber(tagData >> 3), ProtoWireType(tagData & 7), tagSize, nil
 }
 
+// SkipData returns offset after the data of protoType at offset, data is guaranteed to be inside the buffer
 func (p *Reader) SkipData(offset int, protoType ProtoWireType) (int, error) {
+	return p.skipData(offset, protoType, 0)
+}
+
+func (p *Reader) skipData(offset int, protoType ProtoWireType, depth int) (int, error) {
 	switch protoType {
 	case VarIntType:
 		varIntSize, err := p.getVarIntSize(offset)
@@ -43,16 +48,22 @@ func (p *Reader) SkipData(offset int, protoType ProtoWireType) (int, error) {
 		}
 		return offset + varIntSize, nil
 	case Fixed32Type:
-		return offset + 4, nil
+		return p.skipFixed(offset, 4)
 	case Fixed64Type:
-		return offset + 8, nil
+		return p.skipFixed(offset, 8)
 	case BytesType:
 		size, sizeSize := p.readVarIntAt(offset)
 		if sizeSize < 0 {
-			return 0, fmt.Errorf("invalid varint")
+			return 0, p.varIntError(offset)
+		}
+		if size > uint64(len(p.buf)-offset-sizeSize) {
+			return 0, fmt.Errorf("%w: %v bytes at %v, %v left", ErrTruncated, size, offset, len(p.buf)-offset-sizeSize)
 		}
 		return offset + sizeSize + int(size), nil
 	case StartGroupType: // deprecated, but need to skip
+		if depth >= DefaultMaxDepth {
+			return 0, fmt.Errorf("%w: groups deeper than %v", ErrMaxDepth, DefaultMaxDepth)
+		}
 		for {
 			_, cType, tagSize, err := p.ReadTagAt(offset)
 			if err != nil {
@@ -62,14 +73,21 @@ func (p *Reader) SkipData(offset int, protoType ProtoWireType) (int, error) {
 			if cType == EndGroupType {
 				return offset, nil
 			}
-			offset, err = p.SkipData(offset, cType)
+			offset, err = p.skipData(offset, cType, depth+1)
 			if err != nil {
 				return 0, err
 			}
 		}
 	}
 
-	return 0, fmt.Errorf("invalid wire type while skipping data: %v", protoType)
+	return 0, fmt.Errorf("%w while skipping data: %v", ErrBadWireType, protoType)
+}
+
+func (p *Reader) skipFixed(offset int, size int) (int, error) {
+	if offset+size > len(p.buf) {
+		return 0, fmt.Errorf("%w: fixed%v at %v", ErrTruncated, size*8, offset)
+	}
+	return offset + size, nil
 }
 
 func (p *Reader) HasNext(offset int, size int) bool {
@@ -79,13 +97,16 @@ func (p *Reader) HasNext(offset int, size int) bool {
 func (p *Reader) getVarIntSize(offset int) (int, error) {
 	for i := 0; i < 10; i++ {
 		if !p.HasNext(offset, i) {
-			return 0, fmt.Errorf("invalid varint, not enough bytes")
+			return 0, fmt.Errorf("%w: varint at %v", ErrTruncated, offset)
+		}
+		if i == 9 && p.buf[offset+i] > 1 {
+			break
 		}
 		if p.buf[offset+i] < 0x80 {
 			return i + 1, nil
 		}
 	}
-	return 10, nil
+	return 0, fmt.Errorf("%w: at %v", ErrOverflow, offset)
 }
 
 func (p *Reader) readFixed32At(offset int) uint64 {
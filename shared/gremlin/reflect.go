@@ -0,0 +1,81 @@
+package gremlin
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	ErrUnknownField = errors.New("unknown field")
+	ErrFieldType    = errors.New("wrong type of field value")
+)
+
+// Reflectable is implemented by generated structs and readers,
+// XXX_GetField returns value in the type of the struct field or the reader getter, ok is false for unknown numbers
+type Reflectable interface {
+	XXX_Descriptor() *MessageDescriptor
+	XXX_GetField(number ProtoWireNumber) (value interface{}, ok bool)
+}
+
+// ReflectableStruct is implemented by generated structs, readers are read only.
+// XXX_SetField accepts values in the type of the struct field, nil clears the field
+type ReflectableStruct interface {
+	Reflectable
+	XXX_SetField(number ProtoWireNumber, value interface{}) error
+}
+
+func NewUnknownFieldError(message string, field interface{}) error {
+	return fmt.Errorf("%w: %v has no field %v", ErrUnknownField, message, field)
+}
+
+func NewFieldTypeError(field string, expected string, value interface{}) error {
+	return fmt.Errorf("%w: %v expects %v, got %T", ErrFieldType, field, expected, value)
+}
+
+// GetField gets field by proto or json name
+func GetField(msg Reflectable, name string) (interface{}, error) {
+	desc := msg.XXX_Descriptor()
+	field := desc.FieldByName(name)
+	if field == nil {
+		return nil, NewUnknownFieldError(desc.Name, name)
+	}
+	value, _ := msg.XXX_GetField(field.Number)
+	return value, nil
+}
+
+// SetField sets field by proto or json name
+func SetField(msg ReflectableStruct, name string, value interface{}) error {
+	desc := msg.XXX_Descriptor()
+	field := desc.FieldByName(name)
+	if field == nil {
+		return NewUnknownFieldError(desc.Name, name)
+	}
+	return msg.XXX_SetField(field.Number, value)
+}
+
+// RangeFields calls f for populated fields in declaration order until f returns false,
+// members of oneofs are populated when chosen even if they hold default value
+func RangeFields(msg Reflectable, f func(field *FieldDescriptor, value interface{}) bool) {
+	for _, field := range msg.XXX_Descriptor().Fields {
+		value, ok := msg.XXX_GetField(field.Number)
+		if !ok || value == nil || (field.OneOf == "" && isEmptyValue(value)) {
+			continue
+		}
+		if !f(field, value) {
+			return
+		}
+	}
+}
+
+func isEmptyValue(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
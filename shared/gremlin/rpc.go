@@ -0,0 +1,57 @@
+package gremlin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// rpc runtime for generated services: clients marshal requests and pass them to RpcTransport,
+// servers get them back through RpcHandler returned by generated New<Service>Handler
+
+var ErrRpcUnknownMethod = errors.New("unknown rpc method")
+
+type RpcTransport interface {
+	Call(ctx context.Context, service string, method string, request []byte) ([]byte, error)
+}
+
+type RpcHandler interface {
+	ServiceName() string
+	HandleRpc(ctx context.Context, method string, request []byte) ([]byte, error)
+}
+
+// RpcError is an error with status passed to the caller as is, status values are http status codes
+type RpcError struct {
+	Status  int
+	Message string
+}
+
+func NewRpcError(status int, format string, args ...any) *RpcError {
+	return &RpcError{
+		Status:  status,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("rpc error %v: %v", e.Status, e.Message)
+}
+
+// RpcBadRequest is returned by generated handlers for requests which can't be unmarshalled
+func RpcBadRequest(err error) *RpcError {
+	return NewRpcError(http.StatusBadRequest, "bad request: %v", err)
+}
+
+// rpcErrorStatus returns status and message passed to the caller, message of errors other than
+// RpcError may hold internal details, so they are replaced with generic one
+func rpcErrorStatus(err error) (int, string) {
+	var rpcErr *RpcError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Status, rpcErr.Message
+	}
+	if errors.Is(err, ErrRpcUnknownMethod) {
+		return http.StatusNotFound, err.Error()
+	}
+	return http.StatusInternalServerError, "internal error"
+}
@@ -0,0 +1,160 @@
+package gremlin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// http/1.1 rpc transport: POST {base}/{service}/{method} with gremlin-encoded request as body,
+// 200 response holds gremlin-encoded reply, any other status - error message as text
+
+const RpcContentType = "application/x-protobuf"
+
+const (
+	defaultMaxRpcRequestSize  = 64 << 20
+	defaultMaxRpcResponseSize = 64 << 20
+)
+
+type HttpRpcTransport struct {
+	baseUrl         string
+	client          *http.Client
+	maxResponseSize int64
+}
+
+// NewHttpRpcTransport creates transport calling server at baseUrl, http.DefaultClient is used if client is nil
+func NewHttpRpcTransport(baseUrl string, client *http.Client) *HttpRpcTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HttpRpcTransport{
+		baseUrl:         strings.TrimSuffix(baseUrl, "/"),
+		client:          client,
+		maxResponseSize: defaultMaxRpcResponseSize,
+	}
+}
+
+// WithMaxResponseSize limits response body size, bigger responses fail the call
+func (t *HttpRpcTransport) WithMaxResponseSize(size int64) *HttpRpcTransport {
+	t.maxResponseSize = size
+	return t
+}
+
+func (t *HttpRpcTransport) Call(ctx context.Context, service string, method string, request []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseUrl+"/"+service+"/"+method, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", RpcContentType)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, t.maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > t.maxResponseSize {
+		return nil, fmt.Errorf("rpc response of %v/%v exceeds %v bytes", service, method, t.maxResponseSize)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &RpcError{
+			Status:  resp.StatusCode,
+			Message: strings.TrimSpace(string(body)),
+		}
+	}
+	return body, nil
+}
+
+type HttpRpcServer struct {
+	handlers       map[string]RpcHandler
+	maxRequestSize int64
+	errorLog       *log.Logger
+}
+
+// NewHttpRpcServer serves handlers of generated services for NewHttpRpcTransport clients
+func NewHttpRpcServer(handlers ...RpcHandler) *HttpRpcServer {
+	res := &HttpRpcServer{
+		handlers:       map[string]RpcHandler{},
+		maxRequestSize: defaultMaxRpcRequestSize,
+	}
+	for _, handler := range handlers {
+		res.handlers[handler.ServiceName()] = handler
+	}
+	return res
+}
+
+// WithMaxRequestSize limits request body size, bigger requests are rejected with 413
+func (s *HttpRpcServer) WithMaxRequestSize(size int64) *HttpRpcServer {
+	s.maxRequestSize = size
+	return s
+}
+
+// WithErrorLog sets logger for handler errors which are not passed to the caller,
+// standard logger is used if it's nil
+func (s *HttpRpcServer) WithErrorLog(logger *log.Logger) *HttpRpcServer {
+	s.errorLog = logger
+	return s
+}
+
+func (s *HttpRpcServer) logf(format string, args ...any) {
+	if s.errorLog != nil {
+		s.errorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *HttpRpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	idx := strings.LastIndexByte(path, '/')
+	if idx < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	handler, ok := s.handlers[path[:idx]]
+	if !ok {
+		http.Error(w, "unknown rpc service "+path[:idx], http.StatusNotFound)
+		return
+	}
+
+	if r.ContentLength > s.maxRequestSize {
+		http.Error(w, "request is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	request, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxRequestSize))
+	if err != nil {
+		if int64(len(request)) >= s.maxRequestSize {
+			http.Error(w, "request is too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	response, err := handler.HandleRpc(r.Context(), path[idx+1:], request)
+	if err != nil {
+		status, msg := rpcErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			s.logf("rpc %s failed: %v", path, err)
+		}
+		http.Error(w, msg, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", RpcContentType)
+	_, _ = w.Write(response)
+}
@@ -0,0 +1,102 @@
+package gremlin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type echoHandler struct{}
+
+func (echoHandler) ServiceName() string {
+	return "test.Echo"
+}
+
+func (echoHandler) HandleRpc(_ context.Context, method string, request []byte) ([]byte, error) {
+	switch method {
+	case "Echo":
+		return request, nil
+	case "Fail":
+		return nil, errors.New("failed: secret details")
+	}
+	return nil, ErrRpcUnknownMethod
+}
+
+func TestHttpRpc(t *testing.T) {
+	errorLog := bytes.NewBuffer(nil)
+	server := httptest.NewServer(NewHttpRpcServer(echoHandler{}).
+		WithMaxRequestSize(16).
+		WithErrorLog(log.New(errorLog, "", 0)))
+	defer server.Close()
+	transport := NewHttpRpcTransport(server.URL+"/", server.Client())
+
+	res, err := transport.Call(context.Background(), "test.Echo", "Echo", []byte{1, 2, 3})
+	if err != nil || string(res) != "\x01\x02\x03" {
+		t.Errorf("Echo: got %v %v", res, err)
+	}
+
+	for _, tc := range []struct {
+		service, method string
+		status          int
+	}{
+		{"test.Echo", "Fail", http.StatusInternalServerError},
+		{"test.Echo", "Nope", http.StatusNotFound},
+		{"test.Nope", "Echo", http.StatusNotFound},
+	} {
+		_, err = transport.Call(context.Background(), tc.service, tc.method, nil)
+		var rpcErr *RpcError
+		if !errors.As(err, &rpcErr) || rpcErr.Status != tc.status {
+			t.Errorf("%v/%v: got %v, want status %v", tc.service, tc.method, err, tc.status)
+		}
+	}
+
+	resp, err := server.Client().Get(server.URL + "/test.Echo/Echo")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got %v, want %v", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	_, err = transport.Call(context.Background(), "test.Echo", "Fail", nil)
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Fail: internal error is passed to the caller: %v", err)
+	}
+	if !strings.Contains(errorLog.String(), "test.Echo/Fail failed: failed: secret details") {
+		t.Errorf("Fail: error is not logged: %q", errorLog.String())
+	}
+
+	if _, err = transport.Call(context.Background(), "test.Echo", "Echo", bytes.Repeat([]byte{1}, 16)); err != nil {
+		t.Errorf("Echo of max size: %v", err)
+	}
+	limited := NewHttpRpcTransport(server.URL, server.Client()).WithMaxResponseSize(8)
+	if _, err = limited.Call(context.Background(), "test.Echo", "Echo", bytes.Repeat([]byte{1}, 8)); err != nil {
+		t.Errorf("Echo of max response size: %v", err)
+	}
+	if _, err = limited.Call(context.Background(), "test.Echo", "Echo", bytes.Repeat([]byte{1}, 9)); err == nil {
+		t.Errorf("Echo of oversized response: no error")
+	}
+	_, err = transport.Call(context.Background(), "test.Echo", "Echo", bytes.Repeat([]byte{1}, 17))
+	var rpcErr *RpcError
+	if !errors.As(err, &rpcErr) || rpcErr.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Echo of oversized request: got %v", err)
+	}
+
+	// chunked request has no content length, so it's cut while reading
+	body := struct{ io.Reader }{bytes.NewReader(bytes.Repeat([]byte{1}, 64))}
+	resp, err = server.Client().Post(server.URL+"/test.Echo/Echo", RpcContentType, body)
+	if err != nil {
+		t.Fatalf("chunked POST failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked POST: got %v, want %v", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,121 @@
+package gremlin
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTruncated   = errors.New("truncated data")
+	ErrOverflow    = errors.New("varint overflow")
+	ErrBadWireType = errors.New("bad wire type")
+	ErrFieldNumber = errors.New("field number out of range")
+	ErrMaxDepth    = errors.New("max nesting depth exceeded")
+	ErrMaxSize     = errors.New("max message size exceeded")
+)
+
+const MaxFieldNumber = 1<<29 - 1
+
+const (
+	DefaultMaxDepth = 100
+	DefaultMaxSize  = 64 << 20
+)
+
+// DecodeOptions limits SafeUnmarshal of generated readers, zero value of a limit means no limit
+type DecodeOptions struct {
+	MaxDepth int
+	MaxSize  int
+
+	depth int
+}
+
+var DefaultDecodeOptions = DecodeOptions{
+	MaxDepth: DefaultMaxDepth,
+	MaxSize:  DefaultMaxSize,
+}
+
+// Enter checks limits for message data and returns options for its nested messages
+func (o DecodeOptions) Enter(data []byte) (DecodeOptions, error) {
+	if o.MaxSize > 0 && len(data) > o.MaxSize {
+		return o, fmt.Errorf("%w: %v bytes, limit is %v", ErrMaxSize, len(data), o.MaxSize)
+	}
+	o.depth++
+	if o.MaxDepth > 0 && o.depth > o.MaxDepth {
+		return o, fmt.Errorf("%w: limit is %v", ErrMaxDepth, o.MaxDepth)
+	}
+	return o, nil
+}
+
+// CheckPacked validates packed list at offset, every entry should be complete and inside the list
+func (p *Reader) CheckPacked(offset int, entryType ProtoWireType) error {
+	size, sizeSize := p.readVarIntAt(offset)
+	if sizeSize < 0 {
+		return p.varIntError(offset)
+	}
+	offset += sizeSize
+	end := offset + int(size)
+	switch entryType {
+	case VarIntType:
+		for offset < end {
+			entrySize, err := p.getVarIntSize(offset)
+			if err != nil {
+				return err
+			}
+			offset += entrySize
+		}
+		if offset > end {
+			return fmt.Errorf("%w: packed varint crosses list end", ErrTruncated)
+		}
+	case Fixed32Type:
+		if size%4 != 0 {
+			return fmt.Errorf("%w: packed fixed32 list of %v bytes", ErrTruncated, size)
+		}
+	case Fixed64Type:
+		if size%8 != 0 {
+			return fmt.Errorf("%w: packed fixed64 list of %v bytes", ErrTruncated, size)
+		}
+	default:
+		return fmt.Errorf("%w: %v can't be packed", ErrBadWireType, entryType)
+	}
+	return nil
+}
+
+// CheckMapEntry validates map entry at offset and returns offset of its value data, 0 if value is absent
+func (p *Reader) CheckMapEntry(offset int, keyType ProtoWireType, valueType ProtoWireType) (int, error) {
+	size, sizeSize := p.readVarIntAt(offset)
+	if sizeSize < 0 {
+		return 0, p.varIntError(offset)
+	}
+	offset += sizeSize
+	end := offset + int(size)
+	var valueOffset = 0
+	for offset < end {
+		tag, wire, tagSize, err := p.ReadTagAt(offset)
+		if err != nil {
+			return 0, err
+		}
+		offset += tagSize
+		if (tag == 1 && wire != keyType) || (tag == 2 && wire != valueType) {
+			return 0, fmt.Errorf("%w: %v for map entry field %v", ErrBadWireType, wire, tag)
+		}
+		if tag == 2 {
+			valueOffset = offset
+		}
+		offset, err = p.SkipData(offset, wire)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if offset > end {
+		return 0, fmt.Errorf("%w: map entry field crosses entry end", ErrTruncated)
+	}
+	return valueOffset, nil
+}
+
+func (p *Reader) varIntError(offset int) error {
+	_, err := p.getVarIntSize(offset)
+	if err == nil {
+		return fmt.Errorf("%w: at %v", ErrOverflow, offset)
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package gremlin
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSkipDataErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"truncated tag", []byte{0x80}, ErrTruncated},
+		{"overflowed tag", bytes.Repeat([]byte{0xff}, 11), ErrOverflow},
+		{"zero field number", []byte{0x00, 0x01}, ErrFieldNumber},
+		{"huge field number", []byte{0xf8, 0xff, 0xff, 0xff, 0x7f, 0x00}, ErrFieldNumber},
+		{"truncated varint", []byte{0x08, 0x80}, ErrTruncated},
+		{"overflowed varint", append([]byte{0x08}, bytes.Repeat([]byte{0xff}, 10)...), ErrOverflow},
+		{"truncated fixed32", []byte{0x0d, 0x01, 0x02}, ErrTruncated},
+		{"truncated fixed64", []byte{0x09, 0x01, 0x02, 0x03, 0x04}, ErrTruncated},
+		{"truncated bytes", []byte{0x0a, 0x05, 0x01}, ErrTruncated},
+		{"huge bytes", []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, ErrTruncated},
+		{"end group", []byte{0x0c}, ErrBadWireType},
+		{"wire type 7", []byte{0x0f}, ErrBadWireType},
+		{"deep groups", bytes.Repeat([]byte{0x0b}, DefaultMaxDepth+2), ErrMaxDepth},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := skipAll(NewReader(tc.data)); !errors.Is(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCheckPacked(t *testing.T) {
+	w := NewWriter(32)
+	w.AppendBytesTag(1, 3)
+	w.AppendUint64WithoutTag(300)
+	w.AppendUint64WithoutTag(1)
+	if err := NewReader(w.Bytes()).CheckPacked(1, VarIntType); err != nil {
+		t.Errorf("varint: got %v", err)
+	}
+	if err := NewReader(w.Bytes()).CheckPacked(1, Fixed32Type); !errors.Is(err, ErrTruncated) {
+		t.Errorf("fixed32: got %v, want truncated", err)
+	}
+	if err := NewReader([]byte{0x0a, 0x01, 0x80, 0x01}).CheckPacked(1, VarIntType); !errors.Is(err, ErrTruncated) {
+		t.Errorf("crossing varint: got %v, want truncated", err)
+	}
+}
+
+func TestCheckMapEntry(t *testing.T) {
+	w := NewWriter(32)
+	w.AppendBytesTag(1, 6)
+	w.AppendInt32(1, 1)
+	w.AppendString(2, "ab")
+	offset, err := NewReader(w.Bytes()).CheckMapEntry(1, VarIntType, BytesType)
+	if err != nil || offset != 5 {
+		t.Errorf("got %v, %v", offset, err)
+	}
+	if _, err = NewReader(w.Bytes()).CheckMapEntry(1, BytesType, BytesType); !errors.Is(err, ErrBadWireType) {
+		t.Errorf("key: got %v, want bad wire type", err)
+	}
+}
+
+func TestDecodeOptions(t *testing.T) {
+	opts := DecodeOptions{MaxDepth: 2, MaxSize: 4}
+	nested, err := opts.Enter([]byte{1, 2, 3, 4})
+	if err != nil {
+		t.Fatalf("enter: %v", err)
+	}
+	if nested, err = nested.Enter(nil); err != nil {
+		t.Fatalf("enter nested: %v", err)
+	}
+	if _, err = nested.Enter(nil); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("depth: got %v, want max depth", err)
+	}
+	if _, err = opts.Enter([]byte{1, 2, 3, 4, 5}); !errors.Is(err, ErrMaxSize) {
+		t.Errorf("size: got %v, want max size", err)
+	}
+	if _, err = (DecodeOptions{}).Enter(make([]byte, DefaultMaxSize+1)); err != nil {
+		t.Errorf("no limits: got %v", err)
+	}
+}
+
+func FuzzSkipData(f *testing.F) {
+	f.Add([]byte{0x08, 0x96, 0x01, 0x0a, 0x02, 'h', 'i', 0x0b, 0x0d, 0x01, 0x02, 0x03, 0x04, 0x0c})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_ = skipAll(NewReader(data))
+	})
+}
+
+func skipAll(r *Reader) error {
+	offset := 0
+	for r.HasNext(offset, 0) {
+		_, wire, tagSize, err := r.ReadTagAt(offset)
+		if err != nil {
+			return err
+		}
+		if offset, err = r.SkipData(offset+tagSize, wire); err != nil {
+			return err
+		}
+		if offset > len(r.Bytes()) {
+			return errors.New("skipped past the end")
+		}
+	}
+	return nil
+}
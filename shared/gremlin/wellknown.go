@@ -0,0 +1,328 @@
+package gremlin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runtime part of well-known types from protobufs/google/protobuf, generated code calls it
+// for conversions to go types and for their special json form
+
+const AnyTypeUrlPrefix = "type.googleapis.com/"
+
+var ErrAnyTypeMismatch = errors.New("any holds message of other type")
+
+// valid range of Timestamp: 0001-01-01T00:00:00Z - 9999-12-31T23:59:59.999999999Z
+const (
+	minTimestampSeconds = -62135596800
+	maxTimestampSeconds = 253402300799
+	maxDurationSeconds  = 315576000000
+)
+
+func TimeToProto(t time.Time) (int64, int32) {
+	return t.Unix(), int32(t.Nanosecond())
+}
+
+func TimeFromProto(seconds int64, nanos int32) time.Time {
+	return time.Unix(seconds, int64(nanos)).UTC()
+}
+
+func DurationToProto(d time.Duration) (int64, int32) {
+	return int64(d / time.Second), int32(d % time.Second)
+}
+
+// DurationFromProto saturates durations which don't fit time.Duration
+func DurationFromProto(seconds int64, nanos int32) time.Duration {
+	if seconds > int64(math.MaxInt64/time.Second) {
+		return math.MaxInt64
+	}
+	if seconds < int64(math.MinInt64/time.Second) {
+		return math.MinInt64
+	}
+	d := time.Duration(seconds) * time.Second
+	if nanos > 0 && d > math.MaxInt64-time.Duration(nanos) {
+		return math.MaxInt64
+	}
+	if nanos < 0 && d < math.MinInt64-time.Duration(nanos) {
+		return math.MinInt64
+	}
+	return d + time.Duration(nanos)
+}
+
+func AnyTypeUrl(messageName string) string {
+	return AnyTypeUrlPrefix + messageName
+}
+
+// AnyMessageName returns full message name from type url, everything after the last slash
+func AnyMessageName(typeUrl string) string {
+	return typeUrl[strings.LastIndexByte(typeUrl, '/')+1:]
+}
+
+// well-known types with special json form are packed into Any json as `value` field,
+// fields of other messages are written next to `@type`
+var anyJsonValueTypes = map[string]bool{
+	"google.protobuf.Any":         true,
+	"google.protobuf.Timestamp":   true,
+	"google.protobuf.Duration":    true,
+	"google.protobuf.FieldMask":   true,
+	"google.protobuf.Value":       true,
+	"google.protobuf.Struct":      true,
+	"google.protobuf.ListValue":   true,
+	"google.protobuf.DoubleValue": true,
+	"google.protobuf.FloatValue":  true,
+	"google.protobuf.Int64Value":  true,
+	"google.protobuf.UInt64Value": true,
+	"google.protobuf.Int32Value":  true,
+	"google.protobuf.UInt32Value": true,
+	"google.protobuf.BoolValue":   true,
+	"google.protobuf.StringValue": true,
+	"google.protobuf.BytesValue":  true,
+}
+
+func findAnyDescriptor(typeUrl string) (*MessageDescriptor, error) {
+	desc := FindMessageDescriptor(AnyMessageName(typeUrl))
+	if desc == nil || desc.NewStruct == nil || desc.NewReader == nil {
+		return nil, fmt.Errorf("unknown type of any %q", typeUrl)
+	}
+	return desc, nil
+}
+
+func AnyCheckType(typeUrl string, msg ProtoNamed) error {
+	if AnyMessageName(typeUrl) != msg.XXX_ProtoName() {
+		return fmt.Errorf("%w: %q, not %v", ErrAnyTypeMismatch, typeUrl, msg.XXX_ProtoName())
+	}
+	return nil
+}
+
+// Any writes packed message in canonical form: `{"@type": url, ...fields of the message}`,
+// empty Any is `{}`. Type of the message must be registered, i.e. its package has to be linked
+func (w *JsonWriter) Any(typeUrl string, value []byte) {
+	if typeUrl == "" && len(value) == 0 {
+		w.BeginObject()
+		w.EndObject()
+		return
+	}
+	desc, err := findAnyDescriptor(typeUrl)
+	if err != nil {
+		w.fail(err)
+		w.Null()
+		return
+	}
+	msg := desc.NewReader()
+	if err = msg.SafeUnmarshal(value, DefaultDecodeOptions); err != nil {
+		w.fail(fmt.Errorf("any %q: %w", typeUrl, err))
+		w.Null()
+		return
+	}
+
+	w.BeginObject()
+	w.Key("@type")
+	w.String(typeUrl)
+	if anyJsonValueTypes[desc.Name] {
+		w.Key("value")
+		msg.MarshalJSONTo(w)
+	} else {
+		// fields of the message object are spliced into Any object
+		inner := &JsonWriter{}
+		msg.MarshalJSONTo(inner)
+		w.fail(inner.err)
+		if fields := inner.buf[1 : len(inner.buf)-1]; len(fields) > 0 {
+			w.buf = append(append(w.buf, ','), fields...)
+		}
+	}
+	w.EndObject()
+}
+
+// JsonAny reads Any written by JsonWriter.Any back to type url and marshalled message
+func JsonAny(raw json.RawMessage) (string, []byte, error) {
+	fields, err := JsonObject(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(fields) == 0 {
+		return "", nil, nil
+	}
+	typeUrlRaw, ok := fields["@type"]
+	if !ok {
+		return "", nil, fmt.Errorf("any without @type")
+	}
+	typeUrl, err := JsonString(typeUrlRaw)
+	if err != nil {
+		return "", nil, JsonFieldError("@type", err)
+	}
+	desc, err := findAnyDescriptor(typeUrl)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var data json.RawMessage
+	if anyJsonValueTypes[desc.Name] {
+		if data, ok = fields["value"]; !ok {
+			return "", nil, fmt.Errorf("any %q without value", typeUrl)
+		}
+	} else {
+		delete(fields, "@type")
+		if data, err = json.Marshal(fields); err != nil {
+			return "", nil, err
+		}
+	}
+	msg := desc.NewStruct()
+	if err = msg.UnmarshalJSON(data); err != nil {
+		return "", nil, fmt.Errorf("any %q: %w", typeUrl, err)
+	}
+	return typeUrl, msg.Marshal(), nil
+}
+
+// Timestamp writes RFC 3339 time in UTC with 0, 3, 6 or 9 fractional digits
+func (w *JsonWriter) Timestamp(seconds int64, nanos int32) {
+	t := TimeFromProto(seconds, nanos)
+	w.String(t.Format("2006-01-02T15:04:05") + formatJsonNanos(nanos) + "Z")
+}
+
+// Duration writes seconds with 0, 3, 6 or 9 fractional digits and `s` suffix, like `-1.500s`
+func (w *JsonWriter) Duration(seconds int64, nanos int32) {
+	sign := ""
+	if seconds < 0 || nanos < 0 {
+		sign = "-"
+	}
+	if seconds < 0 {
+		seconds = -seconds
+	}
+	if nanos < 0 {
+		nanos = -nanos
+	}
+	w.String(sign + strconv.FormatInt(seconds, 10) + formatJsonNanos(nanos) + "s")
+}
+
+func formatJsonNanos(nanos int32) string {
+	switch {
+	case nanos == 0:
+		return ""
+	case nanos%1000000 == 0:
+		return fmt.Sprintf(".%03d", nanos/1000000)
+	case nanos%1000 == 0:
+		return fmt.Sprintf(".%06d", nanos/1000)
+	default:
+		return fmt.Sprintf(".%09d", nanos)
+	}
+}
+
+func JsonTimestamp(raw json.RawMessage) (int64, int32, error) {
+	s, err := JsonString(raw)
+	if err != nil {
+		return 0, 0, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+	seconds, nanos := TimeToProto(t)
+	if seconds < minTimestampSeconds || seconds > maxTimestampSeconds {
+		return 0, 0, fmt.Errorf("timestamp %q is out of range", s)
+	}
+	return seconds, nanos, nil
+}
+
+func JsonDuration(raw json.RawMessage) (int64, int32, error) {
+	s, err := JsonString(raw)
+	if err != nil {
+		return 0, 0, err
+	}
+	invalid := fmt.Errorf("invalid duration %q", s)
+
+	if !strings.HasSuffix(s, "s") {
+		return 0, 0, invalid
+	}
+	value := strings.TrimSuffix(s, "s")
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+	secondsPart, nanosPart, _ := strings.Cut(value, ".")
+	if secondsPart == "" || len(nanosPart) > 9 || strings.HasPrefix(secondsPart, "+") {
+		return 0, 0, invalid
+	}
+
+	seconds, err := strconv.ParseInt(secondsPart, 10, 64)
+	if err != nil || seconds > maxDurationSeconds {
+		return 0, 0, invalid
+	}
+	var nanos int64
+	if nanosPart != "" {
+		if nanos, err = strconv.ParseInt(nanosPart+strings.Repeat("0", 9-len(nanosPart)), 10, 32); err != nil || nanos < 0 {
+			return 0, 0, invalid
+		}
+	}
+	if negative {
+		return -seconds, -int32(nanos), nil
+	}
+	return seconds, int32(nanos), nil
+}
+
+// FieldMask writes paths joined by commas, path names are converted to lowerCamel form
+func (w *JsonWriter) FieldMask(paths []string) {
+	var names []string
+	for _, path := range paths {
+		names = append(names, fieldMaskJsonPath(path))
+	}
+	w.String(strings.Join(names, ","))
+}
+
+func fieldMaskJsonPath(path string) string {
+	var res strings.Builder
+	upperNext := false
+	for _, c := range path {
+		if c == '_' {
+			upperNext = true
+			continue
+		}
+		if upperNext && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upperNext = false
+		res.WriteRune(c)
+	}
+	return res.String()
+}
+
+// JsonFieldMask reads paths of comma separated lowerCamel names back to proto names
+func JsonFieldMask(raw json.RawMessage) ([]string, error) {
+	s, err := JsonString(raw)
+	if err != nil {
+		return nil, err
+	}
+	if s == "" {
+		return nil, nil
+	}
+	var res []string
+	for _, path := range strings.Split(s, ",") {
+		if path == "" || strings.Contains(path, "_") {
+			return nil, fmt.Errorf("invalid field mask %q", s)
+		}
+		var name strings.Builder
+		for _, c := range path {
+			if c >= 'A' && c <= 'Z' {
+				name.WriteByte('_')
+				c += 'a' - 'A'
+			}
+			name.WriteRune(c)
+		}
+		res = append(res, name.String())
+	}
+	return res, nil
+}
+
+// JsonValueKind returns the first byte of json value: n, t, f, ", {, [ or first char of number
+func JsonValueKind(raw json.RawMessage) byte {
+	for _, c := range raw {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c
+	}
+	return 0
+}
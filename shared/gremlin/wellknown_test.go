@@ -0,0 +1,84 @@
+package gremlin
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestJsonDuration(t *testing.T) {
+	for _, tc := range []struct {
+		seconds int64
+		nanos   int32
+		json    string
+	}{
+		{0, 0, `"0s"`},
+		{1, 500000000, `"1.500s"`},
+		{-1, -5000, `"-1.000005s"`},
+		{0, -1, `"-0.000000001s"`},
+	} {
+		w := NewJsonWriter()
+		w.Duration(tc.seconds, tc.nanos)
+		if string(w.Bytes()) != tc.json {
+			t.Errorf("%v %v: got %s, want %s", tc.seconds, tc.nanos, w.Bytes(), tc.json)
+		}
+		seconds, nanos, err := JsonDuration(w.Bytes())
+		if err != nil || seconds != tc.seconds || nanos != tc.nanos {
+			t.Errorf("%s: got %v %v %v", tc.json, seconds, nanos, err)
+		}
+	}
+
+	for _, invalid := range []string{`"1"`, `"s"`, `"1.0000000001s"`, `"+1s"`, `"1e3s"`, `1`} {
+		if _, _, err := JsonDuration([]byte(invalid)); err == nil {
+			t.Errorf("%s is accepted", invalid)
+		}
+	}
+}
+
+func TestJsonTimestamp(t *testing.T) {
+	w := NewJsonWriter()
+	w.Timestamp(1665664200, 120000)
+	if string(w.Bytes()) != `"2022-10-13T12:30:00.000120Z"` {
+		t.Errorf("got %s", w.Bytes())
+	}
+
+	seconds, nanos, err := JsonTimestamp([]byte(`"2022-10-13T14:30:00.00012+02:00"`))
+	if err != nil || seconds != 1665664200 || nanos != 120000 {
+		t.Errorf("got %v %v %v", seconds, nanos, err)
+	}
+	if _, _, err = JsonTimestamp([]byte(`"10000-01-01T00:00:00Z"`)); err == nil {
+		t.Errorf("timestamp out of range is accepted")
+	}
+}
+
+func TestDurationFromProto(t *testing.T) {
+	if d := DurationFromProto(-1, -500000000); d != -1500*time.Millisecond {
+		t.Errorf("got %v", d)
+	}
+	if d := DurationFromProto(maxDurationSeconds, 0); d != math.MaxInt64 {
+		t.Errorf("got %v, want saturated", d)
+	}
+	if seconds, nanos := DurationToProto(-1500 * time.Millisecond); seconds != -1 || nanos != -500000000 {
+		t.Errorf("got %v %v", seconds, nanos)
+	}
+}
+
+func TestJsonFieldMask(t *testing.T) {
+	w := NewJsonWriter()
+	w.FieldMask([]string{"user.display_name", "photo"})
+	if string(w.Bytes()) != `"user.displayName,photo"` {
+		t.Fatalf("field mask json: %s", w.Bytes())
+	}
+	paths, err := JsonFieldMask(w.Bytes())
+	if err != nil || len(paths) != 2 || paths[0] != "user.display_name" || paths[1] != "photo" {
+		t.Errorf("field mask paths: %v, %v", paths, err)
+	}
+	if paths, err := JsonFieldMask([]byte(`""`)); err != nil || paths != nil {
+		t.Errorf("empty field mask: %v, %v", paths, err)
+	}
+	for _, invalid := range []string{`"a,,b"`, `"display_name"`, `1`} {
+		if _, err := JsonFieldMask([]byte(invalid)); err == nil {
+			t.Errorf("expected error for %v", invalid)
+		}
+	}
+}
@@ -406,6 +406,11 @@ func (p *Writer) writeByte(data ...byte) {
 	p.writeBytes(data)
 }
 
+// AppendRaw appends already encoded fields, like unknown fields of a message
+func (p *Writer) AppendRaw(data []byte) {
+	p.writeBytes(data)
+}
+
 func (p *Writer) Bytes() []byte {
 	return p.buf[:len(p.buf)]
 }
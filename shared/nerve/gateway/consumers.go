@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"octopus/shared/nerve"
+)
+
+const (
+	defaultLeaseTimeout = 30 * time.Second
+	defaultIdleTimeout  = 5 * time.Minute
+	maxExpireInterval   = time.Second
+)
+
+type lease struct {
+	packet   *nerve.Packet
+	deadline time.Time
+}
+
+// consumer is a receiver of gateway client along with packets handed out to it and not acked yet,
+// packets not acked before their lease deadline are handed out again
+type consumer struct {
+	receiver *nerve.Receiver
+	lock     sync.Mutex
+	leases   map[nerve.QueueElementIndex]*lease
+	// expired leases sorted by index, they are handed out before new packets
+	expired   []*nerve.Packet
+	redeliver chan struct{}
+	// requests in flight, consumer isn't idle while it has them
+	active   int
+	lastUsed time.Time
+}
+
+func newConsumer(receiver *nerve.Receiver) *consumer {
+	return &consumer{
+		receiver:  receiver,
+		leases:    make(map[nerve.QueueElementIndex]*lease),
+		redeliver: make(chan struct{}, 1),
+		lastUsed:  time.Now(),
+	}
+}
+
+// takeExpired returns up to max packets with expired leases
+func (c *consumer) takeExpired(max int) []*nerve.Packet {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	n := len(c.expired)
+	if n > max {
+		n = max
+	}
+	packets := append([]*nerve.Packet(nil), c.expired[:n]...)
+	c.expired = c.expired[n:]
+	return packets
+}
+
+func (c *consumer) lease(packets []*nerve.Packet, timeout time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for _, packet := range packets {
+		c.leases[packet.DbId] = &lease{packet: packet, deadline: deadline}
+	}
+}
+
+func (c *consumer) ack(id nerve.QueueElementIndex) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.leases, id)
+	for i, packet := range c.expired {
+		if packet.DbId == id {
+			c.expired = append(c.expired[:i], c.expired[i+1:]...)
+			break
+		}
+	}
+}
+
+// expire moves leases past their deadline to expired packets, returns number of moved ones
+func (c *consumer) expire(now time.Time) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	n := 0
+	for id, l := range c.leases {
+		if now.Before(l.deadline) {
+			continue
+		}
+		delete(c.leases, id)
+		c.expired = append(c.expired, l.packet)
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+
+	sort.Slice(c.expired, func(i, j int) bool {
+		return c.expired[i].DbId < c.expired[j].DbId
+	})
+	select {
+	case c.redeliver <- struct{}{}:
+	default:
+	}
+	return n
+}
+
+func (c *consumer) isIdle(now time.Time, timeout time.Duration) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.active == 0 && now.Sub(c.lastUsed) >= timeout
+}
+
+// acquireConsumer returns consumer of client marking it busy until releaseConsumer,
+// receiver is created on first use
+func (g *Gateway) acquireConsumer(queue nerve.QueueConfig, id nerve.ConsumerId) *consumer {
+	g.expireOnce.Do(func() {
+		go g.expireLoop()
+	})
+
+	g.consumersLock.Lock()
+	defer g.consumersLock.Unlock()
+
+	key := receiverKey{queue: queue.Name, consumer: id}
+	c, exists := g.consumers[key]
+	if !exists {
+		c = newConsumer(g.synapse.GetBufferedReceiver(queue, id, g.bufferSize))
+		g.consumers[key] = c
+	}
+
+	c.lock.Lock()
+	c.active++
+	c.lock.Unlock()
+	return c
+}
+
+func (g *Gateway) releaseConsumer(c *consumer) {
+	c.lock.Lock()
+	c.active--
+	c.lastUsed = time.Now()
+	c.lock.Unlock()
+}
+
+// expireLoop hands out packets of expired leases again and closes receivers of idle consumers,
+// their unacked packets are read again from consumer pointer by the next receiver
+func (g *Gateway) expireLoop() {
+	interval := g.leaseTimeout
+	if g.idleTimeout < interval {
+		interval = g.idleTimeout
+	}
+	interval /= 4
+	if interval > maxExpireInterval {
+		interval = maxExpireInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case now := <-ticker.C:
+			g.expire(now)
+		}
+	}
+}
+
+func (g *Gateway) expire(now time.Time) {
+	g.consumersLock.Lock()
+	defer g.consumersLock.Unlock()
+
+	for key, c := range g.consumers {
+		if c.isIdle(now, g.idleTimeout) {
+			c.receiver.Close()
+			delete(g.consumers, key)
+			g.logger.Info().
+				Str("queue", string(key.queue)).
+				Str("consumer", string(key.consumer)).
+				Msg("closed idle consumer")
+			continue
+		}
+		if n := c.expire(now); n > 0 {
+			g.logger.Warn().
+				Str("queue", string(key.queue)).
+				Str("consumer", string(key.consumer)).
+				Int("packets", n).
+				Msg("packets are not acked in time, delivering them again")
+		}
+	}
+}
@@ -0,0 +1,419 @@
+// Package gateway exposes nerve queues over http:
+//
+//	POST /v1/queues/{queue}/produce                         - single packet
+//	POST /v1/queues/{queue}/produce-batch                   - batch of packets
+//	GET  /v1/queues/{queue}/consume?consumer=&max=&wait=    - long-poll for packets
+//	POST /v1/queues/{queue}/ack?consumer=                   - ack packets by indexes
+//	GET  /health                                            - synapse health report
+//
+// Bodies are gremlin-encoded messages from protobufs/nerve/gateway.proto when
+// content type is `application/x-protobuf` and their canonical proto3 json form otherwise,
+// single produce also accepts raw payload with `application/octet-stream`.
+// Consumed packets not acked within lease timeout (30s by default) are delivered again,
+// receivers of consumers idle for idle timeout (5m by default) are closed.
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"octopus/shared/gremlin"
+	"octopus/shared/nerve"
+	nervepb "octopus/target/generated-sources/protobuf/nerve"
+)
+
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+	ContentTypeRaw      = "application/octet-stream"
+
+	defaultConsumeMax  = 100
+	defaultConsumeWait = 10 * time.Second
+	maxConsumeWait     = 60 * time.Second
+	defaultMaxBodySize = 64 << 20
+)
+
+type receiverKey struct {
+	queue    nerve.QueueName
+	consumer nerve.ConsumerId
+}
+
+type Gateway struct {
+	synapse       *nerve.Synapse
+	queues        map[nerve.QueueName]nerve.QueueConfig
+	consumers     map[receiverKey]*consumer
+	consumersLock sync.Mutex
+	bufferSize    int
+	maxBodySize   int64
+	leaseTimeout  time.Duration
+	idleTimeout   time.Duration
+	expireOnce    sync.Once
+	closeOnce     sync.Once
+	done          chan struct{}
+	logger        zerolog.Logger
+}
+
+// NewGateway creates gateway for given queues, empty list allows any valid queue name
+func NewGateway(s *nerve.Synapse, queues ...nerve.QueueConfig) *Gateway {
+	g := &Gateway{
+		synapse:      s,
+		queues:       make(map[nerve.QueueName]nerve.QueueConfig),
+		consumers:    make(map[receiverKey]*consumer),
+		bufferSize:   1000,
+		maxBodySize:  defaultMaxBodySize,
+		leaseTimeout: defaultLeaseTimeout,
+		idleTimeout:  defaultIdleTimeout,
+		done:         make(chan struct{}),
+		logger:       log.With().Str("mod", "nerve-gateway").Logger(),
+	}
+	for _, queue := range queues {
+		g.queues[queue.Name] = queue
+	}
+
+	return g
+}
+
+// WithMaxBodySize limits size of request bodies, bigger ones are rejected with 413
+func (g *Gateway) WithMaxBodySize(size int64) *Gateway {
+	if size > 0 {
+		g.maxBodySize = size
+	}
+	return g
+}
+
+// WithLeaseTimeout sets how long consumed packets wait for ack before they are delivered again,
+// it must be set before serving requests
+func (g *Gateway) WithLeaseTimeout(timeout time.Duration) *Gateway {
+	if timeout > 0 {
+		g.leaseTimeout = timeout
+	}
+	return g
+}
+
+// WithIdleTimeout sets how long receiver of consumer without requests is kept open,
+// it must be set before serving requests
+func (g *Gateway) WithIdleTimeout(timeout time.Duration) *Gateway {
+	if timeout > 0 {
+		g.idleTimeout = timeout
+	}
+	return g
+}
+
+func (g *Gateway) Close() {
+	g.closeOnce.Do(func() {
+		close(g.done)
+	})
+
+	g.consumersLock.Lock()
+	defer g.consumersLock.Unlock()
+	for key, c := range g.consumers {
+		c.receiver.Close()
+		delete(g.consumers, key)
+	}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/health" {
+		g.synapse.HealthHandler().ServeHTTP(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "v1" || parts[1] != "queues" {
+		http.NotFound(w, r)
+		return
+	}
+
+	queue, ok := g.getQueue(nerve.QueueName(parts[2]))
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown queue %s", parts[2]), http.StatusNotFound)
+		return
+	}
+
+	var err error
+	var status = http.StatusBadRequest
+	switch parts[3] {
+	case "produce":
+		err = g.checkMethod(r, http.MethodPost)
+		if err == nil {
+			err = g.produce(w, r, queue)
+		}
+	case "produce-batch":
+		err = g.checkMethod(r, http.MethodPost)
+		if err == nil {
+			err = g.produceBatch(w, r, queue)
+		}
+	case "consume":
+		err = g.checkMethod(r, http.MethodGet)
+		if err == nil {
+			err = g.consume(w, r, queue)
+		}
+	case "ack":
+		err = g.checkMethod(r, http.MethodPost)
+		if err == nil {
+			err = g.ack(w, r, queue)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		g.logger.Warn().Err(err).
+			Str("queue", string(queue.Name)).
+			Str("path", r.URL.Path).
+			Msg("gateway request failed")
+		switch err {
+		case errMethodNotAllowed:
+			status = http.StatusMethodNotAllowed
+		case errBodyTooLarge:
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+	}
+}
+
+var errMethodNotAllowed = fmt.Errorf("method not allowed")
+var errBodyTooLarge = fmt.Errorf("request body too large")
+
+func (g *Gateway) checkMethod(r *http.Request, method string) error {
+	if r.Method != method {
+		return errMethodNotAllowed
+	}
+	return nil
+}
+
+func (g *Gateway) getQueue(name nerve.QueueName) (nerve.QueueConfig, bool) {
+	// queue names become table names of backends
+	if !name.IsValid() {
+		return nerve.QueueConfig{}, false
+	}
+	if len(g.queues) == 0 {
+		return nerve.QueueConfig{Name: name}, true
+	}
+	queue, ok := g.queues[name]
+	return queue, ok
+}
+
+func getContentType(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	if idx := strings.Index(contentType, ";"); idx >= 0 {
+		contentType = contentType[:idx]
+	}
+	return strings.TrimSpace(contentType)
+}
+
+func getConsumer(r *http.Request) (nerve.ConsumerId, error) {
+	consumer := r.URL.Query().Get("consumer")
+	if consumer == "" {
+		return "", fmt.Errorf("consumer is required")
+	}
+	return nerve.ConsumerId(consumer), nil
+}
+
+func (g *Gateway) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if r.ContentLength > g.maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.maxBodySize))
+	if err != nil && int64(len(body)) >= g.maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	return body, err
+}
+
+func writeResponse(w http.ResponseWriter, r *http.Request, msg interface{ Marshal() []byte }) error {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		accept = getContentType(r)
+	}
+
+	if strings.Contains(accept, ContentTypeProtobuf) {
+		w.Header().Set("Content-Type", ContentTypeProtobuf)
+		_, err := w.Write(msg.Marshal())
+		return err
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	return json.NewEncoder(w).Encode(msg)
+}
+
+func (g *Gateway) produce(w http.ResponseWriter, r *http.Request, queue nerve.QueueConfig) error {
+	body, err := g.readBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch getContentType(r) {
+	case ContentTypeProtobuf:
+		packet := nervepb.NewNerveGatewayPacketReader()
+		if err = packet.SafeUnmarshal(body, gremlin.DefaultDecodeOptions); err != nil {
+			return err
+		}
+		data = packet.GetData()
+	case ContentTypeJSON:
+		packet := &nervepb.NerveGatewayPacket{}
+		if err = json.Unmarshal(body, packet); err != nil {
+			return err
+		}
+		data = packet.Data
+	default:
+		data = body
+	}
+
+	idx, err := g.synapse.Send(queue, &nerve.Packet{Data: data})
+	if err != nil {
+		return err
+	}
+
+	return writeResponse(w, r, &nervepb.NerveGatewayIndexes{Indexes: []int64{int64(idx)}})
+}
+
+func (g *Gateway) produceBatch(w http.ResponseWriter, r *http.Request, queue nerve.QueueConfig) error {
+	body, err := g.readBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	var packets []*nerve.Packet
+	switch getContentType(r) {
+	case ContentTypeProtobuf:
+		batch := nervepb.NewNerveGatewayPacketsReader()
+		if err = batch.SafeUnmarshal(body, gremlin.DefaultDecodeOptions); err != nil {
+			return err
+		}
+		for _, packet := range batch.GetPackets() {
+			packets = append(packets, &nerve.Packet{Data: packet.GetData()})
+		}
+	default:
+		batch := &nervepb.NerveGatewayPackets{}
+		if err = json.Unmarshal(body, batch); err != nil {
+			return err
+		}
+		for _, packet := range batch.Packets {
+			packets = append(packets, &nerve.Packet{Data: packet.Data})
+		}
+	}
+
+	res := &nervepb.NerveGatewayIndexes{Indexes: make([]int64, len(packets))}
+	if len(packets) > 0 {
+		if err = g.synapse.SendPack(queue, packets); err != nil {
+			return err
+		}
+		for i, packet := range packets {
+			res.Indexes[i] = int64(packet.DbId)
+		}
+	}
+
+	return writeResponse(w, r, res)
+}
+
+func (g *Gateway) consume(w http.ResponseWriter, r *http.Request, queue nerve.QueueConfig) error {
+	consumer, err := getConsumer(r)
+	if err != nil {
+		return err
+	}
+
+	max := defaultConsumeMax
+	if v := r.URL.Query().Get("max"); v != "" {
+		if max, err = strconv.Atoi(v); err != nil || max <= 0 {
+			return fmt.Errorf("invalid max: %s", v)
+		}
+	}
+
+	wait := defaultConsumeWait
+	if v := r.URL.Query().Get("wait"); v != "" {
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+			return fmt.Errorf("invalid wait: %s", v)
+		}
+		if wait > maxConsumeWait {
+			wait = maxConsumeWait
+		}
+	}
+
+	c := g.acquireConsumer(queue, consumer)
+	defer g.releaseConsumer(c)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	// packets of expired leases go first, they are older than ones left in receiver
+	packets := c.takeExpired(max)
+	for len(packets) == 0 {
+		select {
+		case packet := <-c.receiver.DataChan:
+			packets = append(packets, packet)
+		case <-c.redeliver:
+			packets = c.takeExpired(max)
+		case <-timer.C:
+			return writeResponse(w, r, &nervepb.NerveGatewayPackets{})
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+
+drain:
+	for len(packets) < max {
+		select {
+		case packet := <-c.receiver.DataChan:
+			packets = append(packets, packet)
+		default:
+			break drain
+		}
+	}
+
+	// packets are leased before response is written, so they aren't lost if client is gone
+	c.lease(packets, g.leaseTimeout)
+	res := &nervepb.NerveGatewayPackets{Packets: make([]*nervepb.NerveGatewayPacket, len(packets))}
+	for i, packet := range packets {
+		res.Packets[i] = &nervepb.NerveGatewayPacket{Index: int64(packet.DbId), Data: packet.Data}
+	}
+	return writeResponse(w, r, res)
+}
+
+func (g *Gateway) ack(w http.ResponseWriter, r *http.Request, queue nerve.QueueConfig) error {
+	consumer, err := getConsumer(r)
+	if err != nil {
+		return err
+	}
+
+	body, err := g.readBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	var indexes []int64
+	switch getContentType(r) {
+	case ContentTypeProtobuf:
+		req := nervepb.NewNerveGatewayIndexesReader()
+		if err = req.SafeUnmarshal(body, gremlin.DefaultDecodeOptions); err != nil {
+			return err
+		}
+		indexes = req.GetIndexes()
+	default:
+		req := &nervepb.NerveGatewayIndexes{}
+		if err = json.Unmarshal(body, req); err != nil {
+			return err
+		}
+		indexes = req.Indexes
+	}
+
+	c := g.acquireConsumer(queue, consumer)
+	defer g.releaseConsumer(c)
+	for _, idx := range indexes {
+		c.ack(nerve.QueueElementIndex(idx))
+		c.receiver.AckId(nerve.QueueElementIndex(idx))
+	}
+
+	return writeResponse(w, r, &nervepb.NerveGatewayIndexes{Indexes: indexes})
+}
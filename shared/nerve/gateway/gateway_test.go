@@ -0,0 +1,279 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"octopus/shared/gremlin"
+	"octopus/shared/nerve"
+	nervepb "octopus/target/generated-sources/protobuf/nerve"
+)
+
+func newTestGateway(t *testing.T, queues ...nerve.QueueConfig) (*Gateway, *httptest.Server) {
+	g := NewGateway(nerve.NewSynapse(nerve.NewMemoryBackend(2)), queues...)
+	server := httptest.NewServer(g)
+	t.Cleanup(func() {
+		server.Close()
+		g.Close()
+	})
+	return g, server
+}
+
+func doRequest(t *testing.T, method, url, contentType string, body []byte) (int, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	buf := bytes.NewBuffer(nil)
+	if _, err = buf.ReadFrom(res.Body); err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, buf.Bytes()
+}
+
+func toJSON(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func getIndexes(t *testing.T, method, url, contentType string, reqBody []byte) []int64 {
+	status, body := doRequest(t, method, url, contentType, reqBody)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", status, body)
+	}
+	res := &nervepb.NerveGatewayIndexes{}
+	if err := json.Unmarshal(body, res); err != nil {
+		t.Fatalf("error decoding %s: %v", body, err)
+	}
+	return res.Indexes
+}
+
+func TestGatewayProduceConsumeAck(t *testing.T) {
+	_, server := newTestGateway(t)
+	queueUrl := server.URL + "/v1/queues/NQGatewayTest"
+
+	indexes := getIndexes(t, http.MethodPost, queueUrl+"/produce", ContentTypeRaw, []byte("raw"))
+	if len(indexes) != 1 || indexes[0] != 1 {
+		t.Fatalf("unexpected raw produce indexes %v", indexes)
+	}
+
+	indexes = getIndexes(t, http.MethodPost, queueUrl+"/produce", ContentTypeJSON,
+		toJSON(t, &nervepb.NerveGatewayPacket{Data: []byte("json")}))
+	if len(indexes) != 1 || indexes[0] != 2 {
+		t.Fatalf("unexpected json produce indexes %v", indexes)
+	}
+
+	packet := &nervepb.NerveGatewayPacket{Data: []byte("protobuf")}
+	status, body := doRequest(t, http.MethodPost, queueUrl+"/produce", ContentTypeProtobuf, packet.Marshal())
+	if status != http.StatusOK {
+		t.Fatalf("unexpected protobuf produce status %d: %s", status, body)
+	}
+	res := nervepb.NewNerveGatewayIndexesReader()
+	if err := res.SafeUnmarshal(body, gremlin.DefaultDecodeOptions); err != nil {
+		t.Fatal(err)
+	}
+	if got := res.GetIndexes(); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("unexpected protobuf produce indexes %v", got)
+	}
+
+	indexes = getIndexes(t, http.MethodPost, queueUrl+"/produce-batch", ContentTypeJSON,
+		toJSON(t, &nervepb.NerveGatewayPackets{Packets: []*nervepb.NerveGatewayPacket{
+			{Data: []byte("batch-1")},
+			{Data: []byte("batch-2")},
+		}}))
+	if len(indexes) != 2 || indexes[0] != 4 || indexes[1] != 5 {
+		t.Fatalf("unexpected batch produce indexes %v", indexes)
+	}
+
+	expected := []string{"raw", "json", "protobuf", "batch-1", "batch-2"}
+	var consumed []*nervepb.NerveGatewayPacket
+	for len(consumed) < len(expected) {
+		status, body = doRequest(t, http.MethodGet, queueUrl+"/consume?consumer=NCGatewayTest&wait=5s", "", nil)
+		if status != http.StatusOK {
+			t.Fatalf("unexpected consume status %d: %s", status, body)
+		}
+		batch := &nervepb.NerveGatewayPackets{}
+		if err := json.Unmarshal(body, batch); err != nil {
+			t.Fatal(err)
+		}
+		if len(batch.Packets) == 0 {
+			t.Fatalf("consumed %d packets of %d", len(consumed), len(expected))
+		}
+		consumed = append(consumed, batch.Packets...)
+	}
+
+	acked := make([]int64, 0, len(consumed))
+	for i, packet := range consumed {
+		if packet.Index != int64(i+1) || string(packet.Data) != expected[i] {
+			t.Fatalf("unexpected packet %d: %d %q", i, packet.Index, packet.Data)
+		}
+		acked = append(acked, packet.Index)
+	}
+
+	indexes = getIndexes(t, http.MethodPost, queueUrl+"/ack?consumer=NCGatewayTest", ContentTypeJSON,
+		toJSON(t, &nervepb.NerveGatewayIndexes{Indexes: acked}))
+	if len(indexes) != len(acked) {
+		t.Fatalf("unexpected ack indexes %v", indexes)
+	}
+
+	status, body = doRequest(t, http.MethodGet, queueUrl+"/consume?consumer=NCGatewayTest&wait=10ms", "", nil)
+	batch := &nervepb.NerveGatewayPackets{}
+	if err := json.Unmarshal(body, batch); status != http.StatusOK || err != nil || len(batch.Packets) != 0 {
+		t.Fatalf("unexpected consume after ack %d: %s", status, body)
+	}
+}
+
+func TestGatewayQueues(t *testing.T) {
+	_, server := newTestGateway(t, nerve.QueueConfig{Name: "NQGatewayAllowed"})
+
+	status, body := doRequest(t, http.MethodPost, server.URL+"/v1/queues/NQGatewayAllowed/produce", ContentTypeRaw, []byte("a"))
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status %d for allowed queue: %s", status, body)
+	}
+
+	status, _ = doRequest(t, http.MethodPost, server.URL+"/v1/queues/NQGatewayOther/produce", ContentTypeRaw, []byte("a"))
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status %d for not allowed queue", status)
+	}
+
+	status, _ = doRequest(t, http.MethodGet, server.URL+"/v1/queues/NQGatewayAllowed/produce", "", nil)
+	if status != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %d for wrong method", status)
+	}
+}
+
+func TestGatewayInvalidQueueName(t *testing.T) {
+	_, server := newTestGateway(t)
+
+	for _, name := range []string{"NQ%20Test", "NQTest%60%3B", "NQ-Test"} {
+		status, _ := doRequest(t, http.MethodPost, server.URL+"/v1/queues/"+name+"/produce", ContentTypeRaw, []byte("a"))
+		if status != http.StatusNotFound {
+			t.Fatalf("unexpected status %d for queue %s", status, name)
+		}
+	}
+}
+
+func TestGatewayBodyTooLarge(t *testing.T) {
+	g, server := newTestGateway(t)
+	g.WithMaxBodySize(16)
+	queueUrl := server.URL + "/v1/queues/NQGatewayTest"
+
+	status, body := doRequest(t, http.MethodPost, queueUrl+"/produce", ContentTypeRaw, bytes.Repeat([]byte("a"), 16))
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status %d for body of max size: %s", status, body)
+	}
+
+	status, _ = doRequest(t, http.MethodPost, queueUrl+"/produce", ContentTypeRaw, bytes.Repeat([]byte("a"), 17))
+	if status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status %d for oversized body", status)
+	}
+
+	// chunked body has no content length, so it's cut by the reader
+	req, err := http.NewRequest(http.MethodPost, queueUrl+"/produce", struct{ *bytes.Reader }{bytes.NewReader(bytes.Repeat([]byte("a"), 64))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status %d for oversized chunked body", res.StatusCode)
+	}
+}
+
+func consumePackets(t *testing.T, url string) []*nervepb.NerveGatewayPacket {
+	status, body := doRequest(t, http.MethodGet, url, "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected consume status %d: %s", status, body)
+	}
+	batch := &nervepb.NerveGatewayPackets{}
+	if err := json.Unmarshal(body, batch); err != nil {
+		t.Fatal(err)
+	}
+	return batch.Packets
+}
+
+func TestGatewayRedeliversUnacked(t *testing.T) {
+	g, server := newTestGateway(t)
+	g.WithLeaseTimeout(200 * time.Millisecond)
+	queueUrl := server.URL + "/v1/queues/NQGatewayLeaseTest"
+
+	getIndexes(t, http.MethodPost, queueUrl+"/produce-batch", ContentTypeJSON,
+		toJSON(t, &nervepb.NerveGatewayPackets{Packets: []*nervepb.NerveGatewayPacket{
+			{Data: []byte("first")},
+			{Data: []byte("second")},
+		}}))
+
+	var consumed []*nervepb.NerveGatewayPacket
+	for len(consumed) < 2 {
+		packets := consumePackets(t, queueUrl+"/consume?consumer=NCGatewayTest&wait=5s")
+		if len(packets) == 0 {
+			t.Fatalf("consumed %d packets of 2", len(consumed))
+		}
+		consumed = append(consumed, packets...)
+	}
+	getIndexes(t, http.MethodPost, queueUrl+"/ack?consumer=NCGatewayTest", ContentTypeJSON,
+		toJSON(t, &nervepb.NerveGatewayIndexes{Indexes: []int64{1}}))
+
+	// the second packet isn't acked, so it's delivered again once its lease expires
+	packets := consumePackets(t, queueUrl+"/consume?consumer=NCGatewayTest&wait=5s")
+	if len(packets) != 1 || packets[0].Index != 2 || string(packets[0].Data) != "second" {
+		t.Fatalf("unexpected redelivered packets %v", packets)
+	}
+	getIndexes(t, http.MethodPost, queueUrl+"/ack?consumer=NCGatewayTest", ContentTypeJSON,
+		toJSON(t, &nervepb.NerveGatewayIndexes{Indexes: []int64{2}}))
+
+	if packets = consumePackets(t, queueUrl+"/consume?consumer=NCGatewayTest&wait=500ms"); len(packets) != 0 {
+		t.Fatalf("acked packets are delivered again %v", packets)
+	}
+}
+
+func TestGatewayClosesIdleConsumers(t *testing.T) {
+	g, server := newTestGateway(t)
+	g.WithIdleTimeout(100 * time.Millisecond)
+	queueUrl := server.URL + "/v1/queues/NQGatewayIdleTest"
+
+	getIndexes(t, http.MethodPost, queueUrl+"/produce", ContentTypeRaw, []byte("a"))
+	packets := consumePackets(t, queueUrl+"/consume?consumer=NCGatewayTest&wait=5s")
+	if len(packets) != 1 {
+		t.Fatalf("unexpected packets %v", packets)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		g.consumersLock.Lock()
+		n := len(g.consumers)
+		g.consumersLock.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("idle consumer is not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// not acked packet is read again by the new receiver
+	packets = consumePackets(t, queueUrl+"/consume?consumer=NCGatewayTest&wait=5s")
+	if len(packets) != 1 || packets[0].Index != 1 {
+		t.Fatalf("unexpected packets after reopening consumer %v", packets)
+	}
+}
@@ -17,19 +17,89 @@ Created by ab, 21.10.2022
 
 package nerve
 
-const (
-	NCTest ConsumerId = "NerveConsumerId_Test"
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"octopus/target/generated-sources/protobuf/nerve"
 )
 
-var NQLocalTest = QueueConfig{
-	Name: "NQLocalTest",
-	Hosts: map[string]BackendConfig{
-		"127.0.0.1": {
-			DbName:              "nerve",
-			Port:                3306,
-			TableParallelism:    4,
-			PointersParallelism: 1,
-			MaxRPSPerThread:     50,
-		},
-	},
+// QueuesDefinition declares queues and consumers, definitions live in appsconfigs/nerve
+// and json-gogen generates QueueConfig variable and ConsumerId constant for every entry
+type QueuesDefinition struct {
+	Queues    map[string]QueueConfig        `json:"queues" gogen:"vars"`
+	Consumers map[string]ConsumerDefinition `json:"consumers" gogen:"consts=Id"`
+}
+
+type ConsumerDefinition struct {
+	Id ConsumerId `json:"id"`
+	// Queues are keys of QueuesDefinition.Queues consumer reads from
+	Queues []string `json:"queues"`
+}
+
+// Validate checks that queues have backends and registered schemas,
+// and every consumer references existing queues
+func (d QueuesDefinition) Validate() error {
+	names := make(map[QueueName]string)
+	for _, key := range sortedKeys(d.Queues) {
+		queue := d.Queues[key]
+		if queue.Name == "" || len(queue.Hosts) == 0 {
+			return fmt.Errorf("queue %s should have name and hosts", key)
+		}
+		if other, exists := names[queue.Name]; exists {
+			return fmt.Errorf("queues %s and %s have the same name %s", other, key, queue.Name)
+		}
+		names[queue.Name] = key
+
+		if queue.SchemaName != "" {
+			if _, exists := GetQueueSchema(queue.SchemaName); !exists {
+				return fmt.Errorf("queue %s has unknown schema %s", key, queue.SchemaName)
+			}
+		}
+	}
+
+	ids := make(map[ConsumerId]string)
+	for _, key := range sortedKeys(d.Consumers) {
+		consumer := d.Consumers[key]
+		if consumer.Id == "" || len(consumer.Queues) == 0 {
+			return fmt.Errorf("consumer %s should have id and queues", key)
+		}
+		if other, exists := ids[consumer.Id]; exists {
+			return fmt.Errorf("consumers %s and %s have the same id %s", other, key, consumer.Id)
+		}
+		ids[consumer.Id] = key
+
+		for _, queue := range consumer.Queues {
+			if _, exists := d.Queues[queue]; !exists {
+				return fmt.Errorf("consumer %s references unknown queue %s", key, queue)
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TestPacketSchema is schema of queues with NST_TEST envelopes of NerveTestPacket,
+// registered as `TestPacket`
+var TestPacketSchema = NewSourcedSchema(map[nerve.NerveSourceType]*QueueSchema{
+	nerve.NerveSourceType_NST_TEST: NewMessageSchema[*nerve.NerveTestPacket]("NerveTestPacket", nerve.NewNerveTestPacketReader),
+})
+
+// NewTestPacketData returns payload matching TestPacketSchema
+func NewTestPacketData(payload []byte) []byte {
+	packet := &nerve.NerveTestPacket{CreatedAt: time.Now().UnixNano(), Payload: payload}
+	envelope := &nerve.NerveSourcedPacket{
+		Source: nerve.NerveSourceType_NST_TEST,
+		Packet: packet.Marshal(),
+	}
+	return envelope.Marshal()
 }
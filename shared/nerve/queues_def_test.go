@@ -0,0 +1,85 @@
+package nerve
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// NCTest is consumer of test queues, it's declared in queues.json as `NCTest`
+const NCTest ConsumerId = "NerveConsumerId_Test"
+
+// loadTestQueues reads the same definitions json-gogen generates declarations from,
+// generated package imports nerve, so tests of nerve can't import it
+func loadTestQueues(tb testing.TB) QueuesDefinition {
+	tb.Helper()
+	data, err := os.ReadFile("../../appsconfigs/nerve/queues.json")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var definition QueuesDefinition
+	if err = json.Unmarshal(data, &definition); err != nil {
+		tb.Fatal(err)
+	}
+	return definition
+}
+
+func getTestQueue(tb testing.TB, key string) QueueConfig {
+	tb.Helper()
+	queue, exists := loadTestQueues(tb).Queues[key]
+	if !exists {
+		tb.Fatalf("queue %s is not defined in queues.json", key)
+	}
+	return queue
+}
+
+func TestQueuesJsonValidate(t *testing.T) {
+	definition := loadTestQueues(t)
+	if err := definition.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if id := definition.Consumers["NCTest"].Id; id != NCTest {
+		t.Fatalf("NCTest is %s in queues.json", id)
+	}
+}
+
+func TestQueuesDefinitionValidate(t *testing.T) {
+	local := getTestQueue(t, "NQLocalTest")
+	sourced := getTestQueue(t, "NQLocalSourcedTest")
+	definition := func() QueuesDefinition {
+		return QueuesDefinition{
+			Queues: map[string]QueueConfig{"NQLocalTest": local, "NQLocalSourcedTest": sourced},
+			Consumers: map[string]ConsumerDefinition{
+				"NCTest": {Id: NCTest, Queues: []string{"NQLocalTest"}},
+			},
+		}
+	}
+
+	if err := definition().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if sourced.GetSchema() != TestPacketSchema {
+		t.Fatalf("NQLocalSourcedTest schema is not TestPacket")
+	}
+
+	unknownQueue := definition()
+	unknownQueue.Consumers["NCOther"] = ConsumerDefinition{Id: "NerveConsumerId_Other", Queues: []string{"NQMissing"}}
+	duplicateId := definition()
+	duplicateId.Consumers["NCCopy"] = ConsumerDefinition{Id: NCTest, Queues: []string{"NQLocalTest"}}
+	noHosts := definition()
+	noHosts.Queues["NQNoHosts"] = QueueConfig{Name: "NQNoHosts"}
+	unknownSchema := definition()
+	unknownSchema.Queues["NQUnknownSchema"] = QueueConfig{Name: "NQUnknownSchema", Hosts: local.Hosts, SchemaName: "Missing"}
+
+	for expected, d := range map[string]QueuesDefinition{
+		"unknown queue NQMissing":    unknownQueue,
+		"have the same id":           duplicateId,
+		"should have name and hosts": noHosts,
+		"unknown schema Missing":     unknownSchema,
+	} {
+		if err := d.Validate(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q error, got %v", expected, err)
+		}
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"octopus/shared/gremlin"
 	"octopus/target/generated-sources/protobuf/nerve"
 	"sync"
+	"time"
 )
 
 func NewSynapse(backend SynapseBackend) *Synapse {
@@ -42,8 +43,12 @@ func NewSynapse(backend SynapseBackend) *Synapse {
 		queueWriterChannels:     make(map[QueueName][]chan *Packet),
 		queueWriterChannelsLock: sync.RWMutex{},
 		Backend:                 backend,
+		readerLimit:             defaultReaderLimit,
+		encryptors:              make(map[QueueName]*queueEncryptor),
+		encryptorsLock:          sync.RWMutex{},
 		trace:                   false,
 		logger:                  &logger,
+		health:                  newHealthTracker(),
 	}
 
 	backend.SetTrace(s.trace)
@@ -51,7 +56,13 @@ func NewSynapse(backend SynapseBackend) *Synapse {
 	go func() {
 		for {
 			info := <-s.infoChan
-			if s.trace {
+			if info.ErrorSavingPointerInAckManSyncSection != nil {
+				s.health.pointerFailed(info.QueueName, "", info.ErrorSavingPointerInAckManSyncSection)
+				s.logger.Error().
+					Err(info.ErrorSavingPointerInAckManSyncSection).
+					Str("queue", string(info.QueueName)).
+					Msg("error saving queue pointer in ack manager")
+			} else if s.trace {
 				s.logger.Info().Interface("info", info).Send()
 			}
 		}
@@ -67,10 +78,30 @@ func (s *Synapse) SetTrace(trace bool) {
 	}
 }
 
+// SetReaderLimit sets max number of packets receivers read from backend at once
+func (s *Synapse) SetReaderLimit(limit QueueElementIndex) {
+	if limit > 0 {
+		s.readerLimit = limit
+	}
+}
+
+// SetGapTimeout sets how long receivers wait for missing packet right after their pointer
+// before acking it, so it's lost for the consumer. Zero, the default, disables skipping:
+// receivers keep retrying the gap and report it in Health.
+func (s *Synapse) SetGapTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	s.gapTimeout = timeout
+}
+
 // Send sends message to `queueName` with body of `data`
 // if ordering is important in some respect, pass `orderKey` (e.g. user-id)
 // otherwise - pass `nil` as orderKey
 func (s *Synapse) Send(queue QueueConfig, packet *Packet) (QueueElementIndex, error) {
+	if err := validatePackets(queue, []*Packet{packet}); err != nil {
+		return 0, err
+	}
 	// packet.dataHash = sha512.Sum512(packet.Data)
 	packet.confirmationChannel = make(chan *Packet, 2)
 	s.getQueueRunnerChannel(queue.Name, packet) <- packet
@@ -91,6 +122,9 @@ func (s *Synapse) SendProtoPack(queue QueueConfig, msg []gremlin.ProtoWriter) er
 
 // SendPack sends slice of packets to nerve to improve throughput
 func (s *Synapse) SendPack(queue QueueConfig, packets []*Packet) error {
+	if err := validatePackets(queue, packets); err != nil {
+		return err
+	}
 	var confirmChan = make(chan *Packet, len(packets))
 
 	for _, packet := range packets {
@@ -119,6 +153,9 @@ func (s *Synapse) SendSourcedPack(queue QueueConfig, pack []*nerve.NerveSourcedP
 func (s *Synapse) AsyncSendSourcedPacket(queue QueueConfig, msg *nerve.NerveSourcedPacket) (chan *Packet, error) {
 	var confirmChan = make(chan *Packet)
 	packet := &Packet{Data: msg.Marshal(), confirmationChannel: confirmChan}
+	if err := validatePackets(queue, []*Packet{packet}); err != nil {
+		return nil, err
+	}
 	s.getQueueRunnerChannel(queue.Name, packet) <- packet
 
 	return confirmChan, nil
@@ -129,7 +166,12 @@ func (s *Synapse) AsyncSendSourcedPack(queue QueueConfig, msg []*nerve.NerveSour
 	var packets = make([]*Packet, len(msg))
 	for i, data := range msg {
 		packets[i] = &Packet{Data: data.Marshal(), confirmationChannel: confirmChan}
-		s.getQueueRunnerChannel(queue.Name, packets[i]) <- packets[i]
+	}
+	if err := validatePackets(queue, packets); err != nil {
+		return nil, err
+	}
+	for _, packet := range packets {
+		s.getQueueRunnerChannel(queue.Name, packet) <- packet
 	}
 
 	var res = make(chan struct{})
@@ -0,0 +1,315 @@
+package nerve
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+	"time"
+
+	"octopus/shared/gremlin"
+	"octopus/target/generated-sources/protobuf/nerve"
+)
+
+// archive file layout is described in protobufs/nerve/archive.proto
+const (
+	archiveFrameHeader byte = 1
+	archiveFrameRecord byte = 2
+	archiveFrameFooter byte = 3
+
+	archiveImportBatchSize = 1000
+	// frames hold one packet, so they are never bigger than mysql longblob rows we actually write
+	archiveMaxFrameSize = 1 << 30
+)
+
+var archiveCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var ErrArchiveCorrupted = errors.New("nerve archive is corrupted")
+var ErrArchiveEncrypted = errors.New("nerve archive packet is encrypted")
+
+// ArchiveTimestampExtractor returns packet timestamp (unix nanoseconds) if payload carries one, 0 otherwise
+type ArchiveTimestampExtractor func(p *Packet) int64
+
+// SourcedPacketTimestamp extracts sending time of packets sent by SendTraced, 0 for other payloads
+func SourcedPacketTimestamp(p *Packet) int64 {
+	sourced := nerve.NewNerveSourcedPacketReader()
+	if err := sourced.SafeUnmarshal(p.Data, gremlin.DefaultDecodeOptions); err != nil {
+		return 0
+	}
+	_, sentAt, ok := spanFromProto(sourced.GetTrace().ToStruct())
+	if !ok || sentAt.IsZero() {
+		return 0
+	}
+	return sentAt.UnixNano()
+}
+
+type archiveWriter struct {
+	gz       *gzip.Writer
+	checksum hash.Hash
+	records  uint64
+	lenBuf   [binary.MaxVarintLen64]byte
+}
+
+func newArchiveWriter(w io.Writer) *archiveWriter {
+	return &archiveWriter{
+		gz:       gzip.NewWriter(w),
+		checksum: sha256.New(),
+	}
+}
+
+func (a *archiveWriter) writeFrame(kind byte, payload []byte) error {
+	if _, err := a.gz.Write([]byte{kind}); err != nil {
+		return err
+	}
+	n := binary.PutUvarint(a.lenBuf[:], uint64(len(payload)))
+	if _, err := a.gz.Write(a.lenBuf[:n]); err != nil {
+		return err
+	}
+	if _, err := a.gz.Write(payload); err != nil {
+		return err
+	}
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.Checksum(payload, archiveCrcTable))
+	_, err := a.gz.Write(crc[:])
+	return err
+}
+
+func (a *archiveWriter) writeRecord(record *nerve.NerveArchiveRecord) error {
+	payload := record.Marshal()
+	a.checksum.Write(payload)
+	a.records++
+	return a.writeFrame(archiveFrameRecord, payload)
+}
+
+func (a *archiveWriter) close() error {
+	footer := &nerve.NerveArchiveFooter{
+		Records:  a.records,
+		Checksum: a.checksum.Sum(nil),
+	}
+	if err := a.writeFrame(archiveFrameFooter, footer.Marshal()); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+type archiveReader struct {
+	r        *bufio.Reader
+	checksum hash.Hash
+	records  uint64
+}
+
+func (a *archiveReader) readFrame() (byte, []byte, error) {
+	kind, err := a.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(a.r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrArchiveCorrupted, err)
+	}
+	if size > archiveMaxFrameSize {
+		return 0, nil, fmt.Errorf("%w: frame of %d bytes", ErrArchiveCorrupted, size)
+	}
+	// buffer grows with data actually read, so corrupted size of a short file doesn't allocate it all
+	buf := bytes.NewBuffer(nil)
+	if _, err = io.CopyN(buf, a.r, int64(size)+4); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, fmt.Errorf("%w: %v", ErrArchiveCorrupted, err)
+	}
+	payload := buf.Bytes()
+	crc := binary.LittleEndian.Uint32(payload[size:])
+	payload = payload[:size]
+	if crc32.Checksum(payload, archiveCrcTable) != crc {
+		return 0, nil, fmt.Errorf("%w: frame checksum mismatch", ErrArchiveCorrupted)
+	}
+
+	return kind, payload, nil
+}
+
+// ExportRange writes packets with indexes in (from, to] into w as gzipped archive,
+// returns number of exported packets. `to` is capped by queue writer pointer.
+//
+// Packets are exported as stored in backend, they are never decrypted. Export fails with
+// ErrArchiveEncrypted on encrypted packet unless keepEncrypted is set, then its ciphertext
+// is archived marked as encrypted, it can be decrypted only along with its index.
+func (s *Synapse) ExportRange(queue QueueConfig, from, to QueueElementIndex, w io.Writer, tsExtractor ArchiveTimestampExtractor, keepEncrypted bool) (uint64, error) {
+	writerPtr, err := s.Backend.GetPtr(queue.Name, "")
+	if err != nil {
+		return 0, err
+	}
+	to = minIndex(to, writerPtr)
+
+	archive := newArchiveWriter(w)
+	header := &nerve.NerveArchiveHeader{
+		QueueName: string(queue.Name),
+		FromIndex: int64(from),
+		ToIndex:   int64(to),
+		CreatedAt: time.Now().UnixNano(),
+	}
+	if err = archive.writeFrame(archiveFrameHeader, header.Marshal()); err != nil {
+		return 0, err
+	}
+
+	limit := s.getDefaultReaderLimit(queue.Name, "")
+	for ptr := from; ptr < to; {
+		maxId := minIndex(to, ptr+limit)
+		batch := make([]*Packet, 0, maxId-ptr)
+		for id := ptr + 1; id <= maxId; id++ {
+			batch = append(batch, &Packet{DbId: id})
+		}
+
+		batch, err = s.Backend.ReadBatch(queue.Name, batch)
+		if err != nil {
+			return archive.records, err
+		}
+		sort.Slice(batch, func(i, j int) bool {
+			return batch[i].DbId < batch[j].DbId
+		})
+
+		for _, packet := range batch {
+			record := &nerve.NerveArchiveRecord{
+				Index: int64(packet.DbId),
+				Data:  packet.Data,
+			}
+			if isPlainFramedPayload(packet.Data) {
+				record.Data = packet.Data[len(encryptionMarker)+1:]
+			} else if isEncryptedPayload(packet.Data) {
+				if !keepEncrypted {
+					return archive.records, fmt.Errorf("%w: packet %d of %s", ErrArchiveEncrypted, packet.DbId, queue.Name)
+				}
+				record.Encrypted = true
+			}
+			if tsExtractor != nil && !record.Encrypted {
+				record.Timestamp = tsExtractor(&Packet{DbId: packet.DbId, Data: record.Data})
+			}
+			if err = archive.writeRecord(record); err != nil {
+				return archive.records, err
+			}
+		}
+
+		s.logger.Info().
+			Str("queue", string(queue.Name)).
+			Int64("ptr", int64(maxId)).
+			Int64("to", int64(to)).
+			Uint64("records", archive.records).
+			Msg("exporting")
+		ptr = maxId
+	}
+
+	return archive.records, archive.close()
+}
+
+// ReadArchive reads archive verifying checksums and calls fn for every record,
+// footer is checked after the last record, so fn may see records of corrupted archive.
+func ReadArchive(r io.Reader, fn func(record *nerve.NerveArchiveRecordReader) error) (*nerve.NerveArchiveHeader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrArchiveCorrupted, err)
+	}
+	defer gz.Close()
+
+	archive := &archiveReader{r: bufio.NewReader(gz), checksum: sha256.New()}
+
+	kind, payload, err := archive.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if kind != archiveFrameHeader {
+		return nil, fmt.Errorf("%w: no header", ErrArchiveCorrupted)
+	}
+	headerReader := nerve.NewNerveArchiveHeaderReader()
+	if err = headerReader.SafeUnmarshal(payload, gremlin.DefaultDecodeOptions); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrArchiveCorrupted, err)
+	}
+	header := headerReader.ToStruct()
+
+	for {
+		kind, payload, err = archive.readFrame()
+		if err == io.EOF {
+			return header, fmt.Errorf("%w: no footer", ErrArchiveCorrupted)
+		}
+		if err != nil {
+			return header, err
+		}
+
+		switch kind {
+		case archiveFrameRecord:
+			archive.checksum.Write(payload)
+			archive.records++
+
+			record := nerve.NewNerveArchiveRecordReader()
+			if err = record.SafeUnmarshal(payload, gremlin.DefaultDecodeOptions); err != nil {
+				return header, fmt.Errorf("%w: %v", ErrArchiveCorrupted, err)
+			}
+			if err = fn(record); err != nil {
+				return header, err
+			}
+		case archiveFrameFooter:
+			footer := nerve.NewNerveArchiveFooterReader()
+			if err = footer.SafeUnmarshal(payload, gremlin.DefaultDecodeOptions); err != nil {
+				return header, fmt.Errorf("%w: %v", ErrArchiveCorrupted, err)
+			}
+			if footer.GetRecords() != archive.records {
+				return header, fmt.Errorf("%w: expected %d records, got %d",
+					ErrArchiveCorrupted, footer.GetRecords(), archive.records)
+			}
+			if !bytes.Equal(footer.GetChecksum(), archive.checksum.Sum(nil)) {
+				return header, fmt.Errorf("%w: checksum mismatch", ErrArchiveCorrupted)
+			}
+			return header, nil
+		default:
+			return header, fmt.Errorf("%w: unknown frame %d", ErrArchiveCorrupted, kind)
+		}
+	}
+}
+
+// VerifyArchive reads the whole archive checking frames and footer checksums
+func VerifyArchive(r io.Reader) (*nerve.NerveArchiveHeader, error) {
+	return ReadArchive(r, func(_ *nerve.NerveArchiveRecordReader) error {
+		return nil
+	})
+}
+
+// ImportArchive sends all archived packets to queue (which may differ from the archived one),
+// packets get new indexes. Run VerifyArchive first to avoid partial imports of broken archives.
+// Encrypted records are bound to their original indexes, so import fails on them with ErrArchiveEncrypted.
+func (s *Synapse) ImportArchive(queue QueueConfig, r io.Reader) (uint64, error) {
+	var imported uint64
+	batch := make([]*Packet, 0, archiveImportBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.SendPack(queue, batch); err != nil {
+			return err
+		}
+		imported += uint64(len(batch))
+		batch = make([]*Packet, 0, archiveImportBatchSize)
+		return nil
+	}
+
+	_, err := ReadArchive(r, func(record *nerve.NerveArchiveRecordReader) error {
+		if record.GetEncrypted() {
+			return fmt.Errorf("%w: record %d", ErrArchiveEncrypted, record.GetIndex())
+		}
+		batch = append(batch, &Packet{Data: record.GetData()})
+		if len(batch) < archiveImportBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return imported, err
+	}
+
+	return imported, flush()
+}
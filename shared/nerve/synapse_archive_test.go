@@ -0,0 +1,165 @@
+package nerve
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"octopus/target/generated-sources/protobuf/nerve"
+)
+
+func writeTestArchive(t *testing.T, records []*nerve.NerveArchiveRecord) []byte {
+	buf := &bytes.Buffer{}
+	archive := newArchiveWriter(buf)
+	header := &nerve.NerveArchiveHeader{QueueName: "NQLocalTest", FromIndex: 0, ToIndex: int64(len(records))}
+	if err := archive.writeFrame(archiveFrameHeader, header.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := archive.writeRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	records := []*nerve.NerveArchiveRecord{
+		{Index: 1, Timestamp: 100, Data: []byte("first")},
+		{Index: 2, Data: nil},
+		{Index: 3, Timestamp: 300, Data: bytes.Repeat([]byte("x"), 1<<16)},
+	}
+
+	var got []*nerve.NerveArchiveRecord
+	header, err := ReadArchive(bytes.NewReader(writeTestArchive(t, records)), func(record *nerve.NerveArchiveRecordReader) error {
+		got = append(got, record.ToStruct())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.QueueName != "NQLocalTest" || header.ToIndex != 3 {
+		t.Fatalf("unexpected header: %+v", header)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("expected %d records, got %d", len(records), len(got))
+	}
+	for i := range records {
+		if got[i].Index != records[i].Index || got[i].Timestamp != records[i].Timestamp ||
+			!bytes.Equal(got[i].Data, records[i].Data) {
+			t.Fatalf("record %d mismatch: %+v", i, got[i])
+		}
+	}
+}
+
+func TestArchiveTruncated(t *testing.T) {
+	// archive without footer
+	var buf bytes.Buffer
+	truncated := newArchiveWriter(&buf)
+	header := &nerve.NerveArchiveHeader{QueueName: "NQLocalTest"}
+	_ = truncated.writeFrame(archiveFrameHeader, header.Marshal())
+	_ = truncated.writeRecord(&nerve.NerveArchiveRecord{Index: 1, Data: []byte("first")})
+	_ = truncated.gz.Close()
+
+	if _, err := VerifyArchive(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrArchiveCorrupted) {
+		t.Fatalf("expected corrupted archive error, got %v", err)
+	}
+}
+
+func TestArchiveChecksumMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	archive := newArchiveWriter(&buf)
+	header := &nerve.NerveArchiveHeader{QueueName: "NQLocalTest"}
+	_ = archive.writeFrame(archiveFrameHeader, header.Marshal())
+	_ = archive.writeRecord(&nerve.NerveArchiveRecord{Index: 1, Data: []byte("first")})
+	// record not accounted in footer checksum
+	_ = archive.writeFrame(archiveFrameRecord, (&nerve.NerveArchiveRecord{Index: 2}).Marshal())
+	archive.records++
+	_ = archive.close()
+
+	if _, err := VerifyArchive(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrArchiveCorrupted) {
+		t.Fatalf("expected corrupted archive error, got %v", err)
+	}
+}
+
+func TestArchiveCorruptedFrameSize(t *testing.T) {
+	for name, size := range map[string]uint64{
+		"over max frame size": 1 << 62,
+		"overflow":            1<<64 - 1,
+		"beyond file end":     archiveMaxFrameSize,
+	} {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		frame := make([]byte, 1+binary.MaxVarintLen64)
+		frame[0] = archiveFrameHeader
+		n := binary.PutUvarint(frame[1:], size)
+		_, _ = gz.Write(append(frame[:1+n], "short"...))
+		_ = gz.Close()
+
+		if _, err := VerifyArchive(&buf); !errors.Is(err, ErrArchiveCorrupted) {
+			t.Errorf("%s: expected corrupted archive error, got %v", name, err)
+		}
+	}
+}
+
+func TestSourcedPacketTimestamp(t *testing.T) {
+	sentAt := time.Unix(0, 1_666_000_000_123)
+	sourced := &nerve.NerveSourcedPacket{Packet: []byte("data"), Trace: NewTrace().toProto(sentAt)}
+	if ts := SourcedPacketTimestamp(&Packet{Data: sourced.Marshal()}); ts != sentAt.UnixNano() {
+		t.Errorf("got %d, want %d", ts, sentAt.UnixNano())
+	}
+
+	untraced := &nerve.NerveSourcedPacket{Packet: []byte("data")}
+	for _, data := range [][]byte{untraced.Marshal(), []byte("raw payload"), nil} {
+		if ts := SourcedPacketTimestamp(&Packet{Data: data}); ts != 0 {
+			t.Errorf("%q: got %d, want 0", data, ts)
+		}
+	}
+}
+
+func TestArchiveExportEncrypted(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	queue := QueueConfig{Name: "NQArchiveEncryptedTest"}
+	provider, _ := NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+
+	s := NewSynapse(backend)
+	s.SetQueueEncryption(queue.Name, provider)
+	if _, err := s.Send(queue, &Packet{Data: []byte("secret")}); err != nil {
+		t.Fatal(err)
+	}
+	s.SetQueueEncryption(queue.Name, nil)
+	if _, err := s.Send(queue, &Packet{Data: []byte("plain")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ExportRange(queue, 0, 2, &bytes.Buffer{}, nil, false); !errors.Is(err, ErrArchiveEncrypted) {
+		t.Fatalf("expected encrypted packet error, got %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := s.ExportRange(queue, 0, 2, buf, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	var got []*nerve.NerveArchiveRecord
+	_, err := ReadArchive(bytes.NewReader(buf.Bytes()), func(record *nerve.NerveArchiveRecordReader) error {
+		got = append(got, record.ToStruct())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !got[0].Encrypted || bytes.Contains(got[0].Data, []byte("secret")) ||
+		got[1].Encrypted || string(got[1].Data) != "plain" {
+		t.Fatalf("unexpected records %+v", got)
+	}
+
+	if _, err = s.ImportArchive(queue, bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrArchiveEncrypted) {
+		t.Fatalf("expected encrypted record error, got %v", err)
+	}
+}
@@ -51,12 +51,12 @@ func BenchmarkSynapseWrite(b *testing.B) {
 	b.N = 10_000_000
 
 	t := time.Now()
-	sendData(s, b.N)
+	sendData(s, getTestQueue(b, "NQLocalTest"), b.N)
 
 	b.Logf("sent %d messages in %v, speed = %v msg/s", b.N, time.Since(t), float64(b.N)/time.Since(t).Seconds())
 }
 
-func sendData(s *Synapse, n int) {
+func sendData(s *Synapse, queue QueueConfig, n int) {
 	const writeWorkers = 2000
 	const packSize = 128
 
@@ -73,7 +73,7 @@ func sendData(s *Synapse, n int) {
 						Data: []byte("test"),
 					}
 				}
-				_ = s.SendPack(NQLocalTest, pack)
+				_ = s.SendPack(queue, pack)
 				atomic.AddUint64(&msgSent, uint64(packSize))
 			}
 		}()
@@ -107,14 +107,14 @@ func BenchmarkSynapseRead(b *testing.B) {
 	s := NewSynapse(backend)
 
 	b.N = 10_000_000
-	sendData(s, b.N)
+	sendData(s, getTestQueue(b, "NQLocalTest"), b.N)
 	b.ResetTimer()
 
 	t := time.Now()
 
 	var msgGot uint64 = 0
 
-	receiver := s.GetReceiver(NQLocalTest, NCTest)
+	receiver := s.GetReceiver(getTestQueue(b, "NQLocalTest"), NCTest)
 	for msg := range receiver.DataChan {
 		msgGot += 1
 		receiver.Ack(msg)
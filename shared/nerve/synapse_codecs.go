@@ -0,0 +1,188 @@
+package nerve
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Encoded payloads are stored as codecMarker + codec id + encoded data.
+// Marker starts with zero byte, which is never a valid first byte of a gremlin
+// message, so rows written before compression was enabled are read as is.
+// Raw payloads starting with the marker are stored with identityCodecId, so they
+// are never mistaken for encoded ones.
+var codecMarker = []byte{0x00, 0xff, 'N', 'C'}
+
+const codecHeaderLen = 5
+
+const identityCodecId byte = 0
+
+// decoded payload can't be bigger than this, so corrupted rows can't exhaust memory
+var maxDecodedPayloadSize int64 = 1 << 30
+
+// compressing tiny payloads only makes them bigger
+const minCompressedPayloadSize = 64
+
+// PayloadCodec transforms packet data before it's written to backend and back after it's read.
+// Id is stored along with every encoded payload and must never change once data is written.
+type PayloadCodec interface {
+	Id() byte
+	Name() string
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+var codecsLock sync.RWMutex
+var codecsById = map[byte]PayloadCodec{}
+var codecsByName = map[string]PayloadCodec{}
+
+// RegisterPayloadCodec makes codec available for queue configs and for decoding
+func RegisterPayloadCodec(codec PayloadCodec) {
+	if codec.Id() == identityCodecId {
+		panic(fmt.Sprintf("payload codec id %d is reserved", identityCodecId))
+	}
+
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	if existing, exists := codecsById[codec.Id()]; exists && existing.Name() != codec.Name() {
+		panic(fmt.Sprintf("payload codec id %d is already used by %s", codec.Id(), existing.Name()))
+	}
+	codecsById[codec.Id()] = codec
+	codecsByName[codec.Name()] = codec
+}
+
+// GetPayloadCodec returns codec by name, empty name means no encoding
+func GetPayloadCodec(name string) (PayloadCodec, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	codec, exists := codecsByName[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown payload codec %s", name)
+	}
+	return codec, nil
+}
+
+func framePayload(codecId byte, data []byte) []byte {
+	res := make([]byte, 0, codecHeaderLen+len(data))
+	res = append(res, codecMarker...)
+	res = append(res, codecId)
+	return append(res, data...)
+}
+
+func encodePayload(codec PayloadCodec, data []byte) ([]byte, error) {
+	if codec != nil && len(data) >= minCompressedPayloadSize {
+		encoded, err := codec.Encode(data)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding payload with %s: %w", codec.Name(), err)
+		}
+		if len(encoded)+codecHeaderLen < len(data) {
+			return framePayload(codec.Id(), encoded), nil
+		}
+	}
+
+	if bytes.HasPrefix(data, codecMarker) {
+		return framePayload(identityCodecId, data), nil
+	}
+	return data, nil
+}
+
+func decodePayload(data []byte) ([]byte, error) {
+	if len(data) < codecHeaderLen || !bytes.HasPrefix(data, codecMarker) {
+		return data, nil
+	}
+
+	codecId := data[len(codecMarker)]
+	if codecId == identityCodecId {
+		return data[codecHeaderLen:], nil
+	}
+
+	codecsLock.RLock()
+	codec, exists := codecsById[codecId]
+	codecsLock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown payload codec id %d", codecId)
+	}
+
+	decoded, err := codec.Decode(data[codecHeaderLen:])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding payload with %s: %w", codec.Name(), err)
+	}
+	return decoded, nil
+}
+
+// readDecoded reads decompressed payload up to maxDecodedPayloadSize
+func readDecoded(r io.Reader) ([]byte, error) {
+	decoded, err := io.ReadAll(io.LimitReader(r, maxDecodedPayloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > maxDecodedPayloadSize {
+		return nil, fmt.Errorf("decoded payload is bigger than %d bytes", maxDecodedPayloadSize)
+	}
+	return decoded, nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Id() byte     { return 1 }
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) Encode(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readDecoded(r)
+}
+
+type deflateCodec struct{}
+
+func (deflateCodec) Id() byte     { return 2 }
+func (deflateCodec) Name() string { return "deflate" }
+
+func (deflateCodec) Encode(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (deflateCodec) Decode(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return readDecoded(r)
+}
+
+func init() {
+	RegisterPayloadCodec(gzipCodec{})
+	RegisterPayloadCodec(deflateCodec{})
+}
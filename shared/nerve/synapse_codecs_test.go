@@ -0,0 +1,105 @@
+package nerve
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPayloadCodecsRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("repetitive gremlin message "), 20)
+	for _, name := range []string{"gzip", "deflate"} {
+		codec, err := GetPayloadCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encoded, err := encodePayload(codec, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(encoded) >= len(payload) || !bytes.HasPrefix(encoded, codecMarker) {
+			t.Fatalf("%s: payload is not compressed", name)
+		}
+
+		decoded, err := decodePayload(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, payload) {
+			t.Fatalf("%s: decoded payload differs", name)
+		}
+	}
+}
+
+func TestPayloadCodecsLegacyRows(t *testing.T) {
+	for _, payload := range [][]byte{nil, []byte("test"), {0x08, 0x96, 0x01}, {0x00, 0xff}} {
+		decoded, err := decodePayload(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, payload) {
+			t.Fatalf("raw payload %v changed to %v", payload, decoded)
+		}
+	}
+
+	codec, _ := GetPayloadCodec("gzip")
+	small := []byte("small")
+	encoded, err := encodePayload(codec, small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, small) {
+		t.Fatalf("small payload should be stored as is")
+	}
+}
+
+func TestPayloadCodecsUnknown(t *testing.T) {
+	if _, err := GetPayloadCodec("zstd"); err == nil {
+		t.Fatalf("expected unknown codec error")
+	}
+	if _, err := decodePayload(append(append([]byte{}, codecMarker...), 200, 1, 2, 3)); err == nil {
+		t.Fatalf("expected unknown codec id error")
+	}
+}
+
+func TestPayloadCodecsMarkerPrefixedPayload(t *testing.T) {
+	gzip, _ := GetPayloadCodec("gzip")
+	payloads := [][]byte{
+		append(append([]byte{}, codecMarker...), 1, 2, 3),
+		append(append([]byte{}, codecMarker...), 200),
+		codecMarker,
+		append(append([]byte{}, codecMarker...), bytes.Repeat([]byte{1}, 100)...),
+	}
+	for _, codec := range []PayloadCodec{nil, gzip} {
+		for _, payload := range payloads {
+			encoded, err := encodePayload(codec, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decodePayload(encoded)
+			if err != nil {
+				t.Fatalf("payload %v: %v", payload, err)
+			}
+			if !bytes.Equal(decoded, payload) {
+				t.Fatalf("payload %v decoded as %v", payload, decoded)
+			}
+		}
+	}
+}
+
+func TestPayloadCodecsDecodedSizeLimit(t *testing.T) {
+	defer func(size int64) { maxDecodedPayloadSize = size }(maxDecodedPayloadSize)
+	maxDecodedPayloadSize = 100
+
+	payload := bytes.Repeat([]byte("a"), 200)
+	for _, name := range []string{"gzip", "deflate"} {
+		codec, _ := GetPayloadCodec(name)
+		encoded, err := encodePayload(codec, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = decodePayload(encoded); err == nil {
+			t.Fatalf("%s: expected decoded size error", name)
+		}
+	}
+}
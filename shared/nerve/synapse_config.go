@@ -18,6 +18,8 @@
 // ------------------------------------------------
 package nerve
 
+import "time"
+
 const perChannelMemory = 1024 * 16 // * 8 bytes...
 
 func (s *Synapse) getQueueConfirmationBufferDefaultSize(_ QueueName) int {
@@ -40,8 +42,14 @@ func (s *Synapse) getQueueWriterIOThreadChannelLen(_ QueueName) int {
 	return perChannelMemory
 }
 
+const defaultReaderLimit QueueElementIndex = 50000
+
 func (s *Synapse) getDefaultReaderLimit(_ QueueName, _ ConsumerId) QueueElementIndex {
-	return 50000
+	return s.readerLimit
+}
+
+func (s *Synapse) getGapTimeout(_ QueueName) time.Duration {
+	return s.gapTimeout
 }
 
 func (s *Synapse) getDefaultReceiverChanLen() int {
@@ -0,0 +1,112 @@
+package nerve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	consumeRetryDelay    = 100 * time.Millisecond
+	consumeMaxRetryDelay = 10 * time.Second
+)
+
+var errReceiverClosed = errors.New("receiver is closed")
+
+// BatchHandler processes batch of packets, returned error means nothing in the batch
+// is processed and the whole batch will be handed over again
+type BatchHandler func(ctx context.Context, batch []*Packet) error
+
+// Consume reads packets in batches of up to batchSize and processes them by `workers`
+// handlers in parallel. Packets of successfully handled batch are acked right away,
+// the receiver ack buffer moves consumer pointer once all previous packets are acked too.
+// Consume blocks until ctx is cancelled and in-flight batches are finished, then closes
+// the receiver and returns once acks of handled batches are saved to consumer pointer.
+// Packets read but not handled by then are never delivered by the receiver again,
+// so they are left to the next receiver of the queue, which starts from consumer pointer.
+func (r *Receiver) Consume(ctx context.Context, workers, batchSize int, handler BatchHandler) error {
+	if workers <= 0 || batchSize <= 0 {
+		return fmt.Errorf("invalid consume config: workers=%d, batch size=%d", workers, batchSize)
+	}
+	if r.isClosed() {
+		return errReceiverClosed
+	}
+	defer r.Close()
+
+	batches := make(chan []*Packet, workers)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(worker int) {
+			defer wg.Done()
+			for batch := range batches {
+				r.handleBatch(ctx, worker, batch, handler)
+			}
+		}(i)
+	}
+
+	r.collectBatches(ctx, batchSize, batches)
+	close(batches)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+func (r *Receiver) collectBatches(ctx context.Context, batchSize int, batches chan []*Packet) {
+	batch := make([]*Packet, 0, batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packet := <-r.DataChan:
+			batch = append(batch, packet)
+			// don't wait for the full batch if there is nothing more to read right now
+			if len(batch) < batchSize && len(r.DataChan) > 0 {
+				continue
+			}
+
+			select {
+			case batches <- batch:
+				batch = make([]*Packet, 0, batchSize)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (r *Receiver) handleBatch(ctx context.Context, worker int, batch []*Packet, handler BatchHandler) {
+	delay := consumeRetryDelay
+	for {
+		err := handler(ctx, batch)
+		if err == nil {
+			for _, packet := range batch {
+				r.Ack(packet)
+			}
+			return
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		r.logger.Error().Err(err).
+			Str("consumer", string(r.ConsumerId)).
+			Int("worker", worker).
+			Int64("from", int64(batch[0].DbId)).
+			Int("batch-size", len(batch)).
+			Dur("retry-in", delay).
+			Msg("error handling batch")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > consumeMaxRetryDelay {
+			delay = consumeMaxRetryDelay
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package nerve
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReceiverConsume(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	s := NewSynapse(backend)
+	queue := QueueConfig{Name: "NQConsumeTest"}
+	fillQueue(t, s, queue, 500)
+
+	receiver := s.GetBufferedReceiver(queue, NCTest, 100)
+	defer receiver.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failures int32
+	handled := map[QueueElementIndex]int{}
+	handledLock := sync.Mutex{}
+	done := make(chan error)
+	go func() {
+		done <- receiver.Consume(ctx, 4, 16, func(ctx context.Context, batch []*Packet) error {
+			if len(batch) > 16 {
+				t.Errorf("batch of %d packets", len(batch))
+			}
+			// every 10th batch fails once
+			if batch[0].DbId%10 == 0 && atomic.AddInt32(&failures, 1) < 5 {
+				return errors.New("handler failure")
+			}
+			handledLock.Lock()
+			for _, p := range batch {
+				handled[p.DbId]++
+			}
+			handledLock.Unlock()
+			return nil
+		})
+	}()
+
+	waitForPtr(t, backend, queue, NCTest, 500)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected consume result: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("consume is not stopped")
+	}
+
+	for id := QueueElementIndex(1); id <= 500; id++ {
+		if handled[id] != 1 {
+			t.Fatalf("packet %d handled %d times", id, handled[id])
+		}
+	}
+
+	if err := receiver.Consume(context.Background(), 1, 1, nil); err == nil {
+		t.Fatalf("expected error consuming closed receiver")
+	}
+}
+
+func TestReceiverConsumeCancelRedelivers(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	s := NewSynapse(backend)
+	queue := QueueConfig{Name: "NQConsumeCancelTest"}
+	fillQueue(t, s, queue, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	receiver := s.GetBufferedReceiver(queue, NCTest, 100)
+	go func() {
+		// handler blocks until cancel, so no batch is handled
+		done <- receiver.Consume(ctx, 2, 10, func(ctx context.Context, batch []*Packet) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("consume is not stopped")
+	}
+
+	next := s.GetBufferedReceiver(queue, NCTest, 100)
+	defer next.Close()
+	for id := QueueElementIndex(1); id <= 100; id++ {
+		select {
+		case p := <-next.DataChan:
+			if p.DbId != id {
+				t.Fatalf("got packet %d, expected %d", p.DbId, id)
+			}
+			next.Ack(p)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("packet %d is not redelivered", id)
+		}
+	}
+	waitForPtr(t, backend, queue, NCTest, 100)
+}
+
+func TestReceiverConsumeCancelAfterHandling(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	queue := QueueConfig{Name: "NQConsumeCancelAckTest"}
+	fillQueue(t, NewSynapse(backend), queue, 10)
+
+	// slow pointer writes keep flush in progress when consume is cancelled
+	s := NewSynapse(NewFaultyBackend(backend, 1).WithFaults(FaultWritePtr, FaultConfig{
+		MinLatency: 50 * time.Millisecond,
+		MaxLatency: 50 * time.Millisecond,
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	receiver := s.GetBufferedReceiver(queue, NCTest, 100)
+	var handled int32
+	err := receiver.Consume(ctx, 1, 1, func(ctx context.Context, batch []*Packet) error {
+		if atomic.AddInt32(&handled, int32(len(batch))) == 10 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("unexpected consume error %v", err)
+	}
+
+	// acks of the last batch are flushed before Consume returns
+	if ptr, err := backend.GetPtr(queue.Name, NCTest); err != nil || ptr != 10 {
+		t.Fatalf("consumer pointer is %d (%v) right after consume, expected 10", ptr, err)
+	}
+}
+
+func TestReceiverConsumeInvalidConfig(t *testing.T) {
+	r := &Receiver{}
+	if err := r.Consume(context.Background(), 0, 10, nil); err == nil {
+		t.Fatalf("expected error for zero workers")
+	}
+}
+
+func writeQueueWithGap(t *testing.T, backend SynapseBackend, queue QueueConfig) {
+	// packet 3 is never written, as if its write failed after getting index
+	err := backend.WriteBatch(queue.Name, []*Packet{
+		{DbId: 1, Data: []byte("1")},
+		{DbId: 2, Data: []byte("2")},
+		{DbId: 4, Data: []byte("4")},
+		{DbId: 5, Data: []byte("5")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = backend.WritePtr(queue.Name, "", 5); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receiveIds(t *testing.T, receiver *Receiver, ids ...QueueElementIndex) {
+	for _, expected := range ids {
+		select {
+		case packet := <-receiver.DataChan:
+			if packet.DbId != expected {
+				t.Fatalf("got packet %d, expected %d", packet.DbId, expected)
+			}
+			receiver.Ack(packet)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("packet %d is not delivered", expected)
+		}
+	}
+}
+
+func TestReceiverWaitsForGap(t *testing.T) {
+	backend := NewMemoryBackend(1)
+	queue := QueueConfig{Name: "NQGapTest"}
+	writeQueueWithGap(t, backend, queue)
+
+	s := NewSynapse(backend)
+	s.SetHealthConfig(HealthConfig{FailureTimeout: 100 * time.Millisecond})
+	receiver := s.GetBufferedReceiver(queue, NCTest, 10)
+	defer receiver.Close()
+
+	receiveIds(t, receiver, 1, 2)
+	select {
+	case packet := <-receiver.DataChan:
+		t.Fatalf("packet %d is delivered over the gap", packet.DbId)
+	case <-time.After(500 * time.Millisecond):
+	}
+	waitForPtr(t, backend, queue, NCTest, 2)
+
+	report := s.Health(context.Background())
+	if report.Healthy || len(report.Receivers) != 1 || report.Receivers[0].Healthy || report.Receivers[0].MissingId != 3 {
+		t.Fatalf("gap is not reported: %+v", report)
+	}
+
+	// late write fills the gap
+	if err := backend.WriteBatch(queue.Name, []*Packet{{DbId: 3, Data: []byte("3")}}); err != nil {
+		t.Fatal(err)
+	}
+	receiveIds(t, receiver, 3, 4, 5)
+	waitForPtr(t, backend, queue, NCTest, 5)
+
+	report = s.Health(context.Background())
+	if !report.Healthy || report.Receivers[0].MissingId != 0 {
+		t.Fatalf("filled gap is still reported: %+v", report)
+	}
+}
+
+func TestReceiverSkipsGapWithTimeout(t *testing.T) {
+	backend := NewMemoryBackend(1)
+	queue := QueueConfig{Name: "NQGapSkipTest"}
+	writeQueueWithGap(t, backend, queue)
+
+	s := NewSynapse(backend)
+	s.SetGapTimeout(200 * time.Millisecond)
+	receiver := s.GetBufferedReceiver(queue, NCTest, 10)
+	defer receiver.Close()
+
+	receiveIds(t, receiver, 1, 2, 4, 5)
+	waitForPtr(t, backend, queue, NCTest, 5)
+}
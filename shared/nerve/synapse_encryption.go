@@ -0,0 +1,411 @@
+package nerve
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Encrypted payload layout:
+//
+//	encryptionMarker | version | key id len | key id | wrapped data key | nonce | ciphertext
+//
+// data key is random, it's encrypted (wrapped) by the queue key from KeyProvider
+// and is replaced every dataKeyTTL. Queue name and packet index are authenticated
+// along with the payload, so rows can't be moved around.
+// Ciphertext doesn't compress, so payload is compressed by the queue codec before
+// encryption (since version 2) and backend stores encrypted payloads as is.
+// Raw payloads starting with the marker are stored as encryptionMarker | plainVersion | data,
+// so they are never mistaken for encrypted ones.
+var encryptionMarker = []byte{0x00, 0xff, 'N', 'E'}
+
+const (
+	plainVersion         byte = 0
+	rawEncryptionVersion byte = 1
+	encryptionVersion    byte = 2
+	dataKeySize               = 32
+	dataKeyTTL                = 10 * time.Minute
+	maxCachedDataKeys         = 4096
+	gcmNonceSize              = 12
+	wrappedDataKeySize        = gcmNonceSize + dataKeySize + 16
+)
+
+var ErrUnknownKey = errors.New("unknown nerve encryption key")
+
+// KeyProvider gives keys for queue payloads encryption, keys must be 16, 24 or 32 bytes long (AES-128/192/256).
+// Keys are never removed while data encrypted by them is stored.
+type KeyProvider interface {
+	// CurrentKeyId returns id of the key new packets of queue are encrypted with
+	CurrentKeyId(queueName QueueName) (string, error)
+	// GetKey returns key by id
+	GetKey(keyId string) ([]byte, error)
+}
+
+// StaticKeyProvider keeps keys in memory, rotation adds new current key keeping old ones for reads
+type StaticKeyProvider struct {
+	lock      sync.RWMutex
+	currentId string
+	keys      map[string][]byte
+}
+
+func NewStaticKeyProvider(currentId string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	p := &StaticKeyProvider{keys: make(map[string][]byte)}
+	for keyId, key := range keys {
+		if err := p.AddKey(keyId, key); err != nil {
+			return nil, err
+		}
+	}
+	if _, exists := p.keys[currentId]; !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, currentId)
+	}
+	p.currentId = currentId
+
+	return p, nil
+}
+
+// AddKey adds key available for decryption only
+func (p *StaticKeyProvider) AddKey(keyId string, key []byte) error {
+	if keyId == "" || len(keyId) > 255 {
+		return fmt.Errorf("invalid key id %q", keyId)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	p.keys[keyId] = append([]byte(nil), key...)
+	p.lock.Unlock()
+	return nil
+}
+
+// Rotate adds key and makes it current, packets encrypted by previous keys stay readable
+func (p *StaticKeyProvider) Rotate(keyId string, key []byte) error {
+	if err := p.AddKey(keyId, key); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	p.currentId = keyId
+	p.lock.Unlock()
+	return nil
+}
+
+func (p *StaticKeyProvider) CurrentKeyId(_ QueueName) (string, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.currentId, nil
+}
+
+func (p *StaticKeyProvider) GetKey(keyId string) ([]byte, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	key, exists := p.keys[keyId]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyId)
+	}
+	return key, nil
+}
+
+type dataKey struct {
+	keyId     string
+	wrapped   []byte
+	aead      cipher.AEAD
+	createdAt time.Time
+}
+
+type queueEncryptor struct {
+	queueName QueueName
+	provider  KeyProvider
+	// disabled encryptor only decrypts packets written before
+	disabled bool
+
+	currentLock sync.Mutex
+	current     *dataKey
+
+	// unwrapped data keys by wrapped form
+	cacheLock sync.RWMutex
+	cache     map[string]cipher.AEAD
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *queueEncryptor) getDataKey() (*dataKey, error) {
+	keyId, err := e.provider.CurrentKeyId(e.queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	e.currentLock.Lock()
+	defer e.currentLock.Unlock()
+	if e.current != nil && e.current.keyId == keyId && time.Since(e.current.createdAt) < dataKeyTTL {
+		return e.current, nil
+	}
+
+	queueKey, err := e.provider.GetKey(keyId)
+	if err != nil {
+		return nil, err
+	}
+	queueAead, err := newGCM(queueKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plainKey := make([]byte, dataKeySize)
+	nonce := make([]byte, gcmNonceSize)
+	if _, err = rand.Read(plainKey); err != nil {
+		return nil, err
+	}
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(plainKey)
+	if err != nil {
+		return nil, err
+	}
+
+	e.current = &dataKey{
+		keyId:     keyId,
+		wrapped:   queueAead.Seal(nonce, nonce, plainKey, []byte(keyId)),
+		aead:      aead,
+		createdAt: time.Now(),
+	}
+	return e.current, nil
+}
+
+func (e *queueEncryptor) additionalData(id QueueElementIndex) []byte {
+	return []byte(string(e.queueName) + ":" + strconv.FormatInt(int64(id), 10))
+}
+
+func (e *queueEncryptor) encrypt(id QueueElementIndex, data []byte, codec PayloadCodec) ([]byte, error) {
+	key, err := e.getDataKey()
+	if err != nil {
+		return nil, err
+	}
+	if data, err = encodePayload(codec, data); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcmNonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	res := make([]byte, 0, len(encryptionMarker)+2+len(key.keyId)+wrappedDataKeySize+gcmNonceSize+len(data)+16)
+	res = append(res, encryptionMarker...)
+	res = append(res, encryptionVersion, byte(len(key.keyId)))
+	res = append(res, key.keyId...)
+	res = append(res, key.wrapped...)
+	res = append(res, nonce...)
+	return key.aead.Seal(res, nonce, data, e.additionalData(id)), nil
+}
+
+func (e *queueEncryptor) unwrap(keyId string, wrapped []byte) (cipher.AEAD, error) {
+	e.cacheLock.RLock()
+	aead, exists := e.cache[string(wrapped)]
+	e.cacheLock.RUnlock()
+	if exists {
+		return aead, nil
+	}
+
+	queueKey, err := e.provider.GetKey(keyId)
+	if err != nil {
+		return nil, err
+	}
+	queueAead, err := newGCM(queueKey)
+	if err != nil {
+		return nil, err
+	}
+	plainKey, err := queueAead.Open(nil, wrapped[:gcmNonceSize], wrapped[gcmNonceSize:], []byte(keyId))
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key of %s: %w", keyId, err)
+	}
+	aead, err = newGCM(plainKey)
+	if err != nil {
+		return nil, err
+	}
+
+	e.cacheLock.Lock()
+	if len(e.cache) >= maxCachedDataKeys {
+		e.cache = make(map[string]cipher.AEAD)
+	}
+	e.cache[string(wrapped)] = aead
+	e.cacheLock.Unlock()
+
+	return aead, nil
+}
+
+func isEncryptedPayload(data []byte) bool {
+	return bytes.HasPrefix(data, encryptionMarker)
+}
+
+func isPlainFramedPayload(data []byte) bool {
+	return len(data) > len(encryptionMarker) && isEncryptedPayload(data) && data[len(encryptionMarker)] == plainVersion
+}
+
+// framePlainPayload keeps raw payload starting with encryptionMarker from being decrypted
+func framePlainPayload(data []byte) []byte {
+	if !isEncryptedPayload(data) {
+		return data
+	}
+	res := make([]byte, 0, len(encryptionMarker)+1+len(data))
+	res = append(res, encryptionMarker...)
+	res = append(res, plainVersion)
+	return append(res, data...)
+}
+
+func (e *queueEncryptor) decrypt(id QueueElementIndex, data []byte) ([]byte, error) {
+	if !isEncryptedPayload(data) {
+		// written before encryption was enabled
+		return data, nil
+	}
+	if isPlainFramedPayload(data) {
+		return data[len(encryptionMarker)+1:], nil
+	}
+
+	rest := data[len(encryptionMarker):]
+	if len(rest) < 2 || (rest[0] != encryptionVersion && rest[0] != rawEncryptionVersion) {
+		return nil, fmt.Errorf("unsupported encrypted payload of packet %d", id)
+	}
+	version := rest[0]
+	keyIdLen := int(rest[1])
+	rest = rest[2:]
+	if len(rest) < keyIdLen+wrappedDataKeySize+gcmNonceSize {
+		return nil, fmt.Errorf("truncated encrypted payload of packet %d", id)
+	}
+	keyId := string(rest[:keyIdLen])
+	rest = rest[keyIdLen:]
+
+	aead, err := e.unwrap(keyId, rest[:wrappedDataKeySize])
+	if err != nil {
+		return nil, err
+	}
+	rest = rest[wrappedDataKeySize:]
+
+	plain, err := aead.Open(nil, rest[:gcmNonceSize], rest[gcmNonceSize:], e.additionalData(id))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting packet %d: %w", id, err)
+	}
+	if version == rawEncryptionVersion {
+		return plain, nil
+	}
+	return decodePayload(plain)
+}
+
+// SetQueueEncryption turns on encryption of packets data for queue, nil provider turns it off
+// for new packets. Packets written before stay readable while their keys are in provider.
+func (s *Synapse) SetQueueEncryption(queueName QueueName, provider KeyProvider) {
+	s.encryptorsLock.Lock()
+	defer s.encryptorsLock.Unlock()
+
+	if provider == nil {
+		if e, exists := s.encryptors[queueName]; exists {
+			e.disabled = true
+		}
+		return
+	}
+
+	s.encryptors[queueName] = &queueEncryptor{
+		queueName: queueName,
+		provider:  provider,
+		cache:     make(map[string]cipher.AEAD),
+	}
+}
+
+// getQueueEncryptor returns queue encryptor and whether new packets should be encrypted
+func (s *Synapse) getQueueEncryptor(queueName QueueName) (*queueEncryptor, bool) {
+	s.encryptorsLock.RLock()
+	defer s.encryptorsLock.RUnlock()
+	e, exists := s.encryptors[queueName]
+	return e, exists && !e.disabled
+}
+
+// queueCodecBackend is implemented by backends compressing payloads, encrypted payloads
+// are compressed before encryption with the same codec
+type queueCodecBackend interface {
+	getQueueCodec(queueName QueueName) PayloadCodec
+}
+
+func (s *Synapse) getQueueCodec(queueName QueueName) PayloadCodec {
+	if backend, ok := s.Backend.(queueCodecBackend); ok {
+		return backend.getQueueCodec(queueName)
+	}
+	return nil
+}
+
+// encryptBatch returns copy of batch with encrypted data if queue is encrypted
+func (s *Synapse) encryptBatch(queueName QueueName, batch []*Packet) ([]*Packet, error) {
+	e, enabled := s.getQueueEncryptor(queueName)
+	if !enabled {
+		return framePlainBatch(batch), nil
+	}
+
+	codec := s.getQueueCodec(queueName)
+	res := make([]*Packet, len(batch))
+	for i, packet := range batch {
+		encrypted, err := e.encrypt(packet.DbId, packet.Data, codec)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = &Packet{DbId: packet.DbId, Data: encrypted}
+	}
+	return res, nil
+}
+
+// framePlainBatch returns copy of batch if some payloads need framing, batch itself otherwise
+func framePlainBatch(batch []*Packet) []*Packet {
+	for i, packet := range batch {
+		if !isEncryptedPayload(packet.Data) {
+			continue
+		}
+		res := append([]*Packet(nil), batch...)
+		for j := i; j < len(res); j++ {
+			res[j] = &Packet{DbId: res[j].DbId, Data: framePlainPayload(res[j].Data)}
+		}
+		return res
+	}
+	return batch
+}
+
+// readBatch reads packets from backend decrypting them if needed. Batch with packet which
+// can't be decrypted fails as a whole, so receivers retry it until key provider can decrypt it.
+func (s *Synapse) readBatch(queueName QueueName, batch []*Packet) ([]*Packet, error) {
+	result, err := s.Backend.ReadBatch(queueName, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	e, _ := s.getQueueEncryptor(queueName)
+	decrypted := result[:0]
+	for _, packet := range result {
+		if !isEncryptedPayload(packet.Data) {
+			decrypted = append(decrypted, packet)
+			continue
+		}
+		if isPlainFramedPayload(packet.Data) {
+			packet.Data = packet.Data[len(encryptionMarker)+1:]
+			decrypted = append(decrypted, packet)
+			continue
+		}
+
+		if e == nil {
+			return nil, fmt.Errorf("packet %d of %s is encrypted, but queue has no key provider", packet.DbId, queueName)
+		}
+		if packet.Data, err = e.decrypt(packet.DbId, packet.Data); err != nil {
+			return nil, fmt.Errorf("error decrypting packet %d of %s: %w", packet.DbId, queueName, err)
+		}
+		decrypted = append(decrypted, packet)
+	}
+	return decrypted, nil
+}
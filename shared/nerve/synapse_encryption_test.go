@@ -0,0 +1,190 @@
+package nerve
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func receiveN(t *testing.T, receiver *Receiver, n int) [][]byte {
+	var res [][]byte
+	timeout := time.After(5 * time.Second)
+	for len(res) < n {
+		select {
+		case p := <-receiver.DataChan:
+			res = append(res, p.Data)
+			receiver.Ack(p)
+		case <-timeout:
+			t.Fatalf("received %d of %d packets", len(res), n)
+		}
+	}
+	return res
+}
+
+func TestSynapseEncryptionRotation(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	s := NewSynapse(backend)
+	queue := QueueConfig{Name: "NQEncryptedTest"}
+
+	provider, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(prefix string, n int) {
+		pack := make([]*Packet, n)
+		for i := range pack {
+			pack[i] = &Packet{Data: []byte(fmt.Sprintf("%s-secret-%d", prefix, i))}
+		}
+		if err := s.SendPack(queue, pack); err != nil {
+			t.Fatal(err)
+		}
+		for i, p := range pack {
+			if string(p.Data) != fmt.Sprintf("%s-secret-%d", prefix, i) {
+				t.Fatalf("sent packet data is changed")
+			}
+		}
+	}
+
+	send("plain", 5)
+	s.SetQueueEncryption(queue.Name, provider)
+	send("k1", 5)
+	if err = provider.Rotate("k2", bytes.Repeat([]byte{2}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	send("k2", 5)
+	s.SetQueueEncryption(queue.Name, nil)
+	send("off", 5)
+
+	for id, data := range backend.queues[queue.Name] {
+		encrypted := id > 5 && id <= 15
+		if encrypted == bytes.Contains(data, []byte("secret")) {
+			t.Fatalf("packet %d: encrypted=%v, stored %q", id, encrypted, data)
+		}
+	}
+
+	receiver := s.GetBufferedReceiver(queue, NCTest, 100)
+	defer receiver.Close()
+	got := receiveN(t, receiver, 20)
+	for i, prefix := range []string{"plain", "k1", "k2", "off"} {
+		for j := 0; j < 5; j++ {
+			if string(got[i*5+j]) != fmt.Sprintf("%s-secret-%d", prefix, j) {
+				t.Fatalf("unexpected packet %q", got[i*5+j])
+			}
+		}
+	}
+}
+
+func TestQueueEncryptorTampering(t *testing.T) {
+	provider, _ := NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	e := &queueEncryptor{queueName: "NQEncryptedTest", provider: provider, cache: map[string]cipher.AEAD{}}
+
+	encrypted, err := e.encrypt(1, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = e.decrypt(2, encrypted); err == nil {
+		t.Fatalf("packet moved to another index is decrypted")
+	}
+
+	other, _ := NewStaticKeyProvider("k9", map[string][]byte{"k9": bytes.Repeat([]byte{9}, 32)})
+	e = &queueEncryptor{queueName: "NQEncryptedTest", provider: other, cache: map[string]cipher.AEAD{}}
+	if _, err = e.decrypt(1, encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+}
+
+func TestQueueEncryptorCompression(t *testing.T) {
+	provider, _ := NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	e := &queueEncryptor{queueName: "NQEncryptedTest", provider: provider, cache: map[string]cipher.AEAD{}}
+	codec, _ := GetPayloadCodec("gzip")
+
+	payload := bytes.Repeat([]byte("repetitive secret "), 100)
+	encrypted, err := e.encrypt(1, payload, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted) >= len(payload)/2 {
+		t.Fatalf("payload of %d bytes is encrypted to %d bytes", len(payload), len(encrypted))
+	}
+
+	decrypted, err := e.decrypt(1, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, payload) {
+		t.Fatalf("decrypted payload differs")
+	}
+}
+
+func TestSynapseEncryptionMarkerPrefixedPayload(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	s := NewSynapse(backend)
+	queue := QueueConfig{Name: "NQEncryptedMarkerTest"}
+	provider, _ := NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+
+	payloads := [][]byte{
+		append(append([]byte{}, encryptionMarker...), encryptionVersion, 1, 2, 3),
+		append(append([]byte{}, encryptionMarker...), plainVersion),
+		[]byte("plain"),
+	}
+	send := func() {
+		pack := make([]*Packet, len(payloads))
+		for i, payload := range payloads {
+			pack[i] = &Packet{Data: payload}
+		}
+		if err := s.SendPack(queue, pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send()
+	s.SetQueueEncryption(queue.Name, provider)
+	send()
+
+	receiver := s.GetBufferedReceiver(queue, NCTest, 100)
+	defer receiver.Close()
+	got := receiveN(t, receiver, 2*len(payloads))
+	for i, data := range got {
+		if !bytes.Equal(data, payloads[i%len(payloads)]) {
+			t.Fatalf("packet %d: sent %v, received %v", i+1, payloads[i%len(payloads)], data)
+		}
+	}
+}
+
+func TestSynapseEncryptionWaitsForKey(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	queue := QueueConfig{Name: "NQEncryptedWaitTest"}
+	provider, _ := NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+
+	s := NewSynapse(backend)
+	s.SetQueueEncryption(queue.Name, provider)
+	if _, err := s.Send(queue, &Packet{Data: []byte("secret")}); err != nil {
+		t.Fatal(err)
+	}
+	s.SetQueueEncryption(queue.Name, nil)
+	if _, err := s.Send(queue, &Packet{Data: []byte("plain")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// reader without key provider can't decrypt the first packet, so it delivers nothing
+	reader := NewSynapse(backend)
+	receiver := reader.GetBufferedReceiver(queue, NCTest, 100)
+	defer receiver.Close()
+
+	select {
+	case packet := <-receiver.DataChan:
+		t.Fatalf("packet %d is delivered without key", packet.DbId)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	reader.SetQueueEncryption(queue.Name, provider)
+	got := receiveN(t, receiver, 2)
+	if string(got[0]) != "secret" || string(got[1]) != "plain" {
+		t.Fatalf("unexpected packets %q", got)
+	}
+	waitForPtr(t, backend, queue, NCTest, 2)
+}
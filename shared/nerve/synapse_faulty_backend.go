@@ -0,0 +1,235 @@
+package nerve
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type FaultMethod string
+
+const (
+	FaultWriteBatch FaultMethod = "WriteBatch"
+	FaultWritePtr   FaultMethod = "WritePtr"
+	FaultGetPtr     FaultMethod = "GetPtr"
+	FaultReadBatch  FaultMethod = "ReadBatch"
+	FaultPing       FaultMethod = "Ping"
+)
+
+var ErrInjectedFault = errors.New("injected nerve backend fault")
+
+// FaultConfig describes faults injected into one backend method, all rates are probabilities in [0, 1]
+type FaultConfig struct {
+	// ErrorRate - call fails before reaching underlying backend
+	ErrorRate float64
+	// ErrorAfterRate - call reaches underlying backend, but error is returned anyway
+	ErrorAfterRate float64
+	// MinLatency and MaxLatency - every call is delayed by random duration in between
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	// PartialRate - ReadBatch drops random packets from the result
+	PartialRate float64
+	// ReorderRate - ReadBatch shuffles the result, other methods are delayed
+	// by ReorderDelay after the call, so calls made later complete first
+	ReorderRate  float64
+	ReorderDelay time.Duration
+}
+
+type FaultStats struct {
+	Calls    uint64
+	Errors   uint64
+	Partial  uint64
+	Reorders uint64
+}
+
+type faultState struct {
+	config FaultConfig
+	stats  FaultStats
+}
+
+// FaultyBackend wraps SynapseBackend injecting faults configured per method.
+// Random decisions come from one seeded source, so the same seed with the same
+// sequence of calls produces the same faults.
+type FaultyBackend struct {
+	backend SynapseBackend
+	rnd     *rand.Rand
+	rndLock sync.Mutex
+	faults  map[FaultMethod]*faultState
+}
+
+func NewFaultyBackend(backend SynapseBackend, seed int64) *FaultyBackend {
+	return &FaultyBackend{
+		backend: backend,
+		rnd:     rand.New(rand.NewSource(seed)),
+		faults: map[FaultMethod]*faultState{
+			FaultWriteBatch: {},
+			FaultWritePtr:   {},
+			FaultGetPtr:     {},
+			FaultReadBatch:  {},
+			FaultPing:       {},
+		},
+	}
+}
+
+// WithFaults sets faults for method, should be called before backend is used
+func (f *FaultyBackend) WithFaults(method FaultMethod, config FaultConfig) *FaultyBackend {
+	f.faults[method] = &faultState{config: config}
+	return f
+}
+
+func (f *FaultyBackend) Stats(method FaultMethod) FaultStats {
+	state := f.faults[method]
+	return FaultStats{
+		Calls:    atomic.LoadUint64(&state.stats.Calls),
+		Errors:   atomic.LoadUint64(&state.stats.Errors),
+		Partial:  atomic.LoadUint64(&state.stats.Partial),
+		Reorders: atomic.LoadUint64(&state.stats.Reorders),
+	}
+}
+
+func (f *FaultyBackend) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	f.rndLock.Lock()
+	defer f.rndLock.Unlock()
+	return f.rnd.Float64() < rate
+}
+
+func (f *FaultyBackend) duration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	f.rndLock.Lock()
+	defer f.rndLock.Unlock()
+	return min + time.Duration(f.rnd.Int63n(int64(max-min)))
+}
+
+// before is called prior to underlying backend call, returns error if call must fail
+func (f *FaultyBackend) before(method FaultMethod) error {
+	state := f.faults[method]
+	atomic.AddUint64(&state.stats.Calls, 1)
+
+	if latency := f.duration(state.config.MinLatency, state.config.MaxLatency); latency > 0 {
+		time.Sleep(latency)
+	}
+
+	if f.chance(state.config.ErrorRate) {
+		atomic.AddUint64(&state.stats.Errors, 1)
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+// after is called after successful underlying backend call
+func (f *FaultyBackend) after(method FaultMethod) error {
+	state := f.faults[method]
+	if method != FaultReadBatch && f.chance(state.config.ReorderRate) {
+		atomic.AddUint64(&state.stats.Reorders, 1)
+		time.Sleep(state.config.ReorderDelay)
+	}
+
+	if f.chance(state.config.ErrorAfterRate) {
+		atomic.AddUint64(&state.stats.Errors, 1)
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+func (f *FaultyBackend) WriteBatch(name QueueName, data []*Packet) error {
+	if err := f.before(FaultWriteBatch); err != nil {
+		return err
+	}
+	if err := f.backend.WriteBatch(name, data); err != nil {
+		return err
+	}
+	return f.after(FaultWriteBatch)
+}
+
+func (f *FaultyBackend) WritePtr(name QueueName, consumer ConsumerId, ptr QueueElementIndex) error {
+	if err := f.before(FaultWritePtr); err != nil {
+		return err
+	}
+	if err := f.backend.WritePtr(name, consumer, ptr); err != nil {
+		return err
+	}
+	return f.after(FaultWritePtr)
+}
+
+func (f *FaultyBackend) GetDefaultQueueParallelism(name QueueName) uint {
+	return f.backend.GetDefaultQueueParallelism(name)
+}
+
+func (f *FaultyBackend) GetPtr(name QueueName, consumer ConsumerId) (QueueElementIndex, error) {
+	if err := f.before(FaultGetPtr); err != nil {
+		return 0, err
+	}
+	ptr, err := f.backend.GetPtr(name, consumer)
+	if err != nil {
+		return 0, err
+	}
+	if err = f.after(FaultGetPtr); err != nil {
+		return 0, err
+	}
+	return ptr, nil
+}
+
+func (f *FaultyBackend) ReadBatch(name QueueName, data []*Packet) ([]*Packet, error) {
+	if err := f.before(FaultReadBatch); err != nil {
+		return nil, err
+	}
+	result, err := f.backend.ReadBatch(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	state := f.faults[FaultReadBatch]
+	if len(result) > 0 && f.chance(state.config.PartialRate) {
+		atomic.AddUint64(&state.stats.Partial, 1)
+		partial := make([]*Packet, 0, len(result))
+		for _, packet := range result {
+			if !f.chance(0.5) {
+				partial = append(partial, packet)
+			}
+		}
+		result = partial
+	}
+
+	if f.chance(state.config.ReorderRate) {
+		atomic.AddUint64(&state.stats.Reorders, 1)
+		f.rndLock.Lock()
+		f.rnd.Shuffle(len(result), func(i, j int) {
+			result[i], result[j] = result[j], result[i]
+		})
+		f.rndLock.Unlock()
+	}
+
+	if err = f.after(FaultReadBatch); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (f *FaultyBackend) SetTrace(trace bool) {
+	f.backend.SetTrace(trace)
+}
+
+func (f *FaultyBackend) GetHostName() string {
+	return f.backend.GetHostName()
+}
+
+// Ping fails with injected faults of FaultPing, otherwise pings underlying backend if it supports that
+func (f *FaultyBackend) Ping(ctx context.Context) error {
+	if err := f.before(FaultPing); err != nil {
+		return err
+	}
+	if pinger, ok := f.backend.(BackendPinger); ok {
+		if err := pinger.Ping(ctx); err != nil {
+			return err
+		}
+	}
+	return f.after(FaultPing)
+}
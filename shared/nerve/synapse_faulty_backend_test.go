@@ -0,0 +1,153 @@
+package nerve
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func runFaultyPipeline(t *testing.T, backend *FaultyBackend, producers, packsPerProducer, packSize int) {
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	defer zerolog.SetGlobalLevel(level)
+
+	queue := QueueConfig{Name: "NQFaultTest"}
+	s := NewSynapse(backend)
+	s.SetReaderLimit(32)
+
+	wg := sync.WaitGroup{}
+	wg.Add(producers)
+	for p := 0; p < producers; p++ {
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < packsPerProducer; i++ {
+				pack := make([]*Packet, packSize)
+				for j := range pack {
+					pack[j] = &Packet{Data: []byte(fmt.Sprintf("%d-%d-%d", p, i, j))}
+				}
+				_ = s.SendPack(queue, pack)
+			}
+		}(p)
+	}
+
+	total := producers * packsPerProducer * packSize
+	receiver := s.GetBufferedReceiver(queue, NCTest, 100)
+	defer receiver.Close()
+
+	seenIds := make(map[QueueElementIndex]struct{}, total)
+	seenData := make(map[string]struct{}, total)
+	timeout := time.After(30 * time.Second)
+	for len(seenIds) < total {
+		select {
+		case packet := <-receiver.DataChan:
+			if _, dup := seenIds[packet.DbId]; dup {
+				t.Fatalf("packet %d received twice", packet.DbId)
+			}
+			if _, dup := seenData[string(packet.Data)]; dup {
+				t.Fatalf("payload %s stored twice", packet.Data)
+			}
+			seenIds[packet.DbId] = struct{}{}
+			seenData[string(packet.Data)] = struct{}{}
+			receiver.Ack(packet)
+		case <-timeout:
+			t.Fatalf("received %d of %d packets", len(seenIds), total)
+		}
+	}
+	wg.Wait()
+
+	for id := QueueElementIndex(1); id <= QueueElementIndex(total); id++ {
+		if _, exists := seenIds[id]; !exists {
+			t.Fatalf("packet %d is lost", id)
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		ptr, err := backend.backend.GetPtr(queue.Name, NCTest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ptr == QueueElementIndex(total) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("consumer pointer is stuck at %d of %d", ptr, total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSynapseWriteErrors(t *testing.T) {
+	backend := NewFaultyBackend(NewMemoryBackend(4), 1).
+		WithFaults(FaultWriteBatch, FaultConfig{ErrorRate: 0.2, ErrorAfterRate: 0.2}).
+		WithFaults(FaultWritePtr, FaultConfig{ErrorRate: 0.2, ErrorAfterRate: 0.1})
+
+	runFaultyPipeline(t, backend, 8, 20, 16)
+
+	if backend.Stats(FaultWriteBatch).Errors == 0 || backend.Stats(FaultWritePtr).Errors == 0 {
+		t.Fatalf("no faults injected")
+	}
+}
+
+func TestSynapseOutOfOrderWrites(t *testing.T) {
+	backend := NewFaultyBackend(NewMemoryBackend(4), 2).
+		WithFaults(FaultWriteBatch, FaultConfig{
+			MaxLatency:   2 * time.Millisecond,
+			ReorderRate:  0.3,
+			ReorderDelay: 5 * time.Millisecond,
+		}).
+		WithFaults(FaultWritePtr, FaultConfig{ReorderRate: 0.3, ReorderDelay: 2 * time.Millisecond})
+
+	runFaultyPipeline(t, backend, 8, 20, 16)
+
+	if backend.Stats(FaultWriteBatch).Reorders == 0 {
+		t.Fatalf("no faults injected")
+	}
+}
+
+func TestSynapsePartialReads(t *testing.T) {
+	backend := NewFaultyBackend(NewMemoryBackend(4), 3).
+		WithFaults(FaultReadBatch, FaultConfig{
+			ErrorRate:   0.1,
+			PartialRate: 0.5,
+			ReorderRate: 0.5,
+		}).
+		WithFaults(FaultWritePtr, FaultConfig{ErrorRate: 0.1})
+
+	runFaultyPipeline(t, backend, 4, 20, 16)
+
+	if backend.Stats(FaultReadBatch).Partial == 0 || backend.Stats(FaultReadBatch).Reorders == 0 {
+		t.Fatalf("no faults injected")
+	}
+}
+
+func TestFaultyBackendDeterministic(t *testing.T) {
+	decisions := func(seed int64) []bool {
+		backend := NewFaultyBackend(NewMemoryBackend(1), seed).
+			WithFaults(FaultGetPtr, FaultConfig{ErrorRate: 0.5})
+		var res []bool
+		for i := 0; i < 64; i++ {
+			_, err := backend.GetPtr("NQFaultTest", "")
+			res = append(res, err != nil)
+		}
+		return res
+	}
+
+	a, b := decisions(42), decisions(42)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("decision %d differs for the same seed", i)
+		}
+	}
+}
+
+func TestSynapseSlowPointers(t *testing.T) {
+	backend := NewFaultyBackend(NewMemoryBackend(4), 4).
+		WithFaults(FaultWritePtr, FaultConfig{MinLatency: time.Millisecond, MaxLatency: 5 * time.Millisecond, ErrorRate: 0.1}).
+		WithFaults(FaultGetPtr, FaultConfig{MaxLatency: time.Millisecond})
+
+	runFaultyPipeline(t, backend, 4, 20, 16)
+}
@@ -0,0 +1,327 @@
+package nerve
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthFailureTimeout = 30 * time.Second
+	defaultHealthPingTimeout    = 2 * time.Second
+)
+
+// BackendPinger is implemented by backends able to check connectivity without touching queues,
+// backends without it are considered reachable
+type BackendPinger interface {
+	Ping(ctx context.Context) error
+}
+
+type HealthConfig struct {
+	// writers and pointers failing to save longer than that are unhealthy, 30s by default
+	FailureTimeout time.Duration
+	// receivers lagging more packets behind queue writer pointer are unhealthy, 0 disables the check
+	MaxReceiverLag QueueElementIndex
+	// time limit of backend checks, 2s by default
+	PingTimeout time.Duration
+}
+
+type BackendHealth struct {
+	Healthy   bool   `json:"healthy"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// FailureHealth describes writer or pointer which is failing to be saved right now,
+// empty Consumer stands for queue writer pointer
+type FailureHealth struct {
+	Queue        QueueName  `json:"queue"`
+	Consumer     ConsumerId `json:"consumer,omitempty"`
+	Healthy      bool       `json:"healthy"`
+	FailingSince time.Time  `json:"failing_since"`
+	Failures     uint64     `json:"failures"`
+	LastError    string     `json:"last_error"`
+}
+
+type ReceiverHealth struct {
+	Queue       QueueName         `json:"queue"`
+	Consumer    ConsumerId        `json:"consumer"`
+	Healthy     bool              `json:"healthy"`
+	WriterPtr   QueueElementIndex `json:"writer_ptr"`
+	ConsumerPtr QueueElementIndex `json:"consumer_ptr"`
+	Lag         QueueElementIndex `json:"lag"`
+	// packet right after consumer pointer which can't be read, receiver retries it until it appears
+	MissingId    QueueElementIndex `json:"missing_id,omitempty"`
+	MissingSince *time.Time        `json:"missing_since,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Healthy   bool             `json:"healthy"`
+	Host      string           `json:"host"`
+	Backend   BackendHealth    `json:"backend"`
+	Writers   []FailureHealth  `json:"writers"`
+	Pointers  []FailureHealth  `json:"pointers"`
+	Receivers []ReceiverHealth `json:"receivers"`
+}
+
+type failureKey struct {
+	queue    QueueName
+	consumer ConsumerId
+}
+
+type failureState struct {
+	since     time.Time
+	failures  uint64
+	lastError error
+}
+
+// healthTracker collects failures of retry loops, which never give up on their own
+type healthTracker struct {
+	lock      sync.Mutex
+	config    HealthConfig
+	writers   map[QueueName]*failureState
+	pointers  map[failureKey]*failureState
+	receivers map[*Receiver]struct{}
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		config: HealthConfig{
+			FailureTimeout: defaultHealthFailureTimeout,
+			PingTimeout:    defaultHealthPingTimeout,
+		},
+		writers:   make(map[QueueName]*failureState),
+		pointers:  make(map[failureKey]*failureState),
+		receivers: make(map[*Receiver]struct{}),
+	}
+}
+
+func recordFailure(state *failureState, err error) *failureState {
+	if state == nil {
+		state = &failureState{since: time.Now()}
+	}
+	state.failures++
+	state.lastError = err
+	return state
+}
+
+func (h *healthTracker) writeFailed(queue QueueName, err error) {
+	h.lock.Lock()
+	h.writers[queue] = recordFailure(h.writers[queue], err)
+	h.lock.Unlock()
+}
+
+func (h *healthTracker) writeSucceeded(queue QueueName) {
+	h.lock.Lock()
+	delete(h.writers, queue)
+	h.lock.Unlock()
+}
+
+func (h *healthTracker) pointerFailed(queue QueueName, consumer ConsumerId, err error) {
+	k := failureKey{queue: queue, consumer: consumer}
+	h.lock.Lock()
+	h.pointers[k] = recordFailure(h.pointers[k], err)
+	h.lock.Unlock()
+}
+
+func (h *healthTracker) pointerSaved(queue QueueName, consumer ConsumerId) {
+	h.lock.Lock()
+	delete(h.pointers, failureKey{queue: queue, consumer: consumer})
+	h.lock.Unlock()
+}
+
+func (h *healthTracker) addReceiver(r *Receiver) {
+	h.lock.Lock()
+	h.receivers[r] = struct{}{}
+	h.lock.Unlock()
+}
+
+func (h *healthTracker) removeReceiver(r *Receiver) {
+	h.lock.Lock()
+	delete(h.receivers, r)
+	h.lock.Unlock()
+}
+
+func (h *healthTracker) failureHealth(k failureKey, state *failureState, now time.Time) FailureHealth {
+	return FailureHealth{
+		Queue:        k.queue,
+		Consumer:     k.consumer,
+		Healthy:      now.Sub(state.since) < h.config.FailureTimeout,
+		FailingSince: state.since,
+		Failures:     state.failures,
+		LastError:    state.lastError.Error(),
+	}
+}
+
+// snapshot returns failures sorted by queue and consumer along with registered receivers
+func (h *healthTracker) snapshot() (HealthConfig, []FailureHealth, []FailureHealth, []*Receiver) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := time.Now()
+	writers := make([]FailureHealth, 0, len(h.writers))
+	for queue, state := range h.writers {
+		writers = append(writers, h.failureHealth(failureKey{queue: queue}, state, now))
+	}
+	pointers := make([]FailureHealth, 0, len(h.pointers))
+	for k, state := range h.pointers {
+		pointers = append(pointers, h.failureHealth(k, state, now))
+	}
+	receivers := make([]*Receiver, 0, len(h.receivers))
+	for r := range h.receivers {
+		receivers = append(receivers, r)
+	}
+
+	sortFailures(writers)
+	sortFailures(pointers)
+	sort.Slice(receivers, func(i, j int) bool {
+		if receivers[i].QueueName != receivers[j].QueueName {
+			return receivers[i].QueueName < receivers[j].QueueName
+		}
+		return receivers[i].ConsumerId < receivers[j].ConsumerId
+	})
+	return h.config, writers, pointers, receivers
+}
+
+func sortFailures(failures []FailureHealth) {
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].Queue != failures[j].Queue {
+			return failures[i].Queue < failures[j].Queue
+		}
+		return failures[i].Consumer < failures[j].Consumer
+	})
+}
+
+// SetHealthConfig sets thresholds of Health checks, zero durations keep defaults
+func (s *Synapse) SetHealthConfig(config HealthConfig) {
+	if config.FailureTimeout <= 0 {
+		config.FailureTimeout = defaultHealthFailureTimeout
+	}
+	if config.PingTimeout <= 0 {
+		config.PingTimeout = defaultHealthPingTimeout
+	}
+	s.health.lock.Lock()
+	s.health.config = config
+	s.health.lock.Unlock()
+}
+
+// withTimeout runs fn until ctx is done, fn keeps running in background after timeout
+// as backend calls can't be cancelled
+func withTimeout(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Health checks backend connectivity and lag of receivers, and reports writers and pointers
+// failing to be saved. Synapse is unhealthy if backend is unreachable, some writer or pointer
+// fails longer than HealthConfig.FailureTimeout, some receiver waits for missing packet
+// longer than that or lags more than HealthConfig.MaxReceiverLag packets.
+func (s *Synapse) Health(ctx context.Context) *HealthReport {
+	config, writers, pointers, receivers := s.health.snapshot()
+	ctx, cancel := context.WithTimeout(ctx, config.PingTimeout)
+	defer cancel()
+
+	report := &HealthReport{
+		Healthy:   true,
+		Host:      s.Backend.GetHostName(),
+		Writers:   writers,
+		Pointers:  pointers,
+		Receivers: make([]ReceiverHealth, 0, len(receivers)),
+	}
+
+	start := time.Now()
+	err := withTimeout(ctx, func() error {
+		if pinger, ok := s.Backend.(BackendPinger); ok {
+			return pinger.Ping(ctx)
+		}
+		return nil
+	})
+	report.Backend = BackendHealth{Healthy: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		report.Backend.Error = err.Error()
+	}
+
+	for _, r := range receivers {
+		report.Receivers = append(report.Receivers, s.receiverHealth(ctx, r, config, err))
+	}
+
+	report.Healthy = report.Backend.Healthy
+	for _, w := range writers {
+		report.Healthy = report.Healthy && w.Healthy
+	}
+	for _, p := range pointers {
+		report.Healthy = report.Healthy && p.Healthy
+	}
+	for _, r := range report.Receivers {
+		report.Healthy = report.Healthy && r.Healthy
+	}
+
+	if !report.Healthy {
+		s.logger.Warn().Interface("health", report).Msg("synapse is unhealthy")
+	}
+	return report
+}
+
+func (s *Synapse) receiverHealth(ctx context.Context, r *Receiver, config HealthConfig, backendErr error) ReceiverHealth {
+	res := ReceiverHealth{Queue: r.QueueName, Consumer: r.ConsumerId}
+
+	err := backendErr
+	if err == nil {
+		err = withTimeout(ctx, func() error {
+			var err error
+			if res.WriterPtr, err = s.Backend.GetPtr(r.QueueName, ""); err != nil {
+				return err
+			}
+			res.ConsumerPtr, err = s.Backend.GetPtr(r.QueueName, r.ConsumerId)
+			return err
+		})
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Lag = res.WriterPtr - res.ConsumerPtr
+	res.Healthy = config.MaxReceiverLag <= 0 || res.Lag <= config.MaxReceiverLag
+	if id, since := r.gap(); id != 0 {
+		res.MissingId = id
+		res.MissingSince = &since
+		res.Healthy = res.Healthy && time.Since(since) < config.FailureTimeout
+	}
+	return res
+}
+
+// HealthHandler serves Health report as json with 200 status if synapse is healthy
+// and 503 otherwise, it's meant for readiness probes taking instance out of rotation
+func (s *Synapse) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		report := s.Health(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			s.logger.Error().Err(err).Msg("error writing health report")
+		}
+	})
+}
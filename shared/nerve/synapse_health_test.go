@@ -0,0 +1,94 @@
+package nerve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSynapseHealth(t *testing.T) {
+	queue := QueueConfig{Name: "NQHealthTest"}
+	s := NewSynapse(NewMemoryBackend(2))
+	s.SetHealthConfig(HealthConfig{MaxReceiverLag: 2})
+	receiver := s.GetReceiver(queue, NCTest)
+	defer receiver.Close()
+
+	sendEvents(t, s, queue, "a", "b")
+	report := s.Health(context.Background())
+	if !report.Healthy || !report.Backend.Healthy || len(report.Receivers) != 1 || report.Receivers[0].Lag != 2 {
+		t.Fatalf("unexpected health report: %+v", report)
+	}
+
+	sendEvents(t, s, queue, "c")
+	report = s.Health(context.Background())
+	if report.Healthy || report.Receivers[0].Healthy || report.Receivers[0].Lag != 3 {
+		t.Fatalf("lagging receiver is reported as healthy: %+v", report)
+	}
+
+	for i := 0; i < 3; i++ {
+		receiver.Ack(<-receiver.DataChan)
+	}
+	waitForPtr(t, s.Backend, queue, NCTest, 3)
+	if report = s.Health(context.Background()); !report.Healthy {
+		t.Fatalf("receiver is still lagging: %+v", report)
+	}
+}
+
+func TestSynapseHealthFailures(t *testing.T) {
+	s := NewSynapse(NewMemoryBackend(1))
+	s.SetHealthConfig(HealthConfig{FailureTimeout: 50 * time.Millisecond})
+
+	errWrite := errors.New("write failed")
+	s.health.writeFailed("NQHealthTest", errWrite)
+	s.health.pointerFailed("NQHealthTest", NCTest, errWrite)
+	s.health.pointerFailed("NQHealthTest", NCTest, errWrite)
+
+	report := s.Health(context.Background())
+	if !report.Healthy || len(report.Writers) != 1 || len(report.Pointers) != 1 {
+		t.Fatalf("recent failures must be reported without failing health: %+v", report)
+	}
+	if p := report.Pointers[0]; p.Failures != 2 || p.Consumer != NCTest || p.LastError != errWrite.Error() {
+		t.Fatalf("unexpected pointer failure: %+v", p)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if report = s.Health(context.Background()); report.Healthy || report.Writers[0].Healthy || report.Pointers[0].Healthy {
+		t.Fatalf("stuck writer and pointer must fail health: %+v", report)
+	}
+
+	s.health.writeSucceeded("NQHealthTest")
+	s.health.pointerSaved("NQHealthTest", NCTest)
+	if report = s.Health(context.Background()); !report.Healthy || len(report.Writers) != 0 || len(report.Pointers) != 0 {
+		t.Fatalf("failures must be cleared after success: %+v", report)
+	}
+}
+
+func TestSynapseHealthHandler(t *testing.T) {
+	backend := NewFaultyBackend(NewMemoryBackend(1), 1).
+		WithFaults(FaultPing, FaultConfig{ErrorRate: 1})
+	s := NewSynapse(backend)
+
+	rec := httptest.NewRecorder()
+	s.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unreachable backend gives status %d", rec.Code)
+	}
+
+	var report HealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Healthy || report.Backend.Healthy || report.Backend.Error != ErrInjectedFault.Error() {
+		t.Fatalf("unexpected health report: %+v", report)
+	}
+
+	rec = httptest.NewRecorder()
+	NewSynapse(NewMemoryBackend(1)).HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("healthy synapse gives status %d", rec.Code)
+	}
+}
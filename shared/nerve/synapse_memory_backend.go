@@ -0,0 +1,87 @@
+package nerve
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryBackend keeps queues in memory, it's meant for tests only
+type MemoryBackend struct {
+	lock        sync.RWMutex
+	queues      map[QueueName]map[QueueElementIndex][]byte
+	pointers    map[string]QueueElementIndex
+	parallelism uint
+}
+
+func NewMemoryBackend(parallelism uint) *MemoryBackend {
+	if parallelism == 0 {
+		parallelism = 1
+	}
+
+	return &MemoryBackend{
+		queues:      make(map[QueueName]map[QueueElementIndex][]byte),
+		pointers:    make(map[string]QueueElementIndex),
+		parallelism: parallelism,
+	}
+}
+
+func (m *MemoryBackend) WriteBatch(name QueueName, data []*Packet) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	queue, exists := m.queues[name]
+	if !exists {
+		queue = make(map[QueueElementIndex][]byte)
+		m.queues[name] = queue
+	}
+	for _, packet := range data {
+		queue[packet.DbId] = packet.Data
+	}
+
+	return nil
+}
+
+func (m *MemoryBackend) WritePtr(name QueueName, consumer ConsumerId, ptr QueueElementIndex) error {
+	m.lock.Lock()
+	m.pointers[getPtrKeyName(name, consumer)] = ptr
+	m.lock.Unlock()
+
+	return nil
+}
+
+func (m *MemoryBackend) GetDefaultQueueParallelism(_ QueueName) uint {
+	return m.parallelism
+}
+
+func (m *MemoryBackend) GetPtr(name QueueName, consumer ConsumerId) (QueueElementIndex, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.pointers[getPtrKeyName(name, consumer)], nil
+}
+
+func (m *MemoryBackend) ReadBatch(name QueueName, data []*Packet) ([]*Packet, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	queue := m.queues[name]
+	result := make([]*Packet, 0, len(data))
+	for _, packet := range data {
+		if msg, exists := queue[packet.DbId]; exists {
+			result = append(result, &Packet{Data: msg, DbId: packet.DbId})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DbId < result[j].DbId
+	})
+
+	return result, nil
+}
+
+func (m *MemoryBackend) SetTrace(_ bool) {
+}
+
+func (m *MemoryBackend) GetHostName() string {
+	return "memory"
+}
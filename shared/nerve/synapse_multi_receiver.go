@@ -0,0 +1,223 @@
+package nerve
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+type multiSourceKey struct {
+	queue    QueueName
+	consumer ConsumerId
+}
+
+type multiSource struct {
+	key      multiSourceKey
+	receiver *Receiver
+	weight   int
+}
+
+// MultiReceiverPacket is a packet received by MultiReceiver along with its source
+type MultiReceiverPacket struct {
+	*Packet
+	QueueName  QueueName
+	ConsumerId ConsumerId
+}
+
+// MultiReceiver merges several queues into one stream, every queue is read by its own
+// Receiver (so has its own consumer pointer), sources are scheduled with weighted round-robin:
+// during each round source with weight N may deliver up to N packets.
+type MultiReceiver struct {
+	DataChan chan *MultiReceiverPacket
+
+	synapse     *Synapse
+	sources     []*multiSource
+	sourcesLock sync.RWMutex
+	wakeChan    chan struct{}
+	terminate   chan struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+	bufferSize  int
+}
+
+// NewMultiReceiver creates MultiReceiver without sources, add them with AddQueue.
+// bufferSize is the buffer of every source, DataChan itself is unbuffered,
+// so packets are picked by scheduler only when they are about to be consumed
+func (s *Synapse) NewMultiReceiver(bufferSize int) *MultiReceiver {
+	m := &MultiReceiver{
+		DataChan:   make(chan *MultiReceiverPacket),
+		synapse:    s,
+		wakeChan:   make(chan struct{}, 1),
+		terminate:  make(chan struct{}),
+		done:       make(chan struct{}),
+		bufferSize: bufferSize,
+	}
+
+	go m.scheduler()
+
+	return m
+}
+
+// AddQueue starts receiving queue as consumer, weight is the share of packets
+// queue gets when all queues have data
+func (m *MultiReceiver) AddQueue(queue QueueConfig, consumer ConsumerId, weight int) error {
+	if weight <= 0 {
+		return fmt.Errorf("invalid weight %d for queue %s", weight, queue.Name)
+	}
+
+	key := multiSourceKey{queue: queue.Name, consumer: consumer}
+	m.sourcesLock.Lock()
+	for _, source := range m.sources {
+		if source.key == key {
+			m.sourcesLock.Unlock()
+			return fmt.Errorf("queue %s is already received as %s", queue.Name, consumer)
+		}
+	}
+	m.sources = append(m.sources, &multiSource{
+		key:      key,
+		receiver: m.synapse.GetBufferedReceiver(queue, consumer, m.bufferSize),
+		weight:   weight,
+	})
+	m.sourcesLock.Unlock()
+
+	m.wake()
+	return nil
+}
+
+// RemoveQueue stops receiving queue, packets of the queue which are not acked yet
+// will be received again by the next receiver of the queue
+func (m *MultiReceiver) RemoveQueue(queue QueueConfig, consumer ConsumerId) bool {
+	key := multiSourceKey{queue: queue.Name, consumer: consumer}
+	m.sourcesLock.Lock()
+	var removed *multiSource
+	for i, source := range m.sources {
+		if source.key == key {
+			removed = source
+			m.sources = append(m.sources[:i:i], m.sources[i+1:]...)
+			break
+		}
+	}
+	m.sourcesLock.Unlock()
+
+	if removed == nil {
+		return false
+	}
+
+	removed.receiver.Close()
+	m.wake()
+	return true
+}
+
+func (m *MultiReceiver) getSource(key multiSourceKey) *multiSource {
+	m.sourcesLock.RLock()
+	defer m.sourcesLock.RUnlock()
+	for _, source := range m.sources {
+		if source.key == key {
+			return source
+		}
+	}
+	return nil
+}
+
+// Ack routes ack to the receiver of packet's queue
+func (m *MultiReceiver) Ack(p *MultiReceiverPacket) {
+	source := m.getSource(multiSourceKey{queue: p.QueueName, consumer: p.ConsumerId})
+	if source == nil {
+		m.synapse.logger.Warn().
+			Str("queue", string(p.QueueName)).
+			Str("consumer", string(p.ConsumerId)).
+			Int64("id", int64(p.DbId)).
+			Msg("ack for removed queue, skip ack")
+		return
+	}
+	source.receiver.Ack(p.Packet)
+}
+
+// Close stops all receivers, DataChan is not closed. It's safe to call Close several times
+func (m *MultiReceiver) Close() {
+	m.closeOnce.Do(func() {
+		close(m.terminate)
+		<-m.done
+
+		m.sourcesLock.Lock()
+		sources := m.sources
+		m.sources = nil
+		m.sourcesLock.Unlock()
+
+		for _, source := range sources {
+			source.receiver.Close()
+		}
+	})
+}
+
+func (m *MultiReceiver) wake() {
+	select {
+	case m.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+func (m *MultiReceiver) snapshot() []*multiSource {
+	m.sourcesLock.RLock()
+	defer m.sourcesLock.RUnlock()
+	return append([]*multiSource(nil), m.sources...)
+}
+
+// deliver returns false if MultiReceiver is terminated
+func (m *MultiReceiver) deliver(source *multiSource, packet *Packet) bool {
+	select {
+	case m.DataChan <- &MultiReceiverPacket{Packet: packet, QueueName: source.key.queue, ConsumerId: source.key.consumer}:
+		return true
+	case <-m.terminate:
+		return false
+	}
+}
+
+func (m *MultiReceiver) scheduler() {
+	defer close(m.done)
+
+	for {
+		sources := m.snapshot()
+
+		delivered := 0
+		for _, source := range sources {
+		sourceRound:
+			for i := 0; i < source.weight; i++ {
+				select {
+				case packet := <-source.receiver.DataChan:
+					if !m.deliver(source, packet) {
+						return
+					}
+					delivered++
+				default:
+					break sourceRound
+				}
+			}
+		}
+
+		if delivered > 0 {
+			continue
+		}
+
+		// nothing is ready, wait for any source, terminate or change of sources
+		cases := make([]reflect.SelectCase, 0, len(sources)+2)
+		cases = append(cases,
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.terminate)},
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.wakeChan)})
+		for _, source := range sources {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(source.receiver.DataChan)})
+		}
+
+		chosen, value, _ := reflect.Select(cases)
+		switch chosen {
+		case 0:
+			return
+		case 1:
+			continue
+		default:
+			if !m.deliver(sources[chosen-2], value.Interface().(*Packet)) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package nerve
+
+import (
+	"testing"
+	"time"
+)
+
+func fillQueue(t *testing.T, s *Synapse, queue QueueConfig, n int) {
+	pack := make([]*Packet, n)
+	for i := range pack {
+		pack[i] = &Packet{Data: []byte(queue.Name)}
+	}
+	if err := s.SendPack(queue, pack); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForPtr(t *testing.T, backend SynapseBackend, queue QueueConfig, consumer ConsumerId, ptr QueueElementIndex) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := backend.GetPtr(queue.Name, consumer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == ptr {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s pointer is %d, expected %d", queue.Name, got, ptr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMultiReceiverWeights(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	s := NewSynapse(backend)
+	heavy := QueueConfig{Name: "NQMultiHeavy"}
+	light := QueueConfig{Name: "NQMultiLight"}
+	fillQueue(t, s, heavy, 200)
+	fillQueue(t, s, light, 200)
+
+	m := s.NewMultiReceiver(50)
+	defer m.Close()
+	if err := m.AddQueue(heavy, NCTest, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddQueue(light, NCTest, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// let both receivers fill their buffers so scheduling is not affected by reading
+	deadline := time.Now().Add(5 * time.Second)
+	for _, source := range m.snapshot() {
+		for len(source.receiver.DataChan) < cap(source.receiver.DataChan) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s receiver is not filled", source.key.queue)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	counts := map[QueueName]int{}
+	for i := 0; i < 400; i++ {
+		p := <-m.DataChan
+		if i < 40 {
+			counts[p.QueueName]++
+		}
+		m.Ack(p)
+	}
+
+	if counts[heavy.Name] < 27 || counts[heavy.Name] > 33 {
+		t.Fatalf("expected ~30 of 40 packets from heavy queue, got %v", counts)
+	}
+
+	waitForPtr(t, backend, heavy, NCTest, 200)
+	waitForPtr(t, backend, light, NCTest, 200)
+}
+
+func TestMultiReceiverAddRemove(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	s := NewSynapse(backend)
+	first := QueueConfig{Name: "NQMultiFirst"}
+	second := QueueConfig{Name: "NQMultiSecond"}
+
+	m := s.NewMultiReceiver(10)
+	defer m.Close()
+	if err := m.AddQueue(first, NCTest, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddQueue(first, NCTest, 1); err == nil {
+		t.Fatal("expected error adding the same queue twice")
+	}
+
+	fillQueue(t, s, first, 10)
+	for i := 0; i < 10; i++ {
+		p := <-m.DataChan
+		if p.QueueName != first.Name {
+			t.Fatalf("unexpected queue %s", p.QueueName)
+		}
+		m.Ack(p)
+	}
+	waitForPtr(t, backend, first, NCTest, 10)
+
+	if !m.RemoveQueue(first, NCTest) {
+		t.Fatal("queue is not removed")
+	}
+	if err := m.AddQueue(second, NCTest, 1); err != nil {
+		t.Fatal(err)
+	}
+	fillQueue(t, s, second, 10)
+	fillQueue(t, s, first, 10)
+
+	for i := 0; i < 10; i++ {
+		p := <-m.DataChan
+		if p.QueueName != second.Name {
+			t.Fatalf("got packet from removed queue %s", p.QueueName)
+		}
+		m.Ack(p)
+	}
+	waitForPtr(t, backend, second, NCTest, 10)
+	waitForPtr(t, backend, first, NCTest, 10)
+}
+
+func TestMultiReceiverRemoveBlockedQueue(t *testing.T) {
+	s := NewSynapse(NewMemoryBackend(2))
+	queue := QueueConfig{Name: "NQMultiBlocked"}
+	fillQueue(t, s, queue, 10)
+
+	m := s.NewMultiReceiver(1)
+	defer m.Close()
+	if err := m.AddQueue(queue, NCTest, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing reads DataChan, so receiver is blocked delivering packets
+	receiver := m.getSource(multiSourceKey{queue: queue.Name, consumer: NCTest}).receiver
+	deadline := time.Now().Add(5 * time.Second)
+	for len(receiver.DataChan) < cap(receiver.DataChan) {
+		if time.Now().After(deadline) {
+			t.Fatal("receiver is not filled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !m.RemoveQueue(queue, NCTest) {
+		t.Fatal("queue is not removed")
+	}
+
+	// terminate signal is taken only by exiting receiver goroutine
+	deadline = time.Now().Add(5 * time.Second)
+	for len(receiver.TerminateReceiverChan) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("receiver goroutine is not stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMultiReceiverCloseTwice(t *testing.T) {
+	s := NewSynapse(NewMemoryBackend(2))
+	m := s.NewMultiReceiver(1)
+	if err := m.AddQueue(QueueConfig{Name: "NQMultiClose"}, NCTest, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Close()
+	m.Close()
+}
@@ -19,6 +19,7 @@
 package nerve
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strings"
@@ -43,6 +44,26 @@ type SMysqlBackendConfig struct {
 	TableParallelism    uint `json:"table-parallelism"`
 	PointersParallelism uint `json:"pointers-parallelism"`
 	MaxRPSPerThread     uint `json:"max-rps"`
+
+	// non-zero PreviousTableParallelism turns on resharding mode:
+	// new data goes to `TableParallelism` tables, while reads fall back
+	// to the previous layout for everything up to the cutover index
+	PreviousTableParallelism uint `json:"previous-table-parallelism"`
+
+	// non-zero PreviousPointersParallelism turns on pointers migration mode:
+	// pointers are written to `PointersParallelism` tables, while reads fall back
+	// to the previous layout for keys missing in the new one. It may be equal to
+	// PointersParallelism to move keys of unsharded tables to their shards
+	PreviousPointersParallelism uint `json:"previous-pointers-parallelism"`
+
+	// pointers are spread over `PointersParallelism` tables only with ShardedPointers set
+	// or while migrating, otherwise they are written to the first table, as before sharding.
+	// It's set once pointers are migrated, see synapse_mysql_pointers.go
+	ShardedPointers bool `json:"sharded-pointers"`
+
+	// name of PayloadCodec used to compress packets data, empty means no compression,
+	// data is decoded by the codec stored within row, so codec could be changed any time
+	Compression string `json:"compression"`
 }
 
 type threadID QueueElementIndex
@@ -58,6 +79,13 @@ type SMysqlBackend struct {
 	Batches        uint64
 	Packets        uint64
 	rpsTimerRead   time.Time
+
+	reshardCutovers     map[QueueName]reshardCutover
+	reshardCutoversLock sync.RWMutex
+
+	codec           PayloadCodec
+	queueCodecs     map[QueueName]PayloadCodec
+	queueCodecsLock sync.RWMutex
 }
 
 func GetMySQLBackendForQueue(queueName QueueConfig, host string) (SynapseBackend, error) {
@@ -73,10 +101,24 @@ func GetMySQLBackendForQueue(queueName QueueConfig, host string) (SynapseBackend
 		TableParallelism:    backendConfig.TableParallelism,
 		PointersParallelism: backendConfig.PointersParallelism,
 		MaxRPSPerThread:     backendConfig.MaxRPSPerThread,
+
+		PreviousTableParallelism:    backendConfig.PreviousTableParallelism,
+		PreviousPointersParallelism: backendConfig.PreviousPointersParallelism,
+		ShardedPointers:             backendConfig.ShardedPointers,
+		Compression:                 backendConfig.Compression,
 	})
 }
 
 func NewSMysqlBackend(config SMysqlBackendConfig) (*SMysqlBackend, error) {
+	if config.PointersParallelism == 0 {
+		config.PointersParallelism = 1
+	}
+
+	codec, err := GetPayloadCodec(config.Compression)
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := unidb.NewUniDB().
 		WithHost(config.Host).
 		WithDB(config.DbName).
@@ -132,6 +174,13 @@ func NewSMysqlBackend(config SMysqlBackendConfig) (*SMysqlBackend, error) {
 		trace:          true,
 		rpsLock:        sync.RWMutex{},
 		rpsTimers:      make(map[threadID]time.Time),
+
+		reshardCutovers:     make(map[QueueName]reshardCutover),
+		reshardCutoversLock: sync.RWMutex{},
+
+		codec:           codec,
+		queueCodecs:     make(map[QueueName]PayloadCodec),
+		queueCodecsLock: sync.RWMutex{},
 	}, nil
 }
 
@@ -139,16 +188,62 @@ func (s *SMysqlBackend) GetHostName() string {
 	return s.config.Host
 }
 
+// Ping checks connection to nerve database
+func (s *SMysqlBackend) Ping(ctx context.Context) error {
+	return s.Db.GetRawDB().PingContext(ctx)
+}
+
 func (s *SMysqlBackend) SetTrace(trace bool) {
 	s.trace = trace
 }
 
+// SetQueueCompression overrides `Compression` of backend config for queue
+func (s *SMysqlBackend) SetQueueCompression(queueName QueueName, codecName string) error {
+	codec, err := GetPayloadCodec(codecName)
+	if err != nil {
+		return err
+	}
+
+	s.queueCodecsLock.Lock()
+	s.queueCodecs[queueName] = codec
+	s.queueCodecsLock.Unlock()
+	return nil
+}
+
+func (s *SMysqlBackend) getQueueCodec(queueName QueueName) PayloadCodec {
+	s.queueCodecsLock.RLock()
+	defer s.queueCodecsLock.RUnlock()
+	if codec, exists := s.queueCodecs[queueName]; exists {
+		return codec
+	}
+	return s.codec
+}
+
 func (s *SMysqlBackend) ReadBatch(queueName QueueName, data []*Packet) ([]*Packet, error) {
-	tables := s.getTableNamesForQueue(queueName)
+	if !queueName.IsValid() {
+		return nil, fmt.Errorf("invalid queue name %q", queueName)
+	}
+
+	result, err := s.readBatchFromTables(s.getTableNamesForQueue(queueName), data)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.isResharding() && len(result) < len(data) {
+		result, err = s.readMissingFromPreviousLayout(queueName, data, result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (s *SMysqlBackend) readBatchFromTables(tables []string, data []*Packet) ([]*Packet, error) {
 	splittedBatch := make(map[QueueElementIndex][]int)
 	var shardsCnt = 0
 	for i := range data {
-		shardIdx := data[i].DbId % QueueElementIndex(s.config.TableParallelism)
+		shardIdx := data[i].DbId % QueueElementIndex(len(tables))
 		if splittedBatch[shardIdx] == nil {
 			shardsCnt += 1
 		}
@@ -180,14 +275,13 @@ func (s *SMysqlBackend) ReadBatch(queueName QueueName, data []*Packet) ([]*Packe
 				strings.Join(ids, ","))
 
 			rows, err := s.Db.GetRawDB().Query(query)
-			defer rows.Close()
-
 			if err != nil {
 				errorsLock.Lock()
 				errors[shardId] = fmt.Errorf("error querying database: %v", err)
 				errorsLock.Unlock()
 				return
 			}
+			defer rows.Close()
 
 			for rows.Next() {
 				var id QueueElementIndex
@@ -201,6 +295,18 @@ func (s *SMysqlBackend) ReadBatch(queueName QueueName, data []*Packet) ([]*Packe
 					return
 				}
 
+				decoded, err := decodePayload(msg)
+				if err != nil {
+					// rows written before marker-prefixed payloads were framed are raw,
+					// failing here would stop every reader of the queue on this row
+					s.logger.Error().Err(err).
+						Str("table", tables[shardId]).
+						Int64("id", int64(id)).
+						Msg("error decoding packet, delivering it as stored")
+				} else {
+					msg = decoded
+				}
+
 				resultLock.Lock()
 				result = append(result, &Packet{
 					Data: msg,
@@ -245,6 +351,7 @@ func (s *SMysqlBackend) WriteBatch(queueName QueueName, data []*Packet) error {
 	}
 
 	tables := s.getTableNamesForQueue(queueName)
+	codec := s.getQueueCodec(queueName)
 
 	if s.trace {
 		s.logger.Info().Interface("sb", splittedBatch).Msg("sending to mysql")
@@ -277,9 +384,18 @@ when I'm writing this. There are two things you should know:
 		dataRecords := make([]interface{}, len(offsets))
 		size := uint64(0)
 		for i, offset := range offsets {
+			packetCodec := codec
+			if isEncryptedPayload(data[offset].Data) {
+				// encrypted payloads are compressed before encryption
+				packetCodec = nil
+			}
+			encoded, err := encodePayload(packetCodec, data[offset].Data)
+			if err != nil {
+				return err
+			}
 			tokens[i] = fmt.Sprintf("(%d, ?)", data[offset].DbId)
-			dataRecords[i] = data[offset].Data
-			size += uint64(len(data[offset].Data))
+			dataRecords[i] = encoded
+			size += uint64(len(encoded))
 		}
 
 		query := fmt.Sprintf(`insert into %s (id, data) values %s on duplicate key update data=values(data)`,
@@ -315,6 +431,9 @@ func getPtrKeyName(name QueueName, consumer ConsumerId) string {
 }
 
 func (s *SMysqlBackend) WritePtr(name QueueName, consumer ConsumerId, ptr QueueElementIndex) error {
+	if !name.IsValid() {
+		return fmt.Errorf("invalid queue name %q", name)
+	}
 	if consumer == "" {
 		// ensure maxRPSPerThread
 		s.rpsLock.RLock()
@@ -329,10 +448,8 @@ func (s *SMysqlBackend) WritePtr(name QueueName, consumer ConsumerId, ptr QueueE
 		s.rpsLock.Unlock()
 		// done
 	}
-	pointersTables := s.getTableNamesForPointers(name)
-
 	query := fmt.Sprintf("insert into %s (id, ptr) values (?, ?) on duplicate key update ptr = values(ptr)",
-		pointersTables[0])
+		s.getPointersWriteTable(name, consumer))
 	_, err := s.Db.GetRawDB().Exec(query, getPtrKeyName(name, consumer), ptr)
 	if err != nil {
 		return err
@@ -346,31 +463,32 @@ func (s *SMysqlBackend) GetPtr(name QueueName, consumer ConsumerId) (QueueElemen
 		return 0, err
 	}
 
-	pointersTables := s.getTableNamesForPointers(name)
-	query := fmt.Sprintf("select ptr from %s where id = ?", pointersTables[0])
-
-	rows, err := s.Db.GetRawDB().Query(query, getPtrKeyName(name, consumer))
-	if err != nil {
-		return 0, err
-	}
-
-	defer rows.Close()
-
-	for rows.Next() {
-		var ptr QueueElementIndex
-		if err = rows.Scan(&ptr); err != nil {
-			return 0, err
+	key := getPtrKeyName(name, consumer)
+	for _, table := range s.getPointersLookupTables(name, consumer) {
+		ptr, found, err := s.readPtrFromTable(table, key)
+		if err != nil || found {
+			return ptr, err
 		}
-
-		return ptr, nil
 	}
 
+	if consumer == "" && s.isMigratingPointers() {
+		return s.recoverWriterPtr(name)
+	}
 	return 0, nil
 }
 
 func (s *SMysqlBackend) ensureTablesExists(name QueueName) error {
+	// queue name is a part of table names in queries
+	if !name.IsValid() {
+		return fmt.Errorf("invalid queue name %q", name)
+	}
+
 	dataTables := s.getTableNamesForQueue(name)
 	pointersTables := s.getTableNamesForPointers(name)
+	if s.isMigratingPointers() {
+		pointersTables = append(pointersTables,
+			getPointersTableNamesForLayout(name, s.config.PreviousPointersParallelism)...)
+	}
 
 	missingDataTables := make([]int, 0)
 	missingPointersTables := make([]int, 0)
@@ -388,7 +506,7 @@ func (s *SMysqlBackend) ensureTablesExists(name QueueName) error {
 	s.tableCacheLock.RUnlock()
 
 	var err error
-	if len(missingDataTables) > 0 {
+	if len(missingDataTables) > 0 || len(missingPointersTables) > 0 {
 		logger := s.logger.With().
 			Str("queue", string(name)).
 			Logger()
@@ -408,6 +526,9 @@ func (s *SMysqlBackend) ensureTablesExists(name QueueName) error {
 		}
 
 		for _, idx := range missingPointersTables {
+			if err != nil {
+				break
+			}
 			tblName := pointersTables[idx]
 
 			err = s.makeTable(logger, fmt.Sprintf(`
@@ -441,22 +562,20 @@ func (s *SMysqlBackend) makeTable(logger zerolog.Logger, query, tblName string)
 }
 
 func (s *SMysqlBackend) getTableNamesForQueue(name QueueName) []string {
-	results := make([]string, s.config.TableParallelism)
-	for i := uint(0); i < s.config.TableParallelism; i++ {
-		results[i] = fmt.Sprintf("queue_%s_%03d_%04d", name, s.config.TableParallelism, i)
+	return getTableNamesForLayout(name, s.config.TableParallelism)
+}
+
+func getTableNamesForLayout(name QueueName, parallelism uint) []string {
+	results := make([]string, parallelism)
+	for i := uint(0); i < parallelism; i++ {
+		results[i] = fmt.Sprintf("queue_%s_%03d_%04d", name, parallelism, i)
 	}
 
 	return results
 }
 
 func (s *SMysqlBackend) getTableNamesForPointers(name QueueName) []string {
-	results := make([]string, s.config.PointersParallelism)
-	for i := uint(0); i < s.config.PointersParallelism; i++ {
-		results[i] = fmt.Sprintf("queue_%s_%03d_%04d_pointers", name,
-			s.config.PointersParallelism, i)
-	}
-
-	return results
+	return getPointersTableNamesForLayout(name, s.config.PointersParallelism)
 }
 
 func (s *SMysqlBackend) GetDefaultQueueParallelism(_ QueueName) uint {
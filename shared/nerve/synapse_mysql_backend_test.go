@@ -0,0 +1,84 @@
+package nerve
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// mysql backed tests need a server, e.g. `NERVE_TEST_MYSQL_HOST=127.0.0.1 go test ./shared/nerve`,
+// database is taken from NERVE_TEST_MYSQL_DB and should exist
+func newTestMysqlBackend(t *testing.T, config SMysqlBackendConfig) *SMysqlBackend {
+	t.Helper()
+	host := os.Getenv("NERVE_TEST_MYSQL_HOST")
+	if host == "" {
+		t.Skip("NERVE_TEST_MYSQL_HOST is not set")
+	}
+
+	config.Host = host
+	config.DbName = os.Getenv("NERVE_TEST_MYSQL_DB")
+	if config.DbName == "" {
+		config.DbName = "nerve"
+	}
+	if config.TableParallelism == 0 {
+		config.TableParallelism = 1
+	}
+	if config.MaxRPSPerThread == 0 {
+		config.MaxRPSPerThread = 1000
+	}
+
+	backend, err := NewSMysqlBackend(config)
+	if err != nil {
+		t.Fatalf("failed to connect to mysql: %v", err)
+	}
+	backend.SetTrace(false)
+	return backend
+}
+
+// getTestQueueName returns unique queue name, its tables are dropped after the test
+func getTestQueueName(t *testing.T, backend *SMysqlBackend) QueueName {
+	name := QueueName(fmt.Sprintf("NQTest%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		rows, err := backend.Db.GetRawDB().Query(fmt.Sprintf("show tables like 'queue_%s_%%'", name))
+		if err != nil {
+			t.Errorf("failed to list tables of %s: %v", name, err)
+			return
+		}
+		var tables []string
+		for rows.Next() {
+			var table string
+			if err = rows.Scan(&table); err == nil {
+				tables = append(tables, table)
+			}
+		}
+		_ = rows.Close()
+		for _, table := range tables {
+			if _, err = backend.Db.GetRawDB().Exec("drop table " + table); err != nil {
+				t.Errorf("failed to drop %s: %v", table, err)
+			}
+		}
+	})
+	return name
+}
+
+func getTestPackets(from, to QueueElementIndex) []*Packet {
+	packets := make([]*Packet, 0, to-from+1)
+	for id := from; id <= to; id++ {
+		packets = append(packets, &Packet{DbId: id, Data: []byte(fmt.Sprintf("packet-%d", id))})
+	}
+	return packets
+}
+
+func checkTestPackets(t *testing.T, packets []*Packet, from, to QueueElementIndex) {
+	t.Helper()
+	if len(packets) != int(to-from+1) {
+		t.Fatalf("got %d packets, want %d", len(packets), to-from+1)
+	}
+	for i, packet := range packets {
+		id := from + QueueElementIndex(i)
+		if packet.DbId != id || string(packet.Data) != fmt.Sprintf("packet-%d", id) {
+			t.Fatalf("packet %d: got %d %q", id, packet.DbId, packet.Data)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package nerve
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+)
+
+// Pointer keys (`queue:consumer`) are spread over `PointersParallelism` tables by crc32 of the key.
+// Table names contain the number of tables, so changing it needs migration:
+//   - every instance gets `PreviousPointersParallelism` in its queue config, from now on
+//     pointers are written to the new layout, reads fall back to the old one for missing keys;
+//   - MigratePointers copies keys missing in the new layout from the old one;
+//   - once it's done, `PreviousPointersParallelism` is replaced with `ShardedPointers`
+//     in config and old pointers tables can be removed.
+//
+// Before sharding every key was stored in the first table (`_0000`) of the layout, whatever
+// `PointersParallelism` was. Instances without `ShardedPointers` keep writing there, so rolling
+// deploy doesn't leave shards behind pointers still written by old binaries. Reads always fall
+// back to it, and MigratePointers with `PreviousPointersParallelism` equal to
+// `PointersParallelism` moves such keys to their shards.
+//
+// Once every instance runs with migration config, pointers are written to the new layout only,
+// so values there are never older than the ones in the old layout and MigratePointers keeps them.
+// It must not be run before all instances are switched: a pointer written to the old layout
+// after its key is copied would be lost.
+
+func (s *SMysqlBackend) isMigratingPointers() bool {
+	return s.config.PreviousPointersParallelism != 0
+}
+
+func getPointersTableName(name QueueName, parallelism, shard uint) string {
+	return fmt.Sprintf("queue_%s_%03d_%04d_pointers", name, parallelism, shard)
+}
+
+func getPointersTableNamesForLayout(name QueueName, parallelism uint) []string {
+	results := make([]string, parallelism)
+	for i := uint(0); i < parallelism; i++ {
+		results[i] = getPointersTableName(name, parallelism, i)
+	}
+
+	return results
+}
+
+// getPointersShard is the same as `crc32(key) % parallelism` in mysql
+func getPointersShard(key string, parallelism uint) uint {
+	return uint(crc32.ChecksumIEEE([]byte(key)) % uint32(parallelism))
+}
+
+func getPointersTableForLayout(name QueueName, consumer ConsumerId, parallelism uint) string {
+	return getPointersTableName(name, parallelism, getPointersShard(getPtrKeyName(name, consumer), parallelism))
+}
+
+// getPointersWriteTable returns key shard in the current layout once pointers are sharded,
+// legacy `_0000` table otherwise
+func (s *SMysqlBackend) getPointersWriteTable(name QueueName, consumer ConsumerId) string {
+	if s.config.ShardedPointers || s.isMigratingPointers() {
+		return getPointersTableForLayout(name, consumer, s.config.PointersParallelism)
+	}
+	return getPointersTableName(name, s.config.PointersParallelism, 0)
+}
+
+// getPointersLookupTables returns tables to look for the key in, in order of preference:
+// the one pointers are written to, then its shard and legacy `_0000` table in the current layout,
+// then the same for the previous layout
+func (s *SMysqlBackend) getPointersLookupTables(name QueueName, consumer ConsumerId) []string {
+	layouts := []uint{s.config.PointersParallelism}
+	if s.isMigratingPointers() {
+		layouts = append(layouts, s.config.PreviousPointersParallelism)
+	}
+
+	tables := []string{s.getPointersWriteTable(name, consumer)}
+	seen := map[string]struct{}{tables[0]: {}}
+	for _, parallelism := range layouts {
+		for _, table := range []string{
+			getPointersTableForLayout(name, consumer, parallelism),
+			getPointersTableName(name, parallelism, 0),
+		} {
+			if _, exists := seen[table]; !exists {
+				seen[table] = struct{}{}
+				tables = append(tables, table)
+			}
+		}
+	}
+
+	return tables
+}
+
+func (s *SMysqlBackend) readPtrFromTable(table, key string) (QueueElementIndex, bool, error) {
+	rows, err := s.Db.GetRawDB().Query(fmt.Sprintf("select ptr from %s where id = ?", table), key)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, false, rows.Err()
+	}
+	var ptr QueueElementIndex
+	if err = rows.Scan(&ptr); err != nil {
+		return 0, false, err
+	}
+	return ptr, true, nil
+}
+
+// MigratePointers copies pointers of queue from `PreviousPointersParallelism` tables
+// to `PointersParallelism` ones, keys already present in the new layout are kept.
+// Returns number of copied pointers.
+func (s *SMysqlBackend) MigratePointers(name QueueName) (int64, error) {
+	if !s.isMigratingPointers() {
+		return 0, fmt.Errorf("queue %s: previous pointers parallelism is not set", name)
+	}
+	if err := s.ensureTablesExists(name); err != nil {
+		return 0, err
+	}
+
+	var copied int64
+	to := s.config.PointersParallelism
+	// legacy keys of the current layout are in its first table
+	sources := append(getPointersTableNamesForLayout(name, s.config.PreviousPointersParallelism),
+		getPointersTableName(name, to, 0))
+	seen := make(map[string]struct{})
+	for _, from := range sources {
+		if _, exists := seen[from]; exists {
+			continue
+		}
+		seen[from] = struct{}{}
+		for shard, table := range getPointersTableNamesForLayout(name, to) {
+			if table == from {
+				continue
+			}
+			res, err := s.Db.GetRawDB().Exec(fmt.Sprintf(
+				"insert ignore into %s (id, ptr) select id, ptr from %s where crc32(id) %% ? = ?",
+				table, from), to, shard)
+			if err != nil {
+				return copied, fmt.Errorf("error copying pointers from %s to %s: %w", from, table, err)
+			}
+			n, _ := res.RowsAffected()
+			copied += n
+		}
+	}
+
+	s.logger.Info().
+		Str("queue", string(name)).
+		Uint("from", s.config.PreviousPointersParallelism).
+		Uint("to", to).
+		Int64("copied", copied).
+		Msg("pointers migrated")
+	return copied, nil
+}
+
+// getMaxIdFromTables returns the biggest id stored in data tables, 0 if they are empty
+func (s *SMysqlBackend) getMaxIdFromTables(tables []string) (QueueElementIndex, error) {
+	var maxId QueueElementIndex
+	for _, table := range tables {
+		var id sql.NullInt64
+		err := s.Db.GetRawDB().QueryRow(fmt.Sprintf("select max(id) from %s", table)).Scan(&id)
+		if err != nil {
+			return 0, fmt.Errorf("error reading max id from %s: %w", table, err)
+		}
+		if id.Valid && QueueElementIndex(id.Int64) > maxId {
+			maxId = QueueElementIndex(id.Int64)
+		}
+	}
+
+	return maxId, nil
+}
+
+// recoverWriterPtr is used when writer pointer is missing while pointers are migrated:
+// it can't be told from not written yet one, so the pointer is restored from stored data,
+// otherwise writer would start from 0 and overwrite queue
+func (s *SMysqlBackend) recoverWriterPtr(name QueueName) (QueueElementIndex, error) {
+	tables := s.getTableNamesForQueue(name)
+	if s.isResharding() {
+		tables = append(tables, getTableNamesForLayout(name, s.config.PreviousTableParallelism)...)
+	}
+	ptr, err := s.getMaxIdFromTables(tables)
+	if err != nil {
+		return 0, err
+	}
+
+	if ptr != 0 {
+		s.logger.Warn().
+			Str("queue", string(name)).
+			Int64("ptr", int64(ptr)).
+			Msg("writer pointer is missing, recovered from queue data")
+	}
+	return ptr, nil
+}
@@ -0,0 +1,132 @@
+package nerve
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPointersSharding(t *testing.T) {
+	// MigratePointers relies on mysql `crc32` giving the same shards, crc32('MySQL') = 3259397556
+	if shard := getPointersShard("MySQL", 1000); shard != 556 {
+		t.Fatalf("unexpected shard %d", shard)
+	}
+
+	// single table layout is kept as is, so existing deployments don't need migration
+	if table := getPointersTableForLayout("NQLocalTest", NCTest, 1); table != "queue_NQLocalTest_001_0000_pointers" {
+		t.Fatalf("unexpected single table %s", table)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		consumer := ConsumerId(fmt.Sprintf("consumer-%d", i))
+		table := getPointersTableForLayout("NQLocalTest", consumer, 4)
+		if again := getPointersTableForLayout("NQLocalTest", consumer, 4); again != table {
+			t.Fatalf("%s is mapped to %s and %s", consumer, table, again)
+		}
+		counts[table]++
+	}
+	if len(counts) != 4 {
+		t.Fatalf("keys are spread over %d tables", len(counts))
+	}
+	for table, n := range counts {
+		if n < 150 {
+			t.Errorf("only %d keys of 1000 in %s", n, table)
+		}
+	}
+}
+
+func TestPointersLookupTables(t *testing.T) {
+	s := &SMysqlBackend{config: SMysqlBackendConfig{PointersParallelism: 4}}
+	consumer := getConsumerForShard(t, "NQLocalTest", 4, 2)
+
+	// until pointers are migrated they are written to legacy table, as old binaries do
+	if table := s.getPointersWriteTable("NQLocalTest", consumer); table != "queue_NQLocalTest_004_0000_pointers" {
+		t.Fatalf("unexpected write table before migration %s", table)
+	}
+	expected := []string{"queue_NQLocalTest_004_0000_pointers", "queue_NQLocalTest_004_0002_pointers"}
+	if tables := s.getPointersLookupTables("NQLocalTest", consumer); fmt.Sprint(tables) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected lookup tables before migration %v", tables)
+	}
+
+	s.config.ShardedPointers = true
+	if table := s.getPointersWriteTable("NQLocalTest", consumer); table != "queue_NQLocalTest_004_0002_pointers" {
+		t.Fatalf("unexpected write table %s", table)
+	}
+	expected = []string{"queue_NQLocalTest_004_0002_pointers", "queue_NQLocalTest_004_0000_pointers"}
+	if tables := s.getPointersLookupTables("NQLocalTest", consumer); fmt.Sprint(tables) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected lookup tables %v", tables)
+	}
+
+	s.config.ShardedPointers = false
+
+	// migration of legacy tables of the same layout adds nothing
+	s.config.PreviousPointersParallelism = 4
+	if tables := s.getPointersLookupTables("NQLocalTest", consumer); fmt.Sprint(tables) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected lookup tables while migrating legacy tables %v", tables)
+	}
+
+	s.config.PreviousPointersParallelism = 1
+	expected = append(expected, "queue_NQLocalTest_001_0000_pointers")
+	if tables := s.getPointersLookupTables("NQLocalTest", consumer); fmt.Sprint(tables) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected lookup tables while migrating %v", tables)
+	}
+}
+
+func getConsumerForShard(t *testing.T, name QueueName, parallelism, shard uint) ConsumerId {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		consumer := ConsumerId(fmt.Sprintf("consumer-%d", i))
+		if getPointersShard(getPtrKeyName(name, consumer), parallelism) == shard {
+			return consumer
+		}
+	}
+	t.Fatalf("no consumer for shard %d of %d", shard, parallelism)
+	return ""
+}
+
+func TestMysqlBackendLegacyPointers(t *testing.T) {
+	legacy := newTestMysqlBackend(t, SMysqlBackendConfig{PointersParallelism: 4})
+	queue := getTestQueueName(t, legacy)
+	consumer := getConsumerForShard(t, queue, 4, 3)
+	legacyTable := getPointersTableName(queue, 4, 0)
+
+	// before sharding every key of the queue was stored in the first table
+	if err := legacy.WriteBatch(queue, getTestPackets(1, 10)); err != nil {
+		t.Fatalf("WriteBatch failed: %v", err)
+	}
+	_, err := legacy.Db.GetRawDB().Exec(fmt.Sprintf("insert into %s (id, ptr) values (?, ?)", legacyTable),
+		getPtrKeyName(queue, consumer), 7)
+	if err != nil {
+		t.Fatalf("failed to write legacy pointer: %v", err)
+	}
+
+	if ptr, err := legacy.GetPtr(queue, consumer); err != nil || ptr != 7 {
+		t.Fatalf("legacy consumer pointer: got %d, %v", ptr, err)
+	}
+
+	// instances not switched to sharded pointers keep writing legacy table
+	if err = legacy.WritePtr(queue, consumer, 8); err != nil {
+		t.Fatalf("WritePtr failed: %v", err)
+	}
+	if ptr, found, err := legacy.readPtrFromTable(legacyTable, getPtrKeyName(queue, consumer)); err != nil || !found || ptr != 8 {
+		t.Fatalf("legacy table pointer: got %d, %v, %v", ptr, found, err)
+	}
+
+	// writer pointer is lost: it's recovered from data while migrating rather than restarted from 0
+	migrating := newTestMysqlBackend(t, SMysqlBackendConfig{PointersParallelism: 4, PreviousPointersParallelism: 4})
+	if ptr, err := migrating.GetPtr(queue, ""); err != nil || ptr != 10 {
+		t.Fatalf("recovered writer pointer: got %d, %v", ptr, err)
+	}
+
+	if _, err := migrating.MigratePointers(queue); err != nil {
+		t.Fatalf("MigratePointers failed: %v", err)
+	}
+	if _, err := migrating.Db.GetRawDB().Exec(fmt.Sprintf("delete from %s", legacyTable)); err != nil {
+		t.Fatalf("failed to clean legacy table: %v", err)
+	}
+
+	after := newTestMysqlBackend(t, SMysqlBackendConfig{PointersParallelism: 4, ShardedPointers: true})
+	if ptr, err := after.GetPtr(queue, consumer); err != nil || ptr != 8 {
+		t.Fatalf("migrated consumer pointer: got %d, %v", ptr, err)
+	}
+}
@@ -0,0 +1,287 @@
+package nerve
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Resharding moves queue from `PreviousTableParallelism` tables to `TableParallelism` tables:
+//   - every instance gets `PreviousTableParallelism` in its queue config, from now on all
+//     writes go to the new layout, reads fall back to the old one for missing ids;
+//   - Resharder records the cutover index (current writer pointer) and copies old rows
+//     into the new layout, saving its progress in pointers table;
+//   - once Resharder is done, `PreviousTableParallelism` can be dropped from config
+//     and old tables can be removed.
+//
+// Pointers tables do not depend on `TableParallelism`, so consumers keep their positions.
+
+const defaultReshardBatchSize QueueElementIndex = 10_000
+
+// until Resharder records cutover every read with misses would ask for it,
+// so missing cutover is cached too and checked again after this interval
+const reshardCutoverRecheckInterval = 10 * time.Second
+
+type reshardCutover struct {
+	index     QueueElementIndex
+	checkedAt time.Time
+}
+
+func getReshardConsumerId(from, to uint, kind string) ConsumerId {
+	return ConsumerId(fmt.Sprintf("__reshard_%03d_%03d_%s", from, to, kind))
+}
+
+func (s *SMysqlBackend) isResharding() bool {
+	return s.config.PreviousTableParallelism != 0 &&
+		s.config.PreviousTableParallelism != s.config.TableParallelism
+}
+
+// getReshardCutover returns cutover index for queue or 0 if Resharder has not recorded it yet
+func (s *SMysqlBackend) getReshardCutover(name QueueName) (QueueElementIndex, error) {
+	s.reshardCutoversLock.RLock()
+	cached, exists := s.reshardCutovers[name]
+	s.reshardCutoversLock.RUnlock()
+	// cutover never changes once recorded
+	if exists && (cached.index != 0 || time.Since(cached.checkedAt) < reshardCutoverRecheckInterval) {
+		return cached.index, nil
+	}
+
+	cutover, err := s.GetPtr(name, getReshardConsumerId(s.config.PreviousTableParallelism,
+		s.config.TableParallelism, "cutover"))
+	if err != nil {
+		return 0, err
+	}
+
+	s.reshardCutoversLock.Lock()
+	s.reshardCutovers[name] = reshardCutover{index: cutover, checkedAt: time.Now()}
+	s.reshardCutoversLock.Unlock()
+
+	return cutover, nil
+}
+
+func (s *SMysqlBackend) readMissingFromPreviousLayout(name QueueName, data, found []*Packet) ([]*Packet, error) {
+	cutover, err := s.getReshardCutover(name)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := getMissingBeforeCutover(data, found, cutover)
+	if len(missing) == 0 {
+		return found, nil
+	}
+
+	if s.trace {
+		s.logger.Info().
+			Str("queue", string(name)).
+			Int("missing", len(missing)).
+			Int64("cutover", int64(cutover)).
+			Msg("reading from previous layout")
+	}
+
+	previous, err := s.readBatchFromTables(getTableNamesForLayout(name, s.config.PreviousTableParallelism), missing)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergePacketsById(found, previous), nil
+}
+
+// getMissingBeforeCutover returns packets of data not found in the new layout,
+// everything above non-zero cutover is written to the new layout only
+func getMissingBeforeCutover(data, found []*Packet, cutover QueueElementIndex) []*Packet {
+	foundIds := make(map[QueueElementIndex]struct{}, len(found))
+	for _, packet := range found {
+		foundIds[packet.DbId] = struct{}{}
+	}
+
+	missing := make([]*Packet, 0, len(data)-len(found))
+	for _, packet := range data {
+		if _, exists := foundIds[packet.DbId]; exists {
+			continue
+		}
+		if cutover != 0 && packet.DbId > cutover {
+			continue
+		}
+		missing = append(missing, packet)
+	}
+
+	return missing
+}
+
+// mergePacketsById merges two slices sorted by DbId, packet of `a` wins if id is in both,
+// as rows copied by Resharder are in both layouts
+func mergePacketsById(a, b []*Packet) []*Packet {
+	result := make([]*Packet, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i].DbId == b[j].DbId {
+			result = append(result, a[i])
+			i++
+			j++
+		} else if a[i].DbId < b[j].DbId {
+			result = append(result, a[i])
+			i++
+		} else {
+			result = append(result, b[j])
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	result = append(result, b[j:]...)
+
+	return result
+}
+
+type Resharder struct {
+	backend   *SMysqlBackend
+	queueName QueueName
+	from      uint
+	to        uint
+	batchSize QueueElementIndex
+	logger    zerolog.Logger
+}
+
+// NewResharder creates resharder for queue, backend must be in resharding mode
+func NewResharder(backend *SMysqlBackend, queueName QueueName) (*Resharder, error) {
+	if !backend.isResharding() {
+		return nil, fmt.Errorf("backend for %s is not in resharding mode: previous-table-parallelism=%d, table-parallelism=%d",
+			queueName, backend.config.PreviousTableParallelism, backend.config.TableParallelism)
+	}
+
+	return &Resharder{
+		backend:   backend,
+		queueName: queueName,
+		from:      backend.config.PreviousTableParallelism,
+		to:        backend.config.TableParallelism,
+		batchSize: defaultReshardBatchSize,
+		logger: backend.logger.With().
+			Str("queue", string(queueName)).
+			Uint("from", backend.config.PreviousTableParallelism).
+			Uint("to", backend.config.TableParallelism).
+			Logger(),
+	}, nil
+}
+
+func (r *Resharder) WithBatchSize(batchSize QueueElementIndex) *Resharder {
+	if batchSize > 0 {
+		r.batchSize = batchSize
+	}
+	return r
+}
+
+// Run copies all rows of previous layout into the new one, it's safe to restart
+// as progress is saved after every batch. Run it only after every producer
+// and consumer of the queue runs with resharding config.
+func (r *Resharder) Run() error {
+	if err := r.backend.ensureTablesExists(r.queueName); err != nil {
+		return err
+	}
+
+	cutover, err := r.ensureCutover()
+	if err != nil {
+		return err
+	}
+
+	progressId := getReshardConsumerId(r.from, r.to, "progress")
+	progress, err := r.backend.GetPtr(r.queueName, progressId)
+	if err != nil {
+		return err
+	}
+
+	ts := time.Now()
+	var copied int64
+	for {
+		lastId, err := r.getPreviousLayoutMaxId()
+		if err != nil {
+			return err
+		}
+
+		if progress >= lastId {
+			r.logger.Info().
+				Int64("cutover", int64(cutover)).
+				Int64("progress", int64(progress)).
+				Int64("copied", copied).
+				Dur("took", time.Since(ts)).
+				Msg("resharding done, previous-table-parallelism can be removed from queue config")
+			return nil
+		}
+
+		for progress < lastId {
+			upTo := minIndex(progress+r.batchSize, lastId)
+			n, err := r.copyRange(progress, upTo)
+			if err != nil {
+				return err
+			}
+
+			if err = r.backend.WritePtr(r.queueName, progressId, upTo); err != nil {
+				return err
+			}
+
+			progress = upTo
+			copied += n
+			r.logger.Info().
+				Int64("progress", int64(progress)).
+				Int64("last-id", int64(lastId)).
+				Float64("done", float64(progress)*100/float64(lastId)).
+				Int64("copied", copied).
+				Float64("rows-per-sec", float64(copied)/time.Since(ts).Seconds()).
+				Msg("resharding")
+		}
+	}
+}
+
+// ensureCutover records current writer pointer as cutover index if it's not recorded yet:
+// everything above cutover is written by producers to the new layout only
+func (r *Resharder) ensureCutover() (QueueElementIndex, error) {
+	cutoverId := getReshardConsumerId(r.from, r.to, "cutover")
+	cutover, err := r.backend.GetPtr(r.queueName, cutoverId)
+	if err != nil {
+		return 0, err
+	}
+	if cutover != 0 {
+		r.logger.Info().Int64("cutover", int64(cutover)).Msg("resuming resharding")
+		return cutover, nil
+	}
+
+	cutover, err = r.backend.GetPtr(r.queueName, "")
+	if err != nil {
+		return 0, err
+	}
+	if err = r.backend.WritePtr(r.queueName, cutoverId, cutover); err != nil {
+		return 0, err
+	}
+
+	r.logger.Info().Int64("cutover", int64(cutover)).Msg("starting resharding")
+	return cutover, nil
+}
+
+func (r *Resharder) getPreviousLayoutMaxId() (QueueElementIndex, error) {
+	return r.backend.getMaxIdFromTables(getTableNamesForLayout(r.queueName, r.from))
+}
+
+// copyRange copies rows with ids in (from, to] from previous layout to the new one
+func (r *Resharder) copyRange(from, to QueueElementIndex) (int64, error) {
+	oldTables := getTableNamesForLayout(r.queueName, r.from)
+	newTables := getTableNamesForLayout(r.queueName, r.to)
+
+	var copied int64
+	for _, oldTable := range oldTables {
+		for shardId, newTable := range newTables {
+			// `insert ignore` keeps rows written by producers in the new layout untouched
+			res, err := r.backend.Db.GetRawDB().Exec(fmt.Sprintf(
+				"insert ignore into %s (id, data) select id, data from %s where id > ? and id <= ? and id %% ? = ?",
+				newTable, oldTable), from, to, r.to, shardId)
+			if err != nil {
+				return copied, fmt.Errorf("error copying %s to %s: %w", oldTable, newTable, err)
+			}
+
+			n, err := res.RowsAffected()
+			if err == nil {
+				copied += n
+			}
+		}
+	}
+
+	return copied, nil
+}
@@ -0,0 +1,135 @@
+package nerve
+
+import (
+	"testing"
+)
+
+func getTestIds(packets []*Packet) []QueueElementIndex {
+	ids := make([]QueueElementIndex, 0, len(packets))
+	for _, packet := range packets {
+		ids = append(ids, packet.DbId)
+	}
+	return ids
+}
+
+func getPacketsByIds(ids ...QueueElementIndex) []*Packet {
+	packets := make([]*Packet, 0, len(ids))
+	for _, id := range ids {
+		packets = append(packets, &Packet{DbId: id})
+	}
+	return packets
+}
+
+func TestMergePacketsById(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []QueueElementIndex
+		want []QueueElementIndex
+	}{
+		{"empty", nil, nil, []QueueElementIndex{}},
+		{"only new layout", []QueueElementIndex{1, 2}, nil, []QueueElementIndex{1, 2}},
+		{"only previous layout", nil, []QueueElementIndex{1, 2}, []QueueElementIndex{1, 2}},
+		{"interleaved", []QueueElementIndex{2, 5, 6}, []QueueElementIndex{1, 3, 4, 7}, []QueueElementIndex{1, 2, 3, 4, 5, 6, 7}},
+		{"copied rows", []QueueElementIndex{1, 2, 3}, []QueueElementIndex{2, 3, 4}, []QueueElementIndex{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := getPacketsByIds(tt.a...), getPacketsByIds(tt.b...)
+			got := mergePacketsById(a, b)
+			if ids := getTestIds(got); len(ids) != len(tt.want) {
+				t.Fatalf("got %v, want %v", ids, tt.want)
+			}
+			for i, id := range getTestIds(got) {
+				if id != tt.want[i] {
+					t.Fatalf("got %v, want %v", getTestIds(got), tt.want)
+				}
+			}
+			// the new layout wins for rows present in both
+			fromNewLayout := make(map[QueueElementIndex]*Packet, len(a))
+			for _, packet := range a {
+				fromNewLayout[packet.DbId] = packet
+			}
+			for _, packet := range got {
+				if p, exists := fromNewLayout[packet.DbId]; exists && p != packet {
+					t.Fatalf("packet %d is taken from previous layout", packet.DbId)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMissingBeforeCutover(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []QueueElementIndex
+		found   []QueueElementIndex
+		cutover QueueElementIndex
+		want    []QueueElementIndex
+	}{
+		{"all found", []QueueElementIndex{1, 2, 3}, []QueueElementIndex{1, 2, 3}, 0, nil},
+		{"no cutover yet", []QueueElementIndex{1, 2, 3, 4}, []QueueElementIndex{3}, 0, []QueueElementIndex{1, 2, 4}},
+		{"above cutover", []QueueElementIndex{1, 2, 3, 4}, []QueueElementIndex{1}, 2, []QueueElementIndex{2}},
+		{"cutover itself", []QueueElementIndex{5, 6}, nil, 5, []QueueElementIndex{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getTestIds(getMissingBeforeCutover(getPacketsByIds(tt.data...), getPacketsByIds(tt.found...), tt.cutover))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestResharder(t *testing.T) {
+	before := newTestMysqlBackend(t, SMysqlBackendConfig{TableParallelism: 2})
+	queue := getTestQueueName(t, before)
+
+	if err := before.WriteBatch(queue, getTestPackets(1, 10)); err != nil {
+		t.Fatalf("WriteBatch failed: %v", err)
+	}
+	if err := before.WritePtr(queue, "", 10); err != nil {
+		t.Fatalf("WritePtr failed: %v", err)
+	}
+
+	// every instance switches to resharding config, old rows are read from the previous layout
+	resharding := newTestMysqlBackend(t, SMysqlBackendConfig{TableParallelism: 3, PreviousTableParallelism: 2})
+	if err := resharding.WriteBatch(queue, getTestPackets(11, 12)); err != nil {
+		t.Fatalf("WriteBatch failed: %v", err)
+	}
+	if err := resharding.WritePtr(queue, "", 12); err != nil {
+		t.Fatalf("WritePtr failed: %v", err)
+	}
+	packets, err := resharding.ReadBatch(queue, getTestPackets(1, 12))
+	if err != nil {
+		t.Fatalf("ReadBatch failed: %v", err)
+	}
+	checkTestPackets(t, packets, 1, 12)
+
+	resharder, err := NewResharder(resharding, queue)
+	if err != nil {
+		t.Fatalf("NewResharder failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = resharder.WithBatchSize(4).Run(); err != nil {
+			t.Fatalf("Run #%d failed: %v", i, err)
+		}
+	}
+	if cutover, err := resharding.GetPtr(queue, getReshardConsumerId(2, 3, "cutover")); err != nil || cutover != 12 {
+		t.Fatalf("cutover: got %d, %v", cutover, err)
+	}
+
+	// previous layout is not needed anymore
+	after := newTestMysqlBackend(t, SMysqlBackendConfig{TableParallelism: 3})
+	if packets, err = after.ReadBatch(queue, getTestPackets(1, 12)); err != nil {
+		t.Fatalf("ReadBatch failed: %v", err)
+	}
+	checkTestPackets(t, packets, 1, 12)
+}
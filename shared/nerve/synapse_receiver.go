@@ -19,6 +19,7 @@
 package nerve
 
 import (
+	"errors"
 	"github.com/rs/zerolog"
 	"sort"
 	"sync"
@@ -32,8 +33,9 @@ func (s *Synapse) newReceiver(logger *zerolog.Logger, queueName QueueName, consu
 		ConsumerId:            consumer,
 		QueueName:             queueName,
 		DataChan:              make(chan *Packet, bufSuze),
-		TerminateReceiverChan: make(chan struct{}),
-		TerminateReaderChan:   make(chan struct{}),
+		TerminateReceiverChan: make(chan struct{}, 1),
+		TerminateReaderChan:   make(chan struct{}, 1),
+		readerDone:            make(chan struct{}),
 		Synapse:               s,
 		ackBuffer:             make([]QueueElementIndex, 0),
 		lastAckedId:           0,
@@ -42,15 +44,27 @@ func (s *Synapse) newReceiver(logger *zerolog.Logger, queueName QueueName, consu
 		logger:                &l,
 	}
 
+	s.health.addReceiver(r)
 	go r.readerAckManager()
 	go r.receiverBody()
 
 	return r
 }
 
+// Close stops receiver, it returns once acks sent before it are flushed to consumer pointer.
+// It's safe to call it more than once
 func (r *Receiver) Close() {
-	r.TerminateReceiverChan <- struct{}{}
-	r.TerminateReaderChan <- struct{}{}
+	r.closeOnce.Do(func() {
+		atomic.StoreInt32(&r.closed, 1)
+		r.Synapse.health.removeReceiver(r)
+		r.TerminateReceiverChan <- struct{}{}
+		r.TerminateReaderChan <- struct{}{}
+	})
+	<-r.readerDone
+}
+
+func (r *Receiver) isClosed() bool {
+	return atomic.LoadInt32(&r.closed) != 0
 }
 
 func (r *Receiver) GetLastPointerFromBackend() QueueElementIndex {
@@ -70,6 +84,10 @@ func (r *Receiver) receiverBody() {
 		var errR error
 		if r.lastReadId == 0 {
 			readerPtr, errR = r.Synapse.Backend.GetPtr(r.QueueName, r.ConsumerId)
+			// only pointer saved in backend is acked, lastReadId may be ahead of acks
+			if errR == nil && readerPtr != 0 {
+				atomic.CompareAndSwapInt64((*int64)(&r.lastAckedId), 0, int64(readerPtr))
+			}
 			if r.Synapse.trace {
 				r.logger.Info().Msgf("got reader ptr %v", readerPtr)
 			}
@@ -85,17 +103,22 @@ func (r *Receiver) receiverBody() {
 				Uint64("err-counter", errCounter).
 				Msg("error reading pointers")
 			errCounter++
+			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 
 		if writerPtr > readerPtr {
 			rp, err := r.readDataFromBackend(readerPtr, writerPtr,
 				r.Synapse.getDefaultReaderLimit(r.QueueName, r.ConsumerId))
-			if err == nil {
+			if err == errReceiverTerminated {
+				return
+			} else if err == nil {
 				if r.Synapse.trace {
 					r.logger.Info().Msgf("set lastReadId to %v", rp)
 				}
 				r.lastReadId = rp
+			} else {
+				time.Sleep(100 * time.Millisecond)
 			}
 		} else {
 			time.Sleep(500 * time.Millisecond)
@@ -110,6 +133,9 @@ func (r *Receiver) receiverBody() {
 	}
 }
 
+var errNoPacketsToDeliver = errors.New("no packets to deliver")
+var errReceiverTerminated = errors.New("receiver is terminated")
+
 func minIndex(a, b QueueElementIndex) QueueElementIndex {
 	if a < b {
 		return a
@@ -126,110 +152,206 @@ func (r *Receiver) readDataFromBackend(readerPtr, writerPtr, limit QueueElementI
 		result[p-readerPtr-1] = &Packet{DbId: p}
 	}
 
-	result, err := r.Synapse.Backend.ReadBatch(r.QueueName, result)
+	result, err := r.Synapse.readBatch(r.QueueName, result)
 	if err != nil {
+		r.logger.Error().
+			Err(err).
+			Int64("reader-ptr", int64(readerPtr)).
+			Msg("error reading packets")
 		return 0, err
 	}
 
-	// log.Info().Interface("last-acked-id", readerPtr-1).Send()
-	if readerPtr != 0 {
-		atomic.CompareAndSwapInt64((*int64)(&r.lastAckedId), 0, int64(readerPtr))
+	// backend may return packets unordered or with gaps, only contiguous
+	// block after readerPtr is delivered, the rest will be read again
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DbId < result[j].DbId
+	})
+	receivedMaxId := readerPtr
+	for _, packet := range result {
+		if packet.DbId == receivedMaxId+1 {
+			receivedMaxId++
+		} else if packet.DbId > receivedMaxId {
+			break
+		}
 	}
 
-	if len(result) == 0 {
-		r.logger.Warn().Msgf("got empty result from backend")
+	if receivedMaxId == readerPtr {
+		if r.skipGap(readerPtr + 1) {
+			return readerPtr + 1, nil
+		}
+		r.logger.Warn().
+			Int64("reader-ptr", int64(readerPtr)).
+			Int("received", len(result)).
+			Msgf("got no packets to deliver from backend")
+		return 0, errNoPacketsToDeliver
 	}
+	r.gapFilled()
 
-	receivedMaxId := result[0].DbId
-	receivedMinId := result[0].DbId
 	for _, packet := range result {
+		if packet.DbId <= readerPtr {
+			continue
+		}
 		if packet.DbId > receivedMaxId {
-			receivedMaxId = packet.DbId
+			break
 		}
-		if r.Synapse.trace {
-			if packet.DbId < receivedMinId {
-				receivedMinId = packet.DbId
-			}
+		// nobody may read DataChan of closed receiver, undelivered packets are read again by the next one
+		select {
+		case r.DataChan <- packet:
+		case <-r.TerminateReceiverChan:
+			return 0, errReceiverTerminated
 		}
-		r.DataChan <- packet
 	}
 	if r.Synapse.trace {
-		r.logger.Info().Msgf("Min pack %v max %v", receivedMinId, receivedMaxId)
+		r.logger.Info().Msgf("Min pack %v max %v", readerPtr+1, receivedMaxId)
 	}
 
 	return receivedMaxId, nil
 }
 
+// skipGap remembers since when packet id is missing, and reports whether it's missing for
+// longer than gap timeout, in that case it's acked, so reader pointer can move over it.
+// Without gap timeout the gap is never skipped and is only reported in Health.
+func (r *Receiver) skipGap(id QueueElementIndex) bool {
+	r.gapLock.Lock()
+	if r.gapId != id {
+		r.gapId = id
+		r.gapSince = time.Now()
+	}
+	waited := time.Since(r.gapSince)
+	r.gapLock.Unlock()
+
+	timeout := r.Synapse.getGapTimeout(r.QueueName)
+	if timeout <= 0 || waited < timeout {
+		return false
+	}
+
+	r.logger.Error().
+		Str("consumer", string(r.ConsumerId)).
+		Int64("id", int64(id)).
+		Dur("waited", waited).
+		Msg("skipping missing packet")
+	r.gapFilled()
+	r.AckId(id)
+	return true
+}
+
+func (r *Receiver) gapFilled() {
+	r.gapLock.Lock()
+	r.gapId = 0
+	r.gapLock.Unlock()
+}
+
+// gap returns id of packet receiver is waiting for and since when, zero id if there is no gap
+func (r *Receiver) gap() (QueueElementIndex, time.Time) {
+	r.gapLock.Lock()
+	defer r.gapLock.Unlock()
+	return r.gapId, r.gapSince
+}
+
 func (r *Receiver) readerAckManager() {
+	defer close(r.readerDone)
 	writing := false
 	doneWriting := make(chan struct{}, 1)
 	for {
 		select {
 		case <-r.TerminateReaderChan:
+			r.flushOnClose(writing, doneWriting)
 			return
 		case <-doneWriting:
-			writing = false
+			// acks received during the write could be waiting for the next flush
+			writing = r.startFlush(doneWriting)
 		case ackedId := <-r.AckChannel:
-			if ackedId <= r.lastAckedId {
-				if r.Synapse.trace {
-					r.logger.Warn().
-						Interface("p", r.lastAckedId).
-						Msg("ackedId <= lastAckedId, skip ack")
-				}
-				continue
+			if r.bufferAck(ackedId) && !writing {
+				writing = r.startFlush(doneWriting)
 			}
+		}
+	}
+}
 
-			r.ackBufferLock.Lock()
-			// log.Info().Interface("buffer-before-append", r.ackBuffer).Send()
-			r.ackBuffer = append(r.ackBuffer, ackedId)
-			if !writing {
-				minId := r.ackBuffer[0]
-				for _, v := range r.ackBuffer {
-					if v < minId {
-						minId = v
-					}
-				}
-				r.ackBufferLock.Unlock()
+// flushOnClose waits for the write in progress and flushes acks left in AckChannel
+func (r *Receiver) flushOnClose(writing bool, doneWriting chan struct{}) {
+	if writing {
+		<-doneWriting
+	}
+	for len(r.AckChannel) > 0 {
+		r.bufferAck(<-r.AckChannel)
+	}
+	for r.startFlush(doneWriting) {
+		<-doneWriting
+	}
+}
 
-				if r.Synapse.trace {
-					r.logger.Info().
-						Interface("p", r.lastAckedId).
-						Interface("minId", minId).
-						Interface("buf", r.ackBuffer).
-						Msg("trying to start write")
-				}
-				if minId <= r.lastAckedId {
-					r.logger.Error().
-						Interface("p", r.lastAckedId).
-						Msg("already acked pointer")
-				}
+// bufferAck adds acked id to ack buffer unless it's acked already
+func (r *Receiver) bufferAck(ackedId QueueElementIndex) bool {
+	if ackedId <= r.getLastAckedId() {
+		if r.Synapse.trace {
+			r.logger.Warn().
+				Interface("p", r.getLastAckedId()).
+				Msg("ackedId <= lastAckedId, skip ack")
+		}
+		return false
+	}
 
-				gotAtLeastOnePacketToMovePtrTo := minId == r.lastAckedId+1
-
-				if gotAtLeastOnePacketToMovePtrTo {
-					writing = true
-					go func(ackedId QueueElementIndex) {
-						_, n := r.tryToFlushReceiver(ackedId)
-						if r.Synapse.trace {
-							r.logger.Info().
-								Str("queue-name", string(r.QueueName)).
-								Str("host", r.Synapse.Backend.GetHostName()).
-								Int("flushed-block-size", n).
-								Interface("acked-id", ackedId).
-								Msg("reader flushed index")
-						}
-						doneWriting <- struct{}{}
-					}(ackedId)
-				}
-			} else {
-				r.ackBufferLock.Unlock()
-			}
+	r.ackBufferLock.Lock()
+	// log.Info().Interface("buffer-before-append", r.ackBuffer).Send()
+	r.ackBuffer = append(r.ackBuffer, ackedId)
+	r.ackBufferLock.Unlock()
+	return true
+}
+
+// startFlush starts moving reader pointer if ack buffer contains next packet after lastAckedId
+func (r *Receiver) startFlush(doneWriting chan struct{}) bool {
+	lastAckedId := r.getLastAckedId()
+
+	r.ackBufferLock.Lock()
+	if len(r.ackBuffer) == 0 {
+		r.ackBufferLock.Unlock()
+		return false
+	}
+	minId := r.ackBuffer[0]
+	for _, v := range r.ackBuffer {
+		if v < minId {
+			minId = v
 		}
 	}
+	r.ackBufferLock.Unlock()
+
+	if r.Synapse.trace {
+		r.logger.Info().
+			Interface("p", lastAckedId).
+			Interface("minId", minId).
+			Interface("buf", r.ackBuffer).
+			Msg("trying to start write")
+	}
+	if minId <= lastAckedId {
+		r.logger.Error().
+			Interface("p", lastAckedId).
+			Msg("already acked pointer")
+	}
+
+	gotAtLeastOnePacketToMovePtrTo := minId == lastAckedId+1
+	if !gotAtLeastOnePacketToMovePtrTo {
+		return false
+	}
+
+	go func(ackedId QueueElementIndex) {
+		_, n := r.tryToFlushReceiver(ackedId)
+		if r.Synapse.trace {
+			r.logger.Info().
+				Str("queue-name", string(r.QueueName)).
+				Str("host", r.Synapse.Backend.GetHostName()).
+				Int("flushed-block-size", n).
+				Interface("acked-id", ackedId).
+				Msg("reader flushed index")
+		}
+		doneWriting <- struct{}{}
+	}(minId)
+
+	return true
 }
 
 func (r *Receiver) tryToFlushReceiver(currentDbId QueueElementIndex) (bool, int) {
-	newReaderPtr := r.lastAckedId
+	newReaderPtr := r.getLastAckedId()
 
 	cnt := 0
 	r.ackBufferLock.Lock()
@@ -268,8 +390,10 @@ func (r *Receiver) tryToFlushReceiver(currentDbId QueueElementIndex) (bool, int)
 		err := r.Synapse.Backend.WritePtr(r.QueueName, r.ConsumerId, newReaderPtr)
 
 		if err == nil {
+			r.Synapse.health.pointerSaved(r.QueueName, r.ConsumerId)
 			break
 		}
+		r.Synapse.health.pointerFailed(r.QueueName, r.ConsumerId, err)
 
 		r.logger.Error().Err(err).Interface("r", r).
 			Msgf("error moving reader pointer to %d", newReaderPtr)
@@ -279,6 +403,10 @@ func (r *Receiver) tryToFlushReceiver(currentDbId QueueElementIndex) (bool, int)
 	return currentDbId <= newReaderPtr, cnt
 }
 
+func (r *Receiver) getLastAckedId() QueueElementIndex {
+	return QueueElementIndex(atomic.LoadInt64((*int64)(&r.lastAckedId)))
+}
+
 // Ack marks packet in question
 func (r *Receiver) Ack(p *Packet) {
 	r.AckChannel <- p.DbId
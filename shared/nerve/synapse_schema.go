@@ -0,0 +1,166 @@
+package nerve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"octopus/shared/gremlin"
+	"octopus/target/generated-sources/protobuf/nerve"
+)
+
+var ErrInvalidPayload = errors.New("payload doesn't match queue schema")
+
+// QueueSchema describes payloads of a queue: either a single gremlin message type
+// or NerveSourcedPacket envelopes with inner message type chosen by NerveSourceType
+type QueueSchema struct {
+	MessageType string
+	Sourced     map[nerve.NerveSourceType]*QueueSchema
+	// decode unmarshals data and reads all fields into generated struct
+	decode func(data []byte) (interface{}, error)
+}
+
+// SchemaReader is implemented by generated readers of message type T
+type SchemaReader[T any] interface {
+	SafeUnmarshal(data []byte, opts gremlin.DecodeOptions) error
+	ToStruct() T
+}
+
+func newSchemaDecoder[T any, R SchemaReader[T]](messageType string, newReader func() R) func(data []byte) (interface{}, error) {
+	return func(data []byte) (interface{}, error) {
+		reader := newReader()
+		// safe unmarshal checks the whole message, so ToStruct never reads malformed data
+		if err := reader.SafeUnmarshal(data, gremlin.DefaultDecodeOptions); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, messageType, err)
+		}
+		return reader.ToStruct(), nil
+	}
+}
+
+// NewMessageSchema creates schema of queue with payloads of single message type T,
+// newReader is generated reader constructor, e.g.
+// `NewMessageSchema[*nerve.NerveTestPacket]("NerveTestPacket", nerve.NewNerveTestPacketReader)`
+func NewMessageSchema[T any, R SchemaReader[T]](messageType string, newReader func() R) *QueueSchema {
+	return &QueueSchema{
+		MessageType: messageType,
+		decode:      newSchemaDecoder[T](messageType, newReader),
+	}
+}
+
+// NewSourcedSchema creates schema of queue with NerveSourcedPacket envelopes
+func NewSourcedSchema(sources map[nerve.NerveSourceType]*QueueSchema) *QueueSchema {
+	return &QueueSchema{
+		MessageType: "NerveSourcedPacket",
+		Sourced:     sources,
+		decode:      newSchemaDecoder[*nerve.NerveSourcedPacket]("NerveSourcedPacket", nerve.NewNerveSourcedPacketReader),
+	}
+}
+
+var queueSchemasLock sync.RWMutex
+var queueSchemas = map[string]*QueueSchema{
+	"TestPacket":   TestPacketSchema,
+	"WindowResult": NewWindowResultSchema(),
+}
+
+// RegisterQueueSchema makes schema available for queue configs by name
+func RegisterQueueSchema(name string, schema *QueueSchema) {
+	queueSchemasLock.Lock()
+	defer queueSchemasLock.Unlock()
+	queueSchemas[name] = schema
+}
+
+// GetQueueSchema returns registered schema by name
+func GetQueueSchema(name string) (*QueueSchema, bool) {
+	queueSchemasLock.RLock()
+	defer queueSchemasLock.RUnlock()
+	schema, exists := queueSchemas[name]
+	return schema, exists
+}
+
+// GetSchema returns queue Schema or registered schema of SchemaName, nil if queue has none
+func (q QueueConfig) GetSchema() *QueueSchema {
+	if q.Schema != nil || q.SchemaName == "" {
+		return q.Schema
+	}
+	schema, _ := GetQueueSchema(q.SchemaName)
+	return schema
+}
+
+type sourcedView struct {
+	Source     string      `json:"source"`
+	SourceId   uint64      `json:"sourceId,omitempty"`
+	SourceName string      `json:"sourceName,omitempty"`
+	Trace      *traceView  `json:"trace,omitempty"`
+	Packet     interface{} `json:"packet"`
+}
+
+// Decode decodes payload into generated struct, envelopes are decoded along with inner packet
+func (q *QueueSchema) Decode(data []byte) (interface{}, error) {
+	decoded, err := q.decode(data)
+	if err != nil || q.Sourced == nil {
+		return decoded, err
+	}
+
+	envelope := decoded.(*nerve.NerveSourcedPacket)
+	inner, exists := q.Sourced[envelope.Source]
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown source type %v", ErrInvalidPayload, envelope.Source)
+	}
+	packet, err := inner.Decode(envelope.Packet)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sourcedView{
+		Source:     envelope.Source.String(),
+		SourceId:   envelope.SourceId,
+		SourceName: envelope.SourceName,
+		Trace:      newTraceView(envelope.Trace),
+		Packet:     packet,
+	}, nil
+}
+
+func (q *QueueSchema) Validate(data []byte) error {
+	_, err := q.Decode(data)
+	return err
+}
+
+// FormatPacket pretty-prints stored packet of queue using queue schema
+func FormatPacket(queue QueueConfig, p *Packet) (string, error) {
+	schema := queue.GetSchema()
+	if schema == nil {
+		return "", fmt.Errorf("queue %s has no schema", queue.Name)
+	}
+
+	decoded, err := schema.Decode(p.Data)
+	if err != nil {
+		return "", err
+	}
+
+	formatted, err := json.MarshalIndent(decoded, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s #%d %s\n%s", queue.Name, p.DbId, schema.MessageType, formatted), nil
+}
+
+// validatePackets checks payloads against queue schema before any of them is sent
+func validatePackets(queue QueueConfig, packets []*Packet) error {
+	if queue.SchemaName != "" && queue.Schema == nil {
+		if _, exists := GetQueueSchema(queue.SchemaName); !exists {
+			return fmt.Errorf("queue %s has unknown schema %s", queue.Name, queue.SchemaName)
+		}
+	}
+	schema := queue.GetSchema()
+	if schema == nil {
+		return nil
+	}
+
+	for i, packet := range packets {
+		if err := schema.Validate(packet.Data); err != nil {
+			return fmt.Errorf("packet %d of %d for %s: %w", i, len(packets), queue.Name, err)
+		}
+	}
+	return nil
+}
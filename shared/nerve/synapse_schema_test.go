@@ -0,0 +1,69 @@
+package nerve
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"octopus/target/generated-sources/protobuf/nerve"
+)
+
+func TestQueueSchemaValidate(t *testing.T) {
+	schema := getTestQueue(t, "NQLocalSourcedTest").GetSchema()
+
+	if err := schema.Validate(NewTestPacketData([]byte("test"))); err != nil {
+		t.Fatalf("valid packet is rejected: %v", err)
+	}
+
+	unknownSource := &nerve.NerveSourcedPacket{Source: 42, Packet: []byte{0x08, 0x01}}
+	malformedInner := &nerve.NerveSourcedPacket{Packet: []byte("test")}
+	invalid := map[string][]byte{
+		"raw bytes":       []byte("test"),
+		"truncated":       NewTestPacketData([]byte("test"))[:5],
+		"unknown source":  unknownSource.Marshal(),
+		"malformed inner": malformedInner.Marshal(),
+	}
+	for name, data := range invalid {
+		if err := schema.Validate(data); !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("%s: expected invalid payload, got %v", name, err)
+		}
+	}
+}
+
+func TestSynapseSendValidation(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	s := NewSynapse(backend)
+	queue := QueueConfig{Name: "NQSchemaTest", SchemaName: getTestQueue(t, "NQLocalSourcedTest").SchemaName}
+
+	pack := []*Packet{
+		{Data: NewTestPacketData([]byte("first"))},
+		{Data: []byte("garbage")},
+	}
+	if err := s.SendPack(queue, pack); !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("expected invalid payload, got %v", err)
+	}
+	if _, err := s.Send(queue, &Packet{Data: []byte("garbage")}); !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("expected invalid payload, got %v", err)
+	}
+	if ptr, _ := backend.GetPtr(queue.Name, ""); ptr != 0 {
+		t.Fatalf("packets of rejected pack are written, writer pointer is %d", ptr)
+	}
+
+	if err := s.SendPack(queue, pack[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := s.GetReceiver(queue, NCTest)
+	defer receiver.Close()
+	data := receiveN(t, receiver, 1)
+
+	formatted, err := FormatPacket(queue, &Packet{DbId: 1, Data: data[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"NQSchemaTest #1 NerveSourcedPacket", `"source": "NST_TEST"`, `"payload": "Zmlyc3Q="`} {
+		if !strings.Contains(formatted, expected) {
+			t.Errorf("%q not found in\n%s", expected, formatted)
+		}
+	}
+}
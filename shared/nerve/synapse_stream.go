@@ -0,0 +1,364 @@
+package nerve
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog"
+	"octopus/shared/gremlin"
+	"octopus/target/generated-sources/protobuf/nerve"
+)
+
+const defaultCheckpointInterval = 5 * time.Second
+
+// streamCheckpointId is the only row of checkpoints queue, every checkpoint overwrites it
+const streamCheckpointId QueueElementIndex = 1
+
+// WindowConfig describes event time windows: every Slide a new window of Size starts.
+// Windows are closed and emitted once events newer than window end + AllowedLateness are seen,
+// events of closed windows are dropped as late.
+type WindowConfig struct {
+	Size            time.Duration
+	Slide           time.Duration
+	AllowedLateness time.Duration
+}
+
+func TumblingWindow(size time.Duration) WindowConfig {
+	return WindowConfig{Size: size, Slide: size}
+}
+
+func SlidingWindow(size, slide time.Duration) WindowConfig {
+	return WindowConfig{Size: size, Slide: slide}
+}
+
+// alignStart returns start of the last window starting at or before ts
+func (w WindowConfig) alignStart(ts int64) int64 {
+	slide := int64(w.Slide)
+	if ts%slide < 0 {
+		return ts - ts%slide - slide
+	}
+	return ts - ts%slide
+}
+
+// windowStarts returns starts of all windows containing ts
+func (w WindowConfig) windowStarts(ts int64) []int64 {
+	size, slide := int64(w.Size), int64(w.Slide)
+	last := w.alignStart(ts)
+
+	res := make([]int64, 0, size/slide+1)
+	for start := last; start > ts-size; start -= slide {
+		res = append(res, start)
+	}
+	return res
+}
+
+// StreamEvent is what aggregation sees of source packet: values of events
+// with the same key and window are aggregated into NerveWindowResult
+type StreamEvent struct {
+	Key   string
+	Time  time.Time
+	Value float64
+}
+
+// StreamExtractor gets event from packet, packets it returns error for are skipped
+type StreamExtractor func(p *Packet) (StreamEvent, error)
+
+// StreamAggregationConfig describes aggregation of Source queue into Output queue of NerveWindowResult.
+// Checkpoints queue keeps aggregation state, it must not be shared with other aggregations,
+// and only one aggregator of the config may run at a time.
+type StreamAggregationConfig struct {
+	Source      QueueConfig
+	Consumer    ConsumerId
+	Output      QueueConfig
+	Checkpoints QueueConfig
+
+	Window  WindowConfig
+	Extract StreamExtractor
+
+	// how often state is saved if no windows are closed, 5s by default
+	CheckpointInterval time.Duration
+	BufferSize         int
+}
+
+type windowKey struct {
+	key   string
+	start int64
+}
+
+// StreamAggregator aggregates source queue packets by key and window.
+//
+// Window state is saved into the single row of checkpoints queue along with index of the last aggregated
+// source packet, source packets are acked only after that, so after restart the state is
+// restored from the last checkpoint and packets aggregated before it are skipped.
+// Results of closed windows are sent before the checkpoint removing them, so after a crash
+// between the two a result may be sent again with the same key, window and values.
+type StreamAggregator struct {
+	synapse *Synapse
+	config  StreamAggregationConfig
+	logger  zerolog.Logger
+
+	windows      map[windowKey]*nerve.NerveWindowResult
+	ptr          QueueElementIndex
+	maxEventTime int64
+	// aggregated packets waiting for checkpoint to be acked
+	pending []*Packet
+
+	// counters are updated by Run, read them after it returns
+	Processed uint64
+	Late      uint64
+	Invalid   uint64
+}
+
+func (s *Synapse) NewStreamAggregator(config StreamAggregationConfig) (*StreamAggregator, error) {
+	w := config.Window
+	if w.Size <= 0 || w.Slide <= 0 || w.Slide > w.Size || w.AllowedLateness < 0 {
+		return nil, fmt.Errorf("invalid window: size=%v, slide=%v, lateness=%v", w.Size, w.Slide, w.AllowedLateness)
+	}
+	if config.Extract == nil {
+		return nil, fmt.Errorf("stream extractor is required")
+	}
+	if config.Source.Name == "" || config.Output.Name == "" || config.Checkpoints.Name == "" || config.Consumer == "" {
+		return nil, fmt.Errorf("source, output and checkpoints queues and consumer are required")
+	}
+	if config.CheckpointInterval <= 0 {
+		config.CheckpointInterval = defaultCheckpointInterval
+	}
+
+	return &StreamAggregator{
+		synapse: s,
+		config:  config,
+		logger: s.logger.With().
+			Str("queue", string(config.Source.Name)).
+			Str("consumer", string(config.Consumer)).
+			Logger(),
+		windows:      make(map[windowKey]*nerve.NerveWindowResult),
+		maxEventTime: math.MinInt64,
+	}, nil
+}
+
+// NewWindowResultSchema returns schema of aggregation output queues
+func NewWindowResultSchema() *QueueSchema {
+	return NewMessageSchema[*nerve.NerveWindowResult]("NerveWindowResult", nerve.NewNerveWindowResultReader)
+}
+
+// Run restores state from the last checkpoint and aggregates source packets until ctx
+// is cancelled, state is checkpointed before return.
+func (a *StreamAggregator) Run(ctx context.Context) error {
+	if err := a.restore(); err != nil {
+		return err
+	}
+
+	receiver := a.synapse.GetBufferedReceiver(a.config.Source, a.config.Consumer, a.config.BufferSize)
+	defer receiver.Close()
+
+	ticker := time.NewTicker(a.config.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := a.flush(receiver); err != nil {
+				return err
+			}
+			return ctx.Err()
+		case packet := <-receiver.DataChan:
+			if !a.process(packet) {
+				continue
+			}
+			if err := a.flush(receiver); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := a.flush(receiver); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (a *StreamAggregator) restore() error {
+	ptr, err := a.synapse.Backend.GetPtr(a.config.Checkpoints.Name, "")
+	if err != nil || ptr == 0 {
+		return err
+	}
+
+	packets, err := a.synapse.readBatch(a.config.Checkpoints.Name, []*Packet{{DbId: ptr}})
+	if err != nil {
+		return err
+	}
+	if len(packets) != 1 {
+		return fmt.Errorf("checkpoint %d of %s is not found", ptr, a.config.Checkpoints.Name)
+	}
+
+	reader := nerve.NewNerveStreamCheckpointReader()
+	if err = reader.SafeUnmarshal(packets[0].Data, gremlin.DefaultDecodeOptions); err != nil {
+		return fmt.Errorf("error reading checkpoint %d of %s: %w", ptr, a.config.Checkpoints.Name, err)
+	}
+	checkpoint := reader.ToStruct()
+
+	a.ptr = QueueElementIndex(checkpoint.Ptr)
+	a.maxEventTime = checkpoint.MaxEventTime
+	for _, window := range checkpoint.Windows {
+		a.windows[windowKey{key: window.Key, start: window.WindowStart}] = window
+	}
+
+	a.logger.Info().
+		Int64("checkpoint", int64(ptr)).
+		Int64("ptr", int64(a.ptr)).
+		Int("windows", len(a.windows)).
+		Msg("stream aggregation state restored")
+	return nil
+}
+
+// process aggregates packet, returns true if some windows are closed
+func (a *StreamAggregator) process(packet *Packet) bool {
+	a.pending = append(a.pending, packet)
+	if packet.DbId <= a.ptr {
+		// aggregated before the last checkpoint, but not acked
+		return false
+	}
+	a.ptr = packet.DbId
+	a.Processed++
+
+	event, err := a.config.Extract(packet)
+	if err != nil {
+		a.Invalid++
+		a.logger.Warn().Err(err).Int64("id", int64(packet.DbId)).Msg("skipping packet")
+		return false
+	}
+
+	ts := event.Time.UnixNano()
+	closed := false
+	if ts > a.maxEventTime {
+		closed = a.hasClosedWindows(a.maxEventTime, ts)
+		a.maxEventTime = ts
+	}
+
+	watermark := a.watermark()
+	for _, start := range a.config.Window.windowStarts(ts) {
+		end := start + int64(a.config.Window.Size)
+		if end <= watermark {
+			a.Late++
+			continue
+		}
+
+		k := windowKey{key: event.Key, start: start}
+		window, exists := a.windows[k]
+		if !exists {
+			window = &nerve.NerveWindowResult{
+				Key:         event.Key,
+				WindowStart: start,
+				WindowEnd:   end,
+				Min:         event.Value,
+				Max:         event.Value,
+			}
+			a.windows[k] = window
+		}
+		window.Count++
+		window.Sum += event.Value
+		window.Min = math.Min(window.Min, event.Value)
+		window.Max = math.Max(window.Max, event.Value)
+	}
+
+	return closed
+}
+
+func (a *StreamAggregator) watermark() int64 {
+	if a.maxEventTime == math.MinInt64 {
+		return math.MinInt64
+	}
+	return a.maxEventTime - int64(a.config.Window.AllowedLateness)
+}
+
+// hasClosedWindows tells whether moving max event time from `from` to `to` may close windows,
+// i.e. whether end of some window gets between watermarks
+func (a *StreamAggregator) hasClosedWindows(from, to int64) bool {
+	if len(a.windows) == 0 || from == math.MinInt64 {
+		return false
+	}
+	shift := int64(a.config.Window.AllowedLateness) + int64(a.config.Window.Size)
+	return a.config.Window.alignStart(to-shift) > a.config.Window.alignStart(from-shift)
+}
+
+func (a *StreamAggregator) takeClosedWindows() []*nerve.NerveWindowResult {
+	watermark := a.watermark()
+	var res []*nerve.NerveWindowResult
+	for k, window := range a.windows {
+		if window.WindowEnd <= watermark {
+			res = append(res, window)
+			delete(a.windows, k)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].WindowStart != res[j].WindowStart {
+			return res[i].WindowStart < res[j].WindowStart
+		}
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+// flush sends results of closed windows, saves checkpoint and acks aggregated packets
+func (a *StreamAggregator) flush(receiver *Receiver) error {
+	if len(a.pending) == 0 {
+		return nil
+	}
+
+	closed := a.takeClosedWindows()
+	if len(closed) > 0 {
+		results := make([]*Packet, len(closed))
+		for i, window := range closed {
+			results[i] = &Packet{Data: window.Marshal()}
+		}
+		if err := a.synapse.SendPack(a.config.Output, results); err != nil {
+			return fmt.Errorf("error sending window results: %w", err)
+		}
+	}
+
+	checkpoint := &nerve.NerveStreamCheckpoint{
+		Ptr:          int64(a.ptr),
+		MaxEventTime: a.maxEventTime,
+		Windows:      make([]*nerve.NerveWindowResult, 0, len(a.windows)),
+	}
+	for _, window := range a.windows {
+		checkpoint.Windows = append(checkpoint.Windows, window)
+	}
+	if err := a.saveCheckpoint(checkpoint); err != nil {
+		return fmt.Errorf("error saving checkpoint: %w", err)
+	}
+
+	for _, packet := range a.pending {
+		receiver.Ack(packet)
+	}
+
+	a.logger.Debug().
+		Int64("ptr", int64(a.ptr)).
+		Int("windows", len(a.windows)).
+		Int("emitted", len(closed)).
+		Uint64("late", a.Late).
+		Msg("stream aggregation checkpoint")
+	a.pending = a.pending[:0]
+	return nil
+}
+
+// saveCheckpoint overwrites the checkpoint row instead of sending a new packet,
+// so checkpoints queue doesn't grow with every flush
+func (a *StreamAggregator) saveCheckpoint(checkpoint *nerve.NerveStreamCheckpoint) error {
+	name := a.config.Checkpoints.Name
+	packets := []*Packet{{DbId: streamCheckpointId, Data: checkpoint.Marshal()}}
+	if err := validatePackets(a.config.Checkpoints, packets); err != nil {
+		return err
+	}
+	packets, err := a.synapse.encryptBatch(name, packets)
+	if err != nil {
+		return err
+	}
+	if err = a.synapse.Backend.WriteBatch(name, packets); err != nil {
+		return err
+	}
+	return a.synapse.Backend.WritePtr(name, "", streamCheckpointId)
+}
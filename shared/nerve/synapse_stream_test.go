@@ -0,0 +1,148 @@
+package nerve
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"octopus/target/generated-sources/protobuf/nerve"
+)
+
+func TestWindowStarts(t *testing.T) {
+	tumbling := TumblingWindow(10)
+	if starts := tumbling.windowStarts(25); !reflect.DeepEqual(starts, []int64{20}) {
+		t.Errorf("tumbling window starts of 25: %v", starts)
+	}
+	if starts := tumbling.windowStarts(-5); !reflect.DeepEqual(starts, []int64{-10}) {
+		t.Errorf("tumbling window starts of -5: %v", starts)
+	}
+
+	sliding := SlidingWindow(10, 5)
+	if starts := sliding.windowStarts(25); !reflect.DeepEqual(starts, []int64{25, 20}) {
+		t.Errorf("sliding window starts of 25: %v", starts)
+	}
+	if starts := sliding.windowStarts(24); !reflect.DeepEqual(starts, []int64{20, 15}) {
+		t.Errorf("sliding window starts of 24: %v", starts)
+	}
+}
+
+// test events are "key:seconds:value"
+func extractTestEvent(p *Packet) (StreamEvent, error) {
+	parts := strings.Split(string(p.Data), ":")
+	if len(parts) != 3 {
+		return StreamEvent{}, fmt.Errorf("invalid event %q", p.Data)
+	}
+	sec, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return StreamEvent{}, err
+	}
+	value, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return StreamEvent{}, err
+	}
+	return StreamEvent{Key: parts[0], Time: time.Unix(sec, 0), Value: value}, nil
+}
+
+func sendEvents(t *testing.T, s *Synapse, queue QueueConfig, events ...string) {
+	pack := make([]*Packet, len(events))
+	for i, event := range events {
+		pack[i] = &Packet{Data: []byte(event)}
+	}
+	if err := s.SendPack(queue, pack); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receiveResults(t *testing.T, receiver *Receiver, n int) []string {
+	var res []string
+	for _, data := range receiveN(t, receiver, n) {
+		reader := nerve.NewNerveWindowResultReader()
+		if err := reader.Unmarshal(data); err != nil {
+			t.Fatal(err)
+		}
+		r := reader.ToStruct()
+		res = append(res, fmt.Sprintf("%s@%d: count=%d sum=%g min=%g max=%g",
+			r.Key, time.Unix(0, r.WindowStart).Unix(), r.Count, r.Sum, r.Min, r.Max))
+	}
+	return res
+}
+
+func TestStreamAggregatorRestart(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	s := NewSynapse(backend)
+	config := StreamAggregationConfig{
+		Source:             QueueConfig{Name: "NQStreamSource"},
+		Consumer:           "NCStreamTest",
+		Output:             QueueConfig{Name: "NQStreamOutput", Schema: NewWindowResultSchema()},
+		Checkpoints:        QueueConfig{Name: "NQStreamCheckpoints"},
+		Window:             TumblingWindow(time.Minute),
+		Extract:            extractTestEvent,
+		CheckpointInterval: 50 * time.Millisecond,
+	}
+	output := s.GetReceiver(config.Output, NCTest)
+	defer output.Close()
+
+	run := func() (*StreamAggregator, context.CancelFunc, chan error) {
+		aggregator, err := s.NewStreamAggregator(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- aggregator.Run(ctx)
+		}()
+		return aggregator, cancel, done
+	}
+
+	sendEvents(t, s, config.Source, "a:0:1", "a:10:2", "b:30:5", "garbage", "a:65:3")
+	aggregator, cancel, done := run()
+	results := receiveResults(t, output, 2)
+	expected := []string{
+		"a@0: count=2 sum=3 min=1 max=2",
+		"b@0: count=1 sum=5 min=5 max=5",
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("unexpected first results: %v", results)
+	}
+	waitForPtr(t, backend, config.Source, config.Consumer, 5)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+	if aggregator.Processed != 5 || aggregator.Invalid != 1 {
+		t.Fatalf("processed %d, invalid %d", aggregator.Processed, aggregator.Invalid)
+	}
+
+	// consumer pointer lagging behind checkpoint must not lead to double counting
+	if err := backend.WritePtr(config.Source.Name, config.Consumer, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	sendEvents(t, s, config.Source, "b:70:1", "a:20:100", "a:130:4")
+	aggregator, cancel, done = run()
+	results = receiveResults(t, output, 2)
+	expected = []string{
+		"a@60: count=1 sum=3 min=3 max=3",
+		"b@60: count=1 sum=1 min=1 max=1",
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("unexpected results after restart: %v", results)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+	if aggregator.Processed != 3 || aggregator.Late != 1 {
+		t.Fatalf("processed %d, late %d", aggregator.Processed, aggregator.Late)
+	}
+
+	// checkpoints overwrite the same row
+	if rows := len(backend.queues[config.Checkpoints.Name]); rows != 1 {
+		t.Fatalf("checkpoints queue has %d rows", rows)
+	}
+}
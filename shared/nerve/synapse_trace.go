@@ -0,0 +1,217 @@
+package nerve
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"octopus/shared/gremlin"
+	"octopus/target/generated-sources/protobuf/nerve"
+)
+
+// TraceId and SpanId are sized as in w3c trace-context, so ids may be shared with other tracing systems
+type TraceId [16]byte
+type SpanId [8]byte
+
+func (t TraceId) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceId) IsZero() bool {
+	return t == TraceId{}
+}
+
+func (s SpanId) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanId) IsZero() bool {
+	return s == SpanId{}
+}
+
+// SpanContext identifies a span of work: spans of one flow share TraceId and refer to the span they
+// were started from by ParentId, which is zero for root spans
+type SpanContext struct {
+	TraceId  TraceId
+	SpanId   SpanId
+	ParentId SpanId
+}
+
+func newSpanId() SpanId {
+	var id SpanId
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(fmt.Errorf("error generating span id: %w", err))
+	}
+	return id
+}
+
+// NewTrace starts root span of a new trace
+func NewTrace() SpanContext {
+	var traceId TraceId
+	if _, err := rand.Read(traceId[:]); err != nil {
+		panic(fmt.Errorf("error generating trace id: %w", err))
+	}
+	return SpanContext{TraceId: traceId, SpanId: newSpanId()}
+}
+
+func (c SpanContext) IsValid() bool {
+	return !c.TraceId.IsZero() && !c.SpanId.IsZero()
+}
+
+// Child starts span of the same trace with c as parent
+func (c SpanContext) Child() SpanContext {
+	return SpanContext{TraceId: c.TraceId, SpanId: newSpanId(), ParentId: c.SpanId}
+}
+
+// MarshalZerologObject allows both Object("trace", c) and EmbedObject(c) on zerolog events
+func (c SpanContext) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("trace-id", c.TraceId.String()).Str("span-id", c.SpanId.String())
+	if !c.ParentId.IsZero() {
+		e.Str("parent-id", c.ParentId.String())
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns ctx carrying span, packets sent with it become children of the span
+func ContextWithSpan(ctx context.Context, span SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	span, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return span, ok && span.IsValid()
+}
+
+// TraceLogger returns logger with trace fields of ctx span, if there is any
+func TraceLogger(ctx context.Context, logger zerolog.Logger) zerolog.Logger {
+	span, ok := SpanFromContext(ctx)
+	if !ok {
+		return logger
+	}
+	return logger.With().EmbedObject(span).Logger()
+}
+
+func (c SpanContext) toProto(sentAt time.Time) *nerve.NerveTraceContext {
+	res := &nerve.NerveTraceContext{
+		TraceId: append([]byte(nil), c.TraceId[:]...),
+		SpanId:  append([]byte(nil), c.SpanId[:]...),
+		SentAt:  sentAt.UnixNano(),
+	}
+	if !c.ParentId.IsZero() {
+		res.ParentSpanId = append([]byte(nil), c.ParentId[:]...)
+	}
+	return res
+}
+
+// spanFromProto returns span of packet trace, ok is false for packets sent without valid trace
+func spanFromProto(trace *nerve.NerveTraceContext) (span SpanContext, sentAt time.Time, ok bool) {
+	if trace == nil || len(trace.TraceId) != len(span.TraceId) || len(trace.SpanId) != len(span.SpanId) {
+		return span, sentAt, false
+	}
+	copy(span.TraceId[:], trace.TraceId)
+	copy(span.SpanId[:], trace.SpanId)
+	if len(trace.ParentSpanId) == len(span.ParentId) {
+		copy(span.ParentId[:], trace.ParentSpanId)
+	}
+	if trace.SentAt != 0 {
+		sentAt = time.Unix(0, trace.SentAt)
+	}
+	return span, sentAt, span.IsValid()
+}
+
+// traceView is json form of packet trace used by FormatPacket
+type traceView struct {
+	TraceId  string     `json:"traceId"`
+	SpanId   string     `json:"spanId"`
+	ParentId string     `json:"parentId,omitempty"`
+	SentAt   *time.Time `json:"sentAt,omitempty"`
+}
+
+func newTraceView(trace *nerve.NerveTraceContext) *traceView {
+	span, sentAt, ok := spanFromProto(trace)
+	if !ok {
+		return nil
+	}
+	res := &traceView{TraceId: span.TraceId.String(), SpanId: span.SpanId.String()}
+	if !sentAt.IsZero() {
+		res.SentAt = &sentAt
+	}
+	if !span.ParentId.IsZero() {
+		res.ParentId = span.ParentId.String()
+	}
+	return res
+}
+
+// SendTracedPack sends envelopes as SendSourcedPack, every packet gets own span, child of ctx span,
+// or root of a new trace if ctx has none. A "nerve packet sent" event is logged for every packet.
+// Envelopes of the caller are not changed, trace is set on their copies.
+func (s *Synapse) SendTracedPack(ctx context.Context, queue QueueConfig, pack []*nerve.NerveSourcedPacket) error {
+	parent, hasParent := SpanFromContext(ctx)
+	now := time.Now()
+	spans := make([]SpanContext, len(pack))
+	traced := make([]*nerve.NerveSourcedPacket, len(pack))
+	for i, packet := range pack {
+		if hasParent {
+			spans[i] = parent.Child()
+		} else {
+			spans[i] = NewTrace()
+		}
+		// shallow copy is enough, only Trace is replaced
+		envelope := *packet
+		envelope.Trace = spans[i].toProto(now)
+		traced[i] = &envelope
+	}
+
+	if err := s.SendSourcedPack(queue, traced); err != nil {
+		return err
+	}
+
+	for i, packet := range traced {
+		s.logger.Info().
+			EmbedObject(spans[i]).
+			Str("queue", string(queue.Name)).
+			Str("source", packet.Source.String()).
+			Uint64("source-id", packet.SourceId).
+			Msg("nerve packet sent")
+	}
+	return nil
+}
+
+// SendTraced sends single envelope, see SendTracedPack
+func (s *Synapse) SendTraced(ctx context.Context, queue QueueConfig, packet *nerve.NerveSourcedPacket) error {
+	return s.SendTracedPack(ctx, queue, []*nerve.NerveSourcedPacket{packet})
+}
+
+// StartSpan decodes envelope of received packet and returns ctx with span of its processing:
+// child of the sender span, or root of a new trace for packets sent without trace.
+// A "nerve packet received" event with time spent in queue is logged.
+func (r *Receiver) StartSpan(ctx context.Context, p *Packet) (context.Context, *nerve.NerveSourcedPacket, error) {
+	reader := nerve.NewNerveSourcedPacketReader()
+	if err := reader.SafeUnmarshal(p.Data, gremlin.DefaultDecodeOptions); err != nil {
+		return ctx, nil, fmt.Errorf("error reading envelope of packet %d: %w", p.DbId, err)
+	}
+	envelope := reader.ToStruct()
+
+	event := r.logger.Info().
+		Str("consumer", string(r.ConsumerId)).
+		Int64("id", int64(p.DbId)).
+		Str("source", envelope.Source.String()).
+		Uint64("source-id", envelope.SourceId)
+
+	var span SpanContext
+	if sender, sentAt, ok := spanFromProto(envelope.Trace); ok {
+		span = sender.Child()
+		if !sentAt.IsZero() {
+			event = event.Dur("queue-latency", time.Since(sentAt))
+		}
+	} else {
+		span = NewTrace()
+	}
+
+	event.EmbedObject(span).Msg("nerve packet received")
+	return ContextWithSpan(ctx, span), envelope, nil
+}
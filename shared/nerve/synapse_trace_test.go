@@ -0,0 +1,93 @@
+package nerve
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"octopus/target/generated-sources/protobuf/nerve"
+)
+
+func TestTracePropagation(t *testing.T) {
+	queue := QueueConfig{Name: "NQTraceTest", Schema: TestPacketSchema}
+	s := NewSynapse(NewMemoryBackend(1))
+	receiver := s.GetReceiver(queue, NCTest)
+	defer receiver.Close()
+
+	root := NewTrace()
+	payload := (&nerve.NerveTestPacket{Payload: []byte("traced")}).Marshal()
+	pack := []*nerve.NerveSourcedPacket{
+		{Source: nerve.NerveSourceType_NST_TEST, SourceId: 1, Packet: payload},
+		{Source: nerve.NerveSourceType_NST_TEST, SourceId: 2, Packet: payload},
+	}
+	if err := s.SendTracedPack(ContextWithSpan(context.Background(), root), queue, pack); err != nil {
+		t.Fatal(err)
+	}
+	for _, envelope := range pack {
+		if envelope.Trace != nil {
+			t.Fatalf("envelope of the caller is changed")
+		}
+	}
+	if err := s.SendSourcedPack(queue, []*nerve.NerveSourcedPacket{
+		{Source: nerve.NerveSourceType_NST_TEST, SourceId: 3, Packet: payload},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var senders []SpanId
+	for i := 0; i < 3; i++ {
+		p := <-receiver.DataChan
+		ctx, envelope, err := receiver.StartSpan(context.Background(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		span, ok := SpanFromContext(ctx)
+		if !ok {
+			t.Fatalf("no span for packet %d", p.DbId)
+		}
+
+		if envelope.SourceId == 3 {
+			if span.TraceId == root.TraceId || !span.ParentId.IsZero() {
+				t.Fatalf("untraced packet must start a new trace: %+v", span)
+			}
+			continue
+		}
+
+		sender, _, ok := spanFromProto(envelope.Trace)
+		if !ok || sender.TraceId != root.TraceId || sender.ParentId != root.SpanId {
+			t.Fatalf("sent span %+v is not a child of %+v", sender, root)
+		}
+		if span.TraceId != root.TraceId || span.ParentId != sender.SpanId || span.SpanId == sender.SpanId {
+			t.Fatalf("received span %+v is not a child of %+v", span, sender)
+		}
+		senders = append(senders, sender.SpanId)
+
+		formatted, err := FormatPacket(queue, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(formatted, root.TraceId.String()) {
+			t.Fatalf("formatted packet has no trace: %s", formatted)
+		}
+		receiver.Ack(p)
+	}
+	if len(senders) != 2 || senders[0] == senders[1] {
+		t.Fatalf("packets must be sent with own spans: %v", senders)
+	}
+}
+
+func TestStartSpanTruncatedEnvelope(t *testing.T) {
+	s := NewSynapse(NewMemoryBackend(1))
+	receiver := s.GetReceiver(QueueConfig{Name: "NQTraceTruncatedTest"}, NCTest)
+	defer receiver.Close()
+
+	// envelope itself is well-formed, while its trace field is truncated
+	trace := NewTrace().toProto(time.Now()).Marshal()
+	data := (&nerve.NerveSourcedPacket{SourceId: 1}).Marshal()
+	data = append(data, 11<<3|2, byte(len(trace)-1))
+	data = append(data, trace[:len(trace)-1]...)
+	if _, _, err := receiver.StartSpan(context.Background(), &Packet{DbId: 1, Data: data}); err == nil {
+		t.Fatal("truncated envelope is decoded")
+	}
+}
@@ -19,7 +19,9 @@
 package nerve
 
 import (
+	"regexp"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -30,11 +32,30 @@ type BackendConfig struct {
 	TableParallelism    uint   `json:"table_parallelism"`
 	PointersParallelism uint   `json:"pointers_parallelism"`
 	MaxRPSPerThread     uint   `json:"max_rps_per_thread"`
+
+	// PreviousTableParallelism is set while queue is being resharded
+	// from PreviousTableParallelism to TableParallelism tables
+	PreviousTableParallelism uint `json:"previous_table_parallelism"`
+
+	// PreviousPointersParallelism is set while pointers are being migrated
+	// from PreviousPointersParallelism to PointersParallelism tables
+	PreviousPointersParallelism uint `json:"previous_pointers_parallelism"`
+
+	// ShardedPointers is set once pointers are migrated to PointersParallelism tables
+	ShardedPointers bool `json:"sharded_pointers"`
+
+	// Compression is a name of registered PayloadCodec, e.g. `gzip` or `deflate`
+	Compression string `json:"compression"`
 }
 
 type QueueConfig struct {
 	Hosts map[string]BackendConfig `json:"hosts"`
 	Name  QueueName                `json:"name"`
+	// SchemaName is a name of registered QueueSchema, used when Schema is nil,
+	// so queue configs (e.g. appsconfigs/nerve/queues.json) may declare schemas too
+	SchemaName string `json:"schema"`
+	// Schema of queue payloads, packets aren't validated if it's nil and SchemaName is empty
+	Schema *QueueSchema `json:"-"`
 }
 
 type Synapse struct {
@@ -49,12 +70,25 @@ type Synapse struct {
 	queueWriterChannels     map[QueueName][]chan *Packet
 	queueWriterChannelsLock sync.RWMutex
 	Backend                 SynapseBackend
+	readerLimit             QueueElementIndex
+	gapTimeout              time.Duration
+	encryptors              map[QueueName]*queueEncryptor
+	encryptorsLock          sync.RWMutex
 	trace                   bool
 	logger                  *zerolog.Logger
+	health                  *healthTracker
 }
 
 type QueueElementIndex int64
 type QueueName string
+
+var queueNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// IsValid reports whether name is safe to be a part of backend table names
+func (n QueueName) IsValid() bool {
+	return queueNamePattern.MatchString(string(n))
+}
+
 type ConsumerId string
 
 type Packet struct {
@@ -78,6 +112,7 @@ type Receiver struct {
 	DataChan              chan *Packet
 	TerminateReceiverChan chan struct{}
 	TerminateReaderChan   chan struct{}
+	readerDone            chan struct{}
 	ConsumerId            ConsumerId
 	QueueName             QueueName
 	Synapse               *Synapse
@@ -86,6 +121,11 @@ type Receiver struct {
 	AckChannel            chan QueueElementIndex
 	ackBufferLock         sync.RWMutex
 	lastReadId            QueueElementIndex
+	gapLock               sync.Mutex
+	gapId                 QueueElementIndex
+	gapSince              time.Time
+	closeOnce             sync.Once
+	closed                int32
 	logger                *zerolog.Logger
 }
 
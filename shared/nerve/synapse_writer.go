@@ -89,12 +89,17 @@ func (s *Synapse) writeBuffer(id int, queueName QueueName, lastSavedId QueueElem
 		s.logger.Info().Int("id", id).Interface("saving batch", *writeBuffer).Send()
 	}
 	for {
-		err := s.Backend.WriteBatch(queueName, *writeBuffer)
+		batch, err := s.encryptBatch(queueName, *writeBuffer)
+		if err == nil {
+			err = s.Backend.WriteBatch(queueName, batch)
+		}
 		if err != nil {
+			s.health.writeFailed(queueName, err)
 			s.logger.Error().Int("id", id).
 				Interface("p", *writeBuffer).
 				Err(err).Msg("Failed to write batch")
 		} else {
+			s.health.writeSucceeded(queueName)
 			break
 		}
 	}
@@ -115,8 +120,10 @@ func (s *Synapse) saveQueueWriterPtr(queueName QueueName, ptr QueueElementIndex)
 	for {
 		err := s.Backend.WritePtr(queueName, "", ptr)
 		if err == nil {
+			s.health.pointerSaved(queueName, "")
 			return nil
 		}
+		s.health.pointerFailed(queueName, "", err)
 
 		s.logger.Error().Err(err).
 			Str("queue", string(queueName)).